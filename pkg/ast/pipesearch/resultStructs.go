@@ -25,6 +25,7 @@ type PipeSearchResponseOuter struct {
 	ElapedTimeMS           int64                         `json:"elapedTimeMS"`
 	AllPossibleColumns     []string                      `json:"allColumns"`
 	Errors                 []string                      `json:"errors,omitempty"`
+	Warnings               []string                      `json:"warnings,omitempty"`
 	MeasureFunctions       []string                      `json:"measureFunctions,omitempty"`
 	MeasureResults         []*structs.BucketHolder       `json:"measure,omitempty"`
 	GroupByCols            []string                      `json:"groupByCols,omitempty"`
@@ -76,4 +77,5 @@ type PipeSearchCompleteResponse struct {
 	BucketCount         int                     `json:"bucketCount,omitempty"`
 	IsTimechart         bool                    `json:"isTimechart"`
 	ColumnsOrder        []string                `json:"columnsOrder,omitempty"`
+	Warnings            []string                `json:"warnings,omitempty"`
 }
@@ -19,30 +19,39 @@ package pipesearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/siglens/siglens/pkg/alerts/alertutils"
+	"github.com/siglens/siglens/pkg/ast"
 	"github.com/siglens/siglens/pkg/common/dtypeutils"
 	fileutils "github.com/siglens/siglens/pkg/common/fileutils"
+	"github.com/siglens/siglens/pkg/config"
 	rutils "github.com/siglens/siglens/pkg/readerUtils"
 	"github.com/siglens/siglens/pkg/segment"
+	"github.com/siglens/siglens/pkg/segment/aggregations"
 	"github.com/siglens/siglens/pkg/segment/query"
 	"github.com/siglens/siglens/pkg/segment/query/metadata"
 	"github.com/siglens/siglens/pkg/segment/reader/record"
 	"github.com/siglens/siglens/pkg/segment/results/segresults"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	sutils "github.com/siglens/siglens/pkg/segment/utils"
+	"github.com/siglens/siglens/pkg/segment/writer"
 	"github.com/siglens/siglens/pkg/utils"
 	vtable "github.com/siglens/siglens/pkg/virtualtable"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 )
 
+// Each subsearch is a full search, so multisearch only runs this many of them at once.
+const MAX_CONCURRENT_SUBSEARCHES = 4
+
 /*
 Example incomingBody
 
@@ -225,7 +234,7 @@ func ProcessAlertsPipeSearchRequest(queryParams alertutils.QueryParams) (*PipeSe
 	readJSON["endEpoch"] = queryParams.EndTime
 	readJSON["state"] = "query"
 
-	httpRespOuter, isScrollMax, timeRange, err := ParseAndExecutePipeRequest(readJSON, qid, orgid, queryStart, dbPanelId)
+	httpRespOuter, isScrollMax, timeRange, err := ParseAndExecutePipeRequest(context.Background(), readJSON, qid, orgid, queryStart, dbPanelId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -237,7 +246,8 @@ func ProcessAlertsPipeSearchRequest(queryParams alertutils.QueryParams) (*PipeSe
 	return httpRespOuter, timeRange, nil
 }
 
-func ParseAndExecutePipeRequest(readJSON map[string]interface{}, qid uint64, myid uint64, queryStart time.Time, dbPanelId string) (*PipeSearchResponseOuter, bool, *dtypeutils.TimeRange, error) {
+// The subsearches of the query are cancelled when ctx is done.
+func ParseAndExecutePipeRequest(ctx context.Context, readJSON map[string]interface{}, qid uint64, myid uint64, queryStart time.Time, dbPanelId string) (*PipeSearchResponseOuter, bool, *dtypeutils.TimeRange, error) {
 	var err error
 
 	nowTs := utils.GetCurrentTimeInMs()
@@ -278,6 +288,33 @@ func ParseAndExecutePipeRequest(readJSON map[string]interface{}, qid uint64, myi
 		log.Error(err.Error())
 		return nil, false, nil, err
 	}
+	// The sorts write their records to disk when they run out of memory, so the files are
+	// removed however the query ends.
+	defer aggregations.RemoveSortSpillFilesInChain(aggs)
+
+	err = executeFilterSubsearches(ctx, simpleNode, readJSON, myid, qid)
+	if err != nil {
+		err = fmt.Errorf("qid=%v, ParseAndExecutePipeRequest: Error running subsearches of the search filter: %+v, err: %+v", qid, searchText, err)
+		log.Error(err.Error())
+		return nil, false, nil, err
+	}
+
+	err = executeSubsearches(ctx, aggs, readJSON, myid)
+	if err != nil {
+		err = fmt.Errorf("qid=%v, ParseAndExecutePipeRequest: Error running subsearches of query: %+v, err: %+v", qid, searchText, err)
+		log.Error(err.Error())
+		return nil, false, nil, err
+	}
+
+	if aggs.HasForeachInChain() {
+		knownCols := getKnownColumns(ti.GetQueryTables(), simpleNode.TimeRange, myid)
+		err = expandForeachCommands(aggs, knownCols, qid)
+		if err != nil {
+			err = fmt.Errorf("qid=%v, ParseAndExecutePipeRequest: Error expanding foreach commands of query: %+v, err: %+v", qid, searchText, err)
+			log.Error(err.Error())
+			return nil, false, nil, err
+		}
+	}
 
 	sizeLimit = GetFinalSizelimit(aggs, sizeLimit)
 
@@ -299,6 +336,616 @@ func ParseAndExecutePipeRequest(readJSON map[string]interface{}, qid uint64, myi
 	return &httpRespOuter, false, simpleNode.TimeRange, nil
 }
 
+// Runs the subsearches used by the pipe commands (like join) before the main
+// search, and attaches their results to the commands that consume them.
+func executeSubsearches(ctx context.Context, aggs *structs.QueryAggregators, readJSON map[string]interface{}, myid uint64) error {
+	for agg := aggs; agg != nil; agg = agg.Next {
+		if agg.HasJoinBlock() {
+			joinReq := agg.OutputTransforms.LetColumns.JoinRequest
+			records, cols, err := executeSubsearch(ctx, joinReq.Subsearch, joinReq.MaxOut, readJSON, myid)
+			if err != nil {
+				return fmt.Errorf("executeSubsearches: failed to run join subsearch: %v, err: %v", joinReq.Subsearch, err)
+			}
+			joinReq.SubsearchRecords = records
+			joinReq.SubsearchCols = cols
+		}
+		if agg.HasAppendColsBlock() {
+			appendColsReq := agg.OutputTransforms.LetColumns.AppendColsRequest
+			records, cols, err := executeSubsearch(ctx, appendColsReq.Subsearch, appendColsReq.MaxOut, readJSON, myid)
+			if err != nil {
+				return fmt.Errorf("executeSubsearches: failed to run appendcols subsearch: %v, err: %v", appendColsReq.Subsearch, err)
+			}
+			appendColsReq.SubsearchRecords = records
+			appendColsReq.SubsearchCols = cols
+		}
+		if agg.GenerateEvent != nil && agg.GenerateEvent.Multisearch != nil {
+			err := executeMultisearch(ctx, agg.GenerateEvent.Multisearch, readJSON, myid)
+			if err != nil {
+				return fmt.Errorf("executeSubsearches: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// The subsearches of multisearch run concurrently, at most MAX_CONCURRENT_SUBSEARCHES at a
+// time. When one of them fails, the ones that are still running are cancelled.
+func executeMultisearch(ctx context.Context, multisearch *structs.Multisearch, readJSON map[string]interface{}, myid uint64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numSubsearches := len(multisearch.Subsearches)
+	allRecords := make([][]map[string]interface{}, numSubsearches)
+	allCols := make([][]string, numSubsearches)
+
+	// The first error is kept, since the subsearches that are cancelled because of it fail too.
+	var firstErr error
+	var errLock sync.Mutex
+	setErr := func(err error) {
+		errLock.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errLock.Unlock()
+		cancel()
+	}
+
+	semaphore := make(chan struct{}, MAX_CONCURRENT_SUBSEARCHES)
+	var waitGroup sync.WaitGroup
+	for i, subsearch := range multisearch.Subsearches {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			setErr(fmt.Errorf("executeMultisearch: subsearch: %v was cancelled, err: %v", subsearch, ctx.Err()))
+			break
+		}
+
+		waitGroup.Add(1)
+		go func(i int, subsearch string) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+
+			records, cols, err := executeSubsearch(ctx, subsearch, multisearch.MaxOut, readJSON, myid)
+			if err != nil {
+				setErr(fmt.Errorf("executeMultisearch: failed to run subsearch: %v, err: %v", subsearch, err))
+				return
+			}
+			allRecords[i] = records
+			allCols[i] = cols
+		}(i, subsearch)
+	}
+	waitGroup.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	multisearch.SubsearchCols = make([]string, 0)
+	for i := range multisearch.Subsearches {
+		for _, col := range allCols[i] {
+			if !utils.SliceContainsString(multisearch.SubsearchCols, col) {
+				multisearch.SubsearchCols = append(multisearch.SubsearchCols, col)
+			}
+		}
+	}
+	multisearch.SubsearchRecords = allRecords
+
+	return nil
+}
+
+// The subsearch runs over the same time range as the main search.
+func executeSubsearch(ctx context.Context, searchText string, maxOut uint64, readJSON map[string]interface{}, myid uint64) ([]map[string]interface{}, []string, error) {
+	return executeSubsearchWithQid(ctx, searchText, maxOut, readJSON, myid, rutils.GetNextQid())
+}
+
+// Like executeSubsearch, but the subsearch is cancelled and fails when it runs longer than maxTime.
+func executeSubsearchWithTimeout(ctx context.Context, searchText string, maxOut uint64, maxTime time.Duration, readJSON map[string]interface{}, myid uint64) ([]map[string]interface{}, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, maxTime)
+	defer cancel()
+
+	records, cols, err := executeSubsearch(ctx, searchText, maxOut, readJSON, myid)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, nil, fmt.Errorf("executeSubsearchWithTimeout: the subsearch did not finish within %v", maxTime)
+		}
+		return nil, nil, fmt.Errorf("executeSubsearchWithTimeout: %v", err)
+	}
+
+	return records, cols, nil
+}
+
+// The subsearch is cancelled when ctx is done, and then it fails instead of returning the rows
+// it found so far.
+func executeSubsearchWithQid(ctx context.Context, searchText string, maxOut uint64, readJSON map[string]interface{}, myid uint64, qid uint64) ([]map[string]interface{}, []string, error) {
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("executeSubsearchWithQid: the subsearch was cancelled before it started, err: %v", ctx.Err())
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			query.CancelQuery(qid)
+		case <-finished:
+		}
+	}()
+
+	subsearchJSON, err := getSubsearchJSON(searchText, maxOut, readJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("executeSubsearchWithQid: %v", err)
+	}
+	httpRespOuter, _, _, err := ParseAndExecutePipeRequest(ctx, subsearchJSON, qid, myid, time.Now(), "-1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("executeSubsearchWithQid: %v", err)
+	}
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("executeSubsearchWithQid: the subsearch was cancelled, err: %v", ctx.Err())
+	}
+	if len(httpRespOuter.Errors) > 0 {
+		return nil, nil, fmt.Errorf("executeSubsearchWithQid: %v", strings.Join(httpRespOuter.Errors, ", "))
+	}
+
+	records, cols := getSubsearchRecords(httpRespOuter)
+	if uint64(len(records)) > maxOut {
+		records = records[:maxOut]
+	}
+
+	return records, cols, nil
+}
+
+// The subsearch runs over the indexes named by its index=<name> terms, and over the
+// indexes of the main search when it does not name any.
+func getSubsearchJSON(searchText string, maxOut uint64, readJSON map[string]interface{}) (map[string]interface{}, error) {
+	indexName := readJSON["indexName"]
+	subsearchIndexNames, searchText, err := extractSubsearchIndexNames(searchText)
+	if err != nil {
+		return nil, fmt.Errorf("getSubsearchJSON: %v", err)
+	}
+	if subsearchIndexNames != "" {
+		indexName = subsearchIndexNames
+	}
+
+	return map[string]interface{}{
+		"searchText":    searchText,
+		"startEpoch":    readJSON["startEpoch"],
+		"endEpoch":      readJSON["endEpoch"],
+		"indexName":     indexName,
+		"queryLanguage": "Splunk QL",
+		"from":          0,
+		"size":          maxOut,
+	}, nil
+}
+
+// Returns the comma separated index names of the index=<name> terms in the search filter
+// of the subsearch, and the search text without those terms and the AND/OR joining them.
+// The index names are sent separately, like the indexName of the main search, since no
+// column holds them. A subsearch cannot exclude indexes, so index!=<name> and NOT index=<name>
+// are errors.
+func extractSubsearchIndexNames(searchText string) (string, string, error) {
+	tokens, filterEnd := getSubsearchFilterTokens(searchText)
+
+	indexNames := make([]string, 0)
+	filterTokens := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		indexName, numTokens, isExcluded := getIndexTerm(tokens[i:])
+		if isExcluded {
+			return "", "", fmt.Errorf("extractSubsearchIndexNames: a subsearch cannot exclude indexes, got: %v", searchText)
+		}
+		if numTokens == 0 {
+			filterTokens = append(filterTokens, tokens[i])
+			continue
+		}
+
+		if len(filterTokens) > 0 && filterTokens[len(filterTokens)-1] == "NOT" {
+			return "", "", fmt.Errorf("extractSubsearchIndexNames: a subsearch cannot exclude indexes, got: %v", searchText)
+		}
+		indexNames = append(indexNames, indexName)
+		if len(filterTokens) > 0 && isSubsearchJoin(filterTokens[len(filterTokens)-1]) {
+			filterTokens = filterTokens[:len(filterTokens)-1]
+		}
+		i += numTokens - 1
+	}
+	if len(indexNames) == 0 {
+		return "", searchText, nil
+	}
+
+	filter := ""
+	if len(filterTokens) > 0 && strings.EqualFold(filterTokens[0], "search") {
+		filter = "search "
+		filterTokens = filterTokens[1:]
+	}
+
+	filterTokens = removeDanglingJoins(filterTokens)
+	if len(filterTokens) == 0 {
+		filter += "*"
+	} else {
+		filter += joinSubsearchFilterTokens(filterTokens)
+	}
+
+	return strings.Join(indexNames, ","), strings.TrimSpace(filter + " " + searchText[filterEnd:]), nil
+}
+
+// Splits the search filter, which ends at the first pipe outside of quotes, into its terms.
+// The parentheses are terms of their own.
+func getSubsearchFilterTokens(searchText string) ([]string, int) {
+	tokens := make([]string, 0)
+	termStart := -1
+	inQuotes := false
+	endTerm := func(end int) {
+		if termStart != -1 {
+			tokens = append(tokens, searchText[termStart:end])
+			termStart = -1
+		}
+	}
+
+	for i, char := range searchText {
+		if char == '"' {
+			inQuotes = !inQuotes
+		}
+		if inQuotes {
+			if termStart == -1 {
+				termStart = i
+			}
+			continue
+		}
+
+		switch char {
+		case ' ', '\t', '\n', '\r':
+			endTerm(i)
+		case '|':
+			endTerm(i)
+			return tokens, i
+		case '(', ')':
+			endTerm(i)
+			tokens = append(tokens, string(char))
+		default:
+			if termStart == -1 {
+				termStart = i
+			}
+		}
+	}
+	endTerm(len(searchText))
+
+	return tokens, len(searchText)
+}
+
+// Returns the index name and the number of tokens of the index term at the start of the
+// tokens, which may be written as index=a, Index=a, index = a, index= a or index =a. The
+// number of tokens is 0 when the tokens do not start with an index term.
+func getIndexTerm(tokens []string) (string, int, bool) {
+	const indexField = "index"
+	if len(tokens[0]) < len(indexField) || !strings.EqualFold(tokens[0][:len(indexField)], indexField) {
+		return "", 0, false
+	}
+
+	term := tokens[0][len(indexField):]
+	numTokens := 1
+	for _, operator := range []string{"!=", "="} {
+		if term == "" && numTokens < len(tokens) && strings.HasPrefix(tokens[numTokens], operator) {
+			term = tokens[numTokens]
+			numTokens++
+		}
+		if !strings.HasPrefix(term, operator) {
+			continue
+		}
+
+		value := term[len(operator):]
+		if value == "" && numTokens < len(tokens) && tokens[numTokens] != ")" {
+			value = tokens[numTokens]
+			numTokens++
+		}
+		if value == "" {
+			return "", 0, false
+		}
+		if operator == "!=" {
+			return "", 0, true
+		}
+
+		return strings.Trim(value, `"`), numTokens, false
+	}
+
+	return "", 0, false
+}
+
+func isSubsearchJoin(token string) bool {
+	return token == "AND" || token == "OR"
+}
+
+// Removes the AND/OR that no longer join two terms, and the parentheses that no longer hold
+// any term, once the index terms are removed.
+func removeDanglingJoins(tokens []string) []string {
+	for {
+		cleaned := make([]string, 0, len(tokens))
+		for i, token := range tokens {
+			if token == ")" && len(cleaned) > 0 && cleaned[len(cleaned)-1] == "(" {
+				cleaned = cleaned[:len(cleaned)-1]
+				continue
+			}
+			if isSubsearchJoin(token) {
+				if len(cleaned) == 0 || cleaned[len(cleaned)-1] == "(" || isSubsearchJoin(cleaned[len(cleaned)-1]) {
+					continue
+				}
+				if i+1 == len(tokens) || tokens[i+1] == ")" {
+					continue
+				}
+			}
+			cleaned = append(cleaned, token)
+		}
+
+		if len(cleaned) == len(tokens) {
+			return cleaned
+		}
+		tokens = cleaned
+	}
+}
+
+func joinSubsearchFilterTokens(tokens []string) string {
+	var sb strings.Builder
+	for i, token := range tokens {
+		if i > 0 && token != ")" && tokens[i-1] != "(" {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(token)
+	}
+	return sb.String()
+}
+
+// Runs the subsearches of the search filter before the main search, and replaces each of
+// them by a filter on its rows.
+func executeFilterSubsearches(ctx context.Context, node *structs.ASTNode, readJSON map[string]interface{}, myid uint64, qid uint64) error {
+	if node == nil {
+		return nil
+	}
+
+	for _, condition := range []*structs.Condition{node.AndFilterCondition, node.OrFilterCondition, node.ExclusionFilterCondition} {
+		if condition == nil {
+			continue
+		}
+		for _, nestedNode := range condition.NestedNodes {
+			err := executeFilterSubsearches(ctx, nestedNode, readJSON, myid, qid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if node.Subsearch == nil {
+		return nil
+	}
+
+	subsearch := node.Subsearch
+	maxTime := time.Duration(subsearch.MaxTime) * time.Second
+	records, cols, err := executeSubsearchWithTimeout(ctx, subsearch.Query, subsearch.MaxOut, maxTime, readJSON, myid)
+	if err != nil {
+		return fmt.Errorf("executeFilterSubsearches: failed to run subsearch: %v, err: %v", subsearch.Query, err)
+	}
+
+	err = setSubsearchFilter(node, records, cols, qid)
+	if err != nil {
+		return fmt.Errorf("executeFilterSubsearches: subsearch: %v, err: %v", subsearch.Query, err)
+	}
+
+	return nil
+}
+
+// Like the format command, each row of the subsearch becomes an AND of its fields and the
+// rows are joined with OR. A subsearch without rows matches nothing, like the NOT() of format.
+func setSubsearchFilter(node *structs.ASTNode, records []map[string]interface{}, cols []string, qid uint64) error {
+	rowNodes := make([]*ast.Node, 0, len(records))
+	for _, record := range records {
+		rowNode := getSubsearchRowNode(record, cols)
+		if rowNode != nil {
+			rowNodes = append(rowNodes, rowNode)
+		}
+	}
+
+	rowsFilter := createMatchAll(qid)
+	if len(rowNodes) > 0 {
+		rowsFilter = &structs.ASTNode{}
+		err := SearchQueryToASTnode(ast.JoinNodes(rowNodes, ast.NodeOr), rowsFilter, qid, false)
+		if err != nil {
+			return fmt.Errorf("setSubsearchFilter: failed to create the filter of the rows, err: %v", err)
+		}
+	}
+
+	negated := node.Subsearch.Negated
+	node.Subsearch = nil
+
+	switch {
+	case len(rowNodes) == 0 && negated:
+		node.AndFilterCondition = createMatchAll(qid).AndFilterCondition
+	case len(rowNodes) > 0 && !negated:
+		node.AndFilterCondition = &structs.Condition{NestedNodes: []*structs.ASTNode{rowsFilter}}
+	default:
+		// A node with only exclusions needs a condition that the lines must match.
+		node.AndFilterCondition = createMatchAll(qid).AndFilterCondition
+		node.ExclusionFilterCondition = &structs.Condition{NestedNodes: []*structs.ASTNode{rowsFilter}}
+	}
+
+	return nil
+}
+
+// Returns a node that matches the fields of the row, or nil when the row has no fields. The
+// internal fields and the timestamp are skipped, like in the format command.
+func getSubsearchRowNode(record map[string]interface{}, cols []string) *ast.Node {
+	timestampKey := config.GetTimeStampKey()
+	fieldNodes := make([]*ast.Node, 0, len(cols))
+	for _, col := range cols {
+		if col == timestampKey || strings.HasPrefix(col, "_") {
+			continue
+		}
+
+		value, exists := record[col]
+		if !exists || value == nil {
+			continue
+		}
+
+		comparison := ast.Comparison{
+			Op:    "=",
+			Field: col,
+		}
+		switch value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+			comparison.Values = json.Number(fmt.Sprintf("%v", value))
+		default:
+			strValue := fmt.Sprintf("%v", value)
+			if strValue == "" {
+				continue
+			}
+			// Quote the value the same way the parser does for the strings in the search.
+			quotedValue := "\"" + strValue + "\""
+			comparison.Values = strings.ToLower(quotedValue)
+			comparison.OriginalValues = quotedValue
+			comparison.CaseInsensitive = true
+		}
+
+		fieldNodes = append(fieldNodes, &ast.Node{
+			NodeType:   ast.NodeTerminal,
+			Comparison: comparison,
+		})
+	}
+
+	return ast.JoinNodes(fieldNodes, ast.NodeAnd)
+}
+
+// Returns the columns of the indexes in the time range, both in the rotated segments and in
+// the ones still being written, sorted by name.
+func getKnownColumns(indexNames []string, timeRange *dtypeutils.TimeRange, myid uint64) []string {
+	cols := metadata.GetColumnsForTheIndexesByTimeRange(timeRange, indexNames, myid)
+	for col := range writer.GetUnrotatedColumnsForTheIndexesByTimeRange(timeRange, indexNames, myid) {
+		cols[col] = true
+	}
+
+	knownCols := make([]string, 0, len(cols))
+	for col := range cols {
+		knownCols = append(knownCols, col)
+	}
+	sort.Strings(knownCols)
+
+	return knownCols
+}
+
+// Sets how the foreach commands parse their subsearches, and runs the subsearch of each
+// known column matching their fields by adding the commands right after the foreach. This is
+// only done while the commands before it keep the columns of the search; the other columns
+// are handled by the foreach when they appear.
+func expandForeachCommands(aggs *structs.QueryAggregators, knownCols []string, qid uint64) error {
+	keepsSearchCols := true
+	for agg := aggs; agg != nil; agg = agg.Next {
+		if !agg.HasForeachBlock() {
+			keepsSearchCols = keepsSearchCols && keepsSearchColumns(agg)
+			continue
+		}
+
+		foreachReq := agg.OutputTransforms.LetColumns.ForeachRequest
+		foreachReq.ParseSubsearch = func(subsearch string) (*structs.QueryAggregators, error) {
+			return parseForeachSubsearch(subsearch, qid)
+		}
+		if !keepsSearchCols || foreachReq.Mode != structs.ForeachMultiField {
+			continue
+		}
+
+		foreachReq.ExpandedFields = make(map[string]struct{})
+		last := agg
+		for _, field := range foreachReq.GetMatchingFields(knownCols) {
+			subsearchAggs, err := foreachReq.ParseSubsearch(foreachReq.GetFieldSubsearch(field))
+			if err != nil {
+				return fmt.Errorf("expandForeachCommands: field: %v, err: %v", field, err)
+			}
+			foreachReq.ExpandedFields[field] = struct{}{}
+
+			subsearchLast := subsearchAggs
+			for subsearchLast.Next != nil {
+				subsearchLast = subsearchLast.Next
+			}
+			subsearchLast.Next = last.Next
+			last.Next = subsearchAggs
+			last = subsearchLast
+		}
+	}
+
+	return nil
+}
+
+// Whether all the columns of the search are still there after the command.
+func keepsSearchColumns(agg *structs.QueryAggregators) bool {
+	if agg.PipeCommandType != structs.OutputTransformType || agg.OutputTransforms == nil || agg.OutputTransforms.OutputColumns != nil {
+		return false
+	}
+
+	letColReq := agg.OutputTransforms.LetColumns
+	return letColReq == nil || letColReq.ValueColRequest != nil || letColReq.RexColRequest != nil
+}
+
+// The subsearch of foreach runs on each record by itself, so only the commands which do not
+// depend on the other records are allowed.
+func parseForeachSubsearch(subsearch string, qid uint64) (*structs.QueryAggregators, error) {
+	_, aggs, err := ParseQuery("* | "+subsearch, qid, "Splunk QL")
+	if err != nil {
+		return nil, fmt.Errorf("parseForeachSubsearch: failed to parse subsearch: %v, err: %v", subsearch, err)
+	}
+	if aggs == nil {
+		return nil, fmt.Errorf("parseForeachSubsearch: subsearch has no commands: %v", subsearch)
+	}
+
+	for agg := aggs; agg != nil; agg = agg.Next {
+		if !isForeachSubsearchCommand(agg) {
+			return nil, fmt.Errorf("parseForeachSubsearch: only eval, rex, rename, where, fields and foreach are allowed in the subsearch: %v", subsearch)
+		}
+	}
+
+	// The columns of nested foreach commands are only known while the search runs.
+	err = expandForeachCommands(aggs, nil, qid)
+	if err != nil {
+		return nil, fmt.Errorf("parseForeachSubsearch: %v", err)
+	}
+
+	return aggs, nil
+}
+
+func isForeachSubsearchCommand(agg *structs.QueryAggregators) bool {
+	if agg.PipeCommandType != structs.OutputTransformType || agg.OutputTransforms == nil ||
+		agg.OutputTransforms.HeadRequest != nil || agg.OutputTransforms.TailRequest != nil {
+		return false
+	}
+
+	letColReq := agg.OutputTransforms.LetColumns
+	return letColReq == nil || letColReq.ValueColRequest != nil || letColReq.RexColRequest != nil ||
+		letColReq.RenameColRequest != nil || letColReq.ForeachRequest != nil
+}
+
+// Returns the subsearch results as records. For aggregation queries the records
+// are built from the group by columns and the measure columns.
+func getSubsearchRecords(httpRespOuter *PipeSearchResponseOuter) ([]map[string]interface{}, []string) {
+	if len(httpRespOuter.MeasureResults) == 0 {
+		return httpRespOuter.Hits.Hits, httpRespOuter.AllPossibleColumns
+	}
+
+	cols := make([]string, 0, len(httpRespOuter.GroupByCols)+len(httpRespOuter.MeasureFunctions))
+	cols = append(cols, httpRespOuter.GroupByCols...)
+	cols = append(cols, httpRespOuter.MeasureFunctions...)
+
+	records := make([]map[string]interface{}, 0, len(httpRespOuter.MeasureResults))
+	for _, bucketHolder := range httpRespOuter.MeasureResults {
+		record := make(map[string]interface{}, len(cols))
+		for i, groupByCol := range httpRespOuter.GroupByCols {
+			if i < len(bucketHolder.GroupByValues) {
+				record[groupByCol] = bucketHolder.GroupByValues[i]
+			}
+		}
+		for measureCol, value := range bucketHolder.MeasureVal {
+			record[measureCol] = value
+		}
+		records = append(records, record)
+	}
+
+	return records, cols
+}
+
 func ProcessPipeSearchRequest(ctx *fasthttp.RequestCtx, myid uint64) {
 	qid := rutils.GetNextQid()
 	defer fileutils.DeferableAddAccessLogEntry(
@@ -344,7 +991,7 @@ func ProcessPipeSearchRequest(ctx *fasthttp.RequestCtx, myid uint64) {
 		log.Errorf("qid=%v, ProcessPipeSearchRequest: failed to decode search request body! err: %+v", qid, err)
 	}
 
-	httpRespOuter, isScrollMax, _, err := ParseAndExecutePipeRequest(readJSON, qid, myid, queryStart, dbPanelId)
+	httpRespOuter, isScrollMax, _, err := ParseAndExecutePipeRequest(ctx, readJSON, qid, myid, queryStart, dbPanelId)
 	if err != nil {
 		utils.SendError(ctx, fmt.Sprintf("Error processing search request: %v", err), "", err)
 		return
@@ -371,6 +1018,7 @@ func getQueryResponseJson(nodeResult *structs.NodeResult, indexName string, quer
 			httpRespOuter.Errors = append(httpRespOuter.Errors, err.Error())
 		}
 	}
+	httpRespOuter.Warnings = nodeResult.Warnings
 
 	allMeasRes, measFuncs, added := segresults.CreateMeasResultsFromAggResults(aggs.BucketLimit, nodeResult.Histogram)
 
@@ -18,8 +18,12 @@
 package pipesearch
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,3 +51,231 @@ func Test_parseSearchBody(t *testing.T) {
 	assert.Equal(t, uint64(700), finalSize, "expected=%v, actual=%v", 700, scroll)
 	assert.Equal(t, 500, scroll, "expected=%v, actual=%v", 500, scroll)
 }
+
+func Test_expandForeachCommands(t *testing.T) {
+	knownCols := []string{"bytes_in", "bytes_out", "host"}
+
+	_, aggs, err := ParseQuery(`* | foreach bytes_* [eval <<FIELD>>_kb = '<<FIELD>>' / 1024] | fields host`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	err = expandForeachCommands(aggs, knownCols, 0)
+	assert.Nil(t, err)
+
+	foreachReq := aggs.OutputTransforms.LetColumns.ForeachRequest
+	assert.NotNil(t, foreachReq.ParseSubsearch)
+	assert.Equal(t, map[string]struct{}{"bytes_in": {}, "bytes_out": {}}, foreachReq.ExpandedFields)
+
+	// The subsearch of each known field runs right after the foreach.
+	newCols := make([]string, 0)
+	agg := aggs.Next
+	for ; agg != nil && agg.OutputTransforms.LetColumns != nil; agg = agg.Next {
+		newCols = append(newCols, agg.OutputTransforms.LetColumns.NewColName)
+	}
+	assert.Equal(t, []string{"bytes_in_kb", "bytes_out_kb"}, newCols)
+	assert.NotNil(t, agg.OutputTransforms.OutputColumns)
+	assert.Nil(t, agg.Next)
+
+	// After fields, only the fields which appear at runtime are handled.
+	_, aggs, err = ParseQuery(`* | fields bytes_in | foreach bytes_* [eval <<FIELD>>_kb = '<<FIELD>>' / 1024]`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	err = expandForeachCommands(aggs, knownCols, 0)
+	assert.Nil(t, err)
+	assert.True(t, aggs.Next.HasForeachBlock())
+	assert.Nil(t, aggs.Next.Next)
+	assert.Nil(t, aggs.Next.OutputTransforms.LetColumns.ForeachRequest.ExpandedFields)
+
+	subsearchAggs, err := aggs.Next.OutputTransforms.LetColumns.ForeachRequest.ParseSubsearch("eval x = 1 | where x > 0")
+	assert.Nil(t, err)
+	assert.NotNil(t, subsearchAggs)
+
+	_, aggs, err = ParseQuery(`* | foreach bytes_* [stats count by <<FIELD>>]`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	err = expandForeachCommands(aggs, knownCols, 0)
+	assert.NotNil(t, err)
+
+	_, aggs, err = ParseQuery(`* | foreach mode=multivalue bytes_in [eval total = total + <<ITEM>>]`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	err = expandForeachCommands(aggs, knownCols, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, aggs.Next)
+	assert.Equal(t, structs.ForeachMultiValue, aggs.OutputTransforms.LetColumns.ForeachRequest.Mode)
+}
+
+func Test_setSubsearchFilter(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	cols := []string{"timestamp", "ip", "count", "_index"}
+	records := []map[string]interface{}{
+		{"timestamp": uint64(1700000000000), "ip": "10.0.0.1", "count": int64(3), "_index": "threats"},
+		{"timestamp": uint64(1700000001000), "ip": "10.0.0.2", "count": nil, "_index": "threats"},
+		{"timestamp": uint64(1700000002000), "_index": "threats"},
+	}
+
+	node := &structs.ASTNode{Subsearch: &structs.SearchSubsearch{Query: "search index=threats"}}
+	err := setSubsearchFilter(node, records, cols, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, node.Subsearch)
+	assert.Nil(t, node.ExclusionFilterCondition)
+	assert.Len(t, node.AndFilterCondition.NestedNodes, 1)
+
+	// The first row matches both fields, and the second only has the ip.
+	rowsFilter := node.AndFilterCondition.NestedNodes[0]
+	assert.Len(t, rowsFilter.OrFilterCondition.NestedNodes, 1)
+	firstRowCriteria := rowsFilter.OrFilterCondition.NestedNodes[0].AndFilterCondition.FilterCriteria
+	assert.Len(t, firstRowCriteria, 2)
+	assert.Equal(t, "ip", firstRowCriteria[0].ExpressionFilter.LeftInput.Expression.LeftInput.ColumnName)
+	assert.Equal(t, "10.0.0.1", firstRowCriteria[0].ExpressionFilter.RightInput.Expression.LeftInput.ColumnValue.StringVal)
+	assert.True(t, firstRowCriteria[0].FilterIsCaseInsensitive)
+	assert.Equal(t, "count", firstRowCriteria[1].ExpressionFilter.LeftInput.Expression.LeftInput.ColumnName)
+	assert.Equal(t, int64(3), firstRowCriteria[1].ExpressionFilter.RightInput.Expression.LeftInput.ColumnValue.SignedVal)
+	assert.Len(t, rowsFilter.OrFilterCondition.FilterCriteria, 1)
+	assert.Equal(t, "10.0.0.2", rowsFilter.OrFilterCondition.FilterCriteria[0].ExpressionFilter.RightInput.Expression.LeftInput.ColumnValue.StringVal)
+
+	// The lines must match none of the rows.
+	node = &structs.ASTNode{Subsearch: &structs.SearchSubsearch{Query: "search index=threats", Negated: true}}
+	err = setSubsearchFilter(node, records[1:], cols, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, createMatchAll(0).AndFilterCondition, node.AndFilterCondition)
+	assert.Len(t, node.ExclusionFilterCondition.NestedNodes, 1)
+	assert.Equal(t, "ip", node.ExclusionFilterCondition.NestedNodes[0].AndFilterCondition.FilterCriteria[0].ExpressionFilter.LeftInput.Expression.LeftInput.ColumnName)
+
+	// Without rows, the subsearch matches nothing, and its negation matches everything.
+	node = &structs.ASTNode{Subsearch: &structs.SearchSubsearch{Query: "search index=threats"}}
+	err = setSubsearchFilter(node, records[2:], cols, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, createMatchAll(0).AndFilterCondition, node.AndFilterCondition)
+	assert.Equal(t, []*structs.ASTNode{createMatchAll(0)}, node.ExclusionFilterCondition.NestedNodes)
+
+	node = &structs.ASTNode{Subsearch: &structs.SearchSubsearch{Query: "search index=threats", Negated: true}}
+	err = setSubsearchFilter(node, nil, cols, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, createMatchAll(0).AndFilterCondition, node.AndFilterCondition)
+	assert.Nil(t, node.ExclusionFilterCondition)
+}
+
+func Test_getSubsearchJSON(t *testing.T) {
+	readJSON := map[string]interface{}{
+		"searchText": `index=web | join type=inner host [search index=hosts | fields host, owner]`,
+		"startEpoch": "now-1h",
+		"endEpoch":   "now",
+		"indexName":  "web",
+	}
+
+	_, aggs, err := ParseQuery(readJSON["searchText"].(string), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.True(t, aggs.HasJoinBlock())
+	joinReq := aggs.OutputTransforms.LetColumns.JoinRequest
+
+	// The join subsearch runs over its own index, not the one of the main search.
+	subsearchJSON, err := getSubsearchJSON(joinReq.Subsearch, joinReq.MaxOut, readJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, "hosts", subsearchJSON["indexName"])
+	assert.Equal(t, "now-1h", subsearchJSON["startEpoch"])
+	assert.Equal(t, "now", subsearchJSON["endEpoch"])
+	assert.Equal(t, joinReq.MaxOut, subsearchJSON["size"])
+
+	subsearchText := subsearchJSON["searchText"].(string)
+	assert.Equal(t, "search * | fields host, owner", subsearchText)
+	_, subsearchAggs, err := ParseQuery(subsearchText, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, subsearchAggs.OutputTransforms.OutputColumns)
+
+	// Without index terms, the subsearch runs over the indexes of the main search.
+	subsearchJSON, err = getSubsearchJSON("search status=500 | fields host", 100, readJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, "web", subsearchJSON["indexName"])
+	assert.Equal(t, "search status=500 | fields host", subsearchJSON["searchText"])
+}
+
+func Test_extractSubsearchIndexNames(t *testing.T) {
+	for _, test := range []struct {
+		searchText         string
+		expectedIndexNames string
+		expectedSearchText string
+	}{
+		{`index=hosts status=500 | stats count by host`, "hosts", "status=500 | stats count by host"},
+		{`search index=a OR index="b-2" | head 5`, "a,b-2", "search * | head 5"},
+		{`index=*`, "*", "*"},
+		{`search index=a OR index=b status=500`, "a,b", "search status=500"},
+		{`index=a AND x=1`, "a", "x=1"},
+		{`x=1 AND index=a`, "a", "x=1"},
+		{`x=1 OR index=a AND y=2`, "a", "x=1 AND y=2"},
+		{`(index=a OR index=b) status=500`, "a,b", "status=500"},
+		{`(index=a status=500) | head 1`, "a", "(status=500) | head 1"},
+		{`search (index=a) AND (x=1 OR y=2)`, "a", "search (x=1 OR y=2)"},
+		{`Index=a status=500`, "a", "status=500"},
+		{`INDEX="a" status=500`, "a", "status=500"},
+		{`index = a status=500`, "a", "status=500"},
+		{`index= a status=500`, "a", "status=500"},
+		{`index =a status=500`, "a", "status=500"},
+
+		// Only the search filter names the indexes.
+		{`status=500 | eval index=1`, "", "status=500 | eval index=1"},
+		{`"a | index=b" | head 5`, "", `"a | index=b" | head 5`},
+		{`indexes=5 OR x=1`, "", "indexes=5 OR x=1"},
+	} {
+		indexNames, searchText, err := extractSubsearchIndexNames(test.searchText)
+		assert.Nil(t, err, test.searchText)
+		assert.Equal(t, test.expectedIndexNames, indexNames, test.searchText)
+		assert.Equal(t, test.expectedSearchText, searchText, test.searchText)
+	}
+
+	for _, searchText := range []string{
+		`index!=a status=500`,
+		`index != a`,
+		`Index!="a"`,
+		`NOT index=a`,
+		`index=a NOT index=b`,
+	} {
+		_, _, err := extractSubsearchIndexNames(searchText)
+		assert.NotNil(t, err, searchText)
+	}
+}
+
+func Test_executeMultisearch_Errors(t *testing.T) {
+	readJSON := map[string]interface{}{
+		"startEpoch": "now-1h",
+		"endEpoch":   "now",
+		"indexName":  "web",
+	}
+
+	// The subsearches do not run once the query is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	multisearch := &structs.Multisearch{
+		Subsearches: []string{"search index=web status=500", "search index=db error=*"},
+		MaxOut:      100,
+	}
+	err := executeMultisearch(ctx, multisearch, readJSON, 0)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cancelled")
+	assert.Nil(t, multisearch.SubsearchRecords)
+
+	// A subsearch that fails makes multisearch fail with its error.
+	multisearch = &structs.Multisearch{
+		Subsearches: []string{"search index=web | stats count by"},
+		MaxOut:      100,
+	}
+	err = executeMultisearch(context.Background(), multisearch, readJSON, 0)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to run subsearch: search index=web | stats count by")
+	assert.Nil(t, multisearch.SubsearchRecords)
+}
+
+func Test_executeSubsearchWithTimeout(t *testing.T) {
+	readJSON := map[string]interface{}{
+		"startEpoch": "now-1h",
+		"endEpoch":   "now",
+		"indexName":  "web",
+	}
+
+	// The subsearch is cancelled once it runs longer than maxTime.
+	records, cols, err := executeSubsearchWithTimeout(context.Background(), "search index=web | fields host", 100, 0, readJSON, 0)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the subsearch did not finish within 0s")
+	assert.Nil(t, records)
+	assert.Nil(t, cols)
+
+	// The other errors of the subsearch are not reported as timeouts.
+	_, _, err = executeSubsearchWithTimeout(context.Background(), "search index=web | stats count by", 100, time.Minute, readJSON, 0)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "did not finish")
+}
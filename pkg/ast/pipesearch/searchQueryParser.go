@@ -32,6 +32,7 @@ import (
 	segment "github.com/siglens/siglens/pkg/segment"
 	"github.com/siglens/siglens/pkg/segment/aggregations"
 	"github.com/siglens/siglens/pkg/segment/query/metadata"
+	"github.com/siglens/siglens/pkg/segment/reader/record"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	. "github.com/siglens/siglens/pkg/segment/structs"
 	. "github.com/siglens/siglens/pkg/segment/utils"
@@ -66,7 +67,7 @@ func ParseRequest(searchText string, startEpoch, endEpoch uint64, qid uint64, qu
 	//aggs
 	if queryAggs != nil {
 		// if groupby request or segment stats exist, dont early exist and no sort is needed
-		if queryAggs.GroupByRequest != nil && queryAggs.StreamStatsOptions == nil {
+		if queryAggs.GroupByRequest != nil && queryAggs.StreamStatsOptions == nil && queryAggs.EventStatsOptions == nil {
 			queryAggs.GroupByRequest.BucketCount = 10_000
 			queryAggs.EarlyExit = false
 			queryAggs.Sort = nil
@@ -88,7 +89,7 @@ func ParseRequest(searchText string, startEpoch, endEpoch uint64, qid uint64, qu
 				queryAggs.TimeHistogram.StartTime = startEpoch
 				queryAggs.TimeHistogram.EndTime = endEpoch
 			}
-		} else if queryAggs.MeasureOperations != nil && queryAggs.StreamStatsOptions == nil {
+		} else if queryAggs.MeasureOperations != nil && queryAggs.StreamStatsOptions == nil && queryAggs.EventStatsOptions == nil {
 			queryAggs.EarlyExit = false
 			queryAggs.Sort = nil
 		} else {
@@ -216,11 +217,69 @@ func parsePipeSearch(searchText string, queryLanguage string, qid uint64) (*ASTN
 		return nil, nil, err
 	}
 
+	pipeCommands = moveFieldFormatsToEnd(pipeCommands)
 	updatePositionForGenEvents(pipeCommands)
+	err = parseAppendPipePipelines(pipeCommands, queryLanguage, qid)
+	if err != nil {
+		log.Errorf("qid=%d, parsePipeSearch: parseAppendPipePipelines error: %v", qid, err)
+		return nil, nil, err
+	}
 
 	return boolNode, pipeCommands, nil
 }
 
+// The pipeline of appendpipe runs over the rows that reach it, so only its pipe commands are kept,
+// and it runs as part of the query.
+func parseAppendPipePipelines(aggs *QueryAggregators, queryLanguageType string, qid uint64) error {
+	for agg := aggs; agg != nil; agg = agg.Next {
+		if agg.HasAppendPipeBlock() {
+			appendPipeReq := agg.OutputTransforms.LetColumns.AppendPipeRequest
+			_, pipelineAggs, err := parsePipeSearch("* | "+appendPipeReq.Pipeline, queryLanguageType, qid)
+			if err != nil {
+				return fmt.Errorf("parseAppendPipePipelines: failed to parse pipeline: %v, err: %v", appendPipeReq.Pipeline, err)
+			}
+			appendPipeReq.PipelineAggs = pipelineAggs
+			appendPipeReq.RunPipeline = func(pipeline *QueryAggregators, records []map[string]interface{}) ([]map[string]interface{}, []string, error) {
+				return record.RunPipelineOnRecords(pipeline, records, qid)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldformat only changes how the final results are displayed, so it runs after all the other
+// commands, keeping the order of the fieldformat commands.
+func moveFieldFormatsToEnd(aggs *QueryAggregators) *QueryAggregators {
+	var head, tail, fieldFormatsHead, fieldFormatsTail *QueryAggregators
+	for agg := aggs; agg != nil; {
+		next := agg.Next
+		agg.Next = nil
+		if agg.HasFieldFormatBlock() {
+			if fieldFormatsTail == nil {
+				fieldFormatsHead = agg
+			} else {
+				fieldFormatsTail.Next = agg
+			}
+			fieldFormatsTail = agg
+		} else {
+			if tail == nil {
+				head = agg
+			} else {
+				tail.Next = agg
+			}
+			tail = agg
+		}
+		agg = next
+	}
+
+	if tail == nil {
+		return fieldFormatsHead
+	}
+	tail.Next = fieldFormatsHead
+	return head
+}
+
 func optimizeQuery(searchNode *ast.Node, aggs *QueryAggregators) (*ast.Node, *QueryAggregators) {
 	searchNode.Simplify()
 
@@ -413,6 +472,8 @@ func SearchQueryToASTnode(node *ast.Node, boolNode *ASTNode, qid uint64, forceCa
 		}
 		boolNode.TimeRange.StartEpochMs = node.TimeModifiers.StartEpoch
 		boolNode.TimeRange.EndEpochMs = node.TimeModifiers.EndEpoch
+	case ast.NodeSubsearch:
+		boolNode.Subsearch = node.Subsearch
 	default:
 		log.Errorf("SearchQueryToASTnode: node type %d not supported", node.NodeType)
 		return errors.New("SearchQueryToASTnode: node type not supported")
@@ -472,6 +533,7 @@ func searchPipeCommandsToASTnode(node *QueryAggregators, qid uint64) (*QueryAggr
 	}
 	pipeCommands.StatsOptions = node.StatsOptions
 	pipeCommands.StreamStatsOptions = node.StreamStatsOptions
+	pipeCommands.EventStatsOptions = node.EventStatsOptions
 	if node.Next != nil {
 		pipeCommands.Next, err = searchPipeCommandsToASTnode(node.Next, qid)
 
@@ -535,6 +597,15 @@ func parseGenerateCmd(node *structs.GenerateEvent, qid uint64) (*QueryAggregator
 	if node.InputLookup != nil {
 		aggNode.GenerateEvent.InputLookup = node.InputLookup
 	}
+	if node.MakeResults != nil {
+		aggNode.GenerateEvent.MakeResults = node.MakeResults
+	}
+	if node.Multisearch != nil {
+		aggNode.GenerateEvent.Multisearch = node.Multisearch
+	}
+	if node.TStats != nil {
+		aggNode.GenerateEvent.TStats = node.TStats
+	}
 
 	return aggNode, nil
 }
@@ -667,6 +738,69 @@ func parseColumnsCmd(node *structs.OutputTransforms, qid uint64) (*QueryAggregat
 		if node.LetColumns.AppendRequest != nil {
 			aggNode.OutputTransforms.LetColumns.AppendRequest = node.LetColumns.AppendRequest
 		}
+		if node.LetColumns.JoinRequest != nil {
+			aggNode.OutputTransforms.LetColumns.JoinRequest = node.LetColumns.JoinRequest
+		}
+		if node.LetColumns.LookupRequest != nil {
+			aggNode.OutputTransforms.LetColumns.LookupRequest = node.LetColumns.LookupRequest
+		}
+		if node.LetColumns.ChartRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ChartRequest = node.LetColumns.ChartRequest
+		}
+		if node.LetColumns.ReshapeRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ReshapeRequest = node.LetColumns.ReshapeRequest
+		}
+		if node.LetColumns.OutputLookupRequest != nil {
+			aggNode.OutputTransforms.LetColumns.OutputLookupRequest = node.LetColumns.OutputLookupRequest
+		}
+		if node.LetColumns.AddTotalsRequest != nil {
+			aggNode.OutputTransforms.LetColumns.AddTotalsRequest = node.LetColumns.AddTotalsRequest
+		}
+		if node.LetColumns.SequentialRequest != nil {
+			aggNode.OutputTransforms.LetColumns.SequentialRequest = node.LetColumns.SequentialRequest
+		}
+		if node.LetColumns.ReverseRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ReverseRequest = node.LetColumns.ReverseRequest
+		}
+		if node.LetColumns.IPLocationRequest != nil {
+			aggNode.OutputTransforms.LetColumns.IPLocationRequest = node.LetColumns.IPLocationRequest
+		}
+		if node.LetColumns.GeoStatsRequest != nil {
+			aggNode.OutputTransforms.LetColumns.GeoStatsRequest = node.LetColumns.GeoStatsRequest
+		}
+		if node.LetColumns.PredictRequest != nil {
+			aggNode.OutputTransforms.LetColumns.PredictRequest = node.LetColumns.PredictRequest
+		}
+		if node.LetColumns.AnomalyRequest != nil {
+			aggNode.OutputTransforms.LetColumns.AnomalyRequest = node.LetColumns.AnomalyRequest
+		}
+		if node.LetColumns.ClusterRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ClusterRequest = node.LetColumns.ClusterRequest
+		}
+		if node.LetColumns.ForeachRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ForeachRequest = node.LetColumns.ForeachRequest
+		}
+		if node.LetColumns.AppendColsRequest != nil {
+			aggNode.OutputTransforms.LetColumns.AppendColsRequest = node.LetColumns.AppendColsRequest
+		}
+		if node.LetColumns.AppendPipeRequest != nil {
+			aggNode.OutputTransforms.LetColumns.AppendPipeRequest = node.LetColumns.AppendPipeRequest
+		}
+		if node.LetColumns.ConvertRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ConvertRequest = node.LetColumns.ConvertRequest
+		}
+		if node.LetColumns.FieldFormatRequest != nil {
+			aggNode.OutputTransforms.LetColumns.FieldFormatRequest = node.LetColumns.FieldFormatRequest
+		}
+		if node.LetColumns.RangeMapRequest != nil {
+			aggNode.OutputTransforms.LetColumns.RangeMapRequest = node.LetColumns.RangeMapRequest
+		}
+		if node.LetColumns.FieldSummaryRequest != nil {
+			aggNode.OutputTransforms.LetColumns.FieldSummaryRequest = node.LetColumns.FieldSummaryRequest
+		}
+		if node.LetColumns.ExtractRequest != nil {
+			aggNode.OutputTransforms.LetColumns.ExtractRequest = node.LetColumns.ExtractRequest
+		}
 	}
 	if node.FilterRows != nil {
 		aggNode.OutputTransforms.FilterRows = node.FilterRows
@@ -727,6 +861,10 @@ func parseORCondition(node *ast.Node, boolNode *ASTNode, qid uint64, forceCaseSe
 			boolNode.OrFilterCondition.JoinCondition(filtercond)
 		}
 		return nil
+	case ast.NodeSubsearch:
+		qsSubNode.Subsearch = node.Subsearch
+		boolNode.OrFilterCondition.NestedNodes = append(boolNode.OrFilterCondition.NestedNodes, qsSubNode)
+		return nil
 	default:
 		log.Errorf("parseORCondition: node type %d not supported", node.NodeType)
 		return errors.New("parseORCondition: node type not supported")
@@ -784,6 +922,10 @@ func parseANDCondition(node *ast.Node, boolNode *ASTNode, qid uint64, forceCaseS
 		boolNode.TimeRange.StartEpochMs = node.TimeModifiers.StartEpoch
 		boolNode.TimeRange.EndEpochMs = node.TimeModifiers.EndEpoch
 		return nil
+	case ast.NodeSubsearch:
+		qsSubNode.Subsearch = node.Subsearch
+		boolNode.AndFilterCondition.NestedNodes = append(boolNode.AndFilterCondition.NestedNodes, qsSubNode)
+		return nil
 	default:
 		log.Errorf("parseANDCondition: node type %d not supported", node.NodeType)
 		return errors.New("parseANDCondition: node type not supported")
@@ -791,9 +933,9 @@ func parseANDCondition(node *ast.Node, boolNode *ASTNode, qid uint64, forceCaseS
 }
 
 func GetFinalSizelimit(aggs *QueryAggregators, sizeLimit uint64) uint64 {
-	if aggs != nil && (aggs.GroupByRequest != nil || aggs.MeasureOperations != nil) && aggs.StreamStatsOptions == nil {
+	if aggs != nil && (aggs.GroupByRequest != nil || aggs.MeasureOperations != nil) && aggs.StreamStatsOptions == nil && aggs.EventStatsOptions == nil {
 		sizeLimit = 0
-	} else if aggs.HasDedupBlockInChain() || aggs.HasSortBlockInChain() || aggs.HasGroupByOrMeasureAggsInChain() || aggs.HasTransactionArgumentsInChain() || aggs.HasTailInChain() || aggs.HasBinInChain() || aggs.HasStreamStatsInChain() || aggs.HasGenerateEvent() {
+	} else if aggs.HasDedupBlockInChain() || aggs.HasSortBlockInChain() || aggs.HasGroupByOrMeasureAggsInChain() || aggs.HasTransactionArgumentsInChain() || aggs.HasTailInChain() || aggs.HasBinInChain() || aggs.HasStreamStatsInChain() || aggs.HasEventStatsInChain() || aggs.HasGenerateEvent() || aggs.HasJoinInChain() || aggs.HasReshapeInChain() || aggs.HasOutputLookupInChain() || aggs.HasAddColTotalsInChain() || aggs.HasSequentialInChain() || aggs.HasReverseInChain() || aggs.HasPredictInChain() || aggs.HasAnomalyInChain() || aggs.HasClusterInChain() || aggs.HasAppendColsInChain() || aggs.HasAppendPipeInChain() || aggs.HasFieldSummaryInChain() {
 		// 1. Dedup needs state information about the previous records, so we can
 		// run into an issue if we show some records, then the user scrolls
 		// down to see more and we run dedup on just the new records and add
@@ -803,6 +945,16 @@ func GetFinalSizelimit(aggs *QueryAggregators, sizeLimit uint64) uint64 {
 		// 2. Sort cmd is similar to Dedup cmd; we need to process all the records at once and extract those with top/rare priority based on requirements.
 		// 3. If there's a Rex block in the chain followed by a Stats block, we need to
 		// see all the matched records before we apply or calculate the stats.
+		// 4. Xyseries, untable and transpose reshape all the records together.
+		// 5. Outputlookup writes all the records to the lookup file, not only the ones shown.
+		// 6. The column totals of addtotals and addcoltotals are the totals of all the records.
+		// 7. Delta, accum and autoregress, like streamstats, depend on all the records before each record.
+		// 8. Reverse needs the last record to know which one comes first.
+		// 9. Predict fits its model to all the records and forecasts the ones after the last.
+		// 10. Anomalydetection and outlier compare each record to the statistics of all the records.
+		// 11. Cluster compares each record to the clusters of all the records before it.
+		// 12. Appendcols and appendpipe add their rows after all the records.
+		// 13. Fieldsummary summarizes all the records.
 		sizeLimit = math.MaxUint64
 	}
 
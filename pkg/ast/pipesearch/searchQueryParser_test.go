@@ -63,3 +63,22 @@ func Test_optimizeStatsEvalQueries(t *testing.T) {
 		`(A=1 OR NOT B=2) AND foo=42 | stats count(eval(foo=42))`,
 	)
 }
+
+func Test_parseAppendPipePipelines(t *testing.T) {
+	query := `index=web | stats count by host | appendpipe [stats sum(count) as count | eval host="total"]`
+	_, aggs, err := ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	appendPipeReq := aggs.Next.OutputTransforms.LetColumns.AppendPipeRequest
+	assert.NotNil(t, appendPipeReq.PipelineAggs)
+	assert.NotNil(t, appendPipeReq.RunPipeline)
+
+	// The pipeline runs over the rows that reach appendpipe.
+	rows := []map[string]interface{}{
+		{"host": "web1", "count": int64(3)},
+		{"host": "web2", "count": int64(4)},
+	}
+	records, cols, err := appendPipeReq.RunPipeline(appendPipeReq.PipelineAggs, rows)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"host": "total", "count": "7"}}, records)
+	assert.Equal(t, []string{"count", "host"}, cols)
+}
@@ -27,6 +27,7 @@ import (
 	fileutils "github.com/siglens/siglens/pkg/common/fileutils"
 	rutils "github.com/siglens/siglens/pkg/readerUtils"
 	"github.com/siglens/siglens/pkg/segment"
+	"github.com/siglens/siglens/pkg/segment/aggregations"
 	"github.com/siglens/siglens/pkg/segment/query"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	segutils "github.com/siglens/siglens/pkg/segment/utils"
@@ -130,6 +131,42 @@ func ProcessPipeSearchWebsocket(conn *websocket.Conn, orgid uint64, ctx *fasthtt
 		}
 		return
 	}
+	// The sorts write their records to disk when they run out of memory, so the files are
+	// removed however the query ends.
+	defer aggregations.RemoveSortSpillFilesInChain(aggs)
+
+	err = executeFilterSubsearches(ctx, simpleNode, event, orgid, qid)
+	if err != nil {
+		log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to run subsearches of the search filter, err: %v", qid, err)
+		wErr := conn.WriteJSON(createErrorResponse(err.Error()))
+		if wErr != nil {
+			log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to write error response to websocket! err: %+v", qid, wErr)
+		}
+		return
+	}
+
+	err = executeSubsearches(ctx, aggs, event, orgid)
+	if err != nil {
+		log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to run subsearches, err: %v", qid, err)
+		wErr := conn.WriteJSON(createErrorResponse(err.Error()))
+		if wErr != nil {
+			log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to write error response to websocket! err: %+v", qid, wErr)
+		}
+		return
+	}
+
+	if aggs.HasForeachInChain() {
+		knownCols := getKnownColumns(ti.GetQueryTables(), simpleNode.TimeRange, orgid)
+		err = expandForeachCommands(aggs, knownCols, qid)
+		if err != nil {
+			log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to expand foreach commands, err: %v", qid, err)
+			wErr := conn.WriteJSON(createErrorResponse(err.Error()))
+			if wErr != nil {
+				log.Errorf("qid=%d, ProcessPipeSearchWebsocket: failed to write error response to websocket! err: %+v", qid, wErr)
+			}
+			return
+		}
+	}
 
 	if queryLanguageType == "SQL" && aggs != nil && aggs.TableName != "*" {
 		indexNameIn = aggs.TableName
@@ -337,6 +374,12 @@ func processCompleteUpdate(conn *websocket.Conn, sizeLimit, qid uint64, aggs *st
 		IsTimechart:         aggs.UsedByTimechart(),
 		ColumnsOrder:        columnsOrder,
 	}
+	warnings, err := query.GetQueryWarnings(qid)
+	if err != nil {
+		log.Errorf("qid=%d, processCompleteUpdate: failed to get the warnings of the query! Error: %v", qid, err)
+	} else {
+		resp.Warnings = warnings
+	}
 	searchErrors, err := query.GetUniqueSearchErrors(qid)
 	if err != nil {
 		log.Errorf("qid=%d, processCompleteUpdate: failed to get search Errors for qid! Error: %v", qid, err)
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"regexp"
@@ -32,6 +33,7 @@ import (
 	"github.com/siglens/siglens/pkg/ast"
 	"github.com/siglens/siglens/pkg/ast/pipesearch"
 	"github.com/siglens/siglens/pkg/ast/spl"
+	"github.com/siglens/siglens/pkg/config"
 	segquery "github.com/siglens/siglens/pkg/segment/query"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	"github.com/siglens/siglens/pkg/segment/utils"
@@ -11146,3 +11148,1509 @@ func Test_Append_2(t *testing.T) {
 	assert.Equal(t, "bar", strings.Trim(subSearch.Comparison.Values.(string), `"`))
 
 }
+
+func Test_Join_1(t *testing.T) {
+	query := `index=auth | join user_id [ search index=orders | fields user_id, total ]`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Equal(t, structs.OutputTransformType, aggregator.PipeCommandType)
+	assert.NotNil(t, aggregator.OutputTransforms)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns)
+
+	joinRequest := aggregator.OutputTransforms.LetColumns.JoinRequest
+	assert.NotNil(t, joinRequest)
+	assert.Equal(t, structs.JoinInner, joinRequest.JoinType)
+	assert.Equal(t, uint64(1), joinRequest.Max)
+	assert.Equal(t, []string{"user_id"}, joinRequest.FieldList)
+	assert.Equal(t, "search index=orders | fields user_id, total", joinRequest.Subsearch)
+	assert.True(t, aggregator.HasJoinInChain())
+}
+
+func Test_Join_2(t *testing.T) {
+	query := `index=auth | join type=left max=0 user_id, host [search index=orders | stats count AS orders BY user_id, host]`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+
+	joinRequest := aggregator.OutputTransforms.LetColumns.JoinRequest
+	assert.NotNil(t, joinRequest)
+	assert.Equal(t, structs.JoinLeft, joinRequest.JoinType)
+	assert.Equal(t, uint64(0), joinRequest.Max)
+	assert.Equal(t, []string{"user_id", "host"}, joinRequest.FieldList)
+	assert.Equal(t, "search index=orders | stats count AS orders BY user_id, host", joinRequest.Subsearch)
+}
+
+func Test_Join_3(t *testing.T) {
+	query := `index=auth | join type=outer max=3 user_id [search index=orders] | fields user_id, total`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+
+	joinRequest := aggregator.OutputTransforms.LetColumns.JoinRequest
+	assert.NotNil(t, joinRequest)
+	assert.Equal(t, structs.JoinLeft, joinRequest.JoinType)
+	assert.Equal(t, uint64(3), joinRequest.Max)
+	assert.Equal(t, "search index=orders", joinRequest.Subsearch)
+	assert.NotNil(t, aggregator.Next)
+	assert.NotNil(t, aggregator.Next.OutputTransforms.OutputColumns)
+}
+
+func Test_Join_4(t *testing.T) {
+	// Invalid join type.
+	query := `index=auth | join type=right user_id [search index=orders]`
+	_, err := spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	// Missing subsearch.
+	query = `index=auth | join user_id`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	// Missing join fields.
+	query = `index=auth | join [search index=orders]`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Lookup_1(t *testing.T) {
+	query := `index=web | lookup owners.csv host OUTPUT team, owner`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Equal(t, structs.OutputTransformType, aggregator.PipeCommandType)
+	assert.NotNil(t, aggregator.OutputTransforms)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns)
+
+	lookupRequest := aggregator.OutputTransforms.LetColumns.LookupRequest
+	assert.NotNil(t, lookupRequest)
+	assert.Equal(t, "owners.csv", lookupRequest.Filename)
+	assert.Equal(t, []*structs.LookupFieldMapping{{LookupField: "host", EventField: "host"}}, lookupRequest.MatchFields)
+	assert.Equal(t, []*structs.LookupFieldMapping{
+		{LookupField: "team", EventField: "team"},
+		{LookupField: "owner", EventField: "owner"},
+	}, lookupRequest.OutputFields)
+	assert.False(t, lookupRequest.OutputNew)
+}
+
+func Test_Lookup_2(t *testing.T) {
+	query := `index=web | lookup "owners.csv.gz" hostname AS host, env OUTPUTNEW owner AS host_owner team`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+
+	lookupRequest := aggregator.OutputTransforms.LetColumns.LookupRequest
+	assert.NotNil(t, lookupRequest)
+	assert.Equal(t, "owners.csv.gz", lookupRequest.Filename)
+	assert.Equal(t, []*structs.LookupFieldMapping{
+		{LookupField: "hostname", EventField: "host"},
+		{LookupField: "env", EventField: "env"},
+	}, lookupRequest.MatchFields)
+	assert.Equal(t, []*structs.LookupFieldMapping{
+		{LookupField: "owner", EventField: "host_owner"},
+		{LookupField: "team", EventField: "team"},
+	}, lookupRequest.OutputFields)
+	assert.True(t, lookupRequest.OutputNew)
+}
+
+func Test_Lookup_3(t *testing.T) {
+	query := `index=web | lookup owners host | stats count BY team`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+
+	lookupRequest := aggregator.OutputTransforms.LetColumns.LookupRequest
+	assert.NotNil(t, lookupRequest)
+	assert.Equal(t, "owners", lookupRequest.Filename)
+	assert.Equal(t, []*structs.LookupFieldMapping{{LookupField: "host", EventField: "host"}}, lookupRequest.MatchFields)
+	assert.Nil(t, lookupRequest.OutputFields)
+	assert.NotNil(t, aggregator.Next)
+}
+
+func Test_Lookup_Invalid(t *testing.T) {
+	queries := []string{
+		`index=web | lookup owners.csv`,
+		`index=web | lookup owners.csv host OUTPUT`,
+		`index=web | lookup owners.csv host* OUTPUT team`,
+		`index=web | lookup owners.csv host OUTPUT team AS`,
+	}
+
+	for _, query := range queries {
+		_, err := spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_EventStats_1(t *testing.T) {
+	query := `index=web | eventstats avg(latency) AS avg_latency, count BY host`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.NotNil(t, aggregator.EventStatsOptions)
+	assert.Nil(t, aggregator.StreamStatsOptions)
+	assert.True(t, aggregator.HasEventStatsInChain())
+	assert.False(t, aggregator.HasStatsBlock())
+
+	assert.NotNil(t, aggregator.GroupByRequest)
+	assert.Equal(t, []string{"host"}, aggregator.GroupByRequest.GroupByColumns)
+	assert.Equal(t, 2, len(aggregator.GroupByRequest.MeasureOperations))
+	assert.Equal(t, utils.Avg, aggregator.GroupByRequest.MeasureOperations[0].MeasureFunc)
+	assert.Equal(t, "latency", aggregator.GroupByRequest.MeasureOperations[0].MeasureCol)
+	assert.Equal(t, utils.Count, aggregator.GroupByRequest.MeasureOperations[1].MeasureFunc)
+
+	assert.NotNil(t, aggregator.Next)
+	assert.NotNil(t, aggregator.Next.OutputTransforms)
+	assert.Equal(t, "avg_latency", aggregator.Next.OutputTransforms.OutputColumns.RenameAggregationColumns["avg(latency)"])
+}
+
+func Test_EventStats_2(t *testing.T) {
+	query := `index=web | eventstats max(bytes) | where bytes=max(bytes)`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.NotNil(t, aggregator.EventStatsOptions)
+	assert.Nil(t, aggregator.GroupByRequest)
+	assert.Equal(t, 1, len(aggregator.MeasureOperations))
+	assert.Equal(t, utils.Max, aggregator.MeasureOperations[0].MeasureFunc)
+	assert.Equal(t, "bytes", aggregator.MeasureOperations[0].MeasureCol)
+	assert.NotNil(t, aggregator.Next)
+}
+
+func Test_EventStats_UnsupportedFunction(t *testing.T) {
+	query := `index=web | eventstats perc95(latency) by host`
+	_, err := spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "function perc is not supported by eventstats, the supported functions are: count, sum, avg, min, max, range, cardinality, values")
+}
+
+func Test_Chart_1(t *testing.T) {
+	query := `index=web | chart count, avg(latency) AS avg_latency over host by status limit=5 useother=false`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Equal(t, structs.GroupByType, aggregator.PipeCommandType)
+	assert.Equal(t, []string{"host", "status"}, aggregator.GroupByRequest.GroupByColumns)
+	assert.Equal(t, 2, len(aggregator.GroupByRequest.MeasureOperations))
+	assert.Equal(t, utils.Count, aggregator.GroupByRequest.MeasureOperations[0].MeasureFunc)
+	assert.Equal(t, utils.Avg, aggregator.GroupByRequest.MeasureOperations[1].MeasureFunc)
+	assert.Equal(t, "avg_latency", aggregator.GroupByRequest.MeasureOperations[1].String())
+
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, structs.OutputTransformType, aggregator.Next.PipeCommandType)
+	chartReq := aggregator.Next.OutputTransforms.LetColumns.ChartRequest
+	assert.NotNil(t, chartReq)
+	assert.Equal(t, "host", chartReq.OverField)
+	assert.Equal(t, "status", chartReq.Timechart.ByField)
+	assert.Equal(t, 5, chartReq.Timechart.LimitExpr.Num)
+	assert.True(t, chartReq.Timechart.LimitExpr.IsTop)
+	assert.Equal(t, structs.LimitScoreMode(structs.LSMByFreq), chartReq.Timechart.LimitExpr.LimitScoreMode)
+	assert.False(t, chartReq.Timechart.TcOptions.UseOther)
+	assert.True(t, chartReq.Timechart.TcOptions.UseNull)
+}
+
+func Test_Chart_2(t *testing.T) {
+	query := `index=web | chart sum(bytes) by host, status usenull=false otherstr=rest`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Equal(t, []string{"host", "status"}, aggregator.GroupByRequest.GroupByColumns)
+
+	chartReq := aggregator.Next.OutputTransforms.LetColumns.ChartRequest
+	assert.NotNil(t, chartReq)
+	assert.Equal(t, "host", chartReq.OverField)
+	assert.Equal(t, "status", chartReq.Timechart.ByField)
+	assert.Equal(t, 10, chartReq.Timechart.LimitExpr.Num)
+	assert.Equal(t, structs.LimitScoreMode(structs.LSMBySum), chartReq.Timechart.LimitExpr.LimitScoreMode)
+	assert.False(t, chartReq.Timechart.TcOptions.UseNull)
+	assert.True(t, chartReq.Timechart.TcOptions.UseOther)
+	assert.Equal(t, "rest", chartReq.Timechart.TcOptions.OtherStr)
+}
+
+func Test_Chart_3(t *testing.T) {
+	query := `index=web | chart over host by status`
+	_, err := spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `index=web | chart max(latency) by host`
+	_, err = spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, structs.GroupByType, aggregator.PipeCommandType)
+	assert.Equal(t, []string{"host"}, aggregator.GroupByRequest.GroupByColumns)
+	assert.Nil(t, aggregator.Next)
+
+	query = `index=web | chart count`
+	astNode, aggregator, err = pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, structs.MeasureAggsType, aggregator.PipeCommandType)
+	assert.Equal(t, 1, len(aggregator.MeasureOperations))
+
+	query = `index=web | chart count by host status limit=3`
+	astNode, aggregator, err = pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, []string{"host", "status"}, aggregator.GroupByRequest.GroupByColumns)
+	assert.Equal(t, 3, aggregator.Next.OutputTransforms.LetColumns.ChartRequest.Timechart.LimitExpr.Num)
+
+	query = `index=web | chart count by host limit=3`
+	astNode, aggregator, err = pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, []string{"host"}, aggregator.GroupByRequest.GroupByColumns)
+}
+
+func Test_Chart_4(t *testing.T) {
+	query := `index=web | chart count over host by status limit=0`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	chartReq := aggregator.Next.OutputTransforms.LetColumns.ChartRequest
+	assert.NotNil(t, chartReq)
+	assert.Nil(t, chartReq.Timechart.LimitExpr)
+
+	query = `index=web | chart count over host* by status`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_XYSeries(t *testing.T) {
+	query := `index=web | stats count by host, status | xyseries host status count`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, structs.OutputTransformType, aggregator.Next.PipeCommandType)
+	reshapeReq := aggregator.Next.OutputTransforms.LetColumns.ReshapeRequest
+	assert.NotNil(t, reshapeReq)
+	assert.Equal(t, structs.ReshapeXYSeries, reshapeReq.ReshapeType)
+	assert.Equal(t, "host", reshapeReq.XField)
+	assert.Equal(t, "status", reshapeReq.YNameField)
+	assert.Equal(t, []string{"count"}, reshapeReq.YDataFields)
+	assert.Equal(t, ": ", reshapeReq.Sep)
+
+	query = `index=web | stats count, avg(latency) AS lat by host, status | xyseries host status count, lat sep="-"`
+	astNode, aggregator, err = pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	// The rename of the aggregation comes before the xyseries.
+	reshapeReq = aggregator.Next.Next.OutputTransforms.LetColumns.ReshapeRequest
+	assert.Equal(t, []string{"count", "lat"}, reshapeReq.YDataFields)
+	assert.Equal(t, "-", reshapeReq.Sep)
+
+	query = `index=web | xyseries host status`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `index=web | xyseries host status count*`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Untable(t *testing.T) {
+	query := `index=web | untable host status count`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, structs.OutputTransformType, aggregator.PipeCommandType)
+	reshapeReq := aggregator.OutputTransforms.LetColumns.ReshapeRequest
+	assert.NotNil(t, reshapeReq)
+	assert.Equal(t, structs.ReshapeUntable, reshapeReq.ReshapeType)
+	assert.Equal(t, "host", reshapeReq.XField)
+	assert.Equal(t, "status", reshapeReq.YNameField)
+	assert.Equal(t, []string{"count"}, reshapeReq.YDataFields)
+
+	query = `index=web | untable host status`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Transpose(t *testing.T) {
+	query := `index=web | stats count by host | transpose`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	reshapeReq := aggregator.Next.OutputTransforms.LetColumns.ReshapeRequest
+	assert.NotNil(t, reshapeReq)
+	assert.Equal(t, structs.ReshapeTranspose, reshapeReq.ReshapeType)
+	assert.Equal(t, uint64(5), reshapeReq.NumRows)
+	assert.Equal(t, "column", reshapeReq.ColumnName)
+	assert.Equal(t, "", reshapeReq.HeaderField)
+	assert.True(t, reshapeReq.IncludeEmpty)
+
+	query = `index=web | stats count by host | transpose 10 column_name="field" header_field=host include_empty=false`
+	astNode, aggregator, err = pipesearch.ParseQuery(string(query), 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	reshapeReq = aggregator.Next.OutputTransforms.LetColumns.ReshapeRequest
+	assert.Equal(t, uint64(10), reshapeReq.NumRows)
+	assert.Equal(t, "field", reshapeReq.ColumnName)
+	assert.Equal(t, "host", reshapeReq.HeaderField)
+	assert.False(t, reshapeReq.IncludeEmpty)
+
+	query = `index=web | transpose include_empty=maybe`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_MakeResults(t *testing.T) {
+	query := `| makeresults`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Nil(t, aggregator.Next)
+	assert.Equal(t, structs.GenerateEventType, aggregator.PipeCommandType)
+	assert.NotNil(t, aggregator.GenerateEvent.MakeResults)
+	assert.Equal(t, uint64(1), aggregator.GenerateEvent.MakeResults.Count)
+	assert.False(t, aggregator.GenerateEvent.MakeResults.Annotate)
+}
+
+func Test_MakeResults_2(t *testing.T) {
+	query := `| makeresults count=5 annotate=true | eval x=1 | stats count by x`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.Equal(t, structs.GenerateEventType, aggregator.PipeCommandType)
+	assert.Equal(t, uint64(5), aggregator.GenerateEvent.MakeResults.Count)
+	assert.True(t, aggregator.GenerateEvent.MakeResults.Annotate)
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, structs.OutputTransformType, aggregator.Next.PipeCommandType)
+	assert.NotNil(t, aggregator.Next.Next)
+	assert.Equal(t, structs.GroupByType, aggregator.Next.Next.PipeCommandType)
+}
+
+func Test_MakeResults_3(t *testing.T) {
+	query := `| makeresults count=2 count=3`
+	_, err := spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `| makeresults count=-1`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `| makeresults annotate=yes`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `| makeresults count=100001`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `| makeresults count=18446744073709551615`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_OutputLookup(t *testing.T) {
+	query := `index=web status=403 | stats count by ip | outputlookup blocked_ips.csv`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, structs.OutputTransformType, aggregator.Next.PipeCommandType)
+	outputLookupReq := aggregator.Next.OutputTransforms.LetColumns.OutputLookupRequest
+	assert.NotNil(t, outputLookupReq)
+	assert.Equal(t, "blocked_ips.csv", outputLookupReq.Filename)
+	assert.False(t, outputLookupReq.Append)
+	assert.True(t, outputLookupReq.CreateEmpty)
+}
+
+func Test_OutputLookup_2(t *testing.T) {
+	query := `index=web | fields ip | outputlookup append=true blocked_ips.csv.gz create_empty=false`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	outputLookupReq := aggregator.Next.OutputTransforms.LetColumns.OutputLookupRequest
+	assert.NotNil(t, outputLookupReq)
+	assert.Equal(t, "blocked_ips.csv.gz", outputLookupReq.Filename)
+	assert.True(t, outputLookupReq.Append)
+	assert.False(t, outputLookupReq.CreateEmpty)
+
+	query = `index=web | outputlookup "blocked ips" append=true | eval x=1`
+	astNode, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	outputLookupReq = aggregator.OutputTransforms.LetColumns.OutputLookupRequest
+	assert.Equal(t, "blocked ips", outputLookupReq.Filename)
+	assert.True(t, outputLookupReq.Append)
+	assert.NotNil(t, aggregator.Next)
+}
+
+func Test_OutputLookup_3(t *testing.T) {
+	query := `index=web | outputlookup append=true`
+	_, err := spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `index=web | outputlookup append=true blocked.csv append=false`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_AddTotals(t *testing.T) {
+	query := `index=billing | stats sum(cost) AS cost, sum(tax) AS tax by customer | addtotals`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	addTotalsReq := aggregator.Next.Next.OutputTransforms.LetColumns.AddTotalsRequest
+	assert.NotNil(t, addTotalsReq)
+	assert.True(t, addTotalsReq.Row)
+	assert.False(t, addTotalsReq.Col)
+	assert.Equal(t, "Total", addTotalsReq.FieldName)
+	assert.Equal(t, "", addTotalsReq.LabelField)
+	assert.Equal(t, "Total", addTotalsReq.Label)
+	assert.Nil(t, addTotalsReq.FieldList)
+}
+
+func Test_AddTotals_2(t *testing.T) {
+	query := `index=billing | addtotals row=false col=true fieldname=sum labelfield=customer label="All customers" cost tax_*`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, structs.OutputTransformType, aggregator.PipeCommandType)
+	addTotalsReq := aggregator.OutputTransforms.LetColumns.AddTotalsRequest
+	assert.NotNil(t, addTotalsReq)
+	assert.False(t, addTotalsReq.Row)
+	assert.True(t, addTotalsReq.Col)
+	assert.Equal(t, "sum", addTotalsReq.FieldName)
+	assert.Equal(t, "customer", addTotalsReq.LabelField)
+	assert.Equal(t, "All customers", addTotalsReq.Label)
+	assert.Equal(t, []string{"cost", "tax_*"}, addTotalsReq.FieldList)
+
+	query = `index=billing | addtotals row=true row=false`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `index=billing | addtotals col=maybe`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_AddColTotals(t *testing.T) {
+	query := `index=billing | addcoltotals labelfield=customer cost`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	addTotalsReq := aggregator.OutputTransforms.LetColumns.AddTotalsRequest
+	assert.NotNil(t, addTotalsReq)
+	assert.False(t, addTotalsReq.Row)
+	assert.True(t, addTotalsReq.Col)
+	assert.Equal(t, "customer", addTotalsReq.LabelField)
+	assert.Equal(t, "Total", addTotalsReq.Label)
+	assert.Equal(t, []string{"cost"}, addTotalsReq.FieldList)
+
+	query = `index=billing | addcoltotals`
+	_, err = spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	query = `index=billing | addcoltotals row=true`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Delta(t *testing.T) {
+	query := `index=web | delta bytes AS bytes_change p=2`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	seqReq := aggregator.OutputTransforms.LetColumns.SequentialRequest
+	assert.NotNil(t, seqReq)
+	assert.Equal(t, structs.SequentialDelta, seqReq.CmdType)
+	assert.Equal(t, "bytes", seqReq.Field)
+	assert.Equal(t, "bytes_change", seqReq.NewField)
+	assert.Equal(t, uint64(2), seqReq.PStart)
+	assert.Equal(t, uint64(2), seqReq.PEnd)
+
+	query = `index=web | delta bytes`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	seqReq = aggregator.OutputTransforms.LetColumns.SequentialRequest
+	assert.Equal(t, "delta(bytes)", seqReq.NewField)
+	assert.Equal(t, uint64(1), seqReq.PEnd)
+
+	query = `index=web | delta bytes p=0`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Accum(t *testing.T) {
+	query := `index=web | accum bytes`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	seqReq := aggregator.OutputTransforms.LetColumns.SequentialRequest
+	assert.NotNil(t, seqReq)
+	assert.Equal(t, structs.SequentialAccum, seqReq.CmdType)
+	assert.Equal(t, "bytes", seqReq.Field)
+	assert.Equal(t, "bytes", seqReq.NewField)
+
+	query = `index=web | accum bytes as total_bytes`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, "total_bytes", aggregator.OutputTransforms.LetColumns.SequentialRequest.NewField)
+}
+
+func Test_Autoregress(t *testing.T) {
+	query := `index=web | autoregress bytes p=2-4`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	seqReq := aggregator.OutputTransforms.LetColumns.SequentialRequest
+	assert.NotNil(t, seqReq)
+	assert.Equal(t, structs.SequentialAutoregress, seqReq.CmdType)
+	assert.Equal(t, "bytes", seqReq.Field)
+	assert.Equal(t, "", seqReq.NewField)
+	assert.Equal(t, uint64(2), seqReq.PStart)
+	assert.Equal(t, uint64(4), seqReq.PEnd)
+
+	query = `index=web | autoregress bytes AS prev_bytes`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	seqReq = aggregator.OutputTransforms.LetColumns.SequentialRequest
+	assert.Equal(t, "prev_bytes", seqReq.NewField)
+	assert.Equal(t, uint64(1), seqReq.PStart)
+	assert.Equal(t, uint64(1), seqReq.PEnd)
+
+	query = `index=web | autoregress bytes AS prev_bytes p=1-3`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+
+	query = `index=web | autoregress bytes p=3-1`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_Reverse(t *testing.T) {
+	query := `index=web | reverse`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns.ReverseRequest)
+
+	query = `index=web | sort -bytes | reverse | head 5`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns.SortColRequest)
+	assert.NotNil(t, aggregator.Next.OutputTransforms.LetColumns.ReverseRequest)
+}
+
+func Test_sortWithLimit(t *testing.T) {
+	query := `index=web | sort limit=5 ip(src), -num(bytes)`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	sortExpr := aggregator.OutputTransforms.LetColumns.SortColRequest
+	assert.NotNil(t, sortExpr)
+	assert.Equal(t, uint64(5), sortExpr.Limit)
+	assert.Equal(t, []int{1, -1}, sortExpr.SortAscending)
+	assert.Equal(t, "ip", sortExpr.SortEles[0].Op)
+	assert.Equal(t, "src", sortExpr.SortEles[0].Field)
+	assert.Equal(t, "num", sortExpr.SortEles[1].Op)
+	assert.Equal(t, "bytes", sortExpr.SortEles[1].Field)
+
+	query = `index=web | sort 0 auto(bytes)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	sortExpr = aggregator.OutputTransforms.LetColumns.SortColRequest
+	assert.Equal(t, uint64(math.MaxUint64), sortExpr.Limit)
+	assert.Equal(t, "auto", sortExpr.SortEles[0].Op)
+
+	query = `index=web | sort bytes`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10000), aggregator.OutputTransforms.LetColumns.SortColRequest.Limit)
+}
+
+func Test_IPLocation(t *testing.T) {
+	query := `index=firewall | iplocation prefix=src_ allfields=true src_ip`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	ipLocationReq := aggregator.OutputTransforms.LetColumns.IPLocationRequest
+	assert.NotNil(t, ipLocationReq)
+	assert.Equal(t, "src_ip", ipLocationReq.Field)
+	assert.Equal(t, "src_", ipLocationReq.Prefix)
+	assert.True(t, ipLocationReq.AllFields)
+
+	query = `index=firewall | iplocation clientip | stats count BY Country`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	ipLocationReq = aggregator.OutputTransforms.LetColumns.IPLocationRequest
+	assert.Equal(t, &structs.IPLocationRequest{Field: "clientip"}, ipLocationReq)
+
+	query = `index=firewall | iplocation prefix=a_ prefix=b_ clientip`
+	_, err = spl.Parse("", []byte(query))
+	assert.NotNil(t, err)
+}
+
+func Test_GeoStats(t *testing.T) {
+	query := `index=web | geostats latfield=client_lat longfield=client_lon zoom=3 count by status`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+
+	binReq := aggregator.OutputTransforms.LetColumns.GeoStatsRequest
+	assert.Equal(t, &structs.GeoStatsRequest{
+		Step:            structs.GeoStatsBinPoints,
+		LatField:        "client_lat",
+		LongField:       "client_lon",
+		OutputLatField:  "latitude",
+		OutputLongField: "longitude",
+		Precision:       3,
+	}, binReq)
+
+	statsNode := aggregator.Next
+	assert.Equal(t, structs.GroupByType, statsNode.PipeCommandType)
+	assert.Equal(t, []string{"geobin", "status"}, statsNode.GroupByRequest.GroupByColumns)
+	assert.Len(t, statsNode.GroupByRequest.MeasureOperations, 1)
+	assert.Equal(t, utils.Count, statsNode.GroupByRequest.MeasureOperations[0].MeasureFunc)
+
+	chartReq := statsNode.Next.OutputTransforms.LetColumns.ChartRequest
+	assert.Equal(t, "geobin", chartReq.OverField)
+	assert.Equal(t, "status", chartReq.Timechart.ByField)
+	assert.Equal(t, 10, chartReq.Timechart.LimitExpr.Num)
+
+	centerReq := statsNode.Next.Next.OutputTransforms.LetColumns.GeoStatsRequest
+	assert.Equal(t, structs.GeoStatsAddBinCenter, centerReq.Step)
+	assert.Equal(t, "latitude", centerReq.OutputLatField)
+	assert.Equal(t, "longitude", centerReq.OutputLongField)
+	assert.Nil(t, statsNode.Next.Next.Next)
+
+	query = `index=web | geostats outputlatfield=y outputlongfield=x avg(bytes) AS avg_bytes | head 5`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	binReq = aggregator.OutputTransforms.LetColumns.GeoStatsRequest
+	assert.Equal(t, "lat", binReq.LatField)
+	assert.Equal(t, "lon", binReq.LongField)
+	assert.Equal(t, uint64(5), binReq.Precision)
+	statsNode = aggregator.Next
+	assert.Equal(t, []string{"geobin"}, statsNode.GroupByRequest.GroupByColumns)
+	assert.Equal(t, "avg_bytes", statsNode.GroupByRequest.MeasureOperations[0].StrEnc)
+	centerReq = statsNode.Next.OutputTransforms.LetColumns.GeoStatsRequest
+	assert.Equal(t, "y", centerReq.OutputLatField)
+	assert.Equal(t, "x", centerReq.OutputLongField)
+	assert.NotNil(t, statsNode.Next.Next)
+
+	for _, query := range []string{
+		`index=web | geostats zoom=0 count`,
+		`index=web | geostats zoom=13 count`,
+		`index=web | geostats latfield=a latfield=b count`,
+		`index=web | geostats outputlatfield=pos outputlongfield=pos count`,
+		`index=web | geostats count by sta*`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_Trendline(t *testing.T) {
+	query := `index=web | timechart count | trendline sma5(count) ema10(count) AS smooth wma3(bytes)`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+
+	trendNode := aggregator.Next
+	assert.Equal(t, &structs.SequentialRequest{
+		CmdType:  structs.SequentialSMA,
+		Field:    "count",
+		NewField: "sma5(count)",
+		PEnd:     5,
+	}, trendNode.OutputTransforms.LetColumns.SequentialRequest)
+
+	trendNode = trendNode.Next
+	assert.Equal(t, &structs.SequentialRequest{
+		CmdType:  structs.SequentialEMA,
+		Field:    "count",
+		NewField: "smooth",
+		PEnd:     10,
+	}, trendNode.OutputTransforms.LetColumns.SequentialRequest)
+
+	trendNode = trendNode.Next
+	assert.Equal(t, &structs.SequentialRequest{
+		CmdType:  structs.SequentialWMA,
+		Field:    "bytes",
+		NewField: "wma3(bytes)",
+		PEnd:     3,
+	}, trendNode.OutputTransforms.LetColumns.SequentialRequest)
+	assert.Nil(t, trendNode.Next)
+
+	for _, query := range []string{
+		`index=web | trendline sma1(count)`,
+		`index=web | trendline sma10001(count)`,
+		`index=web | trendline avg5(count)`,
+		`index=web | trendline sma(count)`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_Predict(t *testing.T) {
+	query := `index=web | timechart span=1h count | predict count`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	predictReq := aggregator.Next.OutputTransforms.LetColumns.PredictRequest
+	assert.Equal(t, &structs.PredictRequest{
+		Fields: []*structs.PredictField{
+			{
+				Field:      "count",
+				NewField:   "prediction(count)",
+				UpperField: "upper95(prediction(count))",
+				LowerField: "lower95(prediction(count))",
+			},
+		},
+		Algorithm:      structs.PredictLL,
+		FutureTimespan: 5,
+		Confidence:     95,
+	}, predictReq)
+
+	query = `index=web | timechart span=1h count, avg(latency) AS lat | predict count AS c upper90=high lower90=low lat algorithm=LLP future_timespan=24 holdback=2 period=12`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	predictReq = aggregator.Next.OutputTransforms.LetColumns.PredictRequest
+	assert.Equal(t, structs.PredictLLP, predictReq.Algorithm)
+	assert.Equal(t, uint64(24), predictReq.FutureTimespan)
+	assert.Equal(t, uint64(2), predictReq.Holdback)
+	assert.Equal(t, uint64(12), predictReq.Period)
+	assert.Equal(t, uint64(90), predictReq.Confidence)
+	assert.Equal(t, []*structs.PredictField{
+		{Field: "count", NewField: "c", UpperField: "high", LowerField: "low"},
+		{Field: "lat", NewField: "prediction(lat)", UpperField: "upper90(prediction(lat))", LowerField: "lower90(prediction(lat))"},
+	}, predictReq.Fields)
+
+	query = `index=web | timechart count | predict count algorithm=llt`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, structs.PredictLLT, aggregator.Next.OutputTransforms.LetColumns.PredictRequest.Algorithm)
+
+	for _, query := range []string{
+		`index=web | predict count algorithm=ARIMA`,
+		`index=web | predict count period=12`,
+		`index=web | predict count algorithm=LLP period=1`,
+		`index=web | predict count upper95=high lower90=low`,
+		`index=web | predict count upper100=high`,
+		`index=web | predict count upper95=high upper95=higher`,
+		`index=web | predict count future_timespan=5 future_timespan=6`,
+		`index=web | predict count count`,
+		`index=web | predict count future_timespan=10001`,
+		`index=web | predict count holdback=10001`,
+		`index=web | predict count algorithm=LLP period=10001`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_AnomalyDetection(t *testing.T) {
+	query := `index=web | anomalydetection`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, &structs.AnomalyRequest{
+		Method:   structs.AnomalyHistogram,
+		Action:   structs.AnomalyFilter,
+		PThresh:  0.01,
+		Param:    2.5,
+		UseLower: true,
+	}, aggregator.OutputTransforms.LetColumns.AnomalyRequest)
+
+	query = `index=web | anomalydetection method=zscore action=annotate pthresh=0.05 latency bytes | where isOutlier=1`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.AnomalyRequest{
+		Method:   structs.AnomalyZScore,
+		Action:   structs.AnomalyAnnotate,
+		Fields:   []string{"latency", "bytes"},
+		PThresh:  0.05,
+		Param:    2.5,
+		UseLower: true,
+	}, aggregator.OutputTransforms.LetColumns.AnomalyRequest)
+	assert.NotNil(t, aggregator.Next)
+
+	query = `index=web | anomalydetection method=iqr param=1.5 latency`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	anomalyReq := aggregator.OutputTransforms.LetColumns.AnomalyRequest
+	assert.Equal(t, structs.AnomalyIQR, anomalyReq.Method)
+	assert.Equal(t, 1.5, anomalyReq.Param)
+
+	for _, query := range []string{
+		`index=web | anomalydetection method=kmeans latency`,
+		`index=web | anomalydetection action=remove latency`,
+		`index=web | anomalydetection pthresh=2 latency`,
+		`index=web | anomalydetection method=iqr method=zscore latency`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_Outlier(t *testing.T) {
+	query := `index=web | outlier latency`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, &structs.AnomalyRequest{
+		Method: structs.AnomalyIQR,
+		Action: structs.AnomalyTransform,
+		Fields: []string{"latency"},
+		Param:  2.5,
+	}, aggregator.OutputTransforms.LetColumns.AnomalyRequest)
+
+	query = `index=web | outlier action=rm param=3 uselower=true`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.AnomalyRequest{
+		Method:   structs.AnomalyIQR,
+		Action:   structs.AnomalyRemove,
+		Param:    3,
+		UseLower: true,
+	}, aggregator.OutputTransforms.LetColumns.AnomalyRequest)
+
+	query = `index=web | outlier action=transform mark=true latency bytes`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	anomalyReq := aggregator.OutputTransforms.LetColumns.AnomalyRequest
+	assert.True(t, anomalyReq.Mark)
+	assert.Equal(t, []string{"latency", "bytes"}, anomalyReq.Fields)
+
+	for _, query := range []string{
+		`index=web | outlier action=filter latency`,
+		`index=web | outlier action=remove mark=true latency`,
+		`index=web | outlier param=-1 latency`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_Cluster(t *testing.T) {
+	query := `index=web | cluster`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, &structs.ClusterRequest{
+		Cluster: &structs.Cluster{
+			Field:     "_raw",
+			Threshold: 0.8,
+			Match:     "termlist",
+		},
+		LabelField: "cluster_label",
+		CountField: "cluster_count",
+	}, aggregator.OutputTransforms.LetColumns.ClusterRequest)
+
+	query = `index=web | cluster field=message t=0.6 match=termset showcount=true labelonly=true delims=" :" | sort -cluster_count`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.ClusterRequest{
+		Cluster: &structs.Cluster{
+			Field:     "message",
+			Threshold: 0.6,
+			Match:     "termset",
+			Delims:    " :",
+		},
+		ShowCount:  true,
+		LabelOnly:  true,
+		LabelField: "cluster_label",
+		CountField: "cluster_count",
+	}, aggregator.OutputTransforms.LetColumns.ClusterRequest)
+	assert.NotNil(t, aggregator.Next)
+
+	query = `index=web | cluster match=ngramset labelfield=pattern countfield=num showcount=true`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	clusterReq := aggregator.OutputTransforms.LetColumns.ClusterRequest
+	assert.Equal(t, "ngramset", clusterReq.Cluster.Match)
+	assert.Equal(t, "pattern", clusterReq.LabelField)
+	assert.Equal(t, "num", clusterReq.CountField)
+
+	for _, query := range []string{
+		`index=web | cluster t=1.5`,
+		`index=web | cluster t=0`,
+		`index=web | cluster match=words`,
+		`index=web | cluster t=0.5 t=0.6`,
+		`index=web | cluster showcount=true labelfield=x countfield=x`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_EvalTextFunctionsSupported(t *testing.T) {
+	query := `* | spath input=rawjson path="user.name"`
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, "user.name", aggregator.OutputTransforms.LetColumns.NewColName)
+	assert.Nil(t, structs.CheckUnsupportedFunctions(aggregator))
+
+	for _, query := range []string{
+		`* | eval x=printf("%d items", count)`,
+		`* | eval x=tojson()`,
+		`* | eval x=object_to_array(obj, "k", "v")`,
+		`* | eval x=spath(_raw, "a.b{1}")`,
+	} {
+		_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+		assert.Nil(t, err, query)
+		assert.Nil(t, structs.CheckUnsupportedFunctions(aggregator), query)
+	}
+
+	// Extracting all the fields is not supported yet, so it is rejected instead of doing nothing.
+	_, aggregator, err = pipesearch.ParseQuery(`* | spath`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, structs.CheckUnsupportedFunctions(aggregator))
+}
+
+func Test_EvalJsonFunctions(t *testing.T) {
+	query := `* | eval obj=json_object("name", user, "ids", json_array(1, "two"))`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.NotNil(t, filterNode)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.NotNil(t, aggregator)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns.ValueColRequest)
+
+	valueExpr := aggregator.OutputTransforms.LetColumns.ValueColRequest
+	assert.Equal(t, int(valueExpr.ValueExprMode), structs.VEMStringExpr)
+	textExpr := valueExpr.StringExpr.TextExpr
+	assert.Equal(t, "json_object", textExpr.Op)
+	assert.Len(t, textExpr.ValueExprList, 4)
+	assert.Equal(t, int(textExpr.ValueExprList[3].ValueExprMode), structs.VEMMultiValueExpr)
+	assert.Equal(t, "json_array", textExpr.ValueExprList[3].MultiValueExpr.Op)
+	assert.Len(t, textExpr.ValueExprList[3].MultiValueExpr.ValueExprParams, 2)
+
+	// The arrays are multivalues, so they can be expanded and indexed.
+	query = `* | eval tags=json_extract(_raw, "user.tags") | mvexpand tags`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	valueExpr = aggregator.OutputTransforms.LetColumns.ValueColRequest
+	assert.Equal(t, int(valueExpr.ValueExprMode), structs.VEMMultiValueExpr)
+	assert.Equal(t, "json_extract", valueExpr.MultiValueExpr.Op)
+	assert.Len(t, valueExpr.MultiValueExpr.StringExprParams, 2)
+	assert.Equal(t, "user.tags", valueExpr.MultiValueExpr.StringExprParams[1].RawString)
+
+	query = `* | eval first=mvindex(json_keys(payload), 0)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	valueExpr = aggregator.OutputTransforms.LetColumns.ValueColRequest
+	assert.Equal(t, int(valueExpr.ValueExprMode), structs.VEMMultiValueExpr)
+	assert.Equal(t, "mvindex", valueExpr.MultiValueExpr.Op)
+	assert.Equal(t, "json_keys", valueExpr.MultiValueExpr.MultiValueExprParams[0].Op)
+
+	query = `* | eval payload=json_set(payload, "user.name", "bob", "user.age", 30)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	textExpr = aggregator.OutputTransforms.LetColumns.ValueColRequest.StringExpr.TextExpr
+	assert.Equal(t, "json_set", textExpr.Op)
+	assert.Equal(t, "payload", textExpr.Val.NumericExpr.Value)
+	assert.Len(t, textExpr.ValueExprList, 4)
+
+	query = `* | where json_valid(payload)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	boolExpr := aggregator.OutputTransforms.FilterRows
+	assert.NotNil(t, boolExpr)
+	assert.Equal(t, "json_valid", boolExpr.ValueOp)
+
+	for _, query := range []string{
+		`* | eval obj=json_object("name")`,
+		`* | eval obj=json_set(payload)`,
+		`* | eval obj=json_set(payload, "a")`,
+	} {
+		_, err := spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_EvalHashAndEncodingFunctions(t *testing.T) {
+	for _, op := range []string{"md5", "sha1", "sha256", "sha512", "base64encode", "base64decode", "urlencode"} {
+		query := `* | eval x=` + op + `(user)`
+		_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+		assert.Nil(t, err, query)
+		assert.Nil(t, structs.CheckUnsupportedFunctions(aggregator), query)
+
+		valueExpr := aggregator.OutputTransforms.LetColumns.ValueColRequest
+		assert.Equal(t, int(valueExpr.ValueExprMode), structs.VEMStringExpr, query)
+		assert.Equal(t, op, valueExpr.StringExpr.TextExpr.Op, query)
+		assert.Equal(t, "user", valueExpr.StringExpr.TextExpr.Param.FieldName, query)
+	}
+}
+
+func Test_Foreach(t *testing.T) {
+	query := `index=web | foreach bytes_* [eval <<FIELD>>_kb = <<FIELD>>/1024]`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	astNode, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, astNode)
+	assert.Equal(t, &structs.ForeachRequest{
+		Fields:    []string{"bytes_*"},
+		Mode:      structs.ForeachMultiField,
+		FieldStr:  "<<FIELD>>",
+		MatchStr:  "<<MATCHSTR>>",
+		ItemStr:   "<<ITEM>>",
+		Subsearch: "eval <<FIELD>>_kb = <<FIELD>>/1024",
+	}, aggregator.OutputTransforms.LetColumns.ForeachRequest)
+
+	query = `index=web | foreach mode=multifield in_* out_* fieldstr=#f# matchstr="#m#" [eval 'total_#m#' = '#f#' + mvindex(split("1,2", ","), 0) | where x > 1] | stats count`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	foreachReq := aggregator.OutputTransforms.LetColumns.ForeachRequest
+	assert.Equal(t, []string{"in_*", "out_*"}, foreachReq.Fields)
+	assert.Equal(t, "#f#", foreachReq.FieldStr)
+	assert.Equal(t, "#m#", foreachReq.MatchStr)
+	assert.Equal(t, `eval 'total_#m#' = '#f#' + mvindex(split("1,2", ","), 0) | where x > 1`, foreachReq.Subsearch)
+	assert.NotNil(t, aggregator.Next)
+
+	query = `index=web | foreach mode=multivalue sizes itemstr=<<SIZE>> [eval total = total + <<SIZE>>]`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	foreachReq = aggregator.OutputTransforms.LetColumns.ForeachRequest
+	assert.Equal(t, structs.ForeachMultiValue, foreachReq.Mode)
+	assert.Equal(t, []string{"sizes"}, foreachReq.Fields)
+	assert.Equal(t, "<<SIZE>>", foreachReq.ItemStr)
+
+	for _, query := range []string{
+		`index=web | foreach [eval x=1]`,
+		`index=web | foreach bytes_* []`,
+		`index=web | foreach bytes_*`,
+		`index=web | foreach bytes_* fieldstr="" [eval x=1]`,
+		`index=web | foreach mode=multivalue a b [eval x=1]`,
+		`index=web | foreach mode=multivalue a* [eval x=1]`,
+		`index=web | foreach mode=multivalue mode=multifield a [eval x=1]`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_AppendColsAppendPipeMultisearch(t *testing.T) {
+	query := `index=web | stats count by host | appendcols override=true maxout=10 [search index=db | stats avg(latency) by host]`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, &structs.AppendColsRequest{
+		Override:  true,
+		Subsearch: "search index=db | stats avg(latency) by host",
+		MaxOut:    10,
+	}, aggregator.Next.OutputTransforms.LetColumns.AppendColsRequest)
+
+	// The appendpipe pipeline is parsed into its own pipe commands.
+	query = `index=web | stats count by host | appendpipe [stats sum(count) as count | eval host="total"]`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	appendPipeReq := aggregator.Next.OutputTransforms.LetColumns.AppendPipeRequest
+	assert.NotNil(t, appendPipeReq)
+	assert.Equal(t, `stats sum(count) as count | eval host="total"`, appendPipeReq.Pipeline)
+	pipelineAggs := appendPipeReq.PipelineAggs
+	assert.NotNil(t, pipelineAggs)
+	assert.Equal(t, structs.MeasureAggsType, pipelineAggs.PipeCommandType)
+	assert.Equal(t, "count", pipelineAggs.MeasureOperations[0].MeasureCol)
+	assert.NotNil(t, pipelineAggs.Next.OutputTransforms.OutputColumns)
+	assert.Equal(t, "host", pipelineAggs.Next.Next.OutputTransforms.LetColumns.NewColName)
+
+	query = `index=web | appendpipe [stats count by]`
+	_, _, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.NotNil(t, err)
+
+	query = `| multisearch [search index=web status=500] [search index=db error=* | eval source="db"] | stats count`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, structs.GenerateEventType, aggregator.PipeCommandType)
+	assert.Equal(t, []string{"search index=web status=500", `search index=db error=* | eval source="db"`},
+		aggregator.GenerateEvent.Multisearch.Subsearches)
+	assert.NotNil(t, aggregator.Next)
+
+	for _, query := range []string{
+		`index=web | appendcols`,
+		`index=web | appendcols override=true override=false [search index=db]`,
+		`index=web | appendpipe []`,
+		`| multisearch [search index=web]`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_TStats(t *testing.T) {
+	query := `| tstats count sum(bytes) as total where index=web OR index=api by host span=1h | sort -total`
+	_, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, structs.GenerateEventType, aggregator.PipeCommandType)
+	assert.Equal(t, &structs.TStats{
+		IndexNames: []string{"web", "api"},
+		MeasureOperations: []*structs.MeasureAggregator{
+			{MeasureCol: "*", MeasureFunc: utils.Count},
+			{MeasureCol: "bytes", MeasureFunc: utils.Sum, StrEnc: "sum(bytes)"},
+		},
+		GroupByColumns: []string{"host"},
+		Span:           &structs.SpanLength{Num: 1, TimeScalr: utils.TMHour},
+	}, aggregator.GenerateEvent.TStats)
+
+	// The renames are applied to the generated rows.
+	assert.NotNil(t, aggregator.Next)
+	assert.Equal(t, map[string]string{"sum(bytes)": "total"}, aggregator.Next.OutputTransforms.OutputColumns.RenameAggregationColumns)
+	assert.NotNil(t, aggregator.Next.Next)
+
+	query = `| tstats min(latency) max(latency) dc(host)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	tstats := aggregator.GenerateEvent.TStats
+	assert.Len(t, tstats.MeasureOperations, 3)
+	assert.Nil(t, tstats.IndexNames)
+	assert.Nil(t, tstats.GroupByColumns)
+	assert.Nil(t, tstats.Span)
+	assert.Nil(t, aggregator.Next)
+
+	for _, query := range []string{
+		`| tstats`,
+		`| tstats values(host)`,
+		`| tstats count(eval(status=500))`,
+		`| tstats count where status=500`,
+		`index=web | tstats count`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_FilterSubsearch(t *testing.T) {
+	query := `index=web [search index=threats | fields ip]`
+	res, err := spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode := res.(ast.QueryStruct).SearchFilter
+	assert.Equal(t, ast.NodeAnd, filterNode.NodeType)
+	assert.Equal(t, ast.NodeTerminal, filterNode.Left.NodeType)
+	assert.Equal(t, ast.NodeSubsearch, filterNode.Right.NodeType)
+	assert.Equal(t, &structs.SearchSubsearch{
+		Query:   "search index=threats | fields ip",
+		MaxOut:  10000,
+		MaxTime: 60,
+	}, filterNode.Right.Subsearch)
+
+	astNode, _, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Len(t, astNode.AndFilterCondition.NestedNodes, 1)
+	assert.Equal(t, "search index=threats | fields ip", astNode.AndFilterCondition.NestedNodes[0].Subsearch.Query)
+
+	query = `index=web NOT [ search index=threats | stats count by ip, host ] | search status=500 OR [search index=admins | fields user]`
+	res, err = spl.Parse("", []byte(query))
+	assert.Nil(t, err)
+	filterNode = res.(ast.QueryStruct).SearchFilter
+	assert.Equal(t, ast.NodeAnd, filterNode.NodeType)
+	assert.Equal(t, ast.NodeSubsearch, filterNode.Left.Right.NodeType)
+	assert.Equal(t, "search index=threats | stats count by ip, host", filterNode.Left.Right.Subsearch.Query)
+	assert.True(t, filterNode.Left.Right.Subsearch.Negated)
+	assert.Equal(t, ast.NodeOr, filterNode.Right.NodeType)
+	assert.Equal(t, ast.NodeSubsearch, filterNode.Right.Right.NodeType)
+	assert.Equal(t, "search index=admins | fields user", filterNode.Right.Right.Subsearch.Query)
+	assert.False(t, filterNode.Right.Right.Subsearch.Negated)
+
+	query = `[search index=threats | fields ip]`
+	astNode, _, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, "search index=threats | fields ip", astNode.Subsearch.Query)
+
+	for _, query := range []string{
+		`index=web []`,
+		`index=web [search index=threats`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+
+	// The limits of the subsearch come from the config.
+	config.SetSubsearchMaxOut(500)
+	config.SetSubsearchMaxTime(5)
+	defer config.SetSubsearchMaxOut(config.DEFAULT_SUBSEARCH_MAX_OUT)
+	defer config.SetSubsearchMaxTime(config.DEFAULT_SUBSEARCH_MAX_TIME)
+	astNode, _, err = pipesearch.ParseQuery(`[search index=threats | fields ip]`, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), astNode.Subsearch.MaxOut)
+	assert.Equal(t, 5, astNode.Subsearch.MaxTime)
+}
+
+func Test_ConvertFieldFormatRangeMap(t *testing.T) {
+	query := `index=web | convert timeformat="%Y-%m-%d" ctime(_time) AS time rmunit(size*) none(size_raw) num(latency)`
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.ConvertRequest{
+		TimeFormat: "%Y-%m-%d",
+		Conversions: []*structs.ConvertExpr{
+			{Func: "ctime", Field: "_time", NewField: "time"},
+			{Func: "rmunit", Field: "size*"},
+			{Func: "none", Field: "size_raw"},
+			{Func: "num", Field: "latency"},
+		},
+	}, aggregator.OutputTransforms.LetColumns.ConvertRequest)
+
+	query = `index=web | convert dur2sec(duration)`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, "%m/%d/%Y %H:%M:%S", aggregator.OutputTransforms.LetColumns.ConvertRequest.TimeFormat)
+
+	query = `index=web | fieldformat bytes=tostring(bytes, "commas") | eval kb=bytes/1024 | stats count by kb`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.NotNil(t, aggregator.OutputTransforms.LetColumns.ValueColRequest)
+	assert.NotNil(t, aggregator.Next.GroupByRequest)
+	fieldFormatAgg := aggregator.Next.Next
+	assert.True(t, fieldFormatAgg.HasFieldFormatBlock())
+	assert.Equal(t, "bytes", fieldFormatAgg.OutputTransforms.LetColumns.NewColName)
+	assert.NotNil(t, fieldFormatAgg.OutputTransforms.LetColumns.FieldFormatRequest.ValueExpr)
+	assert.Nil(t, fieldFormatAgg.Next)
+
+	query = `index=web | rangemap field=latency low=0-100 elevated=100.5-500 severe=-1-0 default=unknown`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.RangeMapRequest{
+		Field: "latency",
+		Ranges: []*structs.RangeMapRange{
+			{Name: "low", Start: 0, End: 100},
+			{Name: "elevated", Start: 100.5, End: 500},
+			{Name: "severe", Start: -1, End: 0},
+		},
+		Default: "unknown",
+	}, aggregator.OutputTransforms.LetColumns.RangeMapRequest)
+
+	query = `index=web | rangemap field=latency low=0-100`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, "None", aggregator.OutputTransforms.LetColumns.RangeMapRequest.Default)
+
+	for _, query := range []string{
+		`index=web | convert`,
+		`index=web | convert ctime()`,
+		`index=web | convert size(bytes)`,
+		`index=web | convert rmunit(size*) AS size`,
+		`index=web | fieldformat bytes`,
+		`index=web | rangemap field=latency`,
+		`index=web | rangemap field=latency default=low`,
+		`index=web | rangemap field=latency low=100-0`,
+		`index=web | rangemap field=latency low=0-10x`,
+		`index=web | rangemap field=latency low=0-10 default=a default=b`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_FieldSummary(t *testing.T) {
+	query := `index=web | fieldsummary`
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.FieldSummaryRequest{
+		MaxVals: 100,
+		Fields:  []string{},
+	}, aggregator.OutputTransforms.LetColumns.FieldSummaryRequest)
+	assert.True(t, aggregator.HasFieldSummaryBlock())
+
+	query = `index=web | fieldsummary maxvals=5 status bytes_* | where count > 10`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.FieldSummaryRequest{
+		MaxVals: 5,
+		Fields:  []string{"status", "bytes_*"},
+	}, aggregator.OutputTransforms.LetColumns.FieldSummaryRequest)
+	assert.NotNil(t, aggregator.Next)
+
+	query = `index=web | fieldsummary approximate=true maxvals=5 status`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.FieldSummaryRequest{
+		MaxVals:     5,
+		Fields:      []string{"status"},
+		Approximate: true,
+	}, aggregator.OutputTransforms.LetColumns.FieldSummaryRequest)
+
+	query = `index=web | stats count by host | fieldsummary host`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"host"}, aggregator.Next.OutputTransforms.LetColumns.FieldSummaryRequest.Fields)
+
+	for _, query := range []string{
+		`index=web | fieldsummary maxvals=`,
+		`index=web | fieldsummary maxvals=-1`,
+		`index=web | fieldsummary status maxvals=5`,
+		`index=web | fieldsummary maxvals=5 maxvals=6`,
+		`index=web | fieldsummary approximate=yes`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func Test_Extract(t *testing.T) {
+	query := `index=web | extract`
+	_, aggregator, err := pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.ExtractRequest{
+		Field:      "_raw",
+		PairDelims: " \t,;",
+		KVDelims:   "=",
+		Limit:      50,
+	}, aggregator.OutputTransforms.LetColumns.ExtractRequest)
+
+	query = `index=web | kv pairdelim="|;", kvdelim=: limit=10 | where status > 400 | stats count by user`
+	_, aggregator, err = pipesearch.ParseQuery(query, 0, "Splunk QL")
+	assert.Nil(t, err)
+	assert.Equal(t, &structs.ExtractRequest{
+		Field:      "_raw",
+		PairDelims: "|;",
+		KVDelims:   ":",
+		Limit:      10,
+	}, aggregator.OutputTransforms.LetColumns.ExtractRequest)
+	assert.NotNil(t, aggregator.Next.OutputTransforms.FilterRows)
+	assert.NotNil(t, aggregator.Next.Next.GroupByRequest)
+
+	// The text is read for the stats after the extraction.
+	assert.Contains(t, aggregator.GetAllColsInAggsIfStatsPresent(), "_raw")
+
+	for _, query := range []string{
+		`index=web | extract limit=`,
+		`index=web | extract kvdelim=""`,
+		`index=web | extract pairdelim=" =" kvdelim="="`,
+		`index=web | extract limit=5 limit=6`,
+		`index=web | kv mode=json`,
+	} {
+		_, err = spl.Parse("", []byte(query))
+		assert.NotNil(t, err, query)
+	}
+}
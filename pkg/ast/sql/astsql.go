@@ -186,6 +186,49 @@ func getMathFunctionSQL(funcName string, argExprs sqlparser.SelectExprs, qid uin
 	return mathFunc, numericExpr, err
 }
 
+// The text functions are evaluated on a column the same way as the math functions, so they
+// replace the value of the column.
+var textFunctionsSQL = map[string]struct{}{
+	"md5":          {},
+	"sha1":         {},
+	"sha256":       {},
+	"sha512":       {},
+	"base64encode": {},
+	"base64decode": {},
+	"urlencode":    {},
+}
+
+func getTextFunctionSQL(funcName string, argExprs sqlparser.SelectExprs, qid uint64) (*structs.MathEvaluator, error) {
+	if len(argExprs) != 1 {
+		log.Errorf("qid=%v, getTextFunctionSQL: incorrect number of arguments for %v", qid, funcName)
+		return nil, fmt.Errorf("incorrect number of arguments for %v, expected 1", funcName)
+	}
+
+	aliasedExpr, ok := argExprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, fmt.Errorf("getTextFunctionSQL: unsupported argument type: %T", argExprs[0])
+	}
+	colName, ok := aliasedExpr.Expr.(*sqlparser.ColName)
+	if !ok {
+		return nil, fmt.Errorf("getTextFunctionSQL: the argument of %v must be a column, got %v", funcName, sqlparser.String(aliasedExpr.Expr))
+	}
+	col := colName.Name.CompliantName()
+
+	return &structs.MathEvaluator{
+		MathCol: col,
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr: &structs.StringExpr{
+				StringExprMode: structs.SEMTextExpr,
+				TextExpr: &structs.TextExpr{
+					Op:    funcName,
+					Param: &structs.StringExpr{StringExprMode: structs.SEMField, FieldName: col},
+				},
+			},
+		},
+	}, nil
+}
+
 func convertToNumericExpr(expr any) (*structs.NumericExpr, error) {
 	switch e := expr.(type) {
 	case *sqlparser.AliasedExpr:
@@ -373,6 +416,23 @@ func parseSelect(astNode *structs.ASTNode, aggNode *structs.QueryAggregators, cu
 
 				funcName := strings.ToLower(agg.Name.CompliantName())
 
+				if _, ok := textFunctionsSQL[funcName]; ok {
+					textOp, err := getTextFunctionSQL(funcName, agg.Exprs, qid)
+					if err != nil {
+						log.Errorf("qid=%v, parseSelect: getTextFunctionSQL failed! err: %+v", qid, err)
+						return astNode, aggNode, columsArray, err
+					}
+
+					mathOps = append(mathOps, textOp)
+					columsArray = append(columsArray, textOp.MathCol)
+					if len(label) != 0 {
+						renameCols[textOp.MathCol] = label
+					} else {
+						renameCols[textOp.MathCol] = funcName + "(" + textOp.MathCol + ")"
+					}
+					continue
+				}
+
 				mathFunc, numericExpr, err := getMathFunctionSQL(funcName, agg.Exprs, qid)
 
 				if mathFunc > 0 {
@@ -207,3 +207,34 @@ func Test_ParseMathFunctions(t *testing.T) {
 	assert.Equal(t, aggs.MathOperations[0].ValueColRequest.NumericExpr.Left.Value, "latitude")
 	assert.Equal(t, aggs.OutputTransforms.OutputColumns.RenameColumns["latitude"], "lat_abs")
 }
+
+func Test_ParseTextFunctions(t *testing.T) {
+	query_string := "SELECT city, SHA256(user_id) as user_hash"
+
+	_, aggs, _, err := ConvertToASTNodeSQL(query_string, 0)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, aggs.MathOperations)
+	assert.Equal(t, aggs.MathOperations[0].MathCol, "user_id")
+	assert.Equal(t, int(aggs.MathOperations[0].ValueColRequest.ValueExprMode), structs.VEMStringExpr)
+	assert.Equal(t, aggs.MathOperations[0].ValueColRequest.StringExpr.TextExpr.Op, "sha256")
+	assert.Equal(t, aggs.MathOperations[0].ValueColRequest.StringExpr.TextExpr.Param.FieldName, "user_id")
+	assert.Equal(t, aggs.OutputTransforms.OutputColumns.IncludeColumns, []string{"city", "user_id"})
+	assert.Equal(t, aggs.OutputTransforms.OutputColumns.RenameColumns["user_id"], "user_hash")
+
+	// text function without alias
+	query_string = "SELECT base64encode(city)"
+
+	_, aggs, _, err = ConvertToASTNodeSQL(query_string, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, aggs.MathOperations[0].MathCol, "city")
+	assert.Equal(t, aggs.MathOperations[0].ValueColRequest.StringExpr.TextExpr.Op, "base64encode")
+	assert.Equal(t, aggs.OutputTransforms.OutputColumns.RenameColumns["city"], "base64encode(city)")
+
+	// the argument must be a single column
+	_, _, _, err = ConvertToASTNodeSQL("SELECT md5(city, state)", 0)
+	assert.NotNil(t, err)
+	_, _, _, err = ConvertToASTNodeSQL("SELECT md5('city')", 0)
+	assert.NotNil(t, err)
+}
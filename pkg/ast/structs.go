@@ -41,6 +41,7 @@ const (
 	NodeOr
 	NodeTerminal
 	TimeModifierNode
+	NodeSubsearch
 )
 
 // Node is a node in the query parse tree
@@ -50,6 +51,7 @@ type Node struct {
 	Left          *Node
 	Right         *Node
 	TimeModifiers *TimeModifiers
+	Subsearch     *structs.SearchSubsearch
 }
 
 func (n *Node) isMatchAll() bool {
@@ -127,6 +127,10 @@ type Configuration struct {
 	Tracing                    TracingConfig  `yaml:"tracing"`       // Tracing related config
 	EmailConfig                EmailConfig    `yaml:"emailConfig"`
 	DatabaseConfig             DatabaseConfig `yaml:"minionSearch"`
+	GeoIPDatabasePath          string         `yaml:"geoIPDatabasePath"`   // path of the MaxMind-format .mmdb file used by iplocation
+	MaxMakeResultsCount        uint64         `yaml:"maxMakeResultsCount"` // maximum number of events a single makeresults can generate
+	SubsearchMaxOut            uint64         `yaml:"subsearchMaxOut"`     // max number of rows of a subsearch in the search filter
+	SubsearchMaxTime           uint64         `yaml:"subsearchMaxTime"`    // max number of seconds a subsearch in the search filter can run
 }
 
 type RunModConfig struct {
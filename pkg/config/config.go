@@ -42,6 +42,9 @@ const MINUTES_REREAD_CONFIG = 15
 const RunModFilePath = "data/common/runmod.cfg"
 
 const SIZE_8GB_IN_MB = uint64(8192)
+const DEFAULT_MAX_MAKE_RESULTS_COUNT = uint64(100_000)
+const DEFAULT_SUBSEARCH_MAX_OUT = uint64(10_000)
+const DEFAULT_SUBSEARCH_MAX_TIME = uint64(60)
 
 var configFileLastModified uint64
 
@@ -176,6 +179,33 @@ func GetLookupPath() string {
 	return runningConfig.DataPath + "lookups/"
 }
 
+func GetGeoIPDatabasePath() string {
+	return runningConfig.GeoIPDatabasePath
+}
+
+func GetMaxMakeResultsCount() uint64 {
+	if runningConfig.MaxMakeResultsCount == 0 {
+		return DEFAULT_MAX_MAKE_RESULTS_COUNT
+	}
+	return runningConfig.MaxMakeResultsCount
+}
+
+// returns the max number of rows of a subsearch in the search filter
+func GetSubsearchMaxOut() uint64 {
+	if runningConfig.SubsearchMaxOut == 0 {
+		return DEFAULT_SUBSEARCH_MAX_OUT
+	}
+	return runningConfig.SubsearchMaxOut
+}
+
+// returns the max number of seconds a subsearch in the search filter can run
+func GetSubsearchMaxTime() uint64 {
+	if runningConfig.SubsearchMaxTime == 0 {
+		return DEFAULT_SUBSEARCH_MAX_TIME
+	}
+	return runningConfig.SubsearchMaxTime
+}
+
 // returns if tls is enabled
 func IsTlsEnabled() bool {
 	return runningConfig.TLS.Enabled
@@ -381,6 +411,22 @@ func SetDataPath(path string) {
 	runningConfig.DataPath = path
 }
 
+func SetGeoIPDatabasePath(path string) {
+	runningConfig.GeoIPDatabasePath = path
+}
+
+func SetMaxMakeResultsCount(count uint64) {
+	runningConfig.MaxMakeResultsCount = count
+}
+
+func SetSubsearchMaxOut(maxOut uint64) {
+	runningConfig.SubsearchMaxOut = maxOut
+}
+
+func SetSubsearchMaxTime(maxTime uint64) {
+	runningConfig.SubsearchMaxTime = maxTime
+}
+
 func SetDataDiskThresholdPercent(percent uint64) {
 	runningConfig.DataDiskThresholdPercent = percent
 }
@@ -507,6 +553,10 @@ func GetTestConfig(dataPath string) common.Configuration {
 		Tracing:                    common.TracingConfig{ServiceName: "", Endpoint: "", SamplingPercentage: 1},
 		DatabaseConfig:             common.DatabaseConfig{Enabled: true, Provider: "sqlite"},
 		EmailConfig:                common.EmailConfig{SmtpHost: "smtp.gmail.com", SmtpPort: 587, SenderEmail: "doe1024john@gmail.com", GmailAppPassword: " "},
+		GeoIPDatabasePath:          "",
+		MaxMakeResultsCount:        DEFAULT_MAX_MAKE_RESULTS_COUNT,
+		SubsearchMaxOut:            DEFAULT_SUBSEARCH_MAX_OUT,
+		SubsearchMaxTime:           DEFAULT_SUBSEARCH_MAX_TIME,
 	}
 
 	return testConfig
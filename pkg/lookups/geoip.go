@@ -0,0 +1,165 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lookups
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/siglens/siglens/pkg/config"
+)
+
+// How often the database file is checked for changes.
+const geoIPReloadCheckInterval = 10 * time.Second
+
+type geoIPDatabase struct {
+	filePath    string
+	modTime     time.Time
+	lastChecked time.Time
+	reader      *MMDBReader
+}
+
+var geoIPDb geoIPDatabase
+var geoIPDbLock sync.Mutex
+
+// GetGeoIPReader returns the reader of the GeoIP database set in the config. The file is
+// memory-mapped once and mapped again when the path or its modification time changes.
+// The file should be replaced by a rename, as the old mapping may still be in use.
+// The caller must close the reader once done with it; the old mapping is released when
+// the last query using it closes its reader.
+func GetGeoIPReader() (*MMDBReader, error) {
+	filePath := config.GetGeoIPDatabasePath()
+	if filePath == "" {
+		return nil, errors.New("GetGeoIPReader: geoIPDatabasePath is not set in the config")
+	}
+
+	geoIPDbLock.Lock()
+	defer geoIPDbLock.Unlock()
+
+	if geoIPDb.reader != nil && geoIPDb.filePath == filePath && time.Since(geoIPDb.lastChecked) < geoIPReloadCheckInterval {
+		geoIPDb.reader.acquire()
+		return geoIPDb.reader, nil
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("GetGeoIPReader: Error while getting info of file %v, err: %v", filePath, err)
+	}
+
+	if geoIPDb.reader == nil || geoIPDb.filePath != filePath || !geoIPDb.modTime.Equal(fileInfo.ModTime()) {
+		reader, err := OpenMMDB(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("GetGeoIPReader: %v", err)
+		}
+		if geoIPDb.reader != nil {
+			_ = geoIPDb.reader.Close()
+		}
+		geoIPDb.filePath = filePath
+		geoIPDb.modTime = fileInfo.ModTime()
+		geoIPDb.reader = reader
+	}
+	geoIPDb.lastChecked = time.Now()
+
+	geoIPDb.reader.acquire()
+	return geoIPDb.reader, nil
+}
+
+// The names of the fields which iplocation adds, and the path of their value in a
+// GeoIP2/GeoLite2 City record.
+var geoIPFields = []struct {
+	name      string
+	path      []interface{}
+	allFields bool
+}{
+	{"City", []interface{}{"city", "names", "en"}, false},
+	{"Country", []interface{}{"country", "names", "en"}, false},
+	{"Region", []interface{}{"subdivisions", 0, "names", "en"}, false},
+	{"lat", []interface{}{"location", "latitude"}, false},
+	{"lon", []interface{}{"location", "longitude"}, false},
+	{"Continent", []interface{}{"continent", "names", "en"}, true},
+	{"MetroCode", []interface{}{"location", "metro_code"}, true},
+	{"Timezone", []interface{}{"location", "time_zone"}, true},
+}
+
+// GetGeoIPFieldNames returns the names of the fields which GetIPLocation can set.
+func GetGeoIPFieldNames(allFields bool) []string {
+	names := make([]string, 0, len(geoIPFields))
+	for _, field := range geoIPFields {
+		if allFields || !field.allFields {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+func getGeoIPValue(record interface{}, path []interface{}) (interface{}, bool) {
+	value := record
+	for _, key := range path {
+		switch key := key.(type) {
+		case string:
+			valueMap, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			value, ok = valueMap[key]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			valueArray, ok := value.([]interface{})
+			if !ok || key >= len(valueArray) {
+				return nil, false
+			}
+			value = valueArray[key]
+		}
+	}
+	return value, true
+}
+
+// GetIPLocation returns the location fields of the IP address; the fields which the
+// database does not have for the address are left out.
+func GetIPLocation(reader *MMDBReader, ipStr string, allFields bool) (map[string]interface{}, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, nil
+	}
+
+	record, err := reader.Lookup(ip)
+	if err != nil {
+		return nil, fmt.Errorf("GetIPLocation: %v", err)
+	}
+	if record == nil {
+		return nil, nil
+	}
+
+	location := make(map[string]interface{})
+	for _, field := range geoIPFields {
+		if field.allFields && !allFields {
+			continue
+		}
+		if value, ok := getGeoIPValue(record, field.path); ok {
+			location[field.name] = value
+		}
+	}
+
+	return location, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lookups
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestMMDB(t *testing.T, filePath string, ipVersion int, recordSize int, networks map[string]interface{}) {
+	err := WriteMockMMDB(filePath, ipVersion, recordSize, networks)
+	assert.Nil(t, err)
+}
+
+func getTestGeoIPNetworks() map[string]interface{} {
+	return map[string]interface{}{
+		"81.2.69.0/24": map[string]interface{}{
+			"city":         map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+			"continent":    map[string]interface{}{"names": map[string]interface{}{"en": "Europe"}},
+			"country":      map[string]interface{}{"iso_code": "GB", "names": map[string]interface{}{"en": "United Kingdom"}},
+			"location":     map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931, "time_zone": "Europe/London"},
+			"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "England"}}},
+		},
+		"216.160.83.56/29": map[string]interface{}{
+			"city": map[string]interface{}{"names": map[string]interface{}{"en": "Milton"}},
+			// A pointer to the "city" map of the first network's data.
+			"country":  []byte{0x20, 0x01},
+			"location": map[string]interface{}{"latitude": 47.2513, "longitude": -122.3149, "metro_code": uint16(819)},
+		},
+		"2001:db8::/32": map[string]interface{}{
+			"country": map[string]interface{}{"names": map[string]interface{}{"en": "Testland"}},
+		},
+	}
+}
+
+func Test_MMDBReaderLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		filePath := filepath.Join(t.TempDir(), "test.mmdb")
+		writeTestMMDB(t, filePath, 6, recordSize, getTestGeoIPNetworks())
+
+		reader, err := OpenMMDB(filePath)
+		assert.Nil(t, err)
+		assert.Equal(t, "GeoLite2-City", reader.Metadata["database_type"])
+
+		location, err := GetIPLocation(reader, "81.2.69.160", false)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"City": "London", "Country": "United Kingdom", "Region": "England", "lat": 51.5142, "lon": -0.0931}, location)
+
+		location, err = GetIPLocation(reader, "81.2.69.160", true)
+		assert.Nil(t, err)
+		assert.Equal(t, "Europe", location["Continent"])
+		assert.Equal(t, "Europe/London", location["Timezone"])
+		assert.NotContains(t, location, "MetroCode")
+
+		location, err = GetIPLocation(reader, "216.160.83.60", true)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"City": "Milton", "lat": 47.2513, "lon": -122.3149, "MetroCode": uint64(819)}, location)
+
+		location, err = GetIPLocation(reader, "2001:db8::1", false)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"Country": "Testland"}, location)
+
+		for _, ip := range []string{"216.160.83.64", "10.0.0.1", "2001:db9::1", "not an ip"} {
+			location, err = GetIPLocation(reader, ip, false)
+			assert.Nil(t, err)
+			assert.Nil(t, location)
+		}
+
+		assert.Nil(t, reader.Close())
+		_, err = GetIPLocation(reader, "81.2.69.160", false)
+		assert.NotNil(t, err)
+		assert.NotNil(t, reader.Close())
+	}
+}
+
+func Test_MMDBDecoderOutOfBounds(t *testing.T) {
+	decoder := &mmdbDecoder{data: []byte{0x41, 'a'}}
+
+	b, err := decoder.readBytes(1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'a'}, b)
+
+	_, err = decoder.readBytes(2, 1)
+	assert.NotNil(t, err)
+
+	// The offset and size must not wrap around.
+	_, err = decoder.readBytes(math.MaxUint64, 2)
+	assert.NotNil(t, err)
+	_, err = decoder.readBytes(1, math.MaxUint64)
+	assert.NotNil(t, err)
+
+	value, _, err := decoder.decode(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", value)
+
+	// A string longer than the data.
+	decoder = &mmdbDecoder{data: []byte{0x5f, 0xff, 0xff, 0xff}}
+	_, _, err = decoder.decode(0, 0)
+	assert.NotNil(t, err)
+}
+
+func Test_MMDBReaderIPv4Database(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.mmdb")
+	writeTestMMDB(t, filePath, 4, 24, map[string]interface{}{
+		"10.1.0.0/16": map[string]interface{}{"city": map[string]interface{}{"names": map[string]interface{}{"en": "Intranet"}}},
+	})
+
+	reader, err := OpenMMDB(filePath)
+	assert.Nil(t, err)
+
+	location, err := GetIPLocation(reader, "10.1.2.3", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"City": "Intranet"}, location)
+
+	location, err = GetIPLocation(reader, "2001:db8::1", false)
+	assert.Nil(t, err)
+	assert.Nil(t, location)
+	assert.Nil(t, reader.Close())
+
+	invalidFilePath := filepath.Join(t.TempDir(), "invalid.mmdb")
+	err = os.WriteFile(invalidFilePath, []byte("not a database"), 0644)
+	assert.Nil(t, err)
+	_, err = OpenMMDB(invalidFilePath)
+	assert.NotNil(t, err)
+}
+
+func Test_GetGeoIPReaderReload(t *testing.T) {
+	defer config.SetGeoIPDatabasePath(config.GetGeoIPDatabasePath())
+
+	config.SetGeoIPDatabasePath("")
+	_, err := GetGeoIPReader()
+	assert.NotNil(t, err)
+
+	filePath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	config.SetGeoIPDatabasePath(filePath)
+	_, err = GetGeoIPReader()
+	assert.NotNil(t, err)
+
+	writeTestMMDB(t, filePath, 6, 24, getTestGeoIPNetworks())
+	reader, err := GetGeoIPReader()
+	assert.Nil(t, err)
+	sameReader, err := GetGeoIPReader()
+	assert.Nil(t, err)
+	assert.True(t, reader == sameReader)
+	assert.Nil(t, sameReader.Close())
+
+	// The file is mapped again once it is replaced.
+	newFilePath := filePath + ".new"
+	writeTestMMDB(t, newFilePath, 4, 24, map[string]interface{}{
+		"81.2.69.0/24": map[string]interface{}{"city": map[string]interface{}{"names": map[string]interface{}{"en": "Reloaded"}}},
+	})
+	err = os.Chtimes(newFilePath, time.Now(), time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	err = os.Rename(newFilePath, filePath)
+	assert.Nil(t, err)
+	geoIPDbLock.Lock()
+	geoIPDb.lastChecked = time.Time{}
+	geoIPDbLock.Unlock()
+
+	newReader, err := GetGeoIPReader()
+	assert.Nil(t, err)
+	assert.False(t, reader == newReader)
+	location, err := GetIPLocation(newReader, "81.2.69.1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"City": "Reloaded"}, location)
+
+	assert.Nil(t, newReader.Close())
+
+	// The old reader can still be used by the queries which have it, until they close it.
+	location, err = GetIPLocation(reader, "81.2.69.1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "London", location["City"])
+	assert.Nil(t, reader.Close())
+	_, err = GetIPLocation(reader, "81.2.69.1", false)
+	assert.NotNil(t, err)
+
+	// The current reader stays open while the database refers to it.
+	location, err = GetIPLocation(newReader, "81.2.69.1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"City": "Reloaded"}, location)
+}
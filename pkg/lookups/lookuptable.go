@@ -0,0 +1,254 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lookups
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siglens/siglens/pkg/config"
+)
+
+type lookupTable struct {
+	modTime     time.Time
+	columnNames []string
+	rows        [][]string
+}
+
+var lookupTableCache = make(map[string]*lookupTable)
+var lookupTableCacheLock sync.Mutex
+
+// GetLookupTable returns the column names and the rows of a lookup file. The
+// parsed file is cached and only read again when its modification time changes,
+// so the returned slices are shared and must not be modified.
+func GetLookupTable(filename string) ([]string, [][]string, error) {
+	filePath, err := getLookupFilePath(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetLookupTable: %v", err)
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetLookupTable: Error while getting info of file %v, err: %v", filePath, err)
+	}
+
+	lookupTableCacheLock.Lock()
+	defer lookupTableCacheLock.Unlock()
+
+	table, ok := lookupTableCache[filePath]
+	if ok && table.modTime.Equal(fileInfo.ModTime()) {
+		return table.columnNames, table.rows, nil
+	}
+
+	table, err = readLookupTable(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetLookupTable: %v", err)
+	}
+	table.modTime = fileInfo.ModTime()
+	lookupTableCache[filePath] = table
+
+	return table.columnNames, table.rows, nil
+}
+
+// Lookup files are uploaded with a .csv or .csv.gz extension, so the extension
+// can be left out when the lookup is referenced in a query.
+func getLookupFilePath(filename string) (string, error) {
+	if filename == "" || filepath.Base(filename) != filename {
+		return "", fmt.Errorf("getLookupFilePath: Invalid lookup file name: %v", filename)
+	}
+
+	lookupsDir := config.GetLookupPath()
+	lowerFileName := strings.ToLower(filename)
+	if strings.HasSuffix(lowerFileName, allowedExtCSV) || strings.HasSuffix(lowerFileName, allowedExtCSVGZ) {
+		return filepath.Join(lookupsDir, filename), nil
+	}
+
+	for _, ext := range []string{allowedExtCSV, allowedExtCSVGZ} {
+		filePath := filepath.Join(lookupsDir, filename+ext)
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath, nil
+		}
+	}
+
+	return "", fmt.Errorf("getLookupFilePath: Lookup file %v does not exist", filename)
+}
+
+func readLookupTable(filePath string) (*lookupTable, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("readLookupTable: Error while opening file %v, err: %v", filePath, err)
+	}
+	defer file.Close()
+
+	var fileReader io.Reader = file
+	if strings.HasSuffix(strings.ToLower(filePath), allowedExtCSVGZ) {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("readLookupTable: Error while creating gzip reader, err: %v", err)
+		}
+		defer gzipReader.Close()
+		fileReader = gzipReader
+	}
+
+	reader := csv.NewReader(fileReader)
+	columnNames, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("readLookupTable: Error reading column names, err: %v", err)
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("readLookupTable: Error reading rows, err: %v", err)
+	}
+
+	return &lookupTable{
+		columnNames: columnNames,
+		rows:        rows,
+	}, nil
+}
+
+// WriteLookupTable writes the rows to a lookup file in the lookups directory. The
+// file name follows the same rules as an uploaded lookup file, so .csv is added
+// when it has neither a .csv nor a .csv.gz extension. When appending to an existing
+// file, the columns of both are kept and missing values are left empty.
+func WriteLookupTable(filename string, columnNames []string, rows [][]string, appendRows bool, createEmpty bool) error {
+	if filename == "" || filepath.Base(filename) != filename {
+		return fmt.Errorf("WriteLookupTable: Invalid lookup file name: %v", filename)
+	}
+
+	lowerFileName := strings.ToLower(filename)
+	if !strings.HasSuffix(lowerFileName, allowedExtCSV) && !strings.HasSuffix(lowerFileName, allowedExtCSVGZ) {
+		filename += allowedExtCSV
+	}
+
+	lookupsDir := config.GetLookupPath()
+	if err := os.MkdirAll(lookupsDir, os.ModePerm); err != nil {
+		return fmt.Errorf("WriteLookupTable: Error creating lookups directory %v, err: %v", lookupsDir, err)
+	}
+	filePath := filepath.Join(lookupsDir, filename)
+
+	lookupTableCacheLock.Lock()
+	defer lookupTableCacheLock.Unlock()
+
+	_, err := os.Stat(filePath)
+	fileExists := err == nil
+
+	if len(rows) == 0 && (!createEmpty || (appendRows && fileExists)) {
+		return nil
+	}
+
+	if appendRows && fileExists {
+		existingTable, err := readLookupTable(filePath)
+		if err != nil {
+			return fmt.Errorf("WriteLookupTable: %v", err)
+		}
+		columnNames, rows = mergeLookupRows(existingTable.columnNames, existingTable.rows, columnNames, rows)
+	}
+
+	// Write to a temporary file first, so a lookup being read never sees a partially written file.
+	tmpFilePath := filePath + ".tmp"
+	isGzip := strings.HasSuffix(strings.ToLower(filename), allowedExtCSVGZ)
+	err = writeLookupTable(tmpFilePath, isGzip, columnNames, rows)
+	if err != nil {
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf("WriteLookupTable: %v", err)
+	}
+
+	err = os.Rename(tmpFilePath, filePath)
+	if err != nil {
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf("WriteLookupTable: Error while renaming %v to %v, err: %v", tmpFilePath, filePath, err)
+	}
+	delete(lookupTableCache, filePath)
+
+	return nil
+}
+
+func mergeLookupRows(columnNames []string, rows [][]string, newColumnNames []string, newRows [][]string) ([]string, [][]string) {
+	colIndex := make(map[string]int, len(columnNames)+len(newColumnNames))
+	mergedColumnNames := make([]string, 0, len(columnNames)+len(newColumnNames))
+	for _, cols := range [][]string{columnNames, newColumnNames} {
+		for _, col := range cols {
+			if _, exists := colIndex[col]; !exists {
+				colIndex[col] = len(mergedColumnNames)
+				mergedColumnNames = append(mergedColumnNames, col)
+			}
+		}
+	}
+
+	mergedRows := make([][]string, 0, len(rows)+len(newRows))
+	addRows := func(rowColumnNames []string, rowsToAdd [][]string) {
+		for _, row := range rowsToAdd {
+			mergedRow := make([]string, len(mergedColumnNames))
+			for i, value := range row {
+				if i < len(rowColumnNames) {
+					mergedRow[colIndex[rowColumnNames[i]]] = value
+				}
+			}
+			mergedRows = append(mergedRows, mergedRow)
+		}
+	}
+	addRows(columnNames, rows)
+	addRows(newColumnNames, newRows)
+
+	return mergedColumnNames, mergedRows
+}
+
+func writeLookupTable(filePath string, isGzip bool, columnNames []string, rows [][]string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("writeLookupTable: Error while creating file %v, err: %v", filePath, err)
+	}
+	defer file.Close()
+
+	var fileWriter io.Writer = file
+	var gzipWriter *gzip.Writer
+	if isGzip {
+		gzipWriter = gzip.NewWriter(file)
+		fileWriter = gzipWriter
+	}
+
+	writer := csv.NewWriter(fileWriter)
+	if len(columnNames) > 0 {
+		err = writer.Write(columnNames)
+		if err != nil {
+			return fmt.Errorf("writeLookupTable: Error writing column names, err: %v", err)
+		}
+	}
+
+	err = writer.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("writeLookupTable: Error writing rows, err: %v", err)
+	}
+
+	if gzipWriter != nil {
+		err = gzipWriter.Close()
+		if err != nil {
+			return fmt.Errorf("writeLookupTable: Error while closing gzip writer, err: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,432 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lookups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// The metadata of a MaxMind DB file starts after this marker, near the end of the file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const mmdbMetadataMaxSize = 128 * 1024
+const mmdbDataSectionSeparatorSize = 16
+
+const (
+	mmdbTypeExtended  = 0
+	mmdbTypePointer   = 1
+	mmdbTypeString    = 2
+	mmdbTypeDouble    = 3
+	mmdbTypeBytes     = 4
+	mmdbTypeUint16    = 5
+	mmdbTypeUint32    = 6
+	mmdbTypeMap       = 7
+	mmdbTypeInt32     = 8
+	mmdbTypeUint64    = 9
+	mmdbTypeUint128   = 10
+	mmdbTypeArray     = 11
+	mmdbTypeContainer = 12
+	mmdbTypeEndMarker = 13
+	mmdbTypeBool      = 14
+	mmdbTypeFloat     = 15
+)
+
+// MMDBReader reads a MaxMind DB file (https://maxmind.github.io/MaxMind-DB/),
+// which is memory-mapped until the last reference to the reader is closed.
+type MMDBReader struct {
+	refCount    int64
+	data        []byte
+	nodeCount   uint64
+	recordSize  uint64
+	ipVersion   uint64
+	treeSize    uint64
+	ipv4Start   uint64 // node of ::/96 in an IPv6 tree, where the IPv4 addresses start
+	dataSection []byte
+	Metadata    map[string]interface{}
+}
+
+func OpenMMDB(filePath string) (*MMDBReader, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenMMDB: failed to open %v, err: %v", filePath, err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("OpenMMDB: failed to get info of %v, err: %v", filePath, err)
+	}
+	if fileInfo.Size() == 0 || fileInfo.Size() > math.MaxInt32 {
+		return nil, fmt.Errorf("OpenMMDB: invalid size %v of %v", fileInfo.Size(), filePath)
+	}
+
+	data, err := mmapFile(file, int(fileInfo.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("OpenMMDB: failed to map %v, err: %v", filePath, err)
+	}
+
+	reader, err := newMMDBReader(data)
+	if err != nil {
+		_ = munmapFile(data)
+		return nil, fmt.Errorf("OpenMMDB: %v is not a valid MaxMind DB file, err: %v", filePath, err)
+	}
+
+	// The caller holds the first reference.
+	reader.refCount = 1
+
+	return reader, nil
+}
+
+// Adds a reference to the reader, which must be closed once it is not used anymore.
+func (reader *MMDBReader) acquire() {
+	atomic.AddInt64(&reader.refCount, 1)
+}
+
+// Close releases a reference to the reader. The file is unmapped once the last
+// reference is released, so the reader must not be used after it is closed.
+func (reader *MMDBReader) Close() error {
+	refCount := atomic.AddInt64(&reader.refCount, -1)
+	if refCount < 0 {
+		return errors.New("Close: the reader is already closed")
+	}
+	if refCount == 0 {
+		return munmapFile(reader.data)
+	}
+	return nil
+}
+
+func newMMDBReader(data []byte) (*MMDBReader, error) {
+	searchStart := 0
+	if len(data) > mmdbMetadataMaxSize {
+		searchStart = len(data) - mmdbMetadataMaxSize
+	}
+	markerIndex := bytes.LastIndex(data[searchStart:], mmdbMetadataMarker)
+	if markerIndex == -1 {
+		return nil, errors.New("newMMDBReader: metadata not found")
+	}
+	metadataStart := searchStart + markerIndex + len(mmdbMetadataMarker)
+
+	metadataDecoder := &mmdbDecoder{data: data[metadataStart:]}
+	metadataValue, _, err := metadataDecoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("newMMDBReader: failed to decode the metadata, err: %v", err)
+	}
+	metadata, ok := metadataValue.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("newMMDBReader: metadata is not a map")
+	}
+
+	reader := &MMDBReader{data: data, Metadata: metadata}
+	for key, dest := range map[string]*uint64{"node_count": &reader.nodeCount, "record_size": &reader.recordSize, "ip_version": &reader.ipVersion} {
+		value, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("newMMDBReader: metadata %v is missing", key)
+		}
+		*dest = value
+	}
+	if reader.nodeCount > uint64(len(data)) {
+		return nil, fmt.Errorf("newMMDBReader: node count %v is larger than the file", reader.nodeCount)
+	}
+
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, fmt.Errorf("newMMDBReader: unsupported record size %v", reader.recordSize)
+	}
+	if reader.ipVersion != 4 && reader.ipVersion != 6 {
+		return nil, fmt.Errorf("newMMDBReader: unsupported ip version %v", reader.ipVersion)
+	}
+
+	reader.treeSize = reader.nodeCount * reader.recordSize * 2 / 8
+	dataSectionStart := reader.treeSize + mmdbDataSectionSeparatorSize
+	if dataSectionStart > uint64(metadataStart-len(mmdbMetadataMarker)) {
+		return nil, errors.New("newMMDBReader: search tree is larger than the file")
+	}
+	reader.dataSection = data[dataSectionStart : metadataStart-len(mmdbMetadataMarker)]
+
+	if reader.ipVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < reader.nodeCount; i++ {
+			node, err = reader.readNode(node, 0)
+			if err != nil {
+				return nil, fmt.Errorf("newMMDBReader: %v", err)
+			}
+		}
+		reader.ipv4Start = node
+	}
+
+	return reader, nil
+}
+
+func (reader *MMDBReader) readNode(node uint64, bit uint64) (uint64, error) {
+	nodeBytes := reader.recordSize * 2 / 8
+	offset := node * nodeBytes
+	if offset+nodeBytes > reader.treeSize {
+		return 0, fmt.Errorf("readNode: node %v is outside the search tree", node)
+	}
+	b := reader.data[offset : offset+nodeBytes]
+
+	switch reader.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint64(b[3]&0xF0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3]&0x0F)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6]), nil
+	default:
+		return uint64(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// Lookup returns the data of the network containing the IP address, or nil if the
+// address is not in the database.
+func (reader *MMDBReader) Lookup(ip net.IP) (interface{}, error) {
+	if atomic.LoadInt64(&reader.refCount) <= 0 {
+		return nil, errors.New("Lookup: the reader is closed")
+	}
+
+	ipBytes := ip.To4()
+	node := uint64(0)
+	if ipBytes == nil {
+		if reader.ipVersion == 4 {
+			return nil, nil
+		}
+		ipBytes = ip.To16()
+		if ipBytes == nil {
+			return nil, fmt.Errorf("Lookup: invalid IP address %v", ip)
+		}
+	} else if reader.ipVersion == 6 {
+		node = reader.ipv4Start
+	}
+
+	var err error
+	for i := 0; i < len(ipBytes)*8 && node < reader.nodeCount; i++ {
+		bit := uint64(ipBytes[i/8]>>(7-uint(i%8))) & 1
+		node, err = reader.readNode(node, bit)
+		if err != nil {
+			return nil, fmt.Errorf("Lookup: %v", err)
+		}
+	}
+
+	if node <= reader.nodeCount {
+		return nil, nil
+	}
+	if node < reader.nodeCount+mmdbDataSectionSeparatorSize {
+		return nil, fmt.Errorf("Lookup: record %v of %v points inside the data section separator", node, ip)
+	}
+
+	dataOffset := node - reader.nodeCount - mmdbDataSectionSeparatorSize
+	decoder := &mmdbDecoder{data: reader.dataSection}
+	value, _, err := decoder.decode(dataOffset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Lookup: failed to decode the data of %v, err: %v", ip, err)
+	}
+
+	return value, nil
+}
+
+type mmdbDecoder struct {
+	data []byte
+}
+
+const mmdbMaxDepth = 64
+
+func (decoder *mmdbDecoder) readBytes(offset uint64, size uint64) ([]byte, error) {
+	dataLen := uint64(len(decoder.data))
+	if size > dataLen || offset > dataLen-size {
+		return nil, fmt.Errorf("readBytes: %v bytes at offset %v are outside the data", size, offset)
+	}
+	return decoder.data[offset : offset+size], nil
+}
+
+func (decoder *mmdbDecoder) readUint(offset uint64, size uint64) (uint64, error) {
+	if size > 8 {
+		return 0, fmt.Errorf("readUint: invalid size %v", size)
+	}
+	b, err := decoder.readBytes(offset, size)
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(0)
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value, nil
+}
+
+// Decodes the value at the offset and returns it along with the offset after it.
+func (decoder *mmdbDecoder) decode(offset uint64, depth int) (interface{}, uint64, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("decode: data is nested too deeply")
+	}
+
+	ctrl, err := decoder.readBytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	dataType := uint64(ctrl[0] >> 5)
+	if dataType == mmdbTypePointer {
+		pointer, newOffset, err := decoder.decodePointer(ctrl[0], offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decoder.decode(pointer, depth+1)
+		return value, newOffset, err
+	}
+
+	if dataType == mmdbTypeExtended {
+		extendedType, err := decoder.readBytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		dataType = 7 + uint64(extendedType[0])
+		offset++
+	}
+
+	size := uint64(ctrl[0] & 0x1f)
+	if size >= 29 {
+		extraBytes := size - 28
+		extra, err := decoder.readUint(offset, extraBytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += extraBytes
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch dataType {
+	case mmdbTypeString:
+		b, err := decoder.readBytes(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return string(b), offset + size, nil
+	case mmdbTypeBytes:
+		b, err := decoder.readBytes(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return append([]byte{}, b...), offset + size, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("decode: invalid size %v of a double", size)
+		}
+		bits, err := decoder.readUint(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(bits), offset + size, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("decode: invalid size %v of a float", size)
+		}
+		bits, err := decoder.readUint(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(uint32(bits))), offset + size, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64:
+		value, err := decoder.readUint(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, offset + size, nil
+	case mmdbTypeInt32:
+		value, err := decoder.readUint(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return int64(int32(uint32(value))), offset + size, nil
+	case mmdbTypeUint128:
+		b, err := decoder.readBytes(offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return new(big.Int).SetBytes(b), offset + size, nil
+	case mmdbTypeBool:
+		return size != 0, offset, nil
+	case mmdbTypeMap:
+		value := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			key, newOffset, err := decoder.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("decode: map key %v is not a string", key)
+			}
+			value[keyStr], offset, err = decoder.decode(newOffset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return value, offset, nil
+	case mmdbTypeArray:
+		value := make([]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			value[i], offset, err = decoder.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return value, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("decode: unsupported data type %v", dataType)
+	}
+}
+
+func (decoder *mmdbDecoder) decodePointer(ctrl byte, offset uint64) (uint64, uint64, error) {
+	pointerSize := uint64((ctrl>>3)&0x3) + 1
+	b, err := decoder.readBytes(offset, pointerSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	prefix := uint64(ctrl & 0x7)
+	var pointer uint64
+	switch pointerSize {
+	case 1:
+		pointer = prefix<<8 | uint64(b[0])
+	case 2:
+		pointer = (prefix<<16 | uint64(b[0])<<8 | uint64(b[1])) + 2048
+	case 3:
+		pointer = (prefix<<24 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])) + 526336
+	default:
+		pointer = uint64(binary.BigEndian.Uint32(b))
+	}
+
+	return pointer, offset + pointerSize, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lookups
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+)
+
+func encodeMMDBControl(dataType int, size int) []byte {
+	var encoded []byte
+	if dataType <= 7 {
+		encoded = []byte{byte(dataType << 5)}
+	} else {
+		encoded = []byte{0, byte(dataType - 7)}
+	}
+
+	switch {
+	case size < 29:
+		encoded[0] |= byte(size)
+	case size < 285:
+		encoded[0] |= 29
+		encoded = append(encoded, byte(size-29))
+	default:
+		encoded[0] |= 30
+		encoded = append(encoded, byte((size-285)>>8), byte(size-285))
+	}
+	return encoded
+}
+
+func encodeMMDBValue(value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case string:
+		return append(encodeMMDBControl(mmdbTypeString, len(value)), value...), nil
+	case float64:
+		encoded := encodeMMDBControl(mmdbTypeDouble, 8)
+		return binary.BigEndian.AppendUint64(encoded, math.Float64bits(value)), nil
+	case uint16:
+		encoded := encodeMMDBControl(mmdbTypeUint16, 2)
+		return binary.BigEndian.AppendUint16(encoded, value), nil
+	case uint32:
+		encoded := encodeMMDBControl(mmdbTypeUint32, 4)
+		return binary.BigEndian.AppendUint32(encoded, value), nil
+	case []interface{}:
+		encoded := encodeMMDBControl(mmdbTypeArray, len(value))
+		for _, item := range value {
+			encodedItem, err := encodeMMDBValue(item)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, encodedItem...)
+		}
+		return encoded, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		encoded := encodeMMDBControl(mmdbTypeMap, len(value))
+		for _, key := range keys {
+			encodedKey, err := encodeMMDBValue(key)
+			if err != nil {
+				return nil, err
+			}
+			encodedValue, err := encodeMMDBValue(value[key])
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, encodedKey...)
+			encoded = append(encoded, encodedValue...)
+		}
+		return encoded, nil
+	case []byte:
+		// An already encoded value, such as a pointer.
+		return value, nil
+	}
+	return nil, fmt.Errorf("encodeMMDBValue: unsupported value type %T", value)
+}
+
+type mockMMDBNode struct {
+	children [2]*mockMMDBNode
+	data     []int // data offset of each child which is a leaf, or -1
+}
+
+func newMockMMDBNode() *mockMMDBNode {
+	return &mockMMDBNode{data: []int{-1, -1}}
+}
+
+// WriteMockMMDB writes a MaxMind DB file with the networks, whose data is encoded one after
+// the other. It is used by the tests that need a GeoIP database.
+func WriteMockMMDB(filePath string, ipVersion int, recordSize int, networks map[string]interface{}) error {
+	root := newMockMMDBNode()
+	var dataSection []byte
+
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("WriteMockMMDB: invalid network %v, err: %v", cidr, err)
+		}
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To16()
+		if ipVersion == 4 {
+			ip = network.IP.To4()
+		} else if network.IP.To4() != nil {
+			// The IPv4 addresses are in ::/96 of an IPv6 tree.
+			ip = append(make(net.IP, 12), network.IP.To4()...)
+			ones += 96
+		}
+
+		dataOffset := len(dataSection)
+		encodedData, err := encodeMMDBValue(networks[cidr])
+		if err != nil {
+			return fmt.Errorf("WriteMockMMDB: %v", err)
+		}
+		dataSection = append(dataSection, encodedData...)
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				node.data[bit] = dataOffset
+				break
+			}
+			if node.children[bit] == nil {
+				node.children[bit] = newMockMMDBNode()
+			}
+			node = node.children[bit]
+		}
+	}
+
+	nodes := []*mockMMDBNode{root}
+	for i := 0; i < len(nodes); i++ {
+		for _, child := range nodes[i].children {
+			if child != nil {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	nodeIndex := make(map[*mockMMDBNode]int, len(nodes))
+	for i, node := range nodes {
+		nodeIndex[node] = i
+	}
+
+	var tree []byte
+	for _, node := range nodes {
+		records := [2]uint64{}
+		for bit := 0; bit < 2; bit++ {
+			if node.children[bit] != nil {
+				records[bit] = uint64(nodeIndex[node.children[bit]])
+			} else if node.data[bit] >= 0 {
+				records[bit] = uint64(len(nodes) + mmdbDataSectionSeparatorSize + node.data[bit])
+			} else {
+				records[bit] = uint64(len(nodes))
+			}
+		}
+
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]),
+				byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		case 28:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]),
+				byte((records[0]>>24)<<4|(records[1]>>24)), byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, uint32(records[0]))
+			tree = binary.BigEndian.AppendUint32(tree, uint32(records[1]))
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "GeoLite2-City",
+		"languages":                   []interface{}{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	}
+
+	fileData := append(tree, make([]byte, mmdbDataSectionSeparatorSize)...)
+	fileData = append(fileData, dataSection...)
+	fileData = append(fileData, mmdbMetadataMarker...)
+	encodedMetadata, err := encodeMMDBValue(metadata)
+	if err != nil {
+		return fmt.Errorf("WriteMockMMDB: %v", err)
+	}
+	fileData = append(fileData, encodedMetadata...)
+
+	err = os.WriteFile(filePath, fileData, 0644)
+	if err != nil {
+		return fmt.Errorf("WriteMockMMDB: Error while writing file %v, err: %v", filePath, err)
+	}
+	return nil
+}
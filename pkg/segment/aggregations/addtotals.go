@@ -0,0 +1,270 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+const addTotalsSummaryRecordKey = "addtotals_summary"
+
+func performAddTotalsRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	addTotalsReq := letColReq.AddTotalsRequest
+	if recs != nil {
+		performAddTotalsRequestWithoutGroupby(nodeResult, addTotalsReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
+		return nil
+	}
+
+	performAddTotalsRequestOnHistogram(nodeResult, addTotalsReq)
+	performAddTotalsRequestOnMeasureResults(nodeResult, addTotalsReq)
+
+	return nil
+}
+
+// Returns the fields to total out of the columns. Without a field list, all the columns
+// other than the groupby columns and the internal fields are totaled; only their numeric
+// values are added up.
+func getAddTotalsFields(addTotalsReq *structs.AddTotalsRequest, columns []string, groupByCols []string) []string {
+	fields := make([]string, 0, len(columns))
+	if len(addTotalsReq.FieldList) == 0 {
+		for _, col := range columns {
+			if putils.SliceContainsString(groupByCols, col) || col == config.GetTimeStampKey() || strings.HasPrefix(col, "_") {
+				continue
+			}
+			fields = append(fields, col)
+		}
+	} else {
+		for _, pattern := range addTotalsReq.FieldList {
+			for _, col := range putils.SelectMatchingStringsWithWildcard(pattern, columns) {
+				if !putils.SliceContainsString(fields, col) {
+					fields = append(fields, col)
+				}
+			}
+		}
+	}
+
+	// The totals themselves are not added up again in the row totals.
+	totalFields := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field != addTotalsReq.FieldName && field != addTotalsReq.LabelField {
+			totalFields = append(totalFields, field)
+		}
+	}
+
+	return totalFields
+}
+
+// Sets the total of the numeric fields of each row, and returns the totals of the
+// numeric columns, including the row totals, when the summary row is needed.
+func computeAddTotals(addTotalsReq *structs.AddTotalsRequest, rows []map[string]interface{}, fields []string) map[string]float64 {
+	if addTotalsReq.Row {
+		for _, row := range rows {
+			rowTotal := 0.0
+			hasNumber := false
+			for _, field := range fields {
+				if number, ok := getNumericValue(row[field]); ok {
+					rowTotal += number
+					hasNumber = true
+				}
+			}
+			if hasNumber {
+				row[addTotalsReq.FieldName] = rowTotal
+			}
+		}
+	}
+
+	if !addTotalsReq.Col {
+		return nil
+	}
+
+	colFields := fields
+	if addTotalsReq.Row {
+		colFields = append(colFields[:len(colFields):len(colFields)], addTotalsReq.FieldName)
+	}
+
+	colTotals := make(map[string]float64, len(colFields))
+	for _, row := range rows {
+		for _, field := range colFields {
+			if number, ok := getNumericValue(row[field]); ok {
+				colTotals[field] += number
+			}
+		}
+	}
+
+	return colTotals
+}
+
+func getAddTotalsSummaryRow(addTotalsReq *structs.AddTotalsRequest, colTotals map[string]float64) map[string]interface{} {
+	summaryRow := make(map[string]interface{}, len(colTotals)+1)
+	for field, total := range colTotals {
+		summaryRow[field] = total
+	}
+	if addTotalsReq.LabelField != "" {
+		summaryRow[addTotalsReq.LabelField] = addTotalsReq.Label
+	}
+	return summaryRow
+}
+
+// The row totals only depend on the record itself, but the summary row sums every record, so with
+// col=true the records are only added up once the search has all of them.
+func performAddTotalsRequestWithoutGroupby(nodeResult *structs.NodeResult, addTotalsReq *structs.AddTotalsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) {
+
+	if addTotalsReq.Col {
+		if !holdRecordsUntilLastSegment(nodeResult, &addTotalsReq.Records, &addTotalsReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+			return
+		}
+
+		for recordKey, record := range addTotalsReq.Records {
+			recs[recordKey] = record
+		}
+		addTotalsReq.Records = nil
+	}
+
+	columns := make([]string, 0, len(finalCols))
+	for col := range finalCols {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	rows := make([]map[string]interface{}, 0, len(recs))
+	for _, record := range recs {
+		rows = append(rows, record)
+	}
+
+	colTotals := computeAddTotals(addTotalsReq, rows, getAddTotalsFields(addTotalsReq, columns, nodeResult.GroupByCols))
+	if addTotalsReq.Row {
+		finalCols[addTotalsReq.FieldName] = true
+	}
+
+	if !addTotalsReq.Col {
+		return
+	}
+
+	summaryIndex := 0
+	for recordKey := range recs {
+		if index, ok := recordIndexInFinal[recordKey]; ok && index >= summaryIndex {
+			summaryIndex = index + 1
+		}
+	}
+	recs[addTotalsSummaryRecordKey] = getAddTotalsSummaryRow(addTotalsReq, colTotals)
+	recordIndexInFinal[addTotalsSummaryRecordKey] = summaryIndex
+	if addTotalsReq.LabelField != "" {
+		finalCols[addTotalsReq.LabelField] = true
+	}
+}
+
+func performAddTotalsRequestOnHistogram(nodeResult *structs.NodeResult, addTotalsReq *structs.AddTotalsRequest) {
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		colTotals := computeAddTotals(addTotalsReq, table.rows, getAddTotalsFields(addTotalsReq, table.columns, table.groupByCols))
+
+		if addTotalsReq.Row {
+			for rowIndex, bucketResult := range aggregationResult.Results {
+				if rowTotal, ok := table.rows[rowIndex][addTotalsReq.FieldName]; ok {
+					bucketResult.StatRes[addTotalsReq.FieldName] = utils.CValueEnclosure{
+						Dtype: utils.SS_DT_FLOAT,
+						CVal:  rowTotal,
+					}
+				}
+			}
+		}
+
+		if !addTotalsReq.Col {
+			continue
+		}
+
+		summaryRow := getAddTotalsSummaryRow(addTotalsReq, colTotals)
+		groupByValues := getReshapeGroupByValues(table, summaryRow)
+		var bucketKey interface{} = groupByValues
+		if len(groupByValues) == 1 {
+			bucketKey = groupByValues[0]
+		}
+
+		statRes := make(map[string]utils.CValueEnclosure, len(summaryRow))
+		for field, value := range summaryRow {
+			if putils.SliceContainsString(table.groupByCols, field) {
+				continue
+			}
+			if field == addTotalsReq.LabelField {
+				statRes[field] = utils.CValueEnclosure{Dtype: utils.SS_DT_STRING, CVal: value}
+			} else {
+				statRes[field] = utils.CValueEnclosure{Dtype: utils.SS_DT_FLOAT, CVal: value}
+			}
+		}
+
+		aggregationResult.Results = append(aggregationResult.Results, &structs.BucketResult{
+			BucketKey:   bucketKey,
+			GroupByKeys: table.groupByCols,
+			StatRes:     statRes,
+		})
+	}
+}
+
+func performAddTotalsRequestOnMeasureResults(nodeResult *structs.NodeResult, addTotalsReq *structs.AddTotalsRequest) {
+	if len(nodeResult.MeasureResults) == 0 {
+		return
+	}
+
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	colTotals := computeAddTotals(addTotalsReq, table.rows, getAddTotalsFields(addTotalsReq, table.columns, table.groupByCols))
+
+	newCols := make([]string, 0)
+	if addTotalsReq.Row {
+		for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+			if rowTotal, ok := table.rows[rowIndex][addTotalsReq.FieldName]; ok {
+				bucketHolder.MeasureVal[addTotalsReq.FieldName] = rowTotal
+			}
+		}
+		newCols = append(newCols, addTotalsReq.FieldName)
+	}
+
+	if addTotalsReq.Col {
+		summaryRow := getAddTotalsSummaryRow(addTotalsReq, colTotals)
+		measureVal := make(map[string]interface{}, len(summaryRow))
+		for field, value := range summaryRow {
+			if !putils.SliceContainsString(table.groupByCols, field) {
+				measureVal[field] = value
+			}
+		}
+
+		nodeResult.MeasureResults = append(nodeResult.MeasureResults, &structs.BucketHolder{
+			GroupByValues: getReshapeGroupByValues(table, summaryRow),
+			MeasureVal:    measureVal,
+		})
+		nodeResult.BucketCount = len(nodeResult.MeasureResults)
+
+		if addTotalsReq.LabelField != "" && !putils.SliceContainsString(table.groupByCols, addTotalsReq.LabelField) {
+			newCols = append(newCols, addTotalsReq.LabelField)
+		}
+	}
+
+	addMeasureResultsColumns(nodeResult, newCols)
+}
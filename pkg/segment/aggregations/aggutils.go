@@ -0,0 +1,118 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"sort"
+
+	"github.com/siglens/siglens/pkg/common/dtypeutils"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/utils"
+)
+
+// Returns the value as a number, for the commands that only use the numeric values of a
+// field. Empty strings, booleans and multi-values are not numbers.
+func getNumericValue(value interface{}) (float64, bool) {
+	switch val := value.(type) {
+	case nil, bool, []string, []interface{}:
+		return 0, false
+	case string:
+		if val == "" {
+			return 0, false
+		}
+	}
+
+	floatVal, err := dtypeutils.ConvertToFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return floatVal, true
+}
+
+// An expression like tojson() uses all the fields, which its fields mark with "*". On the stats
+// results, those are the columns of the row other than the column the expression creates.
+func expandAllFieldsInExpr(fieldsInExpr []string, columns []string, newColName string) []string {
+	if !utils.SliceContainsString(fieldsInExpr, "*") {
+		return fieldsInExpr
+	}
+
+	fields := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if col != newColName && !utils.SliceContainsString(fields, col) {
+			fields = append(fields, col)
+		}
+	}
+	return fields
+}
+
+// Returns the group by columns of the bucket followed by its stats columns in sorted order.
+func getBucketResultColumns(bucketResult *structs.BucketResult) []string {
+	statNames := make([]string, 0, len(bucketResult.StatRes))
+	for statName := range bucketResult.StatRes {
+		statNames = append(statNames, statName)
+	}
+	sort.Strings(statNames)
+
+	return append(append([]string{}, bucketResult.GroupByKeys...), statNames...)
+}
+
+// Returns the group by columns followed by the measure columns that the row has a value for.
+func getMeasureResultsRowColumns(nodeResult *structs.NodeResult, bucketHolder *structs.BucketHolder) []string {
+	columns := append([]string{}, nodeResult.GroupByCols...)
+	for _, measureCol := range nodeResult.MeasureFunctions {
+		if _, ok := bucketHolder.MeasureVal[measureCol]; ok {
+			columns = append(columns, measureCol)
+		}
+	}
+	return columns
+}
+
+// Adds the new columns of the measure results after the existing ones.
+func addMeasureResultsColumns(nodeResult *structs.NodeResult, newCols []string) {
+	for _, col := range newCols {
+		if !utils.SliceContainsString(nodeResult.MeasureFunctions, col) {
+			nodeResult.MeasureFunctions = append(nodeResult.MeasureFunctions, col)
+		}
+		if _, exists := nodeResult.ColumnsOrder[col]; nodeResult.ColumnsOrder != nil && !exists {
+			nodeResult.ColumnsOrder[col] = len(nodeResult.ColumnsOrder)
+		}
+	}
+}
+
+// For the commands that need all the records at once, moves the records of the segment into
+// heldRecords and returns whether all the segments are processed. When a stats ran before the
+// command, its results only come in once it has processed all the segments, so then they are
+// not held back.
+func holdRecordsUntilLastSegment(nodeResult *structs.NodeResult, heldRecords *map[string]map[string]interface{}, numProcessedSegments *uint64,
+	recs map[string]map[string]interface{}, numTotalSegments uint64, finishesSegment bool) bool {
+
+	if finishesSegment {
+		*numProcessedSegments++
+	}
+
+	if *heldRecords == nil {
+		*heldRecords = make(map[string]map[string]interface{}, 0)
+	}
+
+	for recordKey, record := range recs {
+		(*heldRecords)[recordKey] = record
+		delete(recs, recordKey)
+	}
+
+	return *numProcessedSegments >= numTotalSegments || nodeResult.RecsAggsProcessedSegments >= numTotalSegments
+}
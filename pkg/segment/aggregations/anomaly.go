@@ -0,0 +1,440 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+const (
+	AnomalyIsOutlierField     = "isOutlier"
+	AnomalyProbableCauseField = "probable_cause"
+)
+
+// The statistics of a field over all the rows, which are computed in a first pass before
+// any row is checked. The mean, the mean of the squares and the range are kept in the same
+// running stats as streamstats and eventstats.
+type anomalyFieldStats struct {
+	avg          *structs.RunningStreamStatsResults
+	avgOfSquares *structs.RunningStreamStatsResults
+	valueRange   *structs.RunningStreamStatsResults
+
+	numbers     []float64      // iqr: the numeric values, sorted once the first pass is done
+	valueCounts map[string]int // histogram: the number of rows with each value
+	binCounts   []int          // histogram: the number of rows in each bin of the numeric values
+	numValues   int
+	isNumeric   bool // whether all the values of the field are numeric
+
+	lowerBound float64 // iqr
+	upperBound float64 // iqr
+	iqr        float64 // iqr
+}
+
+func performAnomalyRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	anomalyReq := letColReq.AnomalyRequest
+	if recs != nil {
+		if err := performAnomalyRequestWithoutGroupby(nodeResult, anomalyReq, recs, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performAnomalyRequest: %v", err)
+		}
+		return nil
+	}
+
+	if err := performAnomalyRequestOnHistogram(nodeResult, anomalyReq); err != nil {
+		return fmt.Errorf("performAnomalyRequest: %v", err)
+	}
+	if err := performAnomalyRequestOnMeasureResults(nodeResult, anomalyReq); err != nil {
+		return fmt.Errorf("performAnomalyRequest: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the fields to check out of the columns. Without a field list, all the columns
+// other than the internal fields are checked.
+func getAnomalyFields(anomalyReq *structs.AnomalyRequest, columns []string) []string {
+	if len(anomalyReq.Fields) > 0 {
+		return anomalyReq.Fields
+	}
+
+	fields := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if col == config.GetTimeStampKey() || strings.HasPrefix(col, "_") || col == AnomalyIsOutlierField || col == AnomalyProbableCauseField {
+			continue
+		}
+		fields = append(fields, col)
+	}
+	return fields
+}
+
+func addAnomalyRunningStat(ssResults *structs.RunningStreamStatsResults, field string, measureFunc utils.AggregateFunctions, number float64) error {
+	measureAgg := &structs.MeasureAggregator{MeasureCol: field, MeasureFunc: measureFunc}
+	colValue := utils.CValueEnclosure{Dtype: utils.SS_DT_FLOAT, CVal: number}
+	_, _, err := PerformNoWindowStreamStatsOnSingleFunc(&structs.StreamStatsOptions{}, ssResults, measureAgg, colValue, true)
+	return err
+}
+
+// Returns the value at the percentile of the sorted numbers, interpolating between the
+// two closest numbers.
+func getAnomalyPercentile(sortedNumbers []float64, percentile float64) float64 {
+	position := percentile * float64(len(sortedNumbers)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sortedNumbers[lower] + (position-float64(lower))*(sortedNumbers[upper]-sortedNumbers[lower])
+}
+
+// The bins split the range of the values evenly; there are as many bins as the square root
+// of the number of values.
+func getAnomalyBin(fieldStats *anomalyFieldStats, number float64) int {
+	rangeStat := fieldStats.valueRange.RangeStat
+	numBins := len(fieldStats.binCounts)
+	width := (rangeStat.Max - rangeStat.Min) / float64(numBins)
+	if width == 0 {
+		return 0
+	}
+
+	bin := int((number - rangeStat.Min) / width)
+	if bin >= numBins {
+		bin = numBins - 1
+	}
+	return bin
+}
+
+// The first pass over the rows.
+func computeAnomalyFieldStats(anomalyReq *structs.AnomalyRequest, rows []map[string]interface{}, fields []string) (map[string]*anomalyFieldStats, error) {
+	allStats := make(map[string]*anomalyFieldStats, len(fields))
+	for _, field := range fields {
+		fieldStats := &anomalyFieldStats{
+			avg:          InitRunningStreamStatsResults(utils.Avg),
+			avgOfSquares: InitRunningStreamStatsResults(utils.Avg),
+			valueRange:   InitRunningStreamStatsResults(utils.Range),
+			numbers:      make([]float64, 0),
+			valueCounts:  make(map[string]int),
+			isNumeric:    true,
+		}
+		allStats[field] = fieldStats
+
+		for _, row := range rows {
+			value, exists := row[field]
+			if !exists || value == nil || value == "" {
+				continue
+			}
+			fieldStats.numValues++
+			fieldStats.valueCounts[fmt.Sprintf("%v", value)]++
+
+			number, ok := getNumericValue(value)
+			if !ok {
+				fieldStats.isNumeric = false
+				continue
+			}
+			fieldStats.numbers = append(fieldStats.numbers, number)
+
+			for _, stat := range []struct {
+				ssResults   *structs.RunningStreamStatsResults
+				measureFunc utils.AggregateFunctions
+				number      float64
+			}{
+				{fieldStats.avg, utils.Avg, number},
+				{fieldStats.avgOfSquares, utils.Avg, number * number},
+				{fieldStats.valueRange, utils.Range, number},
+			} {
+				if err := addAnomalyRunningStat(stat.ssResults, field, stat.measureFunc, stat.number); err != nil {
+					return nil, fmt.Errorf("computeAnomalyFieldStats: failed to add value %v of field %v, err: %v", number, field, err)
+				}
+			}
+		}
+
+		if len(fieldStats.numbers) == 0 {
+			fieldStats.isNumeric = false
+			continue
+		}
+
+		switch anomalyReq.Method {
+		case structs.AnomalyHistogram:
+			if fieldStats.isNumeric {
+				fieldStats.binCounts = make([]int, int(math.Ceil(math.Sqrt(float64(len(fieldStats.numbers))))))
+				for _, number := range fieldStats.numbers {
+					fieldStats.binCounts[getAnomalyBin(fieldStats, number)]++
+				}
+			}
+		case structs.AnomalyIQR:
+			sort.Float64s(fieldStats.numbers)
+			q1 := getAnomalyPercentile(fieldStats.numbers, 0.25)
+			q3 := getAnomalyPercentile(fieldStats.numbers, 0.75)
+			fieldStats.iqr = q3 - q1
+			fieldStats.lowerBound = q1 - anomalyReq.Param*fieldStats.iqr
+			fieldStats.upperBound = q3 + anomalyReq.Param*fieldStats.iqr
+		}
+	}
+
+	return allStats, nil
+}
+
+// Returns how anomalous the value of the field is, where a higher score is more anomalous,
+// and whether the value is an anomaly.
+func getAnomalyScore(anomalyReq *structs.AnomalyRequest, fieldStats *anomalyFieldStats, value interface{}) (float64, bool) {
+	if value == nil || value == "" || fieldStats.numValues == 0 {
+		return 0, false
+	}
+	number, isNumber := getNumericValue(value)
+
+	switch anomalyReq.Method {
+	case structs.AnomalyHistogram:
+		count := fieldStats.valueCounts[fmt.Sprintf("%v", value)]
+		if fieldStats.isNumeric && isNumber {
+			count = fieldStats.binCounts[getAnomalyBin(fieldStats, number)]
+		}
+		probability := float64(count) / float64(fieldStats.numValues)
+		return 1 - probability, probability < anomalyReq.PThresh
+	case structs.AnomalyZScore:
+		if !isNumber {
+			return 0, false
+		}
+		mean := calculateAvg(fieldStats.avg, false).CVal.(float64)
+		meanOfSquares := calculateAvg(fieldStats.avgOfSquares, false).CVal.(float64)
+		stdDev := math.Sqrt(math.Max(meanOfSquares-mean*mean, 0))
+		if stdDev == 0 {
+			return 0, false
+		}
+		zScore := math.Abs(number-mean) / stdDev
+		probability := math.Erfc(zScore / math.Sqrt2)
+		return zScore, probability < anomalyReq.PThresh
+	case structs.AnomalyIQR:
+		if !isNumber {
+			return 0, false
+		}
+		distance := 0.0
+		if number > fieldStats.upperBound {
+			distance = number - fieldStats.upperBound
+		} else if anomalyReq.UseLower && number < fieldStats.lowerBound {
+			distance = fieldStats.lowerBound - number
+		}
+		if distance == 0 {
+			return 0, false
+		}
+		if fieldStats.iqr > 0 {
+			distance /= fieldStats.iqr
+		}
+		return distance, true
+	}
+
+	return 0, false
+}
+
+// Checks every row against the statistics of all the rows, flags the anomalous ones and
+// returns which rows are kept.
+func applyAnomalyRequest(anomalyReq *structs.AnomalyRequest, rows []map[string]interface{}, columns []string) ([]bool, error) {
+	fields := getAnomalyFields(anomalyReq, columns)
+	allStats, err := computeAnomalyFieldStats(anomalyReq, rows, fields)
+	if err != nil {
+		return nil, fmt.Errorf("applyAnomalyRequest: %v", err)
+	}
+
+	keep := make([]bool, len(rows))
+	for rowIndex, row := range rows {
+		isAnomaly := false
+		probableCause := ""
+		maxScore := 0.0
+		for _, field := range fields {
+			fieldStats := allStats[field]
+			score, isFieldAnomaly := getAnomalyScore(anomalyReq, fieldStats, row[field])
+			if !isFieldAnomaly {
+				continue
+			}
+
+			if !isAnomaly || score > maxScore {
+				probableCause, maxScore = field, score
+			}
+			isAnomaly = true
+
+			if anomalyReq.Action == structs.AnomalyTransform {
+				bound := fieldStats.upperBound
+				if number, _ := getNumericValue(row[field]); number < fieldStats.lowerBound {
+					bound = fieldStats.lowerBound
+				}
+				if anomalyReq.Mark {
+					row[field] = fmt.Sprintf("000 %v", bound)
+				} else {
+					row[field] = bound
+				}
+			}
+		}
+
+		switch anomalyReq.Action {
+		case structs.AnomalyFilter:
+			keep[rowIndex] = isAnomaly
+		case structs.AnomalyRemove:
+			keep[rowIndex] = !isAnomaly
+			continue
+		default:
+			keep[rowIndex] = true
+		}
+
+		row[AnomalyIsOutlierField] = 0
+		if isAnomaly {
+			row[AnomalyIsOutlierField] = 1
+			row[AnomalyProbableCauseField] = probableCause
+		}
+	}
+
+	return keep, nil
+}
+
+func getAnomalyColumns(anomalyReq *structs.AnomalyRequest) []string {
+	if anomalyReq.Action == structs.AnomalyRemove {
+		return []string{}
+	}
+	return []string{AnomalyIsOutlierField, AnomalyProbableCauseField}
+}
+
+// Returns the columns that are set on the rows, including the fields whose values are clamped.
+func getAnomalyChangedColumns(anomalyReq *structs.AnomalyRequest, columns []string) []string {
+	changedCols := getAnomalyColumns(anomalyReq)
+	if anomalyReq.Action == structs.AnomalyTransform {
+		changedCols = append(changedCols, getAnomalyFields(anomalyReq, columns)...)
+	}
+	return changedCols
+}
+
+// The thresholds come from the statistics of each field over every record, so no record can be
+// marked until all of them are seen.
+func performAnomalyRequestWithoutGroupby(nodeResult *structs.NodeResult, anomalyReq *structs.AnomalyRequest, recs map[string]map[string]interface{},
+	finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &anomalyReq.Records, &anomalyReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	recordKeys := make([]string, 0, len(anomalyReq.Records))
+	for recordKey := range anomalyReq.Records {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Strings(recordKeys)
+
+	rows := make([]map[string]interface{}, len(recordKeys))
+	for i, recordKey := range recordKeys {
+		rows[i] = anomalyReq.Records[recordKey]
+	}
+
+	columns := make([]string, 0, len(finalCols))
+	for col := range finalCols {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	keep, err := applyAnomalyRequest(anomalyReq, rows, columns)
+	if err != nil {
+		return fmt.Errorf("performAnomalyRequestWithoutGroupby: %v", err)
+	}
+
+	for i, recordKey := range recordKeys {
+		if keep[i] {
+			recs[recordKey] = rows[i]
+		}
+	}
+	anomalyReq.Records = nil
+
+	for _, col := range getAnomalyColumns(anomalyReq) {
+		finalCols[col] = true
+	}
+
+	return nil
+}
+
+func performAnomalyRequestOnHistogram(nodeResult *structs.NodeResult, anomalyReq *structs.AnomalyRequest) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		keep, err := applyAnomalyRequest(anomalyReq, table.rows, table.columns)
+		if err != nil {
+			return fmt.Errorf("performAnomalyRequestOnHistogram: %v", err)
+		}
+
+		changedCols := getAnomalyChangedColumns(anomalyReq, table.columns)
+		results := make([]*structs.BucketResult, 0, len(aggregationResult.Results))
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			if !keep[rowIndex] {
+				continue
+			}
+
+			// The groupby columns are the keys of the buckets, so they are left unchanged.
+			for _, col := range changedCols {
+				value, ok := table.rows[rowIndex][col]
+				if ok && !putils.SliceContainsString(table.groupByCols, col) {
+					bucketResult.StatRes[col] = CreateCValueFromColValue(value)
+				}
+			}
+			results = append(results, bucketResult)
+		}
+		aggregationResult.Results = results
+	}
+
+	return nil
+}
+
+func performAnomalyRequestOnMeasureResults(nodeResult *structs.NodeResult, anomalyReq *structs.AnomalyRequest) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	keep, err := applyAnomalyRequest(anomalyReq, table.rows, table.columns)
+	if err != nil {
+		return fmt.Errorf("performAnomalyRequestOnMeasureResults: %v", err)
+	}
+
+	changedCols := getAnomalyChangedColumns(anomalyReq, table.columns)
+	measureResults := make([]*structs.BucketHolder, 0, len(nodeResult.MeasureResults))
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		if !keep[rowIndex] {
+			continue
+		}
+
+		for _, col := range changedCols {
+			value, ok := table.rows[rowIndex][col]
+			if ok && !putils.SliceContainsString(table.groupByCols, col) {
+				bucketHolder.MeasureVal[col] = value
+			}
+		}
+		measureResults = append(measureResults, bucketHolder)
+	}
+	nodeResult.MeasureResults = measureResults
+	nodeResult.BucketCount = len(measureResults)
+
+	newCols := make([]string, 0)
+	for _, col := range getAnomalyColumns(anomalyReq) {
+		if !putils.SliceContainsString(table.groupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+
+	return nil
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	segutils "github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+// Appendcols and appendpipe both add records to the results. Appendcols merges each record of
+// its subsearch, which was run before the main search, into the row at the same position.
+// Appendpipe runs its pipeline over the rows once they are all known and adds the records it
+// returns after the rows.
+type appendSubsearch struct {
+	records     []map[string]interface{}
+	cols        []string
+	pipeline    *structs.QueryAggregators // set only for appendpipe
+	runPipeline func(pipeline *structs.QueryAggregators, records []map[string]interface{}) ([]map[string]interface{}, []string, error)
+	mergeRows   bool
+	override    bool // whether the merged values replace the values of the rows
+	heldRecords *map[string]map[string]interface{}
+	numSegments *uint64
+}
+
+func performAppendColsRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	appendColsReq := letColReq.AppendColsRequest
+	subsearch := &appendSubsearch{
+		records:     appendColsReq.SubsearchRecords,
+		cols:        appendColsReq.SubsearchCols,
+		mergeRows:   true,
+		override:    appendColsReq.Override,
+		heldRecords: &appendColsReq.Records,
+		numSegments: &appendColsReq.NumProcessedSegments,
+	}
+
+	if err := performAppendSubsearch(nodeResult, subsearch, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+		return fmt.Errorf("performAppendColsRequest: %v", err)
+	}
+	return nil
+}
+
+func performAppendPipeRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	appendPipeReq := letColReq.AppendPipeRequest
+	if appendPipeReq.PipelineAggs == nil || appendPipeReq.RunPipeline == nil {
+		return fmt.Errorf("performAppendPipeRequest: the pipeline: %v is not parsed", appendPipeReq.Pipeline)
+	}
+	subsearch := &appendSubsearch{
+		pipeline:    appendPipeReq.PipelineAggs,
+		runPipeline: appendPipeReq.RunPipeline,
+		heldRecords: &appendPipeReq.Records,
+		numSegments: &appendPipeReq.NumProcessedSegments,
+	}
+
+	if err := performAppendSubsearch(nodeResult, subsearch, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+		return fmt.Errorf("performAppendPipeRequest: %v", err)
+	}
+	return nil
+}
+
+func performAppendSubsearch(nodeResult *structs.NodeResult, subsearch *appendSubsearch, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if recs != nil {
+		if err := performAppendSubsearchWithoutGroupby(nodeResult, subsearch, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performAppendSubsearch: %v", err)
+		}
+		return nil
+	}
+
+	if err := performAppendSubsearchOnHistogram(nodeResult, subsearch); err != nil {
+		return fmt.Errorf("performAppendSubsearch: %v", err)
+	}
+	if err := performAppendSubsearchOnMeasureResults(nodeResult, subsearch); err != nil {
+		return fmt.Errorf("performAppendSubsearch: %v", err)
+	}
+
+	return nil
+}
+
+// The pipeline gets copies of the rows, so the commands in it do not change the rows.
+func runAppendPipeline(subsearch *appendSubsearch, rows []map[string]interface{}) error {
+	if subsearch.pipeline == nil {
+		return nil
+	}
+
+	records := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		records[i] = copyAppendSubsearchRecord(row)
+	}
+
+	records, cols, err := subsearch.runPipeline(subsearch.pipeline, records)
+	if err != nil {
+		return fmt.Errorf("runAppendPipeline: %v", err)
+	}
+	subsearch.records = records
+	subsearch.cols = cols
+
+	return nil
+}
+
+// Returns how many of the subsearch records are merged into the rows.
+func getNumMergedRows(subsearch *appendSubsearch, numRows int) int {
+	if !subsearch.mergeRows {
+		return 0
+	}
+	if len(subsearch.records) < numRows {
+		return len(subsearch.records)
+	}
+	return numRows
+}
+
+func mergeAppendSubsearchRecord(subsearch *appendSubsearch, row map[string]interface{}, record map[string]interface{}) {
+	for col, value := range record {
+		if existingValue, exists := row[col]; exists && existingValue != nil && !subsearch.override {
+			continue
+		}
+		row[col] = value
+	}
+}
+
+// The subsearch records are copied, since the results may be processed more than once.
+func copyAppendSubsearchRecord(record map[string]interface{}) map[string]interface{} {
+	newRecord := make(map[string]interface{}, len(record))
+	for col, value := range record {
+		newRecord[col] = value
+	}
+	return newRecord
+}
+
+// Appendcols lines up the subsearch records with the rows by position, and appendpipe runs over
+// all the rows, so both need the rows of every segment first.
+func performAppendSubsearchWithoutGroupby(nodeResult *structs.NodeResult, subsearch *appendSubsearch, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, subsearch.heldRecords, subsearch.numSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	currentOrder, err := GetOrderedRecs(*subsearch.heldRecords, recordIndexInFinal)
+	if err != nil {
+		return fmt.Errorf("performAppendSubsearchWithoutGroupby: %v", err)
+	}
+
+	rows := make([]map[string]interface{}, len(currentOrder))
+	for i, recordKey := range currentOrder {
+		recs[recordKey] = (*subsearch.heldRecords)[recordKey]
+		rows[i] = recs[recordKey]
+	}
+	*subsearch.heldRecords = nil
+
+	if err := runAppendPipeline(subsearch, rows); err != nil {
+		return fmt.Errorf("performAppendSubsearchWithoutGroupby: %v", err)
+	}
+
+	numMerged := getNumMergedRows(subsearch, len(currentOrder))
+	for i := 0; i < numMerged; i++ {
+		mergeAppendSubsearchRecord(subsearch, recs[currentOrder[i]], subsearch.records[i])
+	}
+
+	nextIndex := 0
+	for _, index := range recordIndexInFinal {
+		if index >= nextIndex {
+			nextIndex = index + 1
+		}
+	}
+	for _, record := range subsearch.records[numMerged:] {
+		recordKey := fmt.Sprintf("appended_%v", nextIndex)
+		recs[recordKey] = copyAppendSubsearchRecord(record)
+		recordIndexInFinal[recordKey] = nextIndex
+		nextIndex++
+	}
+
+	for _, col := range subsearch.cols {
+		finalCols[col] = true
+	}
+
+	return nil
+}
+
+// The groupby columns are the keys of the buckets, so they are not changed in the merged rows.
+func performAppendSubsearchOnHistogram(nodeResult *structs.NodeResult, subsearch *appendSubsearch) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		if err := runAppendPipeline(subsearch, table.rows); err != nil {
+			return fmt.Errorf("performAppendSubsearchOnHistogram: %v", err)
+		}
+		numMerged := getNumMergedRows(subsearch, len(aggregationResult.Results))
+		for i := 0; i < numMerged; i++ {
+			row := table.rows[i]
+			mergeAppendSubsearchRecord(subsearch, row, subsearch.records[i])
+			for col := range subsearch.records[i] {
+				if !putils.SliceContainsString(table.groupByCols, col) {
+					aggregationResult.Results[i].StatRes[col] = CreateCValueFromColValue(row[col])
+				}
+			}
+		}
+
+		for _, record := range subsearch.records[numMerged:] {
+			groupByValues := getReshapeGroupByValues(table, record)
+			var bucketKey interface{} = groupByValues
+			if len(groupByValues) == 1 {
+				bucketKey = groupByValues[0]
+			}
+
+			bucketResult := &structs.BucketResult{
+				BucketKey:   bucketKey,
+				GroupByKeys: table.groupByCols,
+				StatRes:     make(map[string]segutils.CValueEnclosure, len(record)),
+			}
+			for col, value := range record {
+				if !putils.SliceContainsString(table.groupByCols, col) {
+					bucketResult.StatRes[col] = CreateCValueFromColValue(value)
+				}
+			}
+			aggregationResult.Results = append(aggregationResult.Results, bucketResult)
+		}
+	}
+
+	return nil
+}
+
+func performAppendSubsearchOnMeasureResults(nodeResult *structs.NodeResult, subsearch *appendSubsearch) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	if err := runAppendPipeline(subsearch, table.rows); err != nil {
+		return fmt.Errorf("performAppendSubsearchOnMeasureResults: %v", err)
+	}
+	numMerged := getNumMergedRows(subsearch, len(nodeResult.MeasureResults))
+	for i := 0; i < numMerged; i++ {
+		row := table.rows[i]
+		mergeAppendSubsearchRecord(subsearch, row, subsearch.records[i])
+		for col := range subsearch.records[i] {
+			if !putils.SliceContainsString(table.groupByCols, col) {
+				nodeResult.MeasureResults[i].MeasureVal[col] = row[col]
+			}
+		}
+	}
+
+	for _, record := range subsearch.records[numMerged:] {
+		measureVal := make(map[string]interface{}, len(record))
+		for col, value := range record {
+			if !putils.SliceContainsString(table.groupByCols, col) {
+				measureVal[col] = value
+			}
+		}
+
+		nodeResult.MeasureResults = append(nodeResult.MeasureResults, &structs.BucketHolder{
+			GroupByValues: getReshapeGroupByValues(table, record),
+			MeasureVal:    measureVal,
+		})
+	}
+	nodeResult.BucketCount = len(nodeResult.MeasureResults)
+
+	newCols := make([]string, 0, len(subsearch.cols))
+	for _, col := range subsearch.cols {
+		if !putils.SliceContainsString(table.groupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+
+	return nil
+}
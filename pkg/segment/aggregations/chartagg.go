@@ -0,0 +1,379 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// A row of the stats results, grouped by the over field and the split field.
+type chartInputRow struct {
+	overVal   string
+	byVal     string
+	elemCount uint64
+	values    map[string]utils.CValueEnclosure
+}
+
+// A row of the chart results; there is one row per value of the over field.
+type chartOutputRow struct {
+	overVal   string
+	elemCount uint64
+	statRes   map[string]utils.CValueEnclosure
+}
+
+func InitChartExpr(byField string, limitExpr *structs.LimitExpr, tcOptions *structs.TcOptions, measureOperations []*structs.MeasureAggregator) *structs.TimechartExpr {
+	chartExpr := &structs.TimechartExpr{
+		ByField:   byField,
+		TcOptions: tcOptions,
+		SingleAgg: &structs.SingleAgg{
+			MeasureOperations: measureOperations,
+		},
+	}
+
+	if limitExpr == nil {
+		limitExpr = &structs.LimitExpr{
+			IsTop: true,
+			Num:   10,
+		}
+	}
+
+	// limit=0 keeps all the distinct values of the split field
+	if limitExpr.Num > 0 {
+		chartExpr.LimitExpr = limitExpr
+		chartExpr.LimitExpr.LimitScoreMode = structs.LSMBySum
+		if len(measureOperations) > 1 {
+			chartExpr.LimitExpr.LimitScoreMode = structs.LSMByFreq
+		}
+	}
+
+	if chartExpr.TcOptions == nil {
+		chartExpr.TcOptions = &structs.TcOptions{
+			UseNull:  true,
+			UseOther: true,
+			NullStr:  "null",
+			OtherStr: "other",
+		}
+	}
+
+	return chartExpr
+}
+
+func performChartRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	if recs != nil {
+		performChartRequestWithoutGroupby(letColReq.ChartRequest, recs, finalCols)
+		return nil
+	}
+
+	if err := performChartRequestOnHistogram(nodeResult, letColReq.ChartRequest); err != nil {
+		return fmt.Errorf("performChartRequest: %v", err)
+	}
+	if err := performChartRequestOnMeasureResults(nodeResult, letColReq.ChartRequest); err != nil {
+		return fmt.Errorf("performChartRequest: %v", err)
+	}
+
+	return nil
+}
+
+// The stats results are in the records when the stats is done after other commands,
+// so each record is a row of the stats results.
+func performChartRequestWithoutGroupby(chartReq *structs.ChartRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	recordKeys := make([]string, 0, len(recs))
+	for recordKey := range recs {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Strings(recordKeys)
+
+	rows := make([]*chartInputRow, 0, len(recs))
+	overValToRecordKey := make(map[string]string)
+	for _, recordKey := range recordKeys {
+		record := recs[recordKey]
+		overVal, exists := record[chartReq.OverField]
+		if !exists {
+			continue
+		}
+		overValStr := fmt.Sprintf("%v", overVal)
+		if _, exists := overValToRecordKey[overValStr]; !exists {
+			overValToRecordKey[overValStr] = recordKey
+		}
+
+		byValStr := ""
+		if byVal, exists := record[chartReq.Timechart.ByField]; exists && byVal != nil {
+			byValStr = fmt.Sprintf("%v", byVal)
+		}
+
+		values := make(map[string]utils.CValueEnclosure, len(record))
+		for colName, colValue := range record {
+			values[colName] = getChartCValue(colValue)
+		}
+
+		rows = append(rows, &chartInputRow{
+			overVal: overValStr,
+			byVal:   byValStr,
+			values:  values,
+		})
+	}
+
+	outputRows, columns := pivotChartRows(chartReq, rows)
+
+	for recordKey := range recs {
+		delete(recs, recordKey)
+	}
+	for colName := range finalCols {
+		delete(finalCols, colName)
+	}
+
+	finalCols[chartReq.OverField] = true
+	for _, column := range columns {
+		finalCols[column] = true
+	}
+
+	for _, outputRow := range outputRows {
+		record := make(map[string]interface{}, len(outputRow.statRes)+1)
+		record[chartReq.OverField] = outputRow.overVal
+		for colName, cVal := range outputRow.statRes {
+			record[colName] = cVal.CVal
+		}
+		recs[overValToRecordKey[outputRow.overVal]] = record
+	}
+}
+
+func performChartRequestOnHistogram(nodeResult *structs.NodeResult, chartReq *structs.ChartRequest) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		rows := make([]*chartInputRow, 0, len(aggregationResult.Results))
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			overVal, ok := getAggregationResultGroupByCell(aggregationResult, rowIndex, chartReq.OverField)
+			if !ok {
+				return fmt.Errorf("performChartRequestOnHistogram: failed to get the value of field %v from row %v", chartReq.OverField, rowIndex)
+			}
+			byVal, _ := getAggregationResultGroupByCell(aggregationResult, rowIndex, chartReq.Timechart.ByField)
+
+			rows = append(rows, &chartInputRow{
+				overVal:   overVal,
+				byVal:     byVal,
+				elemCount: bucketResult.ElemCount,
+				values:    bucketResult.StatRes,
+			})
+		}
+
+		outputRows, _ := pivotChartRows(chartReq, rows)
+
+		results := make([]*structs.BucketResult, len(outputRows))
+		for i, outputRow := range outputRows {
+			results[i] = &structs.BucketResult{
+				ElemCount:   outputRow.elemCount,
+				BucketKey:   outputRow.overVal,
+				StatRes:     outputRow.statRes,
+				GroupByKeys: []string{chartReq.OverField},
+			}
+		}
+		aggregationResult.Results = results
+	}
+
+	return nil
+}
+
+func performChartRequestOnMeasureResults(nodeResult *structs.NodeResult, chartReq *structs.ChartRequest) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	rows := make([]*chartInputRow, 0, len(nodeResult.MeasureResults))
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		overVal, ok := getMeasureResultsGroupByCell(nodeResult, rowIndex, chartReq.OverField)
+		if !ok {
+			return fmt.Errorf("performChartRequestOnMeasureResults: failed to get the value of field %v from row %v", chartReq.OverField, rowIndex)
+		}
+		byVal, _ := getMeasureResultsGroupByCell(nodeResult, rowIndex, chartReq.Timechart.ByField)
+
+		values := make(map[string]utils.CValueEnclosure, len(bucketHolder.MeasureVal))
+		for measureName, measureVal := range bucketHolder.MeasureVal {
+			values[measureName] = getChartCValue(measureVal)
+		}
+
+		rows = append(rows, &chartInputRow{
+			overVal: overVal,
+			byVal:   byVal,
+			values:  values,
+		})
+	}
+
+	outputRows, columns := pivotChartRows(chartReq, rows)
+
+	measureResults := make([]*structs.BucketHolder, len(outputRows))
+	for i, outputRow := range outputRows {
+		measureVal := make(map[string]interface{}, len(outputRow.statRes))
+		for colName, cVal := range outputRow.statRes {
+			rawVal, err := cVal.GetValue()
+			if err != nil {
+				log.Errorf("performChartRequestOnMeasureResults: failed to get raw value of column %v, err: %v", colName, err)
+				continue
+			}
+			measureVal[colName] = rawVal
+		}
+
+		measureResults[i] = &structs.BucketHolder{
+			GroupByValues: []string{outputRow.overVal},
+			MeasureVal:    measureVal,
+		}
+	}
+
+	nodeResult.MeasureResults = measureResults
+	nodeResult.MeasureFunctions = columns
+	nodeResult.GroupByCols = []string{chartReq.OverField}
+	nodeResult.BucketCount = len(measureResults)
+
+	return nil
+}
+
+// The values() of a stats is kept as a slice, so that it can be merged into the other column.
+func getChartCValue(value interface{}) utils.CValueEnclosure {
+	if strSlice, ok := value.([]string); ok {
+		return utils.CValueEnclosure{Dtype: utils.SS_DT_STRING_SLICE, CVal: strSlice}
+	}
+	return CreateCValueFromColValue(value)
+}
+
+// Turns every distinct value of the split field into a column named "<agg>: <value>", the same
+// as the columns of a timechart. The values of the split field are ranked the same way as for
+// a timechart, and the values outside of the limit are merged into the "<agg>: <otherstr>" column.
+// Returns the rows in the order their over field value first appears, and the columns in order.
+func pivotChartRows(chartReq *structs.ChartRequest, rows []*chartInputRow) ([]*chartOutputRow, []string) {
+	timechart := chartReq.Timechart
+	tcOptions := timechart.TcOptions
+	measureOperations := timechart.SingleAgg.MeasureOperations
+
+	byVals := make([]string, 0)
+	groupByColValCnt := make(map[string]int)
+	for _, row := range rows {
+		if row.byVal == "" {
+			if !tcOptions.UseNull {
+				continue
+			}
+			row.byVal = tcOptions.NullStr
+		}
+
+		if _, exists := groupByColValCnt[row.byVal]; !exists {
+			byVals = append(byVals, row.byVal)
+		}
+		groupByColValCnt[row.byVal]++
+	}
+
+	groupValScoreMap := InitialScoreMap(timechart, groupByColValCnt)
+	if IsRankBySum(timechart) {
+		for _, row := range rows {
+			scoreVal, exists := groupValScoreMap[row.byVal]
+			if !exists {
+				continue
+			}
+			for _, mInfo := range measureOperations {
+				if eVal, exists := row.values[mInfo.String()]; exists {
+					MergeVal(scoreVal, eVal, nil, nil, nil, nil, mInfo.MeasureFunc, true)
+				}
+			}
+		}
+	}
+	valIsInLimit := CheckGroupByColValsAgainstLimit(timechart, groupByColValCnt, groupValScoreMap, measureOperations)
+
+	outputRows := make([]*chartOutputRow, 0)
+	overValToRow := make(map[string]*chartOutputRow)
+	otherCVals := make(map[string][]*utils.CValueEnclosure)
+	otherStrSets := make(map[string][]map[string]struct{})
+	hasOtherCol := false
+	for _, row := range rows {
+		outputRow, exists := overValToRow[row.overVal]
+		if !exists {
+			outputRow = &chartOutputRow{
+				overVal: row.overVal,
+				statRes: make(map[string]utils.CValueEnclosure),
+			}
+			overValToRow[row.overVal] = outputRow
+			outputRows = append(outputRows, outputRow)
+		}
+		outputRow.elemCount += row.elemCount
+
+		// The null values of the split field are dropped when usenull=false
+		if _, exists := groupByColValCnt[row.byVal]; !exists {
+			continue
+		}
+
+		isOtherCol := IsOtherCol(valIsInLimit, row.byVal)
+		if isOtherCol && !tcOptions.UseOther {
+			continue
+		}
+
+		for index, mInfo := range measureOperations {
+			eVal, exists := row.values[mInfo.String()]
+			if !exists {
+				continue
+			}
+
+			if !isOtherCol {
+				outputRow.statRes[mInfo.String()+": "+row.byVal] = eVal
+				continue
+			}
+
+			if _, exists := otherCVals[row.overVal]; !exists {
+				otherCVals[row.overVal] = make([]*utils.CValueEnclosure, len(measureOperations))
+				otherStrSets[row.overVal] = make([]map[string]struct{}, len(measureOperations))
+				for i := range measureOperations {
+					otherCVals[row.overVal][i] = &utils.CValueEnclosure{CVal: nil, Dtype: utils.SS_INVALID}
+					otherStrSets[row.overVal][i] = make(map[string]struct{})
+				}
+			}
+
+			var strSetToMerge map[string]struct{}
+			if strSlice, ok := eVal.CVal.([]string); ok {
+				strSetToMerge = make(map[string]struct{}, len(strSlice))
+				for _, str := range strSlice {
+					strSetToMerge[str] = struct{}{}
+				}
+			}
+
+			useAdditionForMerge := mInfo.MeasureFunc != utils.Values
+			MergeVal(otherCVals[row.overVal][index], eVal, nil, nil, otherStrSets[row.overVal][index], strSetToMerge, mInfo.MeasureFunc, useAdditionForMerge)
+			hasOtherCol = true
+		}
+	}
+
+	for overVal, otherCValArr := range otherCVals {
+		for index, mInfo := range measureOperations {
+			if otherCValArr[index].Dtype != utils.SS_INVALID {
+				overValToRow[overVal].statRes[mInfo.String()+": "+tcOptions.OtherStr] = *otherCValArr[index]
+			}
+		}
+	}
+
+	columns := make([]string, 0)
+	for _, mInfo := range measureOperations {
+		for _, byVal := range byVals {
+			if !IsOtherCol(valIsInLimit, byVal) {
+				columns = append(columns, mInfo.String()+": "+byVal)
+			}
+		}
+		if hasOtherCol {
+			columns = append(columns, mInfo.String()+": "+tcOptions.OtherStr)
+		}
+	}
+
+	return outputRows, columns
+}
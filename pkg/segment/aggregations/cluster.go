@@ -0,0 +1,276 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+const clusterNgramSize = 3
+
+// The first row of a cluster is the one the other rows are compared to.
+type clusterInfo struct {
+	terms   []string
+	termSet map[string]struct{}
+	count   int
+}
+
+func performClusterRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	clusterReq := letColReq.ClusterRequest
+	if clusterReq.Cluster == nil {
+		return fmt.Errorf("performClusterRequest: the cluster options are missing")
+	}
+
+	if recs != nil {
+		if err := performClusterRequestWithoutGroupby(nodeResult, clusterReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performClusterRequest: %v", err)
+		}
+		return nil
+	}
+
+	performClusterRequestOnHistogram(nodeResult, clusterReq)
+	performClusterRequestOnMeasureResults(nodeResult, clusterReq)
+
+	return nil
+}
+
+// Splits the value into terms. Without delimiters, the terms are the runs of letters and digits.
+func getClusterTerms(cluster *structs.Cluster, value string) []string {
+	if cluster.Match == "ngramset" {
+		runes := []rune(value)
+		if len(runes) <= clusterNgramSize {
+			return []string{value}
+		}
+
+		ngrams := make([]string, 0, len(runes)-clusterNgramSize+1)
+		for i := 0; i+clusterNgramSize <= len(runes); i++ {
+			ngrams = append(ngrams, string(runes[i:i+clusterNgramSize]))
+		}
+		return ngrams
+	}
+
+	return strings.FieldsFunc(value, func(r rune) bool {
+		if cluster.Delims != "" {
+			return strings.ContainsRune(cluster.Delims, r)
+		}
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func getClusterTermSet(terms []string) map[string]struct{} {
+	termSet := make(map[string]struct{}, len(terms))
+	for _, term := range terms {
+		termSet[term] = struct{}{}
+	}
+	return termSet
+}
+
+// Returns how similar the terms are to the first row of the cluster, between 0 and 1. The
+// termlist match compares the terms position by position, while termset and ngramset only
+// compare which terms appear.
+func getClusterSimilarity(cluster *structs.Cluster, info *clusterInfo, terms []string, termSet map[string]struct{}) float64 {
+	if cluster.Match == "termlist" {
+		maxLen := len(info.terms)
+		if len(terms) > maxLen {
+			maxLen = len(terms)
+		}
+		if maxLen == 0 {
+			return 1
+		}
+
+		numMatches := 0
+		for i := 0; i < len(terms) && i < len(info.terms); i++ {
+			if terms[i] == info.terms[i] {
+				numMatches++
+			}
+		}
+		return float64(numMatches) / float64(maxLen)
+	}
+
+	numCommon := 0
+	for term := range termSet {
+		if _, exists := info.termSet[term]; exists {
+			numCommon++
+		}
+	}
+	numAll := len(termSet) + len(info.termSet) - numCommon
+	if numAll == 0 {
+		return 1
+	}
+	return float64(numCommon) / float64(numAll)
+}
+
+// Adds each row to the most similar cluster before it, or starts a new cluster when none is
+// similar enough, then labels the rows and returns which rows are kept.
+func applyClusterRequest(clusterReq *structs.ClusterRequest, rows []map[string]interface{}) []bool {
+	cluster := clusterReq.Cluster
+	clusters := make([]*clusterInfo, 0)
+	labels := make([]int, len(rows))
+
+	for rowIndex, row := range rows {
+		value := ""
+		if fieldValue, exists := row[cluster.Field]; exists && fieldValue != nil {
+			value = fmt.Sprintf("%v", fieldValue)
+		}
+		terms := getClusterTerms(cluster, value)
+		termSet := getClusterTermSet(terms)
+
+		bestIndex, bestSimilarity := -1, 0.0
+		for i, info := range clusters {
+			similarity := getClusterSimilarity(cluster, info, terms, termSet)
+			if similarity >= cluster.Threshold && similarity > bestSimilarity {
+				bestIndex, bestSimilarity = i, similarity
+			}
+		}
+
+		if bestIndex < 0 {
+			bestIndex = len(clusters)
+			clusters = append(clusters, &clusterInfo{terms: terms, termSet: termSet})
+		}
+		clusters[bestIndex].count++
+		labels[rowIndex] = bestIndex
+	}
+
+	keep := make([]bool, len(rows))
+	keptClusters := make(map[int]struct{}, len(clusters))
+	for rowIndex, row := range rows {
+		label := labels[rowIndex]
+		row[clusterReq.LabelField] = label + 1
+		if clusterReq.ShowCount {
+			row[clusterReq.CountField] = clusters[label].count
+		}
+
+		if _, exists := keptClusters[label]; !exists || clusterReq.LabelOnly {
+			keep[rowIndex] = true
+			keptClusters[label] = struct{}{}
+		}
+	}
+
+	return keep
+}
+
+func getClusterColumns(clusterReq *structs.ClusterRequest) []string {
+	if clusterReq.ShowCount {
+		return []string{clusterReq.LabelField, clusterReq.CountField}
+	}
+	return []string{clusterReq.LabelField}
+}
+
+// A record joins the most similar of the clusters started by the records before it, so the
+// clusters are built in the order of the results, over every record.
+func performClusterRequestWithoutGroupby(nodeResult *structs.NodeResult, clusterReq *structs.ClusterRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &clusterReq.Records, &clusterReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	currentOrder, err := GetOrderedRecs(clusterReq.Records, recordIndexInFinal)
+	if err != nil {
+		return fmt.Errorf("performClusterRequestWithoutGroupby: %v", err)
+	}
+
+	rows := make([]map[string]interface{}, len(currentOrder))
+	for i, recordKey := range currentOrder {
+		rows[i] = clusterReq.Records[recordKey]
+	}
+
+	keep := applyClusterRequest(clusterReq, rows)
+	for i, recordKey := range currentOrder {
+		if keep[i] {
+			recs[recordKey] = rows[i]
+		}
+	}
+	clusterReq.Records = nil
+
+	for _, col := range getClusterColumns(clusterReq) {
+		finalCols[col] = true
+	}
+
+	return nil
+}
+
+func performClusterRequestOnHistogram(nodeResult *structs.NodeResult, clusterReq *structs.ClusterRequest) {
+	columns := getClusterColumns(clusterReq)
+
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		keep := applyClusterRequest(clusterReq, table.rows)
+
+		results := make([]*structs.BucketResult, 0, len(aggregationResult.Results))
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			if !keep[rowIndex] {
+				continue
+			}
+
+			// The groupby columns are the keys of the buckets, so they are left unchanged.
+			for _, col := range columns {
+				if !putils.SliceContainsString(table.groupByCols, col) {
+					bucketResult.StatRes[col] = CreateCValueFromColValue(table.rows[rowIndex][col])
+				}
+			}
+			results = append(results, bucketResult)
+		}
+		aggregationResult.Results = results
+	}
+}
+
+func performClusterRequestOnMeasureResults(nodeResult *structs.NodeResult, clusterReq *structs.ClusterRequest) {
+	if len(nodeResult.MeasureResults) == 0 {
+		return
+	}
+
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	keep := applyClusterRequest(clusterReq, table.rows)
+
+	columns := getClusterColumns(clusterReq)
+	measureResults := make([]*structs.BucketHolder, 0, len(nodeResult.MeasureResults))
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		if !keep[rowIndex] {
+			continue
+		}
+
+		for _, col := range columns {
+			if !putils.SliceContainsString(table.groupByCols, col) {
+				bucketHolder.MeasureVal[col] = table.rows[rowIndex][col]
+			}
+		}
+		measureResults = append(measureResults, bucketHolder)
+	}
+	nodeResult.MeasureResults = measureResults
+	nodeResult.BucketCount = len(measureResults)
+
+	newCols := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if !putils.SliceContainsString(table.groupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+}
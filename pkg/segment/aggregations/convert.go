@@ -0,0 +1,172 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	putils "github.com/siglens/siglens/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func performConvertRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	convertReq := letColReq.ConvertRequest
+
+	if recs != nil {
+		for _, record := range recs {
+			convertRow(convertReq, record)
+		}
+		for _, col := range getConvertNewFields(convertReq) {
+			finalCols[col] = true
+		}
+		return
+	}
+
+	performConvertRequestOnHistogram(nodeResult, convertReq)
+	performConvertRequestOnMeasureResults(nodeResult, convertReq)
+}
+
+// Returns the fields of the row that the field of the conversion refers to. The wildcards don't
+// match the internal fields and the timestamp.
+func getConvertFields(field string, row map[string]interface{}) []string {
+	if !strings.Contains(field, "*") {
+		if _, exists := row[field]; exists {
+			return []string{field}
+		}
+		return nil
+	}
+
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		if col == config.GetTimeStampKey() || strings.HasPrefix(col, "_") {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	return putils.SelectMatchingStringsWithWildcard(field, cols)
+}
+
+func getConvertNewFields(convertReq *structs.ConvertRequest) []string {
+	newFields := make([]string, 0)
+	for _, conversion := range convertReq.Conversions {
+		if conversion.NewField != "" {
+			newFields = append(newFields, conversion.NewField)
+		}
+	}
+	return newFields
+}
+
+// Applies the conversions in order to the fields of the row. The fields of none() are not
+// converted by the other conversions.
+func convertRow(convertReq *structs.ConvertRequest, row map[string]interface{}) {
+	excludedFields := make(map[string]struct{})
+	for _, conversion := range convertReq.Conversions {
+		if conversion.Func != "none" {
+			continue
+		}
+		for _, field := range getConvertFields(conversion.Field, row) {
+			excludedFields[field] = struct{}{}
+		}
+	}
+
+	for _, conversion := range convertReq.Conversions {
+		if conversion.Func == "none" {
+			continue
+		}
+
+		for _, field := range getConvertFields(conversion.Field, row) {
+			if _, excluded := excludedFields[field]; excluded {
+				continue
+			}
+
+			value, ok := conversion.Convert(row[field], convertReq.TimeFormat)
+			if !ok {
+				continue
+			}
+
+			newField := field
+			if conversion.NewField != "" {
+				newField = conversion.NewField
+			}
+			if value == nil {
+				delete(row, newField)
+			} else {
+				row[newField] = value
+			}
+		}
+	}
+}
+
+func performConvertRequestOnHistogram(nodeResult *structs.NodeResult, convertReq *structs.ConvertRequest) {
+	for _, aggregationResult := range nodeResult.Histogram {
+		for _, bucketResult := range aggregationResult.Results {
+			row := make(map[string]interface{}, len(bucketResult.StatRes))
+			for col, cValue := range bucketResult.StatRes {
+				value, err := cValue.GetValue()
+				if err != nil {
+					log.Errorf("performConvertRequestOnHistogram: failed to get the value of column %v, err: %v", col, err)
+					continue
+				}
+				row[col] = value
+			}
+
+			oldCols := make([]string, 0, len(row))
+			for col := range row {
+				oldCols = append(oldCols, col)
+			}
+
+			convertRow(convertReq, row)
+
+			// The groupby columns are the keys of the buckets, so they are left unchanged.
+			for _, col := range oldCols {
+				if _, exists := row[col]; !exists {
+					delete(bucketResult.StatRes, col)
+				}
+			}
+			for col, value := range row {
+				if !putils.SliceContainsString(bucketResult.GroupByKeys, col) {
+					bucketResult.StatRes[col] = CreateCValueFromColValue(value)
+				}
+			}
+		}
+	}
+}
+
+func performConvertRequestOnMeasureResults(nodeResult *structs.NodeResult, convertReq *structs.ConvertRequest) {
+	if len(nodeResult.MeasureResults) == 0 {
+		return
+	}
+
+	// The groupby values are not in MeasureVal, so they are left unchanged.
+	for _, bucketHolder := range nodeResult.MeasureResults {
+		convertRow(convertReq, bucketHolder.MeasureVal)
+	}
+
+	newCols := make([]string, 0)
+	for _, col := range getConvertNewFields(convertReq) {
+		if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+func getEventStatsMeasureAggs(agg *structs.QueryAggregators) []*structs.MeasureAggregator {
+	if agg.GroupByRequest != nil {
+		return agg.GroupByRequest.MeasureOperations
+	}
+	return agg.MeasureOperations
+}
+
+// eventstats keeps one running result per group, like streamstats without a window, so
+// only the functions that can be computed that way are supported.
+var eventStatsFuncs = []utils.AggregateFunctions{utils.Count, utils.Sum, utils.Avg, utils.Min, utils.Max,
+	utils.Range, utils.Cardinality, utils.Values}
+
+func ValidateEventStats(agg *structs.QueryAggregators) error {
+	for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+		if !isEventStatsFunc(measureAgg.MeasureFunc) {
+			supportedFuncs := make([]string, len(eventStatsFuncs))
+			for i, measureFunc := range eventStatsFuncs {
+				supportedFuncs[i] = measureFunc.String()
+			}
+			return fmt.Errorf("ValidateEventStats: function %v is not supported by eventstats, the supported functions are: %v",
+				measureAgg.MeasureFunc.String(), strings.Join(supportedFuncs, ", "))
+		}
+	}
+
+	return nil
+}
+
+func isEventStatsFunc(measureFunc utils.AggregateFunctions) bool {
+	for _, eventStatsFunc := range eventStatsFuncs {
+		if measureFunc == eventStatsFunc {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the final value of the running stats; nil means no record had a value for it.
+func getEventStatsResult(ssResults *structs.RunningStreamStatsResults, measureAgg *structs.MeasureAggregator) interface{} {
+	if ssResults.NumProcessedRecords == 0 {
+		if measureAgg.MeasureFunc == utils.Count || measureAgg.MeasureFunc == utils.Cardinality {
+			return 0.0
+		}
+		return nil
+	}
+
+	switch measureAgg.MeasureFunc {
+	case utils.Avg:
+		return calculateAvg(ssResults, false).CVal
+	case utils.Values:
+		return getValues(ssResults.ValuesMap).CVal
+	default:
+		return ssResults.CurrResult.CVal
+	}
+}
+
+// Computes the aggregations over all the records, grouped by the group by fields,
+// and writes the results of its group onto each record. Records missing any of the
+// group by fields do not belong to any group, so they are left unchanged.
+func computeEventStats(agg *structs.QueryAggregators, records []map[string]interface{}) error {
+	measureAggs := getEventStatsMeasureAggs(agg)
+	err := ValidateEventStats(agg)
+	if err != nil {
+		return fmt.Errorf("computeEventStats: %v", err)
+	}
+
+	// The final values are only read once all the records are processed, so the
+	// running results of each record can be ignored.
+	ssOption := &structs.StreamStatsOptions{}
+
+	// maps index of measureAgg -> bucket key -> RunningStreamStatsResults
+	runningStats := make([]map[string]*structs.RunningStreamStatsResults, len(measureAggs))
+	for i := range measureAggs {
+		runningStats[i] = make(map[string]*structs.RunningStreamStatsResults)
+	}
+
+	bucketKeys := make([]string, len(records))
+	hasBucket := make([]bool, len(records))
+	fieldToValue := make(map[string]utils.CValueEnclosure)
+	for recordIndex, record := range records {
+		bucketKey := ""
+		if agg.GroupByRequest != nil {
+			bucketKey, err = GetBucketKey(record, agg.GroupByRequest)
+			if err != nil {
+				continue
+			}
+		}
+		bucketKeys[recordIndex] = bucketKey
+		hasBucket[recordIndex] = true
+
+		for i, measureAgg := range measureAggs {
+			ssResults, exists := runningStats[i][bucketKey]
+			if !exists {
+				ssResults = InitRunningStreamStatsResults(measureAgg.MeasureFunc)
+				runningStats[i][bucketKey] = ssResults
+			}
+
+			colValue, exists := record[measureAgg.MeasureCol]
+			isCountAll := measureAgg.MeasureFunc == utils.Count && measureAgg.MeasureCol == "*"
+			if !exists && !isCountAll && measureAgg.ValueColRequest == nil {
+				continue
+			}
+
+			if measureAgg.ValueColRequest != nil {
+				err = getRecordFieldValues(fieldToValue, measureAgg.ValueColRequest.GetFields(), record)
+				if err != nil {
+					continue
+				}
+			}
+			finalColValue, include := CreateCValueFromValueExpression(measureAgg, fieldToValue, CreateCValueFromColValue(colValue))
+
+			_, _, err = PerformNoWindowStreamStatsOnSingleFunc(ssOption, ssResults, measureAgg, finalColValue, include)
+			if err != nil {
+				return fmt.Errorf("computeEventStats: Error while performing eventstats on function %v for value %v, err: %v", measureAgg.MeasureFunc, colValue, err)
+			}
+		}
+	}
+
+	for recordIndex, record := range records {
+		if !hasBucket[recordIndex] {
+			continue
+		}
+
+		for i, measureAgg := range measureAggs {
+			result := getEventStatsResult(runningStats[i][bucketKeys[recordIndex]], measureAgg)
+			if result != nil {
+				record[measureAgg.String()] = result
+			}
+		}
+	}
+
+	return nil
+}
+
+func PerformEventStats(nodeResult *structs.NodeResult, agg *structs.QueryAggregators, recs map[string]map[string]interface{},
+	finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if recs != nil {
+		return performEventStatsOnRawRecord(agg, recs, finalCols, numTotalSegments, finishesSegment)
+	}
+
+	if len(nodeResult.Histogram) > 0 {
+		err := performEventStatsOnHistogram(nodeResult, agg)
+		if err != nil {
+			return fmt.Errorf("PerformEventStats: Error while performing eventstats on histogram, err: %v", err)
+		}
+	}
+
+	if len(nodeResult.MeasureResults) > 0 {
+		err := performEventStatsOnMeasureResults(nodeResult, agg)
+		if err != nil {
+			return fmt.Errorf("PerformEventStats: Error while performing eventstats on measure results, err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// The stats depend on all the records, so the records of each segment are held
+// back until the last segment is processed.
+func performEventStatsOnRawRecord(agg *structs.QueryAggregators, recs map[string]map[string]interface{}, finalCols map[string]bool,
+	numTotalSegments uint64, finishesSegment bool) error {
+
+	esOption := agg.EventStatsOptions
+	if finishesSegment {
+		esOption.NumProcessedSegments++
+	}
+
+	if esOption.Records == nil {
+		esOption.Records = make(map[string]map[string]interface{}, 0)
+	}
+
+	for recordKey, record := range recs {
+		esOption.Records[recordKey] = record
+		delete(recs, recordKey)
+	}
+
+	if esOption.NumProcessedSegments < numTotalSegments {
+		return nil
+	}
+
+	records := make([]map[string]interface{}, 0, len(esOption.Records))
+	for _, record := range esOption.Records {
+		records = append(records, record)
+	}
+
+	err := computeEventStats(agg, records)
+	if err != nil {
+		return fmt.Errorf("performEventStatsOnRawRecord: %v", err)
+	}
+
+	for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+		finalCols[measureAgg.String()] = true
+	}
+
+	for recordKey, record := range esOption.Records {
+		recs[recordKey] = record
+	}
+	esOption.Records = nil
+
+	return nil
+}
+
+func getEventStatsFields(agg *structs.QueryAggregators) []string {
+	fields := []string{}
+	if agg.GroupByRequest != nil {
+		fields = append(fields, agg.GroupByRequest.GroupByColumns...)
+	}
+	for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+		if measureAgg.ValueColRequest != nil {
+			fields = append(fields, measureAgg.ValueColRequest.GetFields()...)
+		} else if measureAgg.MeasureCol != "*" {
+			fields = append(fields, measureAgg.MeasureCol)
+		}
+	}
+
+	return fields
+}
+
+func performEventStatsOnHistogram(nodeResult *structs.NodeResult, agg *structs.QueryAggregators) error {
+	fieldsInExpr := getEventStatsFields(agg)
+
+	records := make([]map[string]interface{}, 0)
+	for _, aggregationResult := range nodeResult.Histogram {
+		for rowIndex := range aggregationResult.Results {
+			fieldToValue := make(map[string]utils.CValueEnclosure, 0)
+			err := getAggregationResultFieldValues(fieldToValue, fieldsInExpr, aggregationResult, rowIndex)
+			if err != nil {
+				return fmt.Errorf("performEventStatsOnHistogram: Error while getting value from agg results, err: %v", err)
+			}
+			records = append(records, getRecordFromFieldToValue(fieldToValue))
+		}
+	}
+
+	err := computeEventStats(agg, records)
+	if err != nil {
+		return fmt.Errorf("performEventStatsOnHistogram: %v", err)
+	}
+
+	recordIndex := 0
+	for _, aggregationResult := range nodeResult.Histogram {
+		for _, bucketResult := range aggregationResult.Results {
+			record := records[recordIndex]
+			recordIndex++
+
+			for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+				result, exists := record[measureAgg.String()]
+				if !exists {
+					continue
+				}
+
+				dataType := utils.SS_DT_FLOAT
+				if measureAgg.MeasureFunc == utils.Values {
+					dataType = utils.SS_DT_STRING_SLICE
+				} else if _, isFloat := result.(float64); !isFloat {
+					dataType = utils.SS_DT_STRING
+				}
+				bucketResult.StatRes[measureAgg.String()] = utils.CValueEnclosure{
+					Dtype: dataType,
+					CVal:  result,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func performEventStatsOnMeasureResults(nodeResult *structs.NodeResult, agg *structs.QueryAggregators) error {
+	fieldsInExpr := getEventStatsFields(agg)
+
+	records := make([]map[string]interface{}, len(nodeResult.MeasureResults))
+	for rowIndex := range nodeResult.MeasureResults {
+		fieldToValue := make(map[string]utils.CValueEnclosure, 0)
+		err := getMeasureResultsFieldValues(fieldToValue, fieldsInExpr, nodeResult, rowIndex)
+		if err != nil {
+			return fmt.Errorf("performEventStatsOnMeasureResults: Error while getting value from measure results, err: %v", err)
+		}
+		records[rowIndex] = getRecordFromFieldToValue(fieldToValue)
+	}
+
+	err := computeEventStats(agg, records)
+	if err != nil {
+		return fmt.Errorf("performEventStatsOnMeasureResults: %v", err)
+	}
+
+	for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+		if !putils.SliceContainsString(nodeResult.MeasureFunctions, measureAgg.String()) {
+			nodeResult.MeasureFunctions = append(nodeResult.MeasureFunctions, measureAgg.String())
+		}
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		for _, measureAgg := range getEventStatsMeasureAggs(agg) {
+			result, exists := records[rowIndex][measureAgg.String()]
+			if exists {
+				bucketHolder.MeasureVal[measureAgg.String()] = result
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"strings"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+)
+
+// The text is only in the records, so the stats results are left unchanged.
+func performExtractRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	if recs == nil {
+		return
+	}
+
+	extractReq := letColReq.ExtractRequest
+	for _, record := range recs {
+		text, ok := record[extractReq.Field].(string)
+		if !ok {
+			continue
+		}
+
+		keys, values := extractKeyValuePairs(extractReq, text)
+		for i, key := range keys {
+			// The fields of the record are not overwritten.
+			if _, exists := record[key]; exists {
+				continue
+			}
+			record[key] = values[i]
+			finalCols[key] = true
+		}
+	}
+}
+
+// Returns the keys in the order they are in the text, and their values. Values may be quoted
+// with double or single quotes, so that they can hold the delimiters; only the first value
+// of a key is kept.
+func extractKeyValuePairs(extractReq *structs.ExtractRequest, text string) ([]string, []string) {
+	keys := make([]string, 0)
+	values := make([]string, 0)
+	seenKeys := make(map[string]struct{})
+
+	isPairDelim := func(char byte) bool {
+		return strings.IndexByte(extractReq.PairDelims, char) >= 0
+	}
+	isKVDelim := func(char byte) bool {
+		return strings.IndexByte(extractReq.KVDelims, char) >= 0
+	}
+
+	i := 0
+	for i < len(text) && (extractReq.Limit == 0 || uint64(len(keys)) < extractReq.Limit) {
+		for i < len(text) && isPairDelim(text[i]) {
+			i++
+		}
+
+		keyStart := i
+		for i < len(text) && !isPairDelim(text[i]) && !isKVDelim(text[i]) {
+			i++
+		}
+		key := cleanExtractKey(text[keyStart:i])
+
+		// Text without a key/value delimiter is not a pair.
+		if i >= len(text) || !isKVDelim(text[i]) {
+			continue
+		}
+		i++
+
+		var value string
+		if i < len(text) && (text[i] == '"' || text[i] == '\'') {
+			quote := text[i]
+			i++
+			valueStart := i
+			for i < len(text) && text[i] != quote {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				i++
+			}
+			value = strings.ReplaceAll(text[valueStart:i], `\`+string(quote), string(quote))
+			if i < len(text) {
+				i++
+			}
+		} else {
+			valueStart := i
+			for i < len(text) && !isPairDelim(text[i]) {
+				i++
+			}
+			value = text[valueStart:i]
+		}
+
+		if key == "" {
+			continue
+		}
+		if _, seen := seenKeys[key]; seen {
+			continue
+		}
+		seenKeys[key] = struct{}{}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return keys, values
+}
+
+// Field names only have letters, digits and underscores, so the other characters of the keys
+// are replaced by underscores.
+func cleanExtractKey(key string) string {
+	return strings.Map(func(char rune) rune {
+		if char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
+			return char
+		}
+		return '_'
+	}, key)
+}
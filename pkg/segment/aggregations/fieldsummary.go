@@ -0,0 +1,305 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const fieldSummaryFieldCol = "field"
+
+// The columns of the summary, in the order they are displayed.
+var fieldSummaryCols = []string{fieldSummaryFieldCol, "count", "distinct_count", "is_exact", "max", "mean", "min", "numeric_count", "stdev", "values"}
+
+type fieldSummaryValue struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// The statistics of a field, which are turned into its row of the summary. The mean and the
+// variance are updated with Welford's method.
+type fieldSummaryStats struct {
+	count        uint64
+	valueCounts  map[string]uint64
+	numericCount uint64
+	min          float64
+	max          float64
+	mean         float64
+	m2           float64
+}
+
+func performFieldSummaryRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) {
+
+	fieldSummaryReq := letColReq.FieldSummaryRequest
+	if recs != nil {
+		performFieldSummaryRequestWithoutGroupby(nodeResult, fieldSummaryReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
+		return
+	}
+
+	// Both the MeasureResults and the Histogram are summarized, so the columns of the results
+	// are taken before either of them changes the columns order.
+	columnsOrder := nodeResult.ColumnsOrder
+	if len(nodeResult.MeasureResults) > 0 {
+		table := getMeasureResultsTable(nodeResult, columnsOrder)
+		setMeasureResultsTable(nodeResult, getFieldSummaryTable(fieldSummaryReq, table))
+	}
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, columnsOrder)
+		setHistogramResultTable(nodeResult, aggregationResult, getFieldSummaryTable(fieldSummaryReq, table))
+	}
+}
+
+// Each summary row describes one field across every record, and the records are replaced by
+// those rows, so the summary is built from the full set of records.
+func performFieldSummaryRequestWithoutGroupby(nodeResult *structs.NodeResult, fieldSummaryReq *structs.FieldSummaryRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &fieldSummaryReq.Records, &fieldSummaryReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return
+	}
+
+	records := make([]map[string]interface{}, 0, len(fieldSummaryReq.Records))
+	for recordKey, record := range fieldSummaryReq.Records {
+		records = append(records, record)
+		delete(recordIndexInFinal, recordKey)
+	}
+	fieldSummaryReq.Records = nil
+
+	newTable := getFieldSummaryTable(fieldSummaryReq, getRecsResultTable(nodeResult, records, finalCols))
+
+	for col := range finalCols {
+		delete(finalCols, col)
+	}
+	for _, col := range newTable.columns {
+		finalCols[col] = true
+	}
+
+	for i, row := range newTable.rows {
+		recordKey := fmt.Sprintf("fieldsummary_%d", i)
+		recs[recordKey] = row
+		recordIndexInFinal[recordKey] = i
+	}
+}
+
+// Returns the fields to summarize out of the columns. Without a field list, all the columns
+// other than the internal fields and the timestamp are summarized.
+func getFieldSummaryFields(fieldSummaryReq *structs.FieldSummaryRequest, columns []string) []string {
+	fields := make([]string, 0, len(columns))
+	if len(fieldSummaryReq.Fields) == 0 {
+		for _, col := range columns {
+			if col == config.GetTimeStampKey() || strings.HasPrefix(col, "_") {
+				continue
+			}
+			fields = append(fields, col)
+		}
+		return fields
+	}
+
+	for _, pattern := range fieldSummaryReq.Fields {
+		for _, col := range putils.SelectMatchingStringsWithWildcard(pattern, columns) {
+			if !putils.SliceContainsString(fields, col) {
+				fields = append(fields, col)
+			}
+		}
+	}
+
+	return fields
+}
+
+// Returns a table with a row for each summarized field of the table.
+func getFieldSummaryTable(fieldSummaryReq *structs.FieldSummaryRequest, table *resultTable) *resultTable {
+	fields := getFieldSummaryFields(fieldSummaryReq, table.columns)
+	newTable := &resultTable{
+		columns:     fieldSummaryCols,
+		groupByCols: []string{fieldSummaryFieldCol},
+		rows:        make([]map[string]interface{}, 0, len(fields)),
+	}
+
+	for _, field := range fields {
+		stats := &fieldSummaryStats{valueCounts: make(map[string]uint64)}
+		for _, row := range table.rows {
+			stats.addValue(row[field])
+		}
+		newTable.rows = append(newTable.rows, stats.getRow(field, fieldSummaryReq.MaxVals))
+	}
+
+	return newTable
+}
+
+// Each value of a multivalue field is counted on its own, but the field is counted once.
+func (stats *fieldSummaryStats) addValue(value interface{}) {
+	var values []interface{}
+	switch val := value.(type) {
+	case nil:
+		return
+	case string:
+		if val == "" {
+			return
+		}
+		values = []interface{}{val}
+	case []string:
+		for _, item := range val {
+			values = append(values, item)
+		}
+	case []interface{}:
+		values = val
+	default:
+		values = []interface{}{val}
+	}
+
+	if len(values) == 0 {
+		return
+	}
+
+	stats.count++
+	for _, item := range values {
+		stats.valueCounts[fmt.Sprintf("%v", item)]++
+
+		number, ok := getNumericValue(item)
+		if !ok {
+			continue
+		}
+
+		stats.numericCount++
+		if stats.numericCount == 1 || number < stats.min {
+			stats.min = number
+		}
+		if stats.numericCount == 1 || number > stats.max {
+			stats.max = number
+		}
+		delta := number - stats.mean
+		stats.mean += delta / float64(stats.numericCount)
+		stats.m2 += delta * (number - stats.mean)
+	}
+}
+
+func (stats *fieldSummaryStats) getRow(field string, maxVals uint64) map[string]interface{} {
+	row := map[string]interface{}{
+		fieldSummaryFieldCol: field,
+		"count":              stats.count,
+		"distinct_count":     uint64(len(stats.valueCounts)),
+		"is_exact":           int64(1),
+		"numeric_count":      stats.numericCount,
+		"values":             getFieldSummaryValues(stats.valueCounts, maxVals),
+	}
+
+	if stats.numericCount > 0 {
+		row["min"] = stats.min
+		row["max"] = stats.max
+		row["mean"] = stats.mean
+	}
+	if stats.numericCount > 1 {
+		row["stdev"] = math.Sqrt(stats.m2 / float64(stats.numericCount-1))
+	}
+
+	return row
+}
+
+// Returns the most common values as JSON, with the ties ordered by value.
+func getFieldSummaryValues(valueCounts map[string]uint64, maxVals uint64) string {
+	values := make([]fieldSummaryValue, 0, len(valueCounts))
+	for value, count := range valueCounts {
+		values = append(values, fieldSummaryValue{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+
+	if maxVals > 0 && uint64(len(values)) > maxVals {
+		values = values[:maxVals]
+	}
+
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		log.Errorf("getFieldSummaryValues: failed to marshal the values, err: %v", err)
+		return ""
+	}
+
+	return string(valuesJSON)
+}
+
+// Returns a row for each summarized field of the segment stats, along with the columns of the
+// rows. The distinct counts are estimated from the HLLs, and the stats hold neither the values
+// nor the standard deviation.
+func GetFieldSummaryFromSegStats(fieldSummaryReq *structs.FieldSummaryRequest, sstMap map[string]*structs.SegStats) ([]map[string]interface{}, []string) {
+	columns := make([]string, 0, len(sstMap))
+	for col := range sstMap {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	fields := getFieldSummaryFields(fieldSummaryReq, columns)
+	rows := make([]map[string]interface{}, 0, len(fields))
+	for _, field := range fields {
+		segStats := sstMap[field]
+		row := map[string]interface{}{
+			fieldSummaryFieldCol: field,
+			"count":              segStats.Count,
+			"distinct_count":     segStats.GetHllCardinality(),
+			"is_exact":           int64(0),
+			"numeric_count":      uint64(0),
+		}
+
+		if segStats.IsNumeric && segStats.NumStats != nil && segStats.Count > 0 {
+			row["numeric_count"] = segStats.Count
+			row["min"] = getNumTypeEnclosureFloat(segStats.NumStats.Min)
+			row["max"] = getNumTypeEnclosureFloat(segStats.NumStats.Max)
+			row["mean"] = getNumTypeEnclosureFloat(segStats.NumStats.Sum) / float64(segStats.Count)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, fieldSummaryCols
+}
+
+func getNumTypeEnclosureFloat(number utils.NumTypeEnclosure) float64 {
+	if number.Ntype == utils.SS_DT_FLOAT {
+		return number.FloatVal
+	}
+	return float64(number.IntgrVal)
+}
+
+func performFieldSummaryGenEvent(agg *structs.QueryAggregators, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int, finalCols map[string]bool) error {
+	if agg.GenerateEvent.FieldSummary == nil {
+		return nil
+	}
+	if recs == nil {
+		return nil
+	}
+
+	return PopulateGeneratedRecords(agg.GenerateEvent, recs, recordIndexInFinal, finalCols, 0)
+}
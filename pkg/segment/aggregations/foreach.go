@@ -0,0 +1,186 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+)
+
+// In multivalue mode there is a subsearch for each distinct value, so the parsed subsearches
+// are dropped once there are too many of them.
+const maxForeachSubsearches = 1000
+
+func performForeachRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	foreachReq := letColReq.ForeachRequest
+	if foreachReq.ParseSubsearch == nil {
+		return fmt.Errorf("performForeachRequest: the subsearch cannot be parsed")
+	}
+
+	if foreachReq.Mode == structs.ForeachMultiValue {
+		if recs == nil {
+			return fmt.Errorf("performForeachRequest: mode=multivalue is only supported on events")
+		}
+		for recordKey, record := range recs {
+			// The subsearch of each item runs on the record alone, and a record it drops is
+			// dropped from the results without running the subsearches of the other items.
+			for _, item := range getForeachItems(record[foreachReq.Fields[0]]) {
+				singleRec := map[string]map[string]interface{}{recordKey: record}
+				err := performForeachSubsearch(nodeResult, foreachReq, foreachReq.GetItemSubsearch(item), singleRec,
+					recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
+				if err != nil {
+					return fmt.Errorf("performForeachRequest: %v", err)
+				}
+
+				if _, exists := singleRec[recordKey]; !exists {
+					delete(recs, recordKey)
+					delete(recordIndexInFinal, recordKey)
+					break
+				}
+				record = singleRec[recordKey]
+				recs[recordKey] = record
+			}
+		}
+		return nil
+	}
+
+	for _, field := range foreachReq.GetMatchingFields(getForeachColumns(nodeResult, recs)) {
+		if _, exists := foreachReq.ExpandedFields[field]; exists {
+			continue
+		}
+
+		err := performForeachSubsearch(nodeResult, foreachReq, foreachReq.GetFieldSubsearch(field), recs,
+			recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
+		if err != nil {
+			return fmt.Errorf("performForeachRequest: field: %v, err: %v", field, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the columns as they are before the foreach runs, sorted by name. The records are
+// used instead of finalCols, which also has the columns added by the later commands.
+func getForeachColumns(nodeResult *structs.NodeResult, recs map[string]map[string]interface{}) []string {
+	colSet := make(map[string]struct{})
+	if recs != nil {
+		for _, record := range recs {
+			for col := range record {
+				colSet[col] = struct{}{}
+			}
+		}
+	} else {
+		for _, col := range nodeResult.GroupByCols {
+			colSet[col] = struct{}{}
+		}
+		for _, col := range nodeResult.MeasureFunctions {
+			colSet[col] = struct{}{}
+		}
+	}
+
+	cols := make([]string, 0, len(colSet))
+	for col := range colSet {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	return cols
+}
+
+func getForeachItems(value interface{}) []string {
+	switch typedValue := value.(type) {
+	case nil:
+		return nil
+	case []string:
+		return typedValue
+	case []interface{}:
+		items := make([]string, 0, len(typedValue))
+		for _, item := range typedValue {
+			if item != nil {
+				items = append(items, fmt.Sprintf("%v", item))
+			}
+		}
+		return items
+	default:
+		return []string{fmt.Sprintf("%v", typedValue)}
+	}
+}
+
+func performForeachSubsearch(nodeResult *structs.NodeResult, foreachReq *structs.ForeachRequest, subsearch string, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	subsearchAggs, exists := foreachReq.Subsearches[subsearch]
+	if !exists {
+		var err error
+		subsearchAggs, err = foreachReq.ParseSubsearch(subsearch)
+		if err != nil {
+			return fmt.Errorf("performForeachSubsearch: %v", err)
+		}
+
+		if foreachReq.Mode == structs.ForeachMultiValue {
+			err = checkForeachMultiValueSubsearch(subsearchAggs)
+			if err != nil {
+				return fmt.Errorf("performForeachSubsearch: %v", err)
+			}
+		}
+
+		if foreachReq.Subsearches == nil || len(foreachReq.Subsearches) >= maxForeachSubsearches {
+			foreachReq.Subsearches = make(map[string]*structs.QueryAggregators)
+		}
+		foreachReq.Subsearches[subsearch] = subsearchAggs
+	}
+
+	for agg := subsearchAggs; agg != nil; agg = agg.Next {
+		err := performAggOnResult(nodeResult, agg, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment, false, false, false)
+		if err != nil {
+			return fmt.Errorf("performForeachSubsearch: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// In multivalue mode the subsearch runs on one record at a time, so it can only use the
+// commands that handle each record on its own. A command that holds the records back, like
+// sort, dedup or stats, would never return them.
+func checkForeachMultiValueSubsearch(subsearchAggs *structs.QueryAggregators) error {
+	for agg := subsearchAggs; agg != nil; agg = agg.Next {
+		if !isPerRecordCommand(agg) {
+			return fmt.Errorf("checkForeachMultiValueSubsearch: mode=multivalue only supports eval, rex, rename, makemv, where, search and fields")
+		}
+	}
+
+	return nil
+}
+
+func isPerRecordCommand(agg *structs.QueryAggregators) bool {
+	if agg.PipeCommandType != structs.OutputTransformType || agg.OutputTransforms == nil {
+		return false
+	}
+	if agg.OutputTransforms.HeadRequest != nil || agg.OutputTransforms.TailRequest != nil {
+		return false
+	}
+
+	letColReq := agg.OutputTransforms.LetColumns
+	return letColReq == nil || letColReq.ValueColRequest != nil || letColReq.RexColRequest != nil ||
+		letColReq.RenameColRequest != nil || letColReq.MultiValueColRequest != nil
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/siglens/siglens/pkg/common/dtypeutils"
 	"github.com/siglens/siglens/pkg/config"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	"github.com/siglens/siglens/pkg/segment/utils"
@@ -101,6 +103,98 @@ func PerformGenTimes(aggs *structs.QueryAggregators) error {
 	return nil
 }
 
+func createMakeResultsEvent(currTime time.Time, annotate bool) map[string]interface{} {
+	event := map[string]interface{}{
+		config.GetTimeStampKey(): uint64(currTime.UnixMilli()),
+	}
+	if annotate {
+		for _, col := range getMakeResultsAnnotateCols() {
+			event[col] = ""
+		}
+	}
+	return event
+}
+
+func getMakeResultsAnnotateCols() []string {
+	return []string{"_raw", "host", "source", "sourcetype"}
+}
+
+func PerformMakeResults(aggs *structs.QueryAggregators) error {
+	if aggs.GenerateEvent.MakeResults == nil {
+		return fmt.Errorf("PerformMakeResults: MakeResults is nil")
+	}
+	makeResults := aggs.GenerateEvent.MakeResults
+	if maxCount := config.GetMaxMakeResultsCount(); makeResults.Count > maxCount {
+		return fmt.Errorf("PerformMakeResults: count: %v is more than the maximum: %v", makeResults.Count, maxCount)
+	}
+
+	genCols := []string{config.GetTimeStampKey()}
+	if makeResults.Annotate {
+		genCols = append(genCols, getMakeResultsAnnotateCols()...)
+	}
+
+	InitGenEvent(aggs)
+	SetGeneratedCols(aggs.GenerateEvent, genCols)
+	records := aggs.GenerateEvent.GeneratedRecords
+	recordsIndex := aggs.GenerateEvent.GeneratedRecordsIndex
+
+	// All the events are generated at the same time, like they would be by a single search.
+	currTime := time.Now()
+	for key := uint64(0); key < makeResults.Count; key++ {
+		recordKey := fmt.Sprintf("%v_%v", aggs.GenerateEvent.EventPosition, key)
+		records[recordKey] = createMakeResultsEvent(currTime, makeResults.Annotate)
+		recordsIndex[recordKey] = int(key)
+	}
+
+	return nil
+}
+
+// The records of all the subsearches are merged in time order, newest first. The records
+// without a timestamp come after them, in the order of the subsearches.
+func PerformMultisearch(aggs *structs.QueryAggregators) error {
+	if aggs.GenerateEvent.Multisearch == nil {
+		return fmt.Errorf("PerformMultisearch: Multisearch is nil")
+	}
+	multisearch := aggs.GenerateEvent.Multisearch
+
+	InitGenEvent(aggs)
+	SetGeneratedCols(aggs.GenerateEvent, multisearch.SubsearchCols)
+
+	type timedRecord struct {
+		record       map[string]interface{}
+		timestamp    float64
+		hasTimestamp bool
+	}
+
+	timestampKey := config.GetTimeStampKey()
+	timedRecords := make([]timedRecord, 0)
+	for _, subsearchRecords := range multisearch.SubsearchRecords {
+		for _, record := range subsearchRecords {
+			timedRec := timedRecord{record: record}
+			if value, exists := record[timestampKey]; exists {
+				timestamp, err := dtypeutils.ConvertToFloat(value, 64)
+				timedRec.timestamp, timedRec.hasTimestamp = timestamp, err == nil
+			}
+			timedRecords = append(timedRecords, timedRec)
+		}
+	}
+
+	sort.SliceStable(timedRecords, func(i, j int) bool {
+		if timedRecords[i].hasTimestamp != timedRecords[j].hasTimestamp {
+			return timedRecords[i].hasTimestamp
+		}
+		return timedRecords[i].timestamp > timedRecords[j].timestamp
+	})
+
+	for i, timedRec := range timedRecords {
+		recordKey := fmt.Sprintf("%v_%v", aggs.GenerateEvent.EventPosition, i)
+		aggs.GenerateEvent.GeneratedRecords[recordKey] = timedRec.record
+		aggs.GenerateEvent.GeneratedRecordsIndex[recordKey] = i
+	}
+
+	return nil
+}
+
 func checkCSVFormat(filename string) bool {
 	return strings.HasSuffix(filename, ".csv") || strings.HasSuffix(filename, ".csv.gz")
 }
@@ -0,0 +1,238 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	segutils "github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+// The field holding the geohash of the tile of each point, which geostats groups the stats by.
+const GeoStatsBinField = "geobin"
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+const MaxGeohashPrecision = 12
+
+func performGeoStatsRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	geoStatsReq := letColReq.GeoStatsRequest
+	if geoStatsReq.Precision == 0 || geoStatsReq.Precision > MaxGeohashPrecision {
+		return fmt.Errorf("performGeoStatsRequest: invalid precision %v; it must be between 1 and %v", geoStatsReq.Precision, MaxGeohashPrecision)
+	}
+
+	if recs != nil {
+		performGeoStatsRequestWithoutGroupby(geoStatsReq, recs, finalCols)
+		return nil
+	}
+
+	performGeoStatsRequestOnHistogram(nodeResult, geoStatsReq)
+	performGeoStatsRequestOnMeasureResults(nodeResult, geoStatsReq)
+
+	return nil
+}
+
+// Returns the geohash of the point with the given number of characters. The bits of the
+// geohash alternate between the longitude and the latitude, starting with the longitude.
+func encodeGeohash(lat float64, long float64, precision uint64) string {
+	latRange := [2]float64{-90, 90}
+	longRange := [2]float64{-180, 180}
+
+	var sb strings.Builder
+	isLongBit := true
+	charIndex := 0
+	numBits := 0
+	for uint64(sb.Len()) < precision {
+		valRange, val := &latRange, lat
+		if isLongBit {
+			valRange, val = &longRange, long
+		}
+
+		mid := (valRange[0] + valRange[1]) / 2
+		charIndex <<= 1
+		if val >= mid {
+			charIndex |= 1
+			valRange[0] = mid
+		} else {
+			valRange[1] = mid
+		}
+		isLongBit = !isLongBit
+
+		numBits++
+		if numBits == 5 {
+			sb.WriteByte(geohashAlphabet[charIndex])
+			charIndex = 0
+			numBits = 0
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns the latitude and longitude of the center of the tile of the geohash.
+func decodeGeohashCenter(geohash string) (float64, float64, bool) {
+	if geohash == "" {
+		return 0, 0, false
+	}
+
+	latRange := [2]float64{-90, 90}
+	longRange := [2]float64{-180, 180}
+	isLongBit := true
+	for _, char := range strings.ToLower(geohash) {
+		charIndex := strings.IndexRune(geohashAlphabet, char)
+		if charIndex < 0 {
+			return 0, 0, false
+		}
+
+		for bit := 4; bit >= 0; bit-- {
+			valRange := &latRange
+			if isLongBit {
+				valRange = &longRange
+			}
+
+			mid := (valRange[0] + valRange[1]) / 2
+			if charIndex&(1<<bit) != 0 {
+				valRange[0] = mid
+			} else {
+				valRange[1] = mid
+			}
+			isLongBit = !isLongBit
+		}
+	}
+
+	return (latRange[0] + latRange[1]) / 2, (longRange[0] + longRange[1]) / 2, true
+}
+
+// Returns the fields that the step of the request sets on a row, given a way to read the
+// fields of the row. Returns nil when the row does not have a valid point or tile.
+func getGeoStatsFields(geoStatsReq *structs.GeoStatsRequest, getField func(string) (interface{}, bool)) map[string]interface{} {
+	switch geoStatsReq.Step {
+	case structs.GeoStatsBinPoints:
+		latValue, _ := getField(geoStatsReq.LatField)
+		longValue, _ := getField(geoStatsReq.LongField)
+		lat, latOk := getNumericValue(latValue)
+		long, longOk := getNumericValue(longValue)
+		if !latOk || !longOk || lat < -90 || lat > 90 || long < -180 || long > 180 {
+			return nil
+		}
+
+		return map[string]interface{}{
+			GeoStatsBinField: encodeGeohash(lat, long, geoStatsReq.Precision),
+		}
+	case structs.GeoStatsAddBinCenter:
+		geobin, exists := getField(GeoStatsBinField)
+		if !exists {
+			return nil
+		}
+		lat, long, ok := decodeGeohashCenter(fmt.Sprintf("%v", geobin))
+		if !ok {
+			return nil
+		}
+
+		return map[string]interface{}{
+			geoStatsReq.OutputLatField:  lat,
+			geoStatsReq.OutputLongField: long,
+		}
+	}
+
+	return nil
+}
+
+func getGeoStatsColumns(geoStatsReq *structs.GeoStatsRequest) []string {
+	if geoStatsReq.Step == structs.GeoStatsBinPoints {
+		return []string{GeoStatsBinField}
+	}
+	return []string{geoStatsReq.OutputLatField, geoStatsReq.OutputLongField}
+}
+
+// The points without a valid latitude and longitude are not part of any tile, so those
+// records are dropped before the stats.
+func performGeoStatsRequestWithoutGroupby(geoStatsReq *structs.GeoStatsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	for recordKey, record := range recs {
+		fields := getGeoStatsFields(geoStatsReq, func(field string) (interface{}, bool) {
+			value, exists := record[field]
+			return value, exists
+		})
+
+		if fields == nil {
+			if geoStatsReq.Step == structs.GeoStatsBinPoints {
+				delete(recs, recordKey)
+			}
+			continue
+		}
+
+		for col, value := range fields {
+			record[col] = value
+		}
+	}
+
+	for _, col := range getGeoStatsColumns(geoStatsReq) {
+		finalCols[col] = true
+	}
+}
+
+func performGeoStatsRequestOnHistogram(nodeResult *structs.NodeResult, geoStatsReq *structs.GeoStatsRequest) {
+	for _, aggregationResult := range nodeResult.Histogram {
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			fields := getGeoStatsFields(geoStatsReq, func(field string) (interface{}, bool) {
+				value, exists := getAggregationResultCell(aggregationResult, rowIndex, field)
+				if cValue, ok := value.(segutils.CValueEnclosure); ok {
+					value = cValue.CVal
+				}
+				return value, exists
+			})
+
+			for col, value := range fields {
+				// The groupby columns are the keys of the buckets, so they are left unchanged.
+				if putils.SliceContainsString(bucketResult.GroupByKeys, col) {
+					continue
+				}
+				bucketResult.StatRes[col] = CreateCValueFromColValue(value)
+			}
+		}
+	}
+}
+
+func performGeoStatsRequestOnMeasureResults(nodeResult *structs.NodeResult, geoStatsReq *structs.GeoStatsRequest) {
+	if len(nodeResult.MeasureResults) == 0 {
+		return
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		fields := getGeoStatsFields(geoStatsReq, func(field string) (interface{}, bool) {
+			return getMeasureResultsCell(nodeResult, rowIndex, field)
+		})
+
+		for col, value := range fields {
+			if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+				bucketHolder.MeasureVal[col] = value
+			}
+		}
+	}
+
+	newCols := make([]string, 0)
+	for _, col := range getGeoStatsColumns(geoStatsReq) {
+		if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+}
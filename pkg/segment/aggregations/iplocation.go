@@ -0,0 +1,154 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+
+	"github.com/siglens/siglens/pkg/lookups"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	segutils "github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+func performIPLocationRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	ipLocationReq := letColReq.IPLocationRequest
+	reader, err := lookups.GetGeoIPReader()
+	if err != nil {
+		return fmt.Errorf("performIPLocationRequest: %v", err)
+	}
+	defer reader.Close()
+
+	if recs != nil {
+		if err := performIPLocationRequestWithoutGroupby(ipLocationReq, reader, recs, finalCols); err != nil {
+			return fmt.Errorf("performIPLocationRequest: %v", err)
+		}
+		return nil
+	}
+
+	if err := performIPLocationRequestOnHistogram(nodeResult, ipLocationReq, reader); err != nil {
+		return fmt.Errorf("performIPLocationRequest: %v", err)
+	}
+	if err := performIPLocationRequestOnMeasureResults(nodeResult, ipLocationReq, reader); err != nil {
+		return fmt.Errorf("performIPLocationRequest: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the location fields of the IP address in the value, with the prefix of the request.
+func getIPLocationFields(ipLocationReq *structs.IPLocationRequest, reader *lookups.MMDBReader, value interface{}, exists bool) (map[string]interface{}, error) {
+	ipStr, ok := value.(string)
+	if !exists || !ok {
+		return nil, nil
+	}
+
+	location, err := lookups.GetIPLocation(reader, ipStr, ipLocationReq.AllFields)
+	if err != nil {
+		return nil, fmt.Errorf("getIPLocationFields: %v", err)
+	}
+
+	fields := make(map[string]interface{}, len(location))
+	for name, fieldValue := range location {
+		fields[ipLocationReq.Prefix+name] = fieldValue
+	}
+	return fields, nil
+}
+
+func getIPLocationColumns(ipLocationReq *structs.IPLocationRequest) []string {
+	names := lookups.GetGeoIPFieldNames(ipLocationReq.AllFields)
+	columns := make([]string, len(names))
+	for i, name := range names {
+		columns[i] = ipLocationReq.Prefix + name
+	}
+	return columns
+}
+
+func performIPLocationRequestWithoutGroupby(ipLocationReq *structs.IPLocationRequest, reader *lookups.MMDBReader, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	for _, record := range recs {
+		value, exists := record[ipLocationReq.Field]
+		fields, err := getIPLocationFields(ipLocationReq, reader, value, exists)
+		if err != nil {
+			return fmt.Errorf("performIPLocationRequestWithoutGroupby: %v", err)
+		}
+		for col, fieldValue := range fields {
+			record[col] = fieldValue
+		}
+	}
+
+	for _, col := range getIPLocationColumns(ipLocationReq) {
+		finalCols[col] = true
+	}
+
+	return nil
+}
+
+func performIPLocationRequestOnHistogram(nodeResult *structs.NodeResult, ipLocationReq *structs.IPLocationRequest, reader *lookups.MMDBReader) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			value, exists := getAggregationResultCell(aggregationResult, rowIndex, ipLocationReq.Field)
+			if cValue, ok := value.(segutils.CValueEnclosure); ok {
+				value = cValue.CVal
+			}
+			fields, err := getIPLocationFields(ipLocationReq, reader, value, exists)
+			if err != nil {
+				return fmt.Errorf("performIPLocationRequestOnHistogram: %v", err)
+			}
+
+			for col, fieldValue := range fields {
+				// The groupby columns are the keys of the buckets, so they are left unchanged.
+				if putils.SliceContainsString(bucketResult.GroupByKeys, col) {
+					continue
+				}
+				bucketResult.StatRes[col] = CreateCValueFromColValue(fieldValue)
+			}
+		}
+	}
+
+	return nil
+}
+
+func performIPLocationRequestOnMeasureResults(nodeResult *structs.NodeResult, ipLocationReq *structs.IPLocationRequest, reader *lookups.MMDBReader) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		value, exists := getMeasureResultsCell(nodeResult, rowIndex, ipLocationReq.Field)
+		fields, err := getIPLocationFields(ipLocationReq, reader, value, exists)
+		if err != nil {
+			return fmt.Errorf("performIPLocationRequestOnMeasureResults: %v", err)
+		}
+
+		for col, fieldValue := range fields {
+			if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+				bucketHolder.MeasureVal[col] = fieldValue
+			}
+		}
+	}
+
+	newCols := make([]string, 0)
+	for _, col := range getIPLocationColumns(ipLocationReq) {
+		if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/siglens/siglens/pkg/lookups"
+	"github.com/siglens/siglens/pkg/segment/structs"
+)
+
+func performOutputLookupRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	outputLookupReq := letColReq.OutputLookupRequest
+	if recs != nil {
+		if err := performOutputLookupRequestWithoutGroupby(nodeResult, outputLookupReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performOutputLookupRequest: %v", err)
+		}
+		return nil
+	}
+
+	// The MeasureResults and the Histogram hold the same rows, so only one of them is written.
+	var table *resultTable
+	if len(nodeResult.MeasureResults) > 0 {
+		table = getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	} else {
+		table = &resultTable{}
+		for _, aggregationResult := range nodeResult.Histogram {
+			if len(aggregationResult.Results) == 0 {
+				continue
+			}
+			histogramTable := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+			table.columns = histogramTable.columns
+			table.rows = append(table.rows, histogramTable.rows...)
+		}
+	}
+
+	if err := writeOutputLookup(outputLookupReq, table); err != nil {
+		return fmt.Errorf("performOutputLookupRequest: %v", err)
+	}
+
+	return nil
+}
+
+// The lookup file is written in one go, so it waits for every record of the search; the records
+// are then passed on unchanged.
+func performOutputLookupRequestWithoutGroupby(nodeResult *structs.NodeResult, outputLookupReq *structs.OutputLookupRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &outputLookupReq.Records, &outputLookupReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	recordKeys := make([]string, 0, len(outputLookupReq.Records))
+	for recordKey := range outputLookupReq.Records {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Slice(recordKeys, func(i, j int) bool {
+		return recordIndexInFinal[recordKeys[i]] < recordIndexInFinal[recordKeys[j]]
+	})
+
+	records := make([]map[string]interface{}, len(recordKeys))
+	for i, recordKey := range recordKeys {
+		records[i] = outputLookupReq.Records[recordKey]
+	}
+
+	err := writeOutputLookup(outputLookupReq, getRecsResultTable(nodeResult, records, finalCols))
+	if err != nil {
+		return fmt.Errorf("performOutputLookupRequestWithoutGroupby: %v", err)
+	}
+
+	for recordKey, record := range outputLookupReq.Records {
+		recs[recordKey] = record
+	}
+	outputLookupReq.Records = nil
+
+	return nil
+}
+
+func writeOutputLookup(outputLookupReq *structs.OutputLookupRequest, table *resultTable) error {
+	rows := make([][]string, len(table.rows))
+	for i, row := range table.rows {
+		rows[i] = make([]string, len(table.columns))
+		for j, col := range table.columns {
+			rows[i][j] = getOutputLookupValue(row[col])
+		}
+	}
+
+	err := lookups.WriteLookupTable(outputLookupReq.Filename, table.columns, rows, outputLookupReq.Append, outputLookupReq.CreateEmpty)
+	if err != nil {
+		return fmt.Errorf("writeOutputLookup: %v", err)
+	}
+
+	return nil
+}
+
+func getOutputLookupValue(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+const predictFutureRecordKeyPrefix = "predict_future_"
+
+// The smoothing parameters of the model are chosen out of a grid with this step, by the
+// smallest sum of the squared errors of the predictions.
+const predictParamStep = 0.05
+
+// An additive exponential smoothing model: a level, an optional trend and optional seasonal
+// offsets, each of which is corrected by a fraction of the error of every prediction.
+type predictModel struct {
+	alpha  float64 // level
+	beta   float64 // trend
+	gamma  float64 // seasonal offsets
+	period int     // number of rows in a season; 1 when there are no seasonal offsets
+
+	level    float64
+	trend    float64
+	seasonal []float64
+	numSteps int
+}
+
+func newPredictModel(alpha float64, beta float64, gamma float64, period int, initialLevel float64) *predictModel {
+	return &predictModel{
+		alpha:    alpha,
+		beta:     beta,
+		gamma:    gamma,
+		period:   period,
+		level:    initialLevel,
+		seasonal: make([]float64, period),
+	}
+}
+
+// Returns the prediction of the next value before it is seen.
+func (model *predictModel) predict() float64 {
+	return model.level + model.trend + model.seasonal[model.numSteps%model.period]
+}
+
+// Moves the model to the next value; a missing value leaves the model unchanged
+// other than the trend.
+func (model *predictModel) update(value float64, exists bool) float64 {
+	prediction := model.predict()
+	err := 0.0
+	if exists {
+		err = value - prediction
+	}
+
+	model.level += model.trend + model.alpha*err
+	model.trend += model.beta * err
+	model.seasonal[model.numSteps%model.period] += model.gamma * err
+	model.numSteps++
+
+	return err
+}
+
+// Returns the prediction of the value h steps after the last one that the model has seen.
+func (model *predictModel) forecast(h int) float64 {
+	return model.level + float64(h)*model.trend + model.seasonal[(model.numSteps+h-1)%model.period]
+}
+
+// Returns the factor of the variance of the one step error for a forecast h steps ahead.
+func (model *predictModel) forecastVarianceFactor(h int) float64 {
+	factor := 1.0
+	for j := 1; j < h; j++ {
+		c := model.alpha + model.beta*float64(j)
+		if model.period > 1 && j%model.period == 0 {
+			c += model.gamma
+		}
+		factor += c * c
+	}
+	return factor
+}
+
+// Returns the number of rows in a season, as the lag with the highest autocorrelation of
+// the values; 1 when the values do not repeat.
+func findPredictPeriod(values []float64, exists []bool) int {
+	mean, count := 0.0, 0
+	for i, value := range values {
+		if exists[i] {
+			mean += value
+			count++
+		}
+	}
+	if count < 4 {
+		return 1
+	}
+	mean /= float64(count)
+
+	variance := 0.0
+	for i, value := range values {
+		if exists[i] {
+			variance += (value - mean) * (value - mean)
+		}
+	}
+	if variance == 0 {
+		return 1
+	}
+
+	bestPeriod, bestCorrelation := 1, 0.0
+	for lag := 2; lag <= len(values)/2; lag++ {
+		covariance := 0.0
+		for i := lag; i < len(values); i++ {
+			if exists[i] && exists[i-lag] {
+				covariance += (values[i] - mean) * (values[i-lag] - mean)
+			}
+		}
+		if correlation := covariance / variance; correlation > bestCorrelation {
+			bestPeriod, bestCorrelation = lag, correlation
+		}
+	}
+
+	return bestPeriod
+}
+
+// The level starts at the first value; returns false when there are no values.
+func getPredictInitialLevel(values []float64, exists []bool) (float64, bool) {
+	for i, value := range values {
+		if exists[i] {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the model with the smallest sum of the squared errors over the values, and the
+// standard deviation of its errors. The model has seen all the values once it is returned,
+// and it is nil when there are no values.
+func fitPredictModel(predictReq *structs.PredictRequest, values []float64, exists []bool) (*predictModel, float64) {
+	initialLevel, ok := getPredictInitialLevel(values, exists)
+	if !ok {
+		return nil, 0
+	}
+
+	period := 1
+	if predictReq.Algorithm == structs.PredictLLP {
+		period = int(predictReq.Period)
+		if period == 0 {
+			period = findPredictPeriod(values, exists)
+		}
+	}
+
+	numParamSteps := int(math.Round(1 / predictParamStep))
+	var bestModel *predictModel
+	bestSSE, bestCount := math.Inf(1), 0
+	for a := 1; a < numParamSteps; a++ {
+		alpha := float64(a) * predictParamStep
+		for b := 0; b <= a; b++ {
+			beta := float64(b) * predictParamStep
+			if predictReq.Algorithm != structs.PredictLLT && b > 0 {
+				break
+			}
+			for g := 0; g < numParamSteps-a; g++ {
+				gamma := float64(g) * predictParamStep
+				if period == 1 && g > 0 {
+					break
+				}
+
+				model := newPredictModel(alpha, beta, gamma, period, initialLevel)
+				sse, count := 0.0, 0
+				for i, value := range values {
+					err := model.update(value, exists[i])
+					if exists[i] && i > 0 {
+						sse += err * err
+						count++
+					}
+				}
+				if sse < bestSSE {
+					bestModel, bestSSE, bestCount = model, sse, count
+				}
+			}
+		}
+	}
+
+	stdDev := 0.0
+	if bestCount > 0 {
+		stdDev = math.Sqrt(bestSSE / float64(bestCount))
+	}
+	return bestModel, stdDev
+}
+
+// Sets the prediction of the field and its bounds on the rows, and on the future rows after them.
+func setPredictions(predictReq *structs.PredictRequest, predictField *structs.PredictField, rows []map[string]interface{}, futureRows []map[string]interface{}) {
+	numFitted := len(rows) - int(predictReq.Holdback)
+	if numFitted < 0 {
+		numFitted = 0
+	}
+
+	values := make([]float64, numFitted)
+	exists := make([]bool, numFitted)
+	for i := 0; i < numFitted; i++ {
+		values[i], exists[i] = getNumericValue(rows[i][predictField.Field])
+	}
+
+	model, stdDev := fitPredictModel(predictReq, values, exists)
+	if model == nil {
+		return
+	}
+	z := math.Sqrt2 * math.Erfinv(float64(predictReq.Confidence)/100)
+
+	setPrediction := func(row map[string]interface{}, prediction float64, stdDev float64) {
+		row[predictField.NewField] = prediction
+		row[predictField.UpperField] = prediction + z*stdDev
+		row[predictField.LowerField] = prediction - z*stdDev
+	}
+
+	// The model is run again over the values to get the prediction of each one before it is seen.
+	initialLevel, _ := getPredictInitialLevel(values, exists)
+	fittedModel := newPredictModel(model.alpha, model.beta, model.gamma, model.period, initialLevel)
+	for i := 0; i < numFitted; i++ {
+		setPrediction(rows[i], fittedModel.predict(), stdDev)
+		fittedModel.update(values[i], exists[i])
+	}
+
+	forecastRows := append(rows[numFitted:len(rows):len(rows)], futureRows...)
+	for i, row := range forecastRows {
+		h := i + 1
+		setPrediction(row, model.forecast(h), stdDev*math.Sqrt(model.forecastVarianceFactor(h)))
+	}
+}
+
+func getPredictColumns(predictReq *structs.PredictRequest) []string {
+	columns := make([]string, 0, 3*len(predictReq.Fields))
+	for _, field := range predictReq.Fields {
+		columns = append(columns, field.NewField, field.UpperField, field.LowerField)
+	}
+	return columns
+}
+
+func getPredictTimestamp(value interface{}) (uint64, bool) {
+	switch val := value.(type) {
+	case string:
+		timestamp, err := strconv.ParseUint(val, 10, 64)
+		return timestamp, err == nil
+	case uint64:
+		return val, true
+	case int64:
+		return uint64(val), val >= 0
+	default:
+		number, ok := getNumericValue(value)
+		return uint64(number), ok && number >= 0
+	}
+}
+
+// Returns the rows after the last row, which only have the predictions and the time. The time
+// of each future row is one time bucket after the row before it.
+func getPredictFutureRows(predictReq *structs.PredictRequest, rows []map[string]interface{}) []map[string]interface{} {
+	futureRows := make([]map[string]interface{}, predictReq.FutureTimespan)
+	for i := range futureRows {
+		futureRows[i] = make(map[string]interface{})
+	}
+
+	timeField := config.GetTimeStampKey()
+	if len(rows) < 2 {
+		return futureRows
+	}
+	lastTimestamp, lastOk := getPredictTimestamp(rows[len(rows)-1][timeField])
+	prevTimestamp, prevOk := getPredictTimestamp(rows[len(rows)-2][timeField])
+	if !lastOk || !prevOk || lastTimestamp <= prevTimestamp {
+		return futureRows
+	}
+
+	interval := lastTimestamp - prevTimestamp
+	_, isString := rows[len(rows)-1][timeField].(string)
+	for i, futureRow := range futureRows {
+		timestamp := lastTimestamp + uint64(i+1)*interval
+		if isString {
+			futureRow[timeField] = strconv.FormatUint(timestamp, 10)
+		} else {
+			futureRow[timeField] = timestamp
+		}
+	}
+
+	return futureRows
+}
+
+// The options are also checked by the parser, but the request may come from elsewhere.
+func checkPredictRequest(predictReq *structs.PredictRequest, numRows int) error {
+	if predictReq.FutureTimespan > structs.MaxPredictOptionValue {
+		return fmt.Errorf("checkPredictRequest: future_timespan %v is larger than %v", predictReq.FutureTimespan, structs.MaxPredictOptionValue)
+	}
+	if predictReq.Period > structs.MaxPredictOptionValue {
+		return fmt.Errorf("checkPredictRequest: period %v is larger than %v", predictReq.Period, structs.MaxPredictOptionValue)
+	}
+	if predictReq.Holdback > 0 && predictReq.Holdback >= uint64(numRows) {
+		return fmt.Errorf("checkPredictRequest: holdback %v must be less than the number of rows %v", predictReq.Holdback, numRows)
+	}
+	return nil
+}
+
+// Returns the future rows after the rows, with the predictions set on all the rows.
+func predictRows(predictReq *structs.PredictRequest, rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	err := checkPredictRequest(predictReq, len(rows))
+	if err != nil {
+		return nil, fmt.Errorf("predictRows: %v", err)
+	}
+
+	futureRows := getPredictFutureRows(predictReq, rows)
+	for _, field := range predictReq.Fields {
+		setPredictions(predictReq, field, rows, futureRows)
+	}
+	return futureRows, nil
+}
+
+func performPredictRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	predictReq := letColReq.PredictRequest
+	if recs != nil {
+		if err := performPredictRequestWithoutGroupby(nodeResult, predictReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performPredictRequest: %v", err)
+		}
+		return nil
+	}
+
+	if err := performPredictRequestOnHistogram(nodeResult, predictReq); err != nil {
+		return fmt.Errorf("performPredictRequest: %v", err)
+	}
+	if err := performPredictRequestOnMeasureResults(nodeResult, predictReq); err != nil {
+		return fmt.Errorf("performPredictRequest: %v", err)
+	}
+
+	return nil
+}
+
+// The model is fit to the whole series in the order of the results, and the future values
+// follow its last record.
+func performPredictRequestWithoutGroupby(nodeResult *structs.NodeResult, predictReq *structs.PredictRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &predictReq.Records, &predictReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	for recordKey, record := range predictReq.Records {
+		recs[recordKey] = record
+	}
+	predictReq.Records = nil
+
+	currentOrder, err := GetOrderedRecs(recs, recordIndexInFinal)
+	if err != nil {
+		return fmt.Errorf("performPredictRequestWithoutGroupby: %v", err)
+	}
+
+	rows := make([]map[string]interface{}, len(currentOrder))
+	for i, recordKey := range currentOrder {
+		rows[i] = recs[recordKey]
+	}
+
+	futureRows, err := predictRows(predictReq, rows)
+	if err != nil {
+		return fmt.Errorf("performPredictRequestWithoutGroupby: %v", err)
+	}
+	for i, futureRow := range futureRows {
+		recordKey := fmt.Sprintf("%v%v", predictFutureRecordKeyPrefix, i)
+		recs[recordKey] = futureRow
+		recordIndexInFinal[recordKey] = len(rows) + i
+	}
+
+	for _, col := range getPredictColumns(predictReq) {
+		finalCols[col] = true
+	}
+
+	return nil
+}
+
+func performPredictRequestOnHistogram(nodeResult *structs.NodeResult, predictReq *structs.PredictRequest) error {
+	columns := getPredictColumns(predictReq)
+
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		futureRows, err := predictRows(predictReq, table.rows)
+		if err != nil {
+			return fmt.Errorf("performPredictRequestOnHistogram: %v", err)
+		}
+
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			for _, col := range columns {
+				if value, ok := table.rows[rowIndex][col]; ok && !putils.SliceContainsString(table.groupByCols, col) {
+					bucketResult.StatRes[col] = utils.CValueEnclosure{Dtype: utils.SS_DT_FLOAT, CVal: value}
+				}
+			}
+		}
+
+		for _, futureRow := range futureRows {
+			groupByValues := getReshapeGroupByValues(table, futureRow)
+			var bucketKey interface{} = groupByValues
+			if len(groupByValues) == 1 {
+				bucketKey = groupByValues[0]
+			}
+
+			statRes := make(map[string]utils.CValueEnclosure, len(columns))
+			for _, col := range columns {
+				if value, ok := futureRow[col]; ok && !putils.SliceContainsString(table.groupByCols, col) {
+					statRes[col] = utils.CValueEnclosure{Dtype: utils.SS_DT_FLOAT, CVal: value}
+				}
+			}
+
+			aggregationResult.Results = append(aggregationResult.Results, &structs.BucketResult{
+				BucketKey:   bucketKey,
+				GroupByKeys: table.groupByCols,
+				StatRes:     statRes,
+			})
+		}
+	}
+
+	return nil
+}
+
+func performPredictRequestOnMeasureResults(nodeResult *structs.NodeResult, predictReq *structs.PredictRequest) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	columns := getPredictColumns(predictReq)
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	futureRows, err := predictRows(predictReq, table.rows)
+	if err != nil {
+		return fmt.Errorf("performPredictRequestOnMeasureResults: %v", err)
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		for _, col := range columns {
+			if value, ok := table.rows[rowIndex][col]; ok && !putils.SliceContainsString(table.groupByCols, col) {
+				bucketHolder.MeasureVal[col] = value
+			}
+		}
+	}
+
+	for _, futureRow := range futureRows {
+		measureVal := make(map[string]interface{}, len(columns))
+		for _, col := range columns {
+			if value, ok := futureRow[col]; ok && !putils.SliceContainsString(table.groupByCols, col) {
+				measureVal[col] = value
+			}
+		}
+
+		nodeResult.MeasureResults = append(nodeResult.MeasureResults, &structs.BucketHolder{
+			GroupByValues: getReshapeGroupByValues(table, futureRow),
+			MeasureVal:    measureVal,
+		})
+	}
+	nodeResult.BucketCount = len(nodeResult.MeasureResults)
+
+	newCols := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if !putils.SliceContainsString(table.groupByCols, col) {
+			newCols = append(newCols, col)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+	return nil
+}
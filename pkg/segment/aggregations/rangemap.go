@@ -0,0 +1,102 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"github.com/siglens/siglens/pkg/common/dtypeutils"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	segutils "github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+const RangeMapField = "range"
+
+func performRangeMapRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	rangeMapReq := letColReq.RangeMapRequest
+
+	if recs != nil {
+		for _, record := range recs {
+			value, exists := record[rangeMapReq.Field]
+			record[RangeMapField] = getRangeMapValue(rangeMapReq, value, exists)
+		}
+		finalCols[RangeMapField] = true
+		return
+	}
+
+	performRangeMapRequestOnHistogram(nodeResult, rangeMapReq)
+	performRangeMapRequestOnMeasureResults(nodeResult, rangeMapReq)
+}
+
+// Returns the names of the ranges that contain the value; it's a multivalue when more than
+// one range contains it. Values that are missing or not numbers get the default name.
+func getRangeMapValue(rangeMapReq *structs.RangeMapRequest, value interface{}, exists bool) interface{} {
+	if !exists || value == nil {
+		return rangeMapReq.Default
+	}
+
+	number, err := dtypeutils.ConvertToFloat(value, 64)
+	if err != nil {
+		return rangeMapReq.Default
+	}
+
+	names := make([]string, 0, 1)
+	for _, numRange := range rangeMapReq.Ranges {
+		if number >= numRange.Start && number <= numRange.End {
+			names = append(names, numRange.Name)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return rangeMapReq.Default
+	case 1:
+		return names[0]
+	default:
+		return names
+	}
+}
+
+func performRangeMapRequestOnHistogram(nodeResult *structs.NodeResult, rangeMapReq *structs.RangeMapRequest) {
+	for _, aggregationResult := range nodeResult.Histogram {
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			// The groupby columns are the keys of the buckets, so they are left unchanged.
+			if putils.SliceContainsString(bucketResult.GroupByKeys, RangeMapField) {
+				continue
+			}
+
+			value, exists := getAggregationResultCell(aggregationResult, rowIndex, rangeMapReq.Field)
+			if cValue, ok := value.(segutils.CValueEnclosure); ok {
+				value, _ = cValue.GetValue()
+			}
+			bucketResult.StatRes[RangeMapField] = CreateCValueFromColValue(getRangeMapValue(rangeMapReq, value, exists))
+		}
+	}
+}
+
+func performRangeMapRequestOnMeasureResults(nodeResult *structs.NodeResult, rangeMapReq *structs.RangeMapRequest) {
+	if len(nodeResult.MeasureResults) == 0 || putils.SliceContainsString(nodeResult.GroupByCols, RangeMapField) {
+		return
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		value, exists := getMeasureResultsCell(nodeResult, rowIndex, rangeMapReq.Field)
+		bucketHolder.MeasureVal[RangeMapField] = getRangeMapValue(rangeMapReq, value, exists)
+	}
+
+	addMeasureResultsColumns(nodeResult, []string{RangeMapField})
+}
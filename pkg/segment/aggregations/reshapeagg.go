@@ -0,0 +1,501 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/utils"
+	putils "github.com/siglens/siglens/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// The results as a table, with the columns in the order they are displayed.
+type resultTable struct {
+	columns     []string
+	groupByCols []string
+	rows        []map[string]interface{}
+}
+
+func performReshapeRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	reshapeReq := letColReq.ReshapeRequest
+	if recs != nil {
+		if err := performReshapeRequestWithoutGroupby(nodeResult, reshapeReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performReshapeRequest: %v", err)
+		}
+		return nil
+	}
+
+	// Both the MeasureResults and the Histogram are reshaped, so the columns of the results
+	// are taken before either of them changes the groupby columns and the columns order.
+	columnsOrder := nodeResult.ColumnsOrder
+	if err := performReshapeRequestOnMeasureResults(nodeResult, reshapeReq, columnsOrder); err != nil {
+		return fmt.Errorf("performReshapeRequest: %v", err)
+	}
+	if err := performReshapeRequestOnHistogram(nodeResult, reshapeReq, columnsOrder); err != nil {
+		return fmt.Errorf("performReshapeRequest: %v", err)
+	}
+
+	return nil
+}
+
+// xyseries, untable and transpose turn the rows into columns or back, so they need the whole
+// table before they can build the new one.
+func performReshapeRequestWithoutGroupby(nodeResult *structs.NodeResult, reshapeReq *structs.ReshapeRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if !holdRecordsUntilLastSegment(nodeResult, &reshapeReq.Records, &reshapeReq.NumProcessedSegments, recs, numTotalSegments, finishesSegment) {
+		return nil
+	}
+
+	recordKeys := make([]string, 0, len(reshapeReq.Records))
+	for recordKey := range reshapeReq.Records {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Slice(recordKeys, func(i, j int) bool {
+		return recordIndexInFinal[recordKeys[i]] < recordIndexInFinal[recordKeys[j]]
+	})
+
+	records := make([]map[string]interface{}, len(recordKeys))
+	for i, recordKey := range recordKeys {
+		records[i] = reshapeReq.Records[recordKey]
+		delete(recordIndexInFinal, recordKey)
+	}
+	table := getRecsResultTable(nodeResult, records, finalCols)
+	reshapeReq.Records = nil
+
+	newTable, err := reshapeResultTable(reshapeReq, table)
+	if err != nil {
+		return fmt.Errorf("performReshapeRequestWithoutGroupby: %v", err)
+	}
+
+	for col := range finalCols {
+		delete(finalCols, col)
+	}
+	for _, col := range newTable.columns {
+		finalCols[col] = true
+	}
+
+	for i, row := range newTable.rows {
+		recordKey := fmt.Sprintf("reshape_%d", i)
+		recs[recordKey] = row
+		recordIndexInFinal[recordKey] = i
+	}
+
+	return nil
+}
+
+func performReshapeRequestOnHistogram(nodeResult *structs.NodeResult, reshapeReq *structs.ReshapeRequest, columnsOrder map[string]int) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, columnsOrder)
+		newTable, err := reshapeResultTable(reshapeReq, table)
+		if err != nil {
+			return fmt.Errorf("performReshapeRequestOnHistogram: %v", err)
+		}
+
+		setHistogramResultTable(nodeResult, aggregationResult, newTable)
+	}
+
+	return nil
+}
+
+// Replaces the buckets of the aggregation with the rows of the table.
+func setHistogramResultTable(nodeResult *structs.NodeResult, aggregationResult *structs.AggregationResult, newTable *resultTable) {
+	results := make([]*structs.BucketResult, len(newTable.rows))
+	for i, row := range newTable.rows {
+		groupByValues := getReshapeGroupByValues(newTable, row)
+		var bucketKey interface{} = groupByValues
+		if len(groupByValues) == 1 {
+			bucketKey = groupByValues[0]
+		}
+
+		statRes := make(map[string]utils.CValueEnclosure, len(row))
+		for col, value := range row {
+			if putils.SliceContainsString(newTable.groupByCols, col) {
+				continue
+			}
+			var cValue utils.CValueEnclosure
+			if err := cValue.ConvertValue(value); err != nil {
+				log.Errorf("setHistogramResultTable: failed to convert the value of column %v, err: %v", col, err)
+				continue
+			}
+			statRes[col] = cValue
+		}
+
+		results[i] = &structs.BucketResult{
+			BucketKey:   bucketKey,
+			GroupByKeys: newTable.groupByCols,
+			StatRes:     statRes,
+		}
+	}
+	aggregationResult.Results = results
+	nodeResult.GroupByCols = newTable.groupByCols
+	nodeResult.ColumnsOrder = getReshapeColumnsOrder(newTable)
+}
+
+func performReshapeRequestOnMeasureResults(nodeResult *structs.NodeResult, reshapeReq *structs.ReshapeRequest, columnsOrder map[string]int) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	table := getMeasureResultsTable(nodeResult, columnsOrder)
+	newTable, err := reshapeResultTable(reshapeReq, table)
+	if err != nil {
+		return fmt.Errorf("performReshapeRequestOnMeasureResults: %v", err)
+	}
+
+	setMeasureResultsTable(nodeResult, newTable)
+
+	return nil
+}
+
+// Replaces the measure results with the rows of the table.
+func setMeasureResultsTable(nodeResult *structs.NodeResult, newTable *resultTable) {
+	measureResults := make([]*structs.BucketHolder, len(newTable.rows))
+	for i, row := range newTable.rows {
+		measureVal := make(map[string]interface{}, len(row))
+		for col, value := range row {
+			if !putils.SliceContainsString(newTable.groupByCols, col) {
+				measureVal[col] = value
+			}
+		}
+
+		measureResults[i] = &structs.BucketHolder{
+			GroupByValues: getReshapeGroupByValues(newTable, row),
+			MeasureVal:    measureVal,
+		}
+	}
+
+	measureFunctions := make([]string, 0, len(newTable.columns))
+	for _, col := range newTable.columns {
+		if !putils.SliceContainsString(newTable.groupByCols, col) {
+			measureFunctions = append(measureFunctions, col)
+		}
+	}
+
+	nodeResult.MeasureResults = measureResults
+	nodeResult.MeasureFunctions = measureFunctions
+	nodeResult.GroupByCols = newTable.groupByCols
+	nodeResult.BucketCount = len(measureResults)
+	nodeResult.ColumnsOrder = getReshapeColumnsOrder(newTable)
+}
+
+// The groupby columns come first, then the other columns of the records by name.
+func getRecsResultTable(nodeResult *structs.NodeResult, records []map[string]interface{}, finalCols map[string]bool) *resultTable {
+	measureCols := make([]string, 0, len(finalCols))
+	for col := range finalCols {
+		if !putils.SliceContainsString(nodeResult.GroupByCols, col) {
+			measureCols = append(measureCols, col)
+		}
+	}
+	sort.Strings(measureCols)
+
+	table := &resultTable{
+		groupByCols: nodeResult.GroupByCols,
+		rows:        records,
+	}
+	for _, col := range nodeResult.GroupByCols {
+		if finalCols[col] {
+			table.columns = append(table.columns, col)
+		}
+	}
+	table.columns = append(table.columns, measureCols...)
+
+	return table
+}
+
+func getHistogramResultTable(nodeResult *structs.NodeResult, aggregationResult *structs.AggregationResult, columnsOrder map[string]int) *resultTable {
+	groupByCols := aggregationResult.Results[0].GroupByKeys
+	measureCols := make([]string, 0)
+	rows := make([]map[string]interface{}, len(aggregationResult.Results))
+	for rowIndex, bucketResult := range aggregationResult.Results {
+		row := make(map[string]interface{}, len(groupByCols)+len(bucketResult.StatRes))
+		for _, col := range groupByCols {
+			if value, ok := getAggregationResultGroupByCell(aggregationResult, rowIndex, col); ok {
+				row[col] = value
+			}
+		}
+		for col, cValue := range bucketResult.StatRes {
+			value, err := cValue.GetValue()
+			if err != nil {
+				log.Errorf("getHistogramResultTable: failed to get the value of column %v, err: %v", col, err)
+				continue
+			}
+			if !putils.SliceContainsString(measureCols, col) {
+				measureCols = append(measureCols, col)
+			}
+			row[col] = value
+		}
+		rows[rowIndex] = row
+	}
+
+	return &resultTable{
+		columns:     getReshapeColumnsInOrder(nodeResult.GroupByRequest, columnsOrder, groupByCols, measureCols),
+		groupByCols: groupByCols,
+		rows:        rows,
+	}
+}
+
+func getMeasureResultsTable(nodeResult *structs.NodeResult, columnsOrder map[string]int) *resultTable {
+	rows := make([]map[string]interface{}, len(nodeResult.MeasureResults))
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		row := make(map[string]interface{}, len(nodeResult.GroupByCols)+len(bucketHolder.MeasureVal))
+		for i, col := range nodeResult.GroupByCols {
+			if i < len(bucketHolder.GroupByValues) {
+				row[col] = bucketHolder.GroupByValues[i]
+			}
+		}
+		for col, value := range bucketHolder.MeasureVal {
+			row[col] = value
+		}
+		rows[rowIndex] = row
+	}
+
+	return &resultTable{
+		columns:     getReshapeColumnsInOrder(nodeResult.GroupByRequest, columnsOrder, nodeResult.GroupByCols, nodeResult.MeasureFunctions),
+		groupByCols: nodeResult.GroupByCols,
+		rows:        rows,
+	}
+}
+
+// The columns are displayed in ColumnsOrder when it is set. Otherwise the groupby columns
+// come first, then the aggregations in the order of the query, then any other columns.
+func getReshapeColumnsInOrder(groupByRequest *structs.GroupByRequest, columnsOrder map[string]int, groupByCols []string, measureCols []string) []string {
+	columns := make([]string, 0, len(groupByCols)+len(measureCols))
+	columns = append(columns, groupByCols...)
+	for _, col := range measureCols {
+		if !putils.SliceContainsString(columns, col) {
+			columns = append(columns, col)
+		}
+	}
+
+	measureOpIndex := make(map[string]int)
+	if groupByRequest != nil {
+		for i, measureAgg := range groupByRequest.MeasureOperations {
+			measureOpIndex[measureAgg.String()] = i
+		}
+	}
+
+	getRank := func(col string) (int, int, string) {
+		if len(columnsOrder) > 0 {
+			if index, ok := columnsOrder[col]; ok {
+				return 0, index, ""
+			}
+			return 1, 0, col
+		}
+		for i, groupByCol := range groupByCols {
+			if groupByCol == col {
+				return 0, i, ""
+			}
+		}
+		if index, ok := measureOpIndex[col]; ok {
+			return 1, index, ""
+		}
+		return 2, 0, col
+	}
+
+	sort.SliceStable(columns, func(i, j int) bool {
+		tier1, index1, name1 := getRank(columns[i])
+		tier2, index2, name2 := getRank(columns[j])
+		if tier1 != tier2 {
+			return tier1 < tier2
+		}
+		if index1 != index2 {
+			return index1 < index2
+		}
+		return name1 < name2
+	})
+
+	return columns
+}
+
+func getReshapeColumnsOrder(table *resultTable) map[string]int {
+	columnsOrder := make(map[string]int, len(table.columns))
+	for i, col := range table.columns {
+		columnsOrder[col] = i
+	}
+	return columnsOrder
+}
+
+func getReshapeGroupByValues(table *resultTable, row map[string]interface{}) []string {
+	groupByValues := make([]string, len(table.groupByCols))
+	for i, col := range table.groupByCols {
+		if value, ok := row[col]; ok && value != nil {
+			groupByValues[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	return groupByValues
+}
+
+func reshapeResultTable(reshapeReq *structs.ReshapeRequest, table *resultTable) (*resultTable, error) {
+	switch reshapeReq.ReshapeType {
+	case structs.ReshapeXYSeries:
+		return performXYSeries(reshapeReq, table), nil
+	case structs.ReshapeUntable:
+		return performUntable(reshapeReq, table), nil
+	case structs.ReshapeTranspose:
+		return performTranspose(reshapeReq, table), nil
+	default:
+		return nil, fmt.Errorf("reshapeResultTable: unknown reshape type: %v", reshapeReq.ReshapeType)
+	}
+}
+
+// Makes a row for each value of the x field and a column for each value of the y-name field,
+// which is filled with the value of the y-data field. When there are multiple y-data fields,
+// the columns are named <y-data field><sep><y-name value>.
+func performXYSeries(reshapeReq *structs.ReshapeRequest, table *resultTable) *resultTable {
+	newTable := &resultTable{
+		columns:     []string{reshapeReq.XField},
+		groupByCols: []string{reshapeReq.XField},
+		rows:        make([]map[string]interface{}, 0),
+	}
+
+	xValToRow := make(map[string]map[string]interface{})
+	for _, row := range table.rows {
+		xVal, ok := row[reshapeReq.XField]
+		if !ok || xVal == nil {
+			continue
+		}
+		yName, ok := row[reshapeReq.YNameField]
+		if !ok || yName == nil {
+			continue
+		}
+
+		xValStr := fmt.Sprintf("%v", xVal)
+		newRow, exists := xValToRow[xValStr]
+		if !exists {
+			newRow = map[string]interface{}{reshapeReq.XField: xVal}
+			xValToRow[xValStr] = newRow
+			newTable.rows = append(newTable.rows, newRow)
+		}
+
+		for _, yDataField := range reshapeReq.YDataFields {
+			yData, ok := row[yDataField]
+			if !ok {
+				continue
+			}
+
+			col := fmt.Sprintf("%v", yName)
+			if len(reshapeReq.YDataFields) > 1 {
+				col = yDataField + reshapeReq.Sep + col
+			}
+			if !putils.SliceContainsString(newTable.columns, col) {
+				newTable.columns = append(newTable.columns, col)
+			}
+			newRow[col] = yData
+		}
+	}
+
+	return newTable
+}
+
+// The reverse of xyseries: makes a row for each non-empty cell other than the x field,
+// with the x field value, the column name in the y-name field and the value in the y-data field.
+func performUntable(reshapeReq *structs.ReshapeRequest, table *resultTable) *resultTable {
+	yDataField := reshapeReq.YDataFields[0]
+	newTable := &resultTable{
+		columns:     []string{reshapeReq.XField, reshapeReq.YNameField, yDataField},
+		groupByCols: []string{reshapeReq.XField, reshapeReq.YNameField},
+		rows:        make([]map[string]interface{}, 0),
+	}
+
+	for _, row := range table.rows {
+		xVal, ok := row[reshapeReq.XField]
+		if !ok {
+			continue
+		}
+
+		for _, col := range table.columns {
+			if col == reshapeReq.XField {
+				continue
+			}
+			value, ok := row[col]
+			if !ok || value == nil || value == "" {
+				continue
+			}
+
+			newTable.rows = append(newTable.rows, map[string]interface{}{
+				reshapeReq.XField:     xVal,
+				reshapeReq.YNameField: col,
+				yDataField:            value,
+			})
+		}
+	}
+
+	return newTable
+}
+
+// Turns the first rows into columns, so that there is a row for each of the original columns.
+// The new columns are named "row 1", "row 2"... unless a header field is given.
+func performTranspose(reshapeReq *structs.ReshapeRequest, table *resultTable) *resultTable {
+	numRows := len(table.rows)
+	if reshapeReq.NumRows > 0 && reshapeReq.NumRows < uint64(numRows) {
+		numRows = int(reshapeReq.NumRows)
+	}
+
+	newTable := &resultTable{
+		columns:     []string{reshapeReq.ColumnName},
+		groupByCols: []string{reshapeReq.ColumnName},
+		rows:        make([]map[string]interface{}, 0),
+	}
+
+	headers := make([]string, numRows)
+	for i := 0; i < numRows; i++ {
+		headers[i] = fmt.Sprintf("row %d", i+1)
+		if reshapeReq.HeaderField != "" {
+			if header, ok := table.rows[i][reshapeReq.HeaderField]; ok && header != nil {
+				headers[i] = fmt.Sprintf("%v", header)
+			}
+		}
+		if !putils.SliceContainsString(newTable.columns, headers[i]) {
+			newTable.columns = append(newTable.columns, headers[i])
+		}
+	}
+
+	for _, col := range table.columns {
+		if col == reshapeReq.HeaderField {
+			continue
+		}
+
+		newRow := map[string]interface{}{reshapeReq.ColumnName: col}
+		isEmpty := true
+		for i := 0; i < numRows; i++ {
+			value, ok := table.rows[i][col]
+			if !ok || value == nil || value == "" {
+				continue
+			}
+			newRow[headers[i]] = value
+			isEmpty = false
+		}
+
+		if isEmpty && !reshapeReq.IncludeEmpty {
+			continue
+		}
+		newTable.rows = append(newTable.rows, newRow)
+	}
+
+	return newTable
+}
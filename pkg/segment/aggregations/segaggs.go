@@ -30,6 +30,8 @@ import (
 	"time"
 
 	"github.com/siglens/siglens/pkg/common/dtypeutils"
+	"github.com/siglens/siglens/pkg/lookups"
+	"github.com/siglens/siglens/pkg/segment/memory/limit"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	segutils "github.com/siglens/siglens/pkg/segment/utils"
 	"github.com/siglens/siglens/pkg/utils"
@@ -165,17 +167,18 @@ func PostQueryBucketCleaning(nodeResult *structs.NodeResult, post *structs.Query
     2.2 Statistical eval functions: random
     2.3 Multivalue eval functions: mvappend, mvcount, mvdedup, mvfilter, mvfind, mvindex, mvjoin, mvmap, mvrange, mvsort, mvzip, mv_to_json_array
     2.4 Comparison and Conditional functions: case, coalesce, searchmatch, validate, nullif
-    2.5 Conversion functions: ipmask, object_to_array, printf, tojson
-    2.6 Date and Time functions: relative_time, time, strftime, strptime
-    2.7 Trig and Hyperbolic functions: acos, acosh, asin, asinh, atan, atanh, cos, cosh, sin, sinh, tan, tanh, atan2, hypot
-    2.8 Informational functions: cluster, getfields, isnotnull, isnum, typeof
-    2.9 Text functions: replace, spath, upper, trim
+    2.5 Date and Time functions: relative_time, time, strftime, strptime
+    2.6 Trig and Hyperbolic functions: acos, acosh, asin, asinh, atan, atanh, cos, cosh, sin, sinh, tan, tanh, atan2, hypot
+    2.7 Informational functions: getfields, isnotnull, isnum, typeof
 */
 func performAggOnResult(nodeResult *structs.NodeResult, agg *structs.QueryAggregators, recs map[string]map[string]interface{},
 	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool, hasSort bool, timeSort bool, timeSortAsc bool) error {
 	if agg.StreamStatsOptions != nil {
 		return PerformStreamStats(nodeResult, agg, recs, recordIndexInFinal, finalCols, finishesSegment, timeSort, timeSortAsc)
 	}
+	if agg.EventStatsOptions != nil {
+		return PerformEventStats(nodeResult, agg, recs, finalCols, numTotalSegments, finishesSegment)
+	}
 	switch agg.PipeCommandType {
 	case structs.GenerateEventType:
 		return performGenEvent(nodeResult, agg, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
@@ -271,6 +274,18 @@ func performGenEvent(nodeResult *structs.NodeResult, agg *structs.QueryAggregato
 	if agg.GenerateEvent.InputLookup != nil {
 		return performInputLookup(nodeResult, agg, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
 	}
+	if agg.GenerateEvent.MakeResults != nil {
+		return performMakeResults(agg, recs, recordIndexInFinal, finalCols)
+	}
+	if agg.GenerateEvent.Multisearch != nil {
+		return performMultisearch(agg, recs, recordIndexInFinal, finalCols)
+	}
+	if agg.GenerateEvent.TStats != nil {
+		return performTStats(agg, recs, recordIndexInFinal, finalCols)
+	}
+	if agg.GenerateEvent.FieldSummary != nil {
+		return performFieldSummaryGenEvent(agg, recs, recordIndexInFinal, finalCols)
+	}
 
 	return nil
 }
@@ -303,6 +318,39 @@ func performGenTimes(agg *structs.QueryAggregators, recs map[string]map[string]i
 	return PopulateGeneratedRecords(agg.GenerateEvent, recs, recordIndexInFinal, finalCols, 0)
 }
 
+func performMakeResults(agg *structs.QueryAggregators, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int, finalCols map[string]bool) error {
+	if agg.GenerateEvent.MakeResults == nil {
+		return nil
+	}
+	if recs == nil {
+		return nil
+	}
+
+	return PopulateGeneratedRecords(agg.GenerateEvent, recs, recordIndexInFinal, finalCols, 0)
+}
+
+func performMultisearch(agg *structs.QueryAggregators, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int, finalCols map[string]bool) error {
+	if agg.GenerateEvent.Multisearch == nil {
+		return nil
+	}
+	if recs == nil {
+		return nil
+	}
+
+	return PopulateGeneratedRecords(agg.GenerateEvent, recs, recordIndexInFinal, finalCols, 0)
+}
+
+func performTStats(agg *structs.QueryAggregators, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int, finalCols map[string]bool) error {
+	if agg.GenerateEvent.TStats == nil {
+		return nil
+	}
+	if recs == nil {
+		return nil
+	}
+
+	return PopulateGeneratedRecords(agg.GenerateEvent, recs, recordIndexInFinal, finalCols, 0)
+}
+
 func performInputLookup(nodeResult *structs.NodeResult, agg *structs.QueryAggregators, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
 	if agg.GenerateEvent.InputLookup == nil {
 		return nil
@@ -1125,6 +1173,82 @@ func performLetColumnsRequest(nodeResult *structs.NodeResult, aggs *structs.Quer
 		if err := performAppendRequest(); err != nil {
 			return fmt.Errorf("performAppendRequest: %v", err)
 		}
+	} else if letColReq.JoinRequest != nil {
+		if err := performJoinRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.LookupRequest != nil {
+		if err := performLookupRequest(nodeResult, letColReq, recs, finalCols); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ChartRequest != nil {
+		if err := performChartRequest(nodeResult, letColReq, recs, finalCols); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ReshapeRequest != nil {
+		if err := performReshapeRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.OutputLookupRequest != nil {
+		if err := performOutputLookupRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.AddTotalsRequest != nil {
+		if err := performAddTotalsRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ReverseRequest != nil {
+		if err := performReverseRequest(nodeResult, letColReq, recs, recordIndexInFinal, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.IPLocationRequest != nil {
+		if err := performIPLocationRequest(nodeResult, letColReq, recs, finalCols); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.AnomalyRequest != nil {
+		if err := performAnomalyRequest(nodeResult, letColReq, recs, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ClusterRequest != nil {
+		if err := performClusterRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.AppendColsRequest != nil {
+		if err := performAppendColsRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.AppendPipeRequest != nil {
+		if err := performAppendPipeRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ForeachRequest != nil {
+		if err := performForeachRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.PredictRequest != nil {
+		if err := performPredictRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.GeoStatsRequest != nil {
+		if err := performGeoStatsRequest(nodeResult, letColReq, recs, finalCols); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.ConvertRequest != nil {
+		performConvertRequest(nodeResult, letColReq, recs, finalCols)
+	} else if letColReq.FieldFormatRequest != nil {
+		if err := performFieldFormatRequest(nodeResult, aggs, letColReq, recs, finalCols); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
+	} else if letColReq.RangeMapRequest != nil {
+		performRangeMapRequest(nodeResult, letColReq, recs, finalCols)
+	} else if letColReq.FieldSummaryRequest != nil {
+		performFieldSummaryRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment)
+	} else if letColReq.ExtractRequest != nil {
+		performExtractRequest(nodeResult, letColReq, recs, finalCols)
+	} else if letColReq.SequentialRequest != nil {
+		if err := performSequentialRequest(nodeResult, letColReq, recs, recordIndexInFinal, finalCols, finishesSegment); err != nil {
+			return fmt.Errorf("performLetColumnsRequest: %v", err)
+		}
 	} else {
 		return errors.New("performLetColumnsRequest: expected one of MultiColsRequest, SingleColRequest, ValueColRequest, RexColRequest to have a value")
 	}
@@ -1136,6 +1260,511 @@ func performAppendRequest() error {
 	return errors.New("append command is not implemented yet")
 }
 
+const joinKeySeparator = "\x1f"
+
+func performJoinRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	joinReq := letColReq.JoinRequest
+	if joinReq.HashTable == nil {
+		if err := buildJoinHashTable(joinReq); err != nil {
+			return fmt.Errorf("performJoinRequest: %v", err)
+		}
+	}
+
+	// Without following a group by
+	if recs != nil {
+		if err := performJoinRequestWithoutGroupby(joinReq, recs, recordIndexInFinal, finalCols, numTotalSegments, finishesSegment); err != nil {
+			return fmt.Errorf("performJoinRequest: %v", err)
+		}
+		return nil
+	}
+
+	// Following a group by
+	if err := performJoinRequestOnHistogram(nodeResult, joinReq); err != nil {
+		return fmt.Errorf("performJoinRequest: %v", err)
+	}
+
+	if err := performJoinRequestOnMeasureResults(nodeResult, joinReq); err != nil {
+		return fmt.Errorf("performJoinRequest: %v", err)
+	}
+
+	return nil
+}
+
+// Builds the hash table for the subsearch records, keyed by the values of the join fields.
+// Subsearch records missing any of the join fields can never match, so they are skipped.
+func buildJoinHashTable(joinReq *structs.JoinRequest) error {
+	hashTable := make(map[string][]map[string]interface{})
+	hashTableBytes := uint64(0)
+
+	for _, record := range joinReq.SubsearchRecords {
+		joinKey, ok := getJoinKey(joinReq.FieldList, func(field string) (interface{}, bool) {
+			value, exists := record[field]
+			return value, exists
+		})
+		if !ok {
+			continue
+		}
+
+		hashTable[joinKey] = append(hashTable[joinKey], record)
+		hashTableBytes += uint64(len(joinKey)) + getRecordSizeInBytes(record)
+	}
+
+	err := limit.RequestJoinMemory(hashTableBytes)
+	if err != nil {
+		return fmt.Errorf("buildJoinHashTable: %v", err)
+	}
+
+	joinReq.HashTable = hashTable
+	return nil
+}
+
+func getJoinKey(fields []string, getValue func(field string) (interface{}, bool)) (string, bool) {
+	keyParts := make([]string, len(fields))
+	for i, field := range fields {
+		value, exists := getValue(field)
+		if !exists || value == nil {
+			return "", false
+		}
+
+		if enclosure, ok := value.(segutils.CValueEnclosure); ok {
+			value = enclosure.CVal
+		}
+		keyParts[i] = fmt.Sprintf("%v", value)
+	}
+
+	return strings.Join(keyParts, joinKeySeparator), true
+}
+
+func getJoinMatches(joinReq *structs.JoinRequest, joinKey string) []map[string]interface{} {
+	matches := joinReq.HashTable[joinKey]
+	if joinReq.Max > 0 && uint64(len(matches)) > joinReq.Max {
+		matches = matches[:joinReq.Max]
+	}
+
+	return matches
+}
+
+func getRecordSizeInBytes(record map[string]interface{}) uint64 {
+	size := uint64(0)
+	for col, value := range record {
+		size += uint64(len(col))
+		switch value := value.(type) {
+		case string:
+			size += uint64(len(value))
+		default:
+			size += 8
+		}
+	}
+
+	return size
+}
+
+// The join needs all the records before it can decide the final order, so records
+// are buffered until the last segment is processed.
+func performJoinRequestWithoutGroupby(joinReq *structs.JoinRequest, recs map[string]map[string]interface{}, recordIndexInFinal map[string]int,
+	finalCols map[string]bool, numTotalSegments uint64, finishesSegment bool) error {
+
+	if finishesSegment {
+		joinReq.NumProcessedSegments++
+	}
+
+	if joinReq.Records == nil {
+		joinReq.Records = make(map[string]map[string]interface{}, 0)
+	}
+
+	for recordKey, record := range recs {
+		joinReq.Records[recordKey] = record
+		delete(recs, recordKey)
+	}
+
+	if joinReq.NumProcessedSegments < numTotalSegments {
+		return nil
+	}
+
+	recordKeys := make([]string, 0, len(joinReq.Records))
+	for recordKey := range joinReq.Records {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Slice(recordKeys, func(i, j int) bool {
+		return recordIndexInFinal[recordKeys[i]] < recordIndexInFinal[recordKeys[j]]
+	})
+
+	for _, col := range joinReq.SubsearchCols {
+		finalCols[col] = true
+	}
+
+	index := 0
+	for _, recordKey := range recordKeys {
+		record := joinReq.Records[recordKey]
+		delete(recordIndexInFinal, recordKey)
+
+		joinKey, ok := getJoinKey(joinReq.FieldList, func(field string) (interface{}, bool) {
+			value, exists := record[field]
+			return value, exists
+		})
+		var matches []map[string]interface{}
+		if ok {
+			matches = getJoinMatches(joinReq, joinKey)
+		}
+
+		if len(matches) == 0 {
+			if joinReq.JoinType == structs.JoinInner {
+				continue
+			}
+			recs[recordKey] = record
+			recordIndexInFinal[recordKey] = index
+			index++
+			continue
+		}
+
+		for i, match := range matches {
+			joinedRecord := record
+			joinedRecordKey := recordKey
+			if len(matches) > 1 {
+				joinedRecord = make(map[string]interface{}, len(record)+len(match))
+				for col, value := range record {
+					joinedRecord[col] = value
+				}
+			}
+			if i > 0 {
+				joinedRecordKey = fmt.Sprintf("%s_join_%d", recordKey, i)
+			}
+
+			for col, value := range match {
+				joinedRecord[col] = value
+			}
+
+			recs[joinedRecordKey] = joinedRecord
+			recordIndexInFinal[joinedRecordKey] = index
+			index++
+		}
+	}
+
+	joinReq.Records = nil
+	return nil
+}
+
+func performJoinRequestOnHistogram(nodeResult *structs.NodeResult, joinReq *structs.JoinRequest) error {
+	for _, aggregationResult := range nodeResult.Histogram {
+		newResults := make([]*structs.BucketResult, 0, len(aggregationResult.Results))
+
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			joinKey, ok := getJoinKey(joinReq.FieldList, func(field string) (interface{}, bool) {
+				return getAggregationResultCell(aggregationResult, rowIndex, field)
+			})
+			var matches []map[string]interface{}
+			if ok {
+				matches = getJoinMatches(joinReq, joinKey)
+			}
+
+			if len(matches) == 0 {
+				if joinReq.JoinType != structs.JoinInner {
+					newResults = append(newResults, bucketResult)
+				}
+				continue
+			}
+
+			for _, match := range matches {
+				joinedResult := &structs.BucketResult{
+					ElemCount:   bucketResult.ElemCount,
+					StatRes:     make(map[string]segutils.CValueEnclosure, len(bucketResult.StatRes)+len(match)),
+					BucketKey:   bucketResult.BucketKey,
+					GroupByKeys: bucketResult.GroupByKeys,
+				}
+				for col, value := range bucketResult.StatRes {
+					joinedResult.StatRes[col] = value
+				}
+
+				for col, value := range match {
+					if utils.SliceContainsString(joinReq.FieldList, col) {
+						continue
+					}
+
+					var enclosure segutils.CValueEnclosure
+					err := enclosure.ConvertValue(value)
+					if err != nil {
+						enclosure.Dtype = segutils.SS_DT_STRING
+						enclosure.CVal = fmt.Sprintf("%v", value)
+					}
+					joinedResult.StatRes[col] = enclosure
+				}
+
+				newResults = append(newResults, joinedResult)
+			}
+		}
+
+		aggregationResult.Results = newResults
+	}
+
+	return nil
+}
+
+func performJoinRequestOnMeasureResults(nodeResult *structs.NodeResult, joinReq *structs.JoinRequest) error {
+	if len(nodeResult.MeasureResults) == 0 {
+		return nil
+	}
+
+	// New columns from the subsearch are added as MeasureFunctions columns.
+	for _, col := range joinReq.SubsearchCols {
+		if utils.SliceContainsString(nodeResult.GroupByCols, col) || utils.SliceContainsString(nodeResult.MeasureFunctions, col) {
+			continue
+		}
+		nodeResult.MeasureFunctions = append(nodeResult.MeasureFunctions, col)
+	}
+
+	newResults := make([]*structs.BucketHolder, 0, len(nodeResult.MeasureResults))
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		joinKey, ok := getJoinKey(joinReq.FieldList, func(field string) (interface{}, bool) {
+			return getMeasureResultsCell(nodeResult, rowIndex, field)
+		})
+		var matches []map[string]interface{}
+		if ok {
+			matches = getJoinMatches(joinReq, joinKey)
+		}
+
+		if len(matches) == 0 {
+			if joinReq.JoinType != structs.JoinInner {
+				newResults = append(newResults, bucketHolder)
+			}
+			continue
+		}
+
+		for _, match := range matches {
+			joinedHolder := &structs.BucketHolder{
+				GroupByValues: make([]string, len(bucketHolder.GroupByValues)),
+				MeasureVal:    make(map[string]interface{}, len(bucketHolder.MeasureVal)+len(match)),
+			}
+			copy(joinedHolder.GroupByValues, bucketHolder.GroupByValues)
+			for col, value := range bucketHolder.MeasureVal {
+				joinedHolder.MeasureVal[col] = value
+			}
+
+			for col, value := range match {
+				isGroupByCol := false
+				for i, groupByCol := range nodeResult.GroupByCols {
+					if col == groupByCol {
+						joinedHolder.GroupByValues[i] = fmt.Sprintf("%v", value)
+						isGroupByCol = true
+						break
+					}
+				}
+				if !isGroupByCol {
+					joinedHolder.MeasureVal[col] = value
+				}
+			}
+
+			newResults = append(newResults, joinedHolder)
+		}
+	}
+
+	nodeResult.MeasureResults = newResults
+	return nil
+}
+
+func performLookupRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	lookupReq := letColReq.LookupRequest
+	if lookupReq.Table == nil {
+		if err := loadLookupTable(lookupReq); err != nil {
+			return fmt.Errorf("performLookupRequest: %v", err)
+		}
+	}
+
+	// Without following a group by
+	if recs != nil {
+		performLookupRequestWithoutGroupby(lookupReq, recs, finalCols)
+		return nil
+	}
+
+	// Following a group by
+	performLookupRequestOnHistogram(nodeResult, lookupReq)
+	performLookupRequestOnMeasureResults(nodeResult, lookupReq)
+
+	return nil
+}
+
+// Loads the rows of the lookup file, keeping only the output fields of each row.
+// When several rows have the same match field values, the first one is used.
+func loadLookupTable(lookupReq *structs.LookupRequest) error {
+	columnNames, rows, err := lookups.GetLookupTable(lookupReq.Filename)
+	if err != nil {
+		return fmt.Errorf("loadLookupTable: %v", err)
+	}
+
+	columnIndex := make(map[string]int, len(columnNames))
+	for i, col := range columnNames {
+		columnIndex[col] = i
+	}
+
+	matchColIndices := make([]int, len(lookupReq.MatchFields))
+	for i, matchField := range lookupReq.MatchFields {
+		index, ok := columnIndex[matchField.LookupField]
+		if !ok {
+			return fmt.Errorf("loadLookupTable: field %v does not exist in lookup %v", matchField.LookupField, lookupReq.Filename)
+		}
+		matchColIndices[i] = index
+	}
+
+	outputFields := lookupReq.OutputFields
+	if len(outputFields) == 0 {
+		for _, col := range columnNames {
+			isMatchField := false
+			for _, matchField := range lookupReq.MatchFields {
+				if col == matchField.LookupField {
+					isMatchField = true
+					break
+				}
+			}
+			if !isMatchField {
+				outputFields = append(outputFields, &structs.LookupFieldMapping{LookupField: col, EventField: col})
+			}
+		}
+	}
+
+	outputColIndices := make([]int, len(outputFields))
+	outputCols := make([]string, len(outputFields))
+	for i, outputField := range outputFields {
+		index, ok := columnIndex[outputField.LookupField]
+		if !ok {
+			return fmt.Errorf("loadLookupTable: field %v does not exist in lookup %v", outputField.LookupField, lookupReq.Filename)
+		}
+		outputColIndices[i] = index
+		outputCols[i] = outputField.EventField
+	}
+
+	table := make(map[string]map[string]interface{}, len(rows))
+	keyParts := make([]string, len(matchColIndices))
+	for _, row := range rows {
+		for i, index := range matchColIndices {
+			keyParts[i] = row[index]
+		}
+		key := strings.Join(keyParts, joinKeySeparator)
+		if _, exists := table[key]; exists {
+			continue
+		}
+
+		outputValues := make(map[string]interface{}, len(outputColIndices))
+		for i, index := range outputColIndices {
+			outputValues[outputCols[i]] = row[index]
+		}
+		table[key] = outputValues
+	}
+
+	lookupReq.Table = table
+	lookupReq.OutputCols = outputCols
+	return nil
+}
+
+func getLookupMatch(lookupReq *structs.LookupRequest, getValue func(field string) (interface{}, bool)) (map[string]interface{}, bool) {
+	eventFields := make([]string, len(lookupReq.MatchFields))
+	for i, matchField := range lookupReq.MatchFields {
+		eventFields[i] = matchField.EventField
+	}
+
+	key, ok := getJoinKey(eventFields, getValue)
+	if !ok {
+		return nil, false
+	}
+
+	outputValues, ok := lookupReq.Table[key]
+	return outputValues, ok
+}
+
+func performLookupRequestWithoutGroupby(lookupReq *structs.LookupRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) {
+	for _, record := range recs {
+		outputValues, ok := getLookupMatch(lookupReq, func(field string) (interface{}, bool) {
+			value, exists := record[field]
+			return value, exists
+		})
+		if !ok {
+			continue
+		}
+
+		for col, value := range outputValues {
+			if lookupReq.OutputNew && record[col] != nil {
+				continue
+			}
+			record[col] = value
+		}
+	}
+
+	for _, col := range lookupReq.OutputCols {
+		finalCols[col] = true
+	}
+}
+
+func performLookupRequestOnHistogram(nodeResult *structs.NodeResult, lookupReq *structs.LookupRequest) {
+	for _, aggregationResult := range nodeResult.Histogram {
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			outputValues, ok := getLookupMatch(lookupReq, func(field string) (interface{}, bool) {
+				return getAggregationResultCell(aggregationResult, rowIndex, field)
+			})
+			if !ok {
+				continue
+			}
+
+			for col, value := range outputValues {
+				// A group by column always has a value, so OUTPUTNEW keeps it.
+				if utils.SliceContainsString(bucketResult.GroupByKeys, col) {
+					if !lookupReq.OutputNew {
+						setBucketResultGroupByCell(bucketResult, col, fmt.Sprint(value))
+					}
+					continue
+				}
+				if _, exists := bucketResult.StatRes[col]; exists && lookupReq.OutputNew {
+					continue
+				}
+				bucketResult.StatRes[col] = segutils.CValueEnclosure{
+					Dtype: segutils.SS_DT_STRING,
+					CVal:  value,
+				}
+			}
+		}
+	}
+}
+
+func performLookupRequestOnMeasureResults(nodeResult *structs.NodeResult, lookupReq *structs.LookupRequest) {
+	if len(nodeResult.MeasureResults) == 0 {
+		return
+	}
+
+	groupByColIndex := make(map[string]int, len(nodeResult.GroupByCols))
+	for i, groupByCol := range nodeResult.GroupByCols {
+		groupByColIndex[groupByCol] = i
+	}
+
+	for _, col := range lookupReq.OutputCols {
+		if _, isGroupByCol := groupByColIndex[col]; isGroupByCol || utils.SliceContainsString(nodeResult.MeasureFunctions, col) {
+			continue
+		}
+		nodeResult.MeasureFunctions = append(nodeResult.MeasureFunctions, col)
+	}
+
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		outputValues, ok := getLookupMatch(lookupReq, func(field string) (interface{}, bool) {
+			return getMeasureResultsCell(nodeResult, rowIndex, field)
+		})
+		if !ok {
+			continue
+		}
+
+		for col, value := range outputValues {
+			// A group by column always has a value, so OUTPUTNEW keeps it.
+			if index, isGroupByCol := groupByColIndex[col]; isGroupByCol {
+				if !lookupReq.OutputNew {
+					bucketHolder.GroupByValues[index] = fmt.Sprint(value)
+				}
+				continue
+			}
+			if lookupReq.OutputNew && bucketHolder.MeasureVal[col] != nil {
+				continue
+			}
+			bucketHolder.MeasureVal[col] = value
+		}
+	}
+}
+
 func performRenameColRequest(nodeResult *structs.NodeResult, aggs *structs.QueryAggregators, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
 	finalCols map[string]bool) error {
 	//Without following group by
@@ -1866,47 +2495,99 @@ func performSortColRequestWithoutGroupby(nodeResult *structs.NodeResult, letColR
 	if letColReq.SortColRequest.NumProcessedSegments < numTotalSegments {
 		for k, v := range recs {
 			letColReq.SortColRequest.SortRecords[k] = v
+			letColReq.SortColRequest.SortRecordsBytes += getRecordSizeInBytes(v)
 			delete(recs, k)
 		}
 
+		// Once the buffered records exceed the memory budget, they are written to disk.
+		if limit.RequestSortMemory(letColReq.SortColRequest.SortRecordsBytes) != nil {
+			if err := spillSortRecords(letColReq.SortColRequest, recordIndexInFinal); err != nil {
+				removeSortSpillFiles(letColReq.SortColRequest)
+				return fmt.Errorf("performSortColRequestWithoutGroupby: %v", err)
+			}
+		}
+
 		return nil
 	}
 
 	for k, v := range letColReq.SortColRequest.SortRecords {
 		recs[k] = v
 	}
+	letColReq.SortColRequest.SortRecords = make(map[string]map[string]interface{}, 0)
+	letColReq.SortColRequest.SortRecordsBytes = 0
 
-	recKeys := make([]string, 0)
-	keyToSortByValues := make(map[string][]structs.SortValue, 0)
-	for recInden, record := range recs {
-		recKeys = append(recKeys, recInden)
-		sortValue := make([]structs.SortValue, len(letColReq.SortColRequest.SortEles))
-		for i, sortEle := range letColReq.SortColRequest.SortEles {
-			val, exists := record[sortEle.Field]
-			if !exists {
-				val = nil
-			}
-
-			sortValue[i].Val = fmt.Sprintf("%v", val)
-			sortValue[i].InterpretAs = sortEle.Op
+	sortedRecs := getSortedRecords(letColReq.SortColRequest, recs)
+	if len(letColReq.SortColRequest.SpillFiles) > 0 {
+		var err error
+		sortedRecs, err = mergeSortRuns(letColReq.SortColRequest, sortedRecs)
+		if err != nil {
+			return fmt.Errorf("performSortColRequestWithoutGroupby: %v", err)
 		}
-		keyToSortByValues[recInden] = sortValue
+	} else if letColReq.SortColRequest.Limit < uint64(len(sortedRecs)) {
+		sortedRecs = sortedRecs[:letColReq.SortColRequest.Limit]
 	}
 
-	// Sort the recKeys array to ensure that keys with higher priority appear first
-	sort.Slice(recKeys, func(i, j int) bool {
-		key1 := recKeys[i]
-		key2 := recKeys[j]
-		comparisonRes, err := structs.CompareSortValueSlices(keyToSortByValues[key1], keyToSortByValues[key2], letColReq.SortColRequest.SortAscending)
+	// Only the records within the limit are kept, in the sorted order.
+	for recInden := range recs {
+		delete(recs, recInden)
+		delete(recordIndexInFinal, recInden)
+	}
+	for index, sortedRec := range sortedRecs {
+		recs[sortedRec.Key] = sortedRec.Record
+		recordIndexInFinal[sortedRec.Key] = index
+	}
+
+	return nil
+}
+
+func performReverseRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, numTotalSegments uint64, finishesSegment bool) error {
+
+	reverseExpr := letColReq.ReverseRequest
+	if recs != nil {
+		if finishesSegment {
+			reverseExpr.NumProcessedSegments++
+		}
+
+		if reverseExpr.Records == nil {
+			reverseExpr.Records = make(map[string]map[string]interface{}, 0)
+		}
+
+		for recordKey, record := range recs {
+			reverseExpr.Records[recordKey] = record
+			delete(recs, recordKey)
+		}
+
+		if reverseExpr.NumProcessedSegments < numTotalSegments && nodeResult.RecsAggsProcessedSegments < numTotalSegments {
+			return nil
+		}
+
+		for recordKey, record := range reverseExpr.Records {
+			recs[recordKey] = record
+		}
+		reverseExpr.Records = nil
+
+		currentOrder, err := GetOrderedRecs(recs, recordIndexInFinal)
 		if err != nil {
-			return true
+			return fmt.Errorf("performReverseRequest: %v", err)
+		}
+		for index, recordKey := range currentOrder {
+			recordIndexInFinal[recordKey] = len(currentOrder) - 1 - index
 		}
-		return comparisonRes == -1
-	})
 
-	for index, recInden := range recKeys {
-		recordIndexInFinal[recInden] = index
+		return nil
+	}
+
+	for _, aggregationResult := range nodeResult.Histogram {
+		for i, j := 0, len(aggregationResult.Results)-1; i < j; i, j = i+1, j-1 {
+			aggregationResult.Results[i], aggregationResult.Results[j] = aggregationResult.Results[j], aggregationResult.Results[i]
+		}
+	}
+
+	for i, j := 0, len(nodeResult.MeasureResults)-1; i < j; i, j = i+1, j-1 {
+		nodeResult.MeasureResults[i], nodeResult.MeasureResults[j] = nodeResult.MeasureResults[j], nodeResult.MeasureResults[i]
 	}
+
 	return nil
 }
 
@@ -2731,6 +3412,18 @@ func performValueColRequest(nodeResult *structs.NodeResult, aggs *structs.QueryA
 	return nil
 }
 
+// The formatting is evaluated like eval; it only differs in running after the other commands.
+func performFieldFormatRequest(nodeResult *structs.NodeResult, aggs *structs.QueryAggregators, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
+	evalReq := &structs.LetColumnsRequest{
+		NewColName:      letColReq.NewColName,
+		ValueColRequest: letColReq.FieldFormatRequest.ValueExpr,
+	}
+	if err := performValueColRequest(nodeResult, aggs, evalReq, recs, finalCols); err != nil {
+		return fmt.Errorf("performFieldFormatRequest: %v", err)
+	}
+	return nil
+}
+
 // Get the float/numeric value from the record or fieldToValue map if possible
 // Should pass either record or fieldToValue
 func getFloatValForBin(fieldToValue map[string]segutils.CValueEnclosure, record map[string]interface{}, field string) (float64, error) {
@@ -3398,18 +4091,17 @@ func getRecordFieldValues(fieldToValue map[string]segutils.CValueEnclosure, fiel
 
 func performValueColRequestWithoutGroupBy(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{}, finalCols map[string]bool) error {
 	fieldsInExpr := letColReq.ValueColRequest.GetFields()
+	usesAllFields := len(fieldsInExpr) == 1 && fieldsInExpr[0] == "*"
 
-	if len(fieldsInExpr) == 1 && fieldsInExpr[0] == "*" {
-		fieldsInExpr = []string{}
-		for _, record := range recs {
+	for _, record := range recs {
+		// The records may not all have the same fields.
+		if usesAllFields {
+			fieldsInExpr = make([]string, 0, len(record))
 			for fieldName := range record {
 				fieldsInExpr = append(fieldsInExpr, fieldName)
 			}
-			break
 		}
-	}
 
-	for _, record := range recs {
 		fieldToValue := make(map[string]segutils.CValueEnclosure, 0)
 		err := getRecordFieldValues(fieldToValue, fieldsInExpr, record)
 		if err != nil {
@@ -3489,11 +4181,14 @@ func performValueColRequestOnHistogram(nodeResult *structs.NodeResult, letColReq
 	isGroupByCol := utils.SliceContainsString(nodeResult.GroupByCols, letColReq.NewColName)
 
 	// Setup a map from each of the fields used in this expression to its value for a certain row.
-	fieldsInExpr := letColReq.ValueColRequest.GetFields()
-	fieldToValue := make(map[string]segutils.CValueEnclosure, 0)
+	exprFields := letColReq.ValueColRequest.GetFields()
 
 	for _, aggregationResult := range nodeResult.Histogram {
 		for rowIndex, bucketResult := range aggregationResult.Results {
+			// The rows may not all have the same stats columns, so each row gets its own map.
+			fieldsInExpr := expandAllFieldsInExpr(exprFields, getBucketResultColumns(bucketResult), letColReq.NewColName)
+			fieldToValue := make(map[string]segutils.CValueEnclosure, len(fieldsInExpr))
+
 			// Get the values of all the necessary fields.
 			err := getAggregationResultFieldValues(fieldToValue, fieldsInExpr, aggregationResult, rowIndex)
 			if err != nil {
@@ -3628,11 +4323,14 @@ func performValueColRequestOnMeasureResults(nodeResult *structs.NodeResult, letC
 	}
 
 	// Setup a map from each of the fields used in this expression to its value for a certain row.
-	fieldsInExpr := letColReq.ValueColRequest.GetFields()
-	fieldToValue := make(map[string]segutils.CValueEnclosure, 0)
+	exprFields := letColReq.ValueColRequest.GetFields()
 
 	// Compute the value for each row.
 	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		// The rows may not all have a value for each measure column, so each row gets its own map.
+		fieldsInExpr := expandAllFieldsInExpr(exprFields, getMeasureResultsRowColumns(nodeResult, bucketHolder), letColReq.NewColName)
+		fieldToValue := make(map[string]segutils.CValueEnclosure, len(fieldsInExpr))
+
 		// Get the values of all the necessary fields.
 		err := getMeasureResultsFieldValues(fieldToValue, fieldsInExpr, nodeResult, rowIndex)
 		if err != nil {
@@ -3833,6 +4531,28 @@ func getAggregationResultGroupByCell(aggResult *structs.AggregationResult, rowIn
 	return "", false
 }
 
+func setBucketResultGroupByCell(bucketResult *structs.BucketResult, groupByCol string, value string) {
+	for keyIndex, groupByKey := range bucketResult.GroupByKeys {
+		if groupByCol != groupByKey {
+			continue
+		}
+
+		switch bucketKey := bucketResult.BucketKey.(type) {
+		case []string:
+			bucketKey[keyIndex] = value
+		case string:
+			if keyIndex != 0 {
+				log.Errorf("setBucketResultGroupByCell: expected keyIndex to be 0, not %v", keyIndex)
+				return
+			}
+			bucketResult.BucketKey = value
+		default:
+			log.Errorf("setBucketResultGroupByCell: bucket key has unexpected type: %T", bucketKey)
+		}
+		return
+	}
+}
+
 // Replaces values in `fieldToValue` for the specified `fields`, but doesn't
 // remove the extra entries in `fieldToValue`.
 func getMeasureResultsFieldValues(fieldToValue map[string]segutils.CValueEnclosure, fields []string,
@@ -22,16 +22,23 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/lookups"
+	"github.com/siglens/siglens/pkg/segment/memory"
 	"github.com/siglens/siglens/pkg/segment/structs"
 	"github.com/siglens/siglens/pkg/segment/utils"
+	"github.com/siglens/siglens/pkg/segment/writer/stats"
 	putils "github.com/siglens/siglens/pkg/utils"
 	"github.com/stretchr/testify/assert"
+	bbp "github.com/valyala/bytebufferpool"
 )
 
 type SimpleSearchExpr struct {
@@ -3453,3 +3460,2566 @@ func Test_PerformMVExpand(t *testing.T) {
 		}
 	}
 }
+
+func getJoinTestRecords() (map[string]map[string]interface{}, map[string]int) {
+	recs := map[string]map[string]interface{}{
+		"rec1": {"user_id": "u1", "action": "login"},
+		"rec2": {"user_id": "u2", "action": "logout"},
+		"rec3": {"user_id": "u3", "action": "login"},
+		"rec4": {"action": "login"},
+	}
+	recordIndexInFinal := map[string]int{
+		"rec1": 0,
+		"rec2": 1,
+		"rec3": 2,
+		"rec4": 3,
+	}
+
+	return recs, recordIndexInFinal
+}
+
+func getJoinTestRequest(joinType structs.JoinType, max uint64) *structs.LetColumnsRequest {
+	return &structs.LetColumnsRequest{
+		JoinRequest: &structs.JoinRequest{
+			JoinType:  joinType,
+			Max:       max,
+			FieldList: []string{"user_id"},
+			SubsearchRecords: []map[string]interface{}{
+				{"user_id": "u1", "total": uint64(10)},
+				{"user_id": "u1", "total": uint64(20)},
+				{"user_id": "u3", "total": uint64(30)},
+				{"total": uint64(40)},
+			},
+			SubsearchCols: []string{"user_id", "total"},
+		},
+	}
+}
+
+func Test_performJoinRequestWithoutGroupby_Inner(t *testing.T) {
+	recs, recordIndexInFinal := getJoinTestRecords()
+	finalCols := map[string]bool{"user_id": true, "action": true}
+	letColReq := getJoinTestRequest(structs.JoinInner, 1)
+
+	err := performJoinRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, map[string]interface{}{"user_id": "u1", "action": "login", "total": uint64(10)}, recs["rec1"])
+	assert.Equal(t, map[string]interface{}{"user_id": "u3", "action": "login", "total": uint64(30)}, recs["rec3"])
+	assert.Equal(t, map[string]int{"rec1": 0, "rec3": 1}, recordIndexInFinal)
+	assert.True(t, finalCols["total"])
+}
+
+func Test_performJoinRequestWithoutGroupby_Left(t *testing.T) {
+	recs, recordIndexInFinal := getJoinTestRecords()
+	finalCols := map[string]bool{"user_id": true, "action": true}
+	letColReq := getJoinTestRequest(structs.JoinLeft, 0)
+
+	err := performJoinRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 5, len(recs))
+	assert.Equal(t, uint64(10), recs["rec1"]["total"])
+	assert.Equal(t, uint64(20), recs["rec1_join_1"]["total"])
+	assert.Equal(t, "login", recs["rec1_join_1"]["action"])
+	assert.Equal(t, map[string]interface{}{"user_id": "u2", "action": "logout"}, recs["rec2"])
+	assert.Equal(t, uint64(30), recs["rec3"]["total"])
+	assert.Equal(t, map[string]interface{}{"action": "login"}, recs["rec4"])
+	assert.Equal(t, map[string]int{"rec1": 0, "rec1_join_1": 1, "rec2": 2, "rec3": 3, "rec4": 4}, recordIndexInFinal)
+}
+
+func Test_performJoinRequestWithoutGroupby_MultipleSegments(t *testing.T) {
+	recs, recordIndexInFinal := getJoinTestRecords()
+	finalCols := map[string]bool{"user_id": true, "action": true}
+	letColReq := getJoinTestRequest(structs.JoinInner, 1)
+
+	// The records are held back until all the segments are processed.
+	err := performJoinRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec5"] = map[string]interface{}{"user_id": "u3", "action": "logout"}
+	recordIndexInFinal["rec5"] = 4
+	err = performJoinRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, uint64(30), recs["rec5"]["total"])
+	assert.Equal(t, map[string]int{"rec1": 0, "rec3": 1, "rec5": 2}, recordIndexInFinal)
+}
+
+func Test_performJoinRequestOnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"user_id"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"u1"}, MeasureVal: map[string]interface{}{"count": uint64(3)}},
+			{GroupByValues: []string{"u2"}, MeasureVal: map[string]interface{}{"count": uint64(5)}},
+			{GroupByValues: []string{"u3"}, MeasureVal: map[string]interface{}{"count": uint64(7)}},
+		},
+	}
+	letColReq := getJoinTestRequest(structs.JoinInner, 1)
+
+	err := performJoinRequest(nodeResult, letColReq, nil, nil, nil, 1, false)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"count", "total"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 2, len(nodeResult.MeasureResults))
+	assert.Equal(t, []string{"u1"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count": uint64(3), "total": uint64(10)}, nodeResult.MeasureResults[0].MeasureVal)
+	assert.Equal(t, []string{"u3"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count": uint64(7), "total": uint64(30)}, nodeResult.MeasureResults[1].MeasureVal)
+}
+
+func writeTestLookupFile(t *testing.T, filename string, content string) {
+	config.SetDataPath(t.TempDir() + "/")
+	err := os.MkdirAll(config.GetLookupPath(), 0755)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(config.GetLookupPath(), filename), []byte(content), 0644)
+	assert.Nil(t, err)
+}
+
+func Test_performLookupRequestWithoutGroupby(t *testing.T) {
+	writeTestLookupFile(t, "owners.csv", "hostname,team,owner\nweb-1,payments,alice\nweb-2,search,bob\nweb-1,ignored,ignored\n")
+
+	recs := map[string]map[string]interface{}{
+		"rec1": {"host": "web-1", "team": "old"},
+		"rec2": {"host": "web-2"},
+		"rec3": {"host": "web-3", "team": "old"},
+		"rec4": {"status": uint64(200)},
+	}
+	finalCols := map[string]bool{"host": true, "team": true, "status": true}
+	letColReq := &structs.LetColumnsRequest{
+		LookupRequest: &structs.LookupRequest{
+			Filename:    "owners",
+			MatchFields: []*structs.LookupFieldMapping{{LookupField: "hostname", EventField: "host"}},
+		},
+	}
+
+	err := performLookupRequest(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]interface{}{"host": "web-1", "team": "payments", "owner": "alice"}, recs["rec1"])
+	assert.Equal(t, map[string]interface{}{"host": "web-2", "team": "search", "owner": "bob"}, recs["rec2"])
+	assert.Equal(t, map[string]interface{}{"host": "web-3", "team": "old"}, recs["rec3"])
+	assert.Equal(t, map[string]interface{}{"status": uint64(200)}, recs["rec4"])
+	assert.Equal(t, map[string]bool{"host": true, "team": true, "status": true, "owner": true}, finalCols)
+}
+
+func Test_performLookupRequestWithoutGroupby_OutputNew(t *testing.T) {
+	writeTestLookupFile(t, "status.csv", "code,description\n200,OK\n404,Not Found\n")
+
+	recs := map[string]map[string]interface{}{
+		"rec1": {"status": uint64(200)},
+		"rec2": {"status": uint64(404), "status_text": "missing"},
+	}
+	finalCols := map[string]bool{"status": true, "status_text": true}
+	letColReq := &structs.LetColumnsRequest{
+		LookupRequest: &structs.LookupRequest{
+			Filename:     "status.csv",
+			MatchFields:  []*structs.LookupFieldMapping{{LookupField: "code", EventField: "status"}},
+			OutputFields: []*structs.LookupFieldMapping{{LookupField: "description", EventField: "status_text"}},
+			OutputNew:    true,
+		},
+	}
+
+	err := performLookupRequest(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "OK", recs["rec1"]["status_text"])
+	assert.Equal(t, "missing", recs["rec2"]["status_text"])
+}
+
+func Test_performLookupRequest_InvalidField(t *testing.T) {
+	writeTestLookupFile(t, "owners.csv", "hostname,team\nweb-1,payments\n")
+
+	letColReq := &structs.LetColumnsRequest{
+		LookupRequest: &structs.LookupRequest{
+			Filename:     "owners.csv",
+			MatchFields:  []*structs.LookupFieldMapping{{LookupField: "hostname", EventField: "host"}},
+			OutputFields: []*structs.LookupFieldMapping{{LookupField: "owner", EventField: "owner"}},
+		},
+	}
+
+	err := performLookupRequest(&structs.NodeResult{}, letColReq, map[string]map[string]interface{}{}, map[string]bool{})
+	assert.NotNil(t, err)
+}
+
+func Test_performLookupRequestOnMeasureResults(t *testing.T) {
+	writeTestLookupFile(t, "owners.csv", "host,team\nweb-1,payments\nweb-2,search\n")
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web-1"}, MeasureVal: map[string]interface{}{"count": uint64(3)}},
+			{GroupByValues: []string{"web-3"}, MeasureVal: map[string]interface{}{"count": uint64(5)}},
+		},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		LookupRequest: &structs.LookupRequest{
+			Filename:    "owners.csv",
+			MatchFields: []*structs.LookupFieldMapping{{LookupField: "host", EventField: "host"}},
+		},
+	}
+
+	err := performLookupRequest(nodeResult, letColReq, nil, nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"count", "team"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, map[string]interface{}{"count": uint64(3), "team": "payments"}, nodeResult.MeasureResults[0].MeasureVal)
+	assert.Equal(t, map[string]interface{}{"count": uint64(5)}, nodeResult.MeasureResults[1].MeasureVal)
+}
+
+// An OUTPUT field that is also a group by column replaces its value, and OUTPUTNEW keeps it.
+func Test_performLookupRequest_GroupByOutputField(t *testing.T) {
+	writeTestLookupFile(t, "codes.csv", "code,status\n200,200 OK\n404,404 Not Found\n")
+
+	getLetColReq := func(outputNew bool) *structs.LetColumnsRequest {
+		return &structs.LetColumnsRequest{
+			LookupRequest: &structs.LookupRequest{
+				Filename:     "codes.csv",
+				MatchFields:  []*structs.LookupFieldMapping{{LookupField: "code", EventField: "status"}},
+				OutputFields: []*structs.LookupFieldMapping{{LookupField: "status", EventField: "status"}},
+				OutputNew:    outputNew,
+			},
+		}
+	}
+	getHistogramNodeResult := func() *structs.NodeResult {
+		return &structs.NodeResult{
+			Histogram: map[string]*structs.AggregationResult{
+				"status": {
+					Results: []*structs.BucketResult{
+						{
+							BucketKey:   []string{"200", "web"},
+							GroupByKeys: []string{"status", "host"},
+							StatRes:     map[string]utils.CValueEnclosure{"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(3)}},
+						},
+					},
+				},
+			},
+		}
+	}
+	getMeasureResultsNodeResult := func() *structs.NodeResult {
+		return &structs.NodeResult{
+			GroupByCols:      []string{"status"},
+			MeasureFunctions: []string{"count(*)"},
+			MeasureResults: []*structs.BucketHolder{
+				{GroupByValues: []string{"404"}, MeasureVal: map[string]interface{}{"count(*)": uint64(2)}},
+			},
+		}
+	}
+
+	nodeResult := getHistogramNodeResult()
+	err := performLookupRequest(nodeResult, getLetColReq(false), nil, nil)
+	assert.Nil(t, err)
+	bucketResult := nodeResult.Histogram["status"].Results[0]
+	assert.Equal(t, []string{"200 OK", "web"}, bucketResult.BucketKey)
+	assert.NotContains(t, bucketResult.StatRes, "status")
+
+	nodeResult = getHistogramNodeResult()
+	err = performLookupRequest(nodeResult, getLetColReq(true), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"200", "web"}, nodeResult.Histogram["status"].Results[0].BucketKey)
+
+	nodeResult = getMeasureResultsNodeResult()
+	err = performLookupRequest(nodeResult, getLetColReq(false), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"404 Not Found"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, []string{"count(*)"}, nodeResult.MeasureFunctions)
+
+	nodeResult = getMeasureResultsNodeResult()
+	err = performLookupRequest(nodeResult, getLetColReq(true), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"404"}, nodeResult.MeasureResults[0].GroupByValues)
+}
+
+// The values of the lookup table are converted to text for the group by columns.
+func Test_performLookupRequestOnMeasureResults_NonStringValue(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web"}, MeasureVal: map[string]interface{}{"count(*)": uint64(2)}},
+		},
+	}
+	lookupReq := &structs.LookupRequest{
+		MatchFields: []*structs.LookupFieldMapping{{LookupField: "host", EventField: "host"}},
+		Table:       map[string]map[string]interface{}{"web": {"host": int64(7)}},
+		OutputCols:  []string{"host"},
+	}
+
+	performLookupRequestOnMeasureResults(nodeResult, lookupReq)
+	assert.Equal(t, []string{"7"}, nodeResult.MeasureResults[0].GroupByValues)
+}
+
+func Test_PerformEventStats(t *testing.T) {
+	agg := &structs.QueryAggregators{
+		GroupByRequest: &structs.GroupByRequest{
+			GroupByColumns: []string{"host"},
+			MeasureOperations: []*structs.MeasureAggregator{
+				{MeasureCol: "*", MeasureFunc: utils.Count},
+				{MeasureCol: "latency", MeasureFunc: utils.Avg},
+				{MeasureCol: "latency", MeasureFunc: utils.Max},
+			},
+		},
+		EventStatsOptions: &structs.EventStatsOptions{},
+	}
+
+	finalCols := map[string]bool{"host": true, "latency": true}
+
+	// The stats are only added once all the segments are processed.
+	recs := map[string]map[string]interface{}{
+		"rec1": {"host": "web-1", "latency": uint64(10)},
+		"rec2": {"host": "web-2", "latency": uint64(5)},
+	}
+	err := PerformEventStats(&structs.NodeResult{}, agg, recs, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec3"] = map[string]interface{}{"host": "web-1", "latency": uint64(30)}
+	recs["rec4"] = map[string]interface{}{"host": "web-1"}
+	recs["rec5"] = map[string]interface{}{"latency": uint64(100)}
+	err = PerformEventStats(&structs.NodeResult{}, agg, recs, finalCols, 2, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 5, len(recs))
+	for _, recordKey := range []string{"rec1", "rec3", "rec4"} {
+		assert.Equal(t, 3.0, recs[recordKey]["count(*)"])
+		assert.Equal(t, 20.0, recs[recordKey]["avg(latency)"])
+		assert.Equal(t, 30.0, recs[recordKey]["max(latency)"])
+	}
+	assert.Equal(t, 1.0, recs["rec2"]["count(*)"])
+	assert.Equal(t, 5.0, recs["rec2"]["avg(latency)"])
+	assert.Equal(t, 5.0, recs["rec2"]["max(latency)"])
+	assert.Equal(t, map[string]interface{}{"latency": uint64(100)}, recs["rec5"])
+
+	assert.True(t, finalCols["count(*)"])
+	assert.True(t, finalCols["avg(latency)"])
+	assert.True(t, finalCols["max(latency)"])
+}
+
+func Test_PerformEventStats_OnMeasureResults(t *testing.T) {
+	agg := &structs.QueryAggregators{
+		MeasureOperations: []*structs.MeasureAggregator{
+			{MeasureCol: "count", MeasureFunc: utils.Sum},
+		},
+		EventStatsOptions: &structs.EventStatsOptions{},
+	}
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web-1"}, MeasureVal: map[string]interface{}{"count": uint64(3)}},
+			{GroupByValues: []string{"web-2"}, MeasureVal: map[string]interface{}{"count": uint64(7)}},
+		},
+	}
+
+	err := PerformEventStats(nodeResult, agg, nil, nil, 1, false)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"count", "sum(count)"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 10.0, nodeResult.MeasureResults[0].MeasureVal["sum(count)"])
+	assert.Equal(t, 10.0, nodeResult.MeasureResults[1].MeasureVal["sum(count)"])
+}
+
+func Test_PerformEventStats_UnsupportedFunction(t *testing.T) {
+	agg := &structs.QueryAggregators{
+		MeasureOperations: []*structs.MeasureAggregator{
+			{MeasureCol: "latency", MeasureFunc: utils.Perc},
+		},
+		EventStatsOptions: &structs.EventStatsOptions{},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"latency": uint64(10)},
+	}
+
+	err := PerformEventStats(&structs.NodeResult{}, agg, recs, map[string]bool{}, 1, true)
+	assert.NotNil(t, err)
+}
+
+func getTestChartRequest(limitExpr *structs.LimitExpr, tcOptions *structs.TcOptions) *structs.LetColumnsRequest {
+	measureOperations := []*structs.MeasureAggregator{
+		{MeasureCol: "*", MeasureFunc: utils.Count},
+	}
+	return &structs.LetColumnsRequest{
+		ChartRequest: &structs.ChartRequest{
+			OverField: "host",
+			Timechart: InitChartExpr("status", limitExpr, tcOptions, measureOperations),
+		},
+	}
+}
+
+func Test_performChartRequest_OnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host", "status"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web-1", "200"}, MeasureVal: map[string]interface{}{"count(*)": uint64(5)}},
+			{GroupByValues: []string{"web-1", "404"}, MeasureVal: map[string]interface{}{"count(*)": uint64(2)}},
+			{GroupByValues: []string{"web-2", "200"}, MeasureVal: map[string]interface{}{"count(*)": uint64(7)}},
+			{GroupByValues: []string{"web-2", "500"}, MeasureVal: map[string]interface{}{"count(*)": uint64(1)}},
+			{GroupByValues: []string{"web-2", ""}, MeasureVal: map[string]interface{}{"count(*)": uint64(3)}},
+		},
+	}
+
+	letColReq := getTestChartRequest(&structs.LimitExpr{IsTop: true, Num: 2}, nil)
+	err := performChartRequest(nodeResult, letColReq, nil, nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"host"}, nodeResult.GroupByCols)
+	assert.Equal(t, []string{"count(*): 200", "count(*): null", "count(*): other"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 2, len(nodeResult.MeasureResults))
+
+	assert.Equal(t, []string{"web-1"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count(*): 200": 5.0, "count(*): other": 2.0}, nodeResult.MeasureResults[0].MeasureVal)
+
+	assert.Equal(t, []string{"web-2"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count(*): 200": 7.0, "count(*): other": 1.0, "count(*): null": 3.0}, nodeResult.MeasureResults[1].MeasureVal)
+}
+
+func Test_performChartRequest_OnHistogram(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"test": {
+				Results: []*structs.BucketResult{
+					{
+						ElemCount:   5,
+						BucketKey:   []string{"web-1", "200"},
+						GroupByKeys: []string{"host", "status"},
+						StatRes:     map[string]utils.CValueEnclosure{"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(5)}},
+					},
+					{
+						ElemCount:   2,
+						BucketKey:   []string{"web-1", "404"},
+						GroupByKeys: []string{"host", "status"},
+						StatRes:     map[string]utils.CValueEnclosure{"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(2)}},
+					},
+					{
+						ElemCount:   3,
+						BucketKey:   []string{"web-2", ""},
+						GroupByKeys: []string{"host", "status"},
+						StatRes:     map[string]utils.CValueEnclosure{"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(3)}},
+					},
+				},
+			},
+		},
+	}
+
+	tcOptions := &structs.TcOptions{UseNull: false, UseOther: false, NullStr: "null", OtherStr: "other"}
+	letColReq := getTestChartRequest(&structs.LimitExpr{IsTop: true, Num: 1}, tcOptions)
+	err := performChartRequest(nodeResult, letColReq, nil, nil)
+	assert.Nil(t, err)
+
+	results := nodeResult.Histogram["test"].Results
+	assert.Equal(t, 2, len(results))
+
+	assert.Equal(t, "web-1", results[0].BucketKey)
+	assert.Equal(t, []string{"host"}, results[0].GroupByKeys)
+	assert.Equal(t, uint64(7), results[0].ElemCount)
+	assert.Equal(t, map[string]utils.CValueEnclosure{
+		"count(*): 200": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(5)},
+	}, results[0].StatRes)
+
+	// The null status is dropped, but web-2 is still a row of the chart.
+	assert.Equal(t, "web-2", results[1].BucketKey)
+	assert.Equal(t, 0, len(results[1].StatRes))
+}
+
+func Test_performChartRequestWithoutGroupby(t *testing.T) {
+	recs := map[string]map[string]interface{}{
+		"rec1": {"host": "web-1", "status": "200", "count(*)": uint64(5)},
+		"rec2": {"host": "web-1", "status": "404", "count(*)": uint64(2)},
+		"rec3": {"host": "web-2", "status": "200", "count(*)": uint64(7)},
+	}
+	finalCols := map[string]bool{"host": true, "status": true, "count(*)": true}
+
+	letColReq := getTestChartRequest(&structs.LimitExpr{IsTop: true, Num: 0}, nil)
+	err := performChartRequest(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{"host": true, "count(*): 200": true, "count(*): 404": true}, finalCols)
+	assert.Equal(t, map[string]map[string]interface{}{
+		"rec1": {"host": "web-1", "count(*): 200": 5.0, "count(*): 404": 2.0},
+		"rec3": {"host": "web-2", "count(*): 200": 7.0},
+	}, recs)
+}
+
+func getTestReshapeMeasureResults() *structs.NodeResult {
+	return &structs.NodeResult{
+		GroupByCols:      []string{"host", "status"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web-1", "200"}, MeasureVal: map[string]interface{}{"count": uint64(5)}},
+			{GroupByValues: []string{"web-1", "404"}, MeasureVal: map[string]interface{}{"count": uint64(2)}},
+			{GroupByValues: []string{"web-2", "200"}, MeasureVal: map[string]interface{}{"count": uint64(7)}},
+		},
+	}
+}
+
+func Test_performReshapeRequest_XYSeries(t *testing.T) {
+	nodeResult := getTestReshapeMeasureResults()
+	letColReq := &structs.LetColumnsRequest{
+		ReshapeRequest: &structs.ReshapeRequest{
+			ReshapeType: structs.ReshapeXYSeries,
+			XField:      "host",
+			YNameField:  "status",
+			YDataFields: []string{"count"},
+			Sep:         ": ",
+		},
+	}
+	err := performReshapeRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"host"}, nodeResult.GroupByCols)
+	assert.Equal(t, []string{"200", "404"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, map[string]int{"host": 0, "200": 1, "404": 2}, nodeResult.ColumnsOrder)
+	assert.Equal(t, 2, nodeResult.BucketCount)
+	assert.Equal(t, []string{"web-1"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"200": uint64(5), "404": uint64(2)}, nodeResult.MeasureResults[0].MeasureVal)
+	assert.Equal(t, []string{"web-2"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"200": uint64(7)}, nodeResult.MeasureResults[1].MeasureVal)
+}
+
+func Test_performReshapeRequest_Untable(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"200", "404"},
+		ColumnsOrder:     map[string]int{"host": 0, "200": 1, "404": 2},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web-1"}, MeasureVal: map[string]interface{}{"200": uint64(5), "404": uint64(2)}},
+			{GroupByValues: []string{"web-2"}, MeasureVal: map[string]interface{}{"200": uint64(7)}},
+		},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		ReshapeRequest: &structs.ReshapeRequest{
+			ReshapeType: structs.ReshapeUntable,
+			XField:      "host",
+			YNameField:  "status",
+			YDataFields: []string{"count"},
+		},
+	}
+	err := performReshapeRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"host", "status"}, nodeResult.GroupByCols)
+	assert.Equal(t, []string{"count"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 3, len(nodeResult.MeasureResults))
+	assert.Equal(t, []string{"web-1", "200"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count": uint64(5)}, nodeResult.MeasureResults[0].MeasureVal)
+	assert.Equal(t, []string{"web-1", "404"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, []string{"web-2", "200"}, nodeResult.MeasureResults[2].GroupByValues)
+}
+
+func Test_performReshapeRequest_TransposeOnHistogram(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"test": {
+				Results: []*structs.BucketResult{
+					{
+						BucketKey:   "web-1",
+						GroupByKeys: []string{"host"},
+						StatRes:     map[string]utils.CValueEnclosure{"count": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(5)}},
+					},
+					{
+						BucketKey:   "web-2",
+						GroupByKeys: []string{"host"},
+						StatRes:     map[string]utils.CValueEnclosure{"count": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(7)}},
+					},
+				},
+			},
+		},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		ReshapeRequest: &structs.ReshapeRequest{
+			ReshapeType:  structs.ReshapeTranspose,
+			NumRows:      5,
+			ColumnName:   "column",
+			IncludeEmpty: true,
+		},
+	}
+	err := performReshapeRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"column"}, nodeResult.GroupByCols)
+	assert.Equal(t, map[string]int{"column": 0, "row 1": 1, "row 2": 2}, nodeResult.ColumnsOrder)
+	results := nodeResult.Histogram["test"].Results
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "host", results[0].BucketKey)
+	assert.Equal(t, map[string]utils.CValueEnclosure{
+		"row 1": {Dtype: utils.SS_DT_STRING, CVal: "web-1"},
+		"row 2": {Dtype: utils.SS_DT_STRING, CVal: "web-2"},
+	}, results[0].StatRes)
+	assert.Equal(t, "count", results[1].BucketKey)
+	assert.Equal(t, []string{"column"}, results[1].GroupByKeys)
+}
+
+func Test_performReshapeRequestWithoutGroupby(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		ReshapeRequest: &structs.ReshapeRequest{
+			ReshapeType:  structs.ReshapeTranspose,
+			NumRows:      1,
+			ColumnName:   "column",
+			HeaderField:  "host",
+			IncludeEmpty: false,
+		},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"host": "web-1", "bytes": 10, "status": ""},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 0}
+	finalCols := map[string]bool{"host": true, "bytes": true, "status": true}
+
+	// The records are held back until the last segment is processed.
+	err := performReshapeRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec2"] = map[string]interface{}{"host": "web-2", "bytes": 20, "status": "200"}
+	recordIndexInFinal["rec2"] = 1
+	err = performReshapeRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{"column": true, "web-1": true}, finalCols)
+	assert.Equal(t, map[string]map[string]interface{}{
+		"reshape_0": {"column": "bytes", "web-1": 10},
+	}, recs)
+	assert.Equal(t, map[string]int{"reshape_0": 0}, recordIndexInFinal)
+}
+
+func Test_PerformMakeResults(t *testing.T) {
+	aggs := &structs.QueryAggregators{
+		PipeCommandType: structs.GenerateEventType,
+		GenerateEvent: &structs.GenerateEvent{
+			MakeResults: &structs.MakeResults{Count: 3, Annotate: true},
+		},
+	}
+	startTime := uint64(time.Now().UnixMilli())
+	err := PerformMakeResults(aggs)
+	assert.Nil(t, err)
+
+	timestampKey := config.GetTimeStampKey()
+	assert.Equal(t, map[string]bool{timestampKey: true, "_raw": true, "host": true, "source": true, "sourcetype": true}, aggs.GenerateEvent.GeneratedCols)
+	assert.Equal(t, 3, len(aggs.GenerateEvent.GeneratedRecords))
+	assert.Equal(t, map[string]int{"0_0": 0, "0_1": 1, "0_2": 2}, aggs.GenerateEvent.GeneratedRecordsIndex)
+	for _, record := range aggs.GenerateEvent.GeneratedRecords {
+		assert.GreaterOrEqual(t, record[timestampKey].(uint64), startTime)
+		assert.Equal(t, "", record["host"])
+	}
+
+	recs := make(map[string]map[string]interface{})
+	recordIndexInFinal := make(map[string]int)
+	finalCols := make(map[string]bool)
+	err = performGenEvent(&structs.NodeResult{}, aggs, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, aggs.GenerateEvent.GeneratedCols, finalCols)
+}
+
+func Test_PerformMakeResults_NoAnnotate(t *testing.T) {
+	aggs := &structs.QueryAggregators{
+		PipeCommandType: structs.GenerateEventType,
+		GenerateEvent: &structs.GenerateEvent{
+			MakeResults: &structs.MakeResults{Count: 1},
+		},
+	}
+	err := PerformMakeResults(aggs)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{config.GetTimeStampKey(): true}, aggs.GenerateEvent.GeneratedCols)
+	assert.Equal(t, 1, len(aggs.GenerateEvent.GeneratedRecords))
+	assert.Equal(t, 1, len(aggs.GenerateEvent.GeneratedRecords["0_0"]))
+}
+
+func Test_PerformMakeResults_MaxCount(t *testing.T) {
+	config.SetMaxMakeResultsCount(10)
+	defer config.SetMaxMakeResultsCount(config.DEFAULT_MAX_MAKE_RESULTS_COUNT)
+
+	aggs := &structs.QueryAggregators{
+		PipeCommandType: structs.GenerateEventType,
+		GenerateEvent: &structs.GenerateEvent{
+			MakeResults: &structs.MakeResults{Count: 11},
+		},
+	}
+	err := PerformMakeResults(aggs)
+	assert.NotNil(t, err)
+
+	aggs.GenerateEvent.MakeResults.Count = 10
+	err = PerformMakeResults(aggs)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(aggs.GenerateEvent.GeneratedRecords))
+}
+
+func readTestLookupFile(t *testing.T, filename string) string {
+	content, err := os.ReadFile(filepath.Join(config.GetLookupPath(), filename))
+	assert.Nil(t, err)
+	return string(content)
+}
+
+func Test_performOutputLookupRequestWithoutGroupby(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+
+	letColReq := &structs.LetColumnsRequest{
+		OutputLookupRequest: &structs.OutputLookupRequest{Filename: "blocked", CreateEmpty: true},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"ip": "10.0.0.1", "count": 5.0},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 0, "rec2": 1}
+	finalCols := map[string]bool{"ip": true, "count": true}
+
+	// The records are held back until the last segment is processed.
+	err := performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec2"] = map[string]interface{}{"ip": "10.0.0.2", "count": 1.5}
+	err = performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+
+	// The records are passed on unchanged.
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, map[string]interface{}{"ip": "10.0.0.1", "count": 5.0}, recs["rec1"])
+	assert.Equal(t, "count,ip\n5,10.0.0.1\n1.5,10.0.0.2\n", readTestLookupFile(t, "blocked.csv"))
+
+	// The written file can be read back as a lookup.
+	columnNames, rows, err := lookups.GetLookupTable("blocked")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"count", "ip"}, columnNames)
+	assert.Equal(t, [][]string{{"5", "10.0.0.1"}, {"1.5", "10.0.0.2"}}, rows)
+
+	// Appending keeps the existing rows and adds the new columns.
+	letColReq.OutputLookupRequest.Append = true
+	recs = map[string]map[string]interface{}{
+		"rec3": {"ip": "10.0.0.3", "host": "web-1"},
+	}
+	err = performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{"rec3": 0}, map[string]bool{"ip": true, "host": true}, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "count,ip,host\n5,10.0.0.1,\n1.5,10.0.0.2,\n,10.0.0.3,web-1\n", readTestLookupFile(t, "blocked.csv"))
+	columnNames, rows, err = lookups.GetLookupTable("blocked.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"count", "ip", "host"}, columnNames)
+	assert.Equal(t, 3, len(rows))
+}
+
+func Test_performOutputLookupRequest_OnMeasureResults(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+
+	nodeResult := getTestReshapeMeasureResults()
+	letColReq := &structs.LetColumnsRequest{
+		OutputLookupRequest: &structs.OutputLookupRequest{Filename: "counts.csv.gz", CreateEmpty: true},
+	}
+	err := performOutputLookupRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(nodeResult.MeasureResults))
+
+	columnNames, rows, err := lookups.GetLookupTable("counts")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"host", "status", "count"}, columnNames)
+	assert.Equal(t, [][]string{{"web-1", "200", "5"}, {"web-1", "404", "2"}, {"web-2", "200", "7"}}, rows)
+}
+
+func Test_performOutputLookupRequest_CreateEmpty(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+
+	letColReq := &structs.LetColumnsRequest{
+		OutputLookupRequest: &structs.OutputLookupRequest{Filename: "empty.csv", CreateEmpty: false},
+	}
+	recs := map[string]map[string]interface{}{}
+	err := performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{}, map[string]bool{}, 1, true)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(config.GetLookupPath(), "empty.csv"))
+	assert.True(t, os.IsNotExist(err))
+
+	letColReq.OutputLookupRequest.CreateEmpty = true
+	err = performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{}, map[string]bool{}, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "", readTestLookupFile(t, "empty.csv"))
+
+	letColReq.OutputLookupRequest.Filename = "../empty.csv"
+	err = performOutputLookupRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{}, map[string]bool{}, 1, true)
+	assert.NotNil(t, err)
+}
+
+func Test_performAddTotalsRequestWithoutGroupby(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		AddTotalsRequest: &structs.AddTotalsRequest{Row: true, FieldName: "Total", Label: "Total"},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"customer": "acme", "cost": uint64(10), "tax": "2.5", "_raw": "7"},
+		"rec2": {"customer": "globex", "cost": 4.5, "tax": nil},
+		"rec3": {"customer": "initech"},
+	}
+	finalCols := map[string]bool{"customer": true, "cost": true, "tax": true, "_raw": true}
+
+	// The row totals do not hold back the records.
+	err := performAddTotalsRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{}, finalCols, 2, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 12.5, recs["rec1"]["Total"])
+	assert.Equal(t, 4.5, recs["rec2"]["Total"])
+	assert.NotContains(t, recs["rec3"], "Total")
+	assert.True(t, finalCols["Total"])
+}
+
+func Test_performAddTotalsRequestWithoutGroupby_ColTotals(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		AddTotalsRequest: &structs.AddTotalsRequest{Row: true, Col: true, FieldName: "Total", LabelField: "customer", Label: "All", FieldList: []string{"c*"}},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"customer": "acme", "cost": uint64(10), "count": 1, "tax": 2.5},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 0, "rec2": 1}
+	finalCols := map[string]bool{"customer": true, "cost": true, "count": true, "tax": true}
+
+	// The records are held back until the last segment is processed.
+	err := performAddTotalsRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec2"] = map[string]interface{}{"customer": "globex", "cost": 4.5, "count": 2, "tax": 1.0}
+	err = performAddTotalsRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, 11.0, recs["rec1"]["Total"])
+	assert.Equal(t, 6.5, recs["rec2"]["Total"])
+	assert.Equal(t, map[string]interface{}{"customer": "All", "cost": 14.5, "count": 3.0, "Total": 17.5}, recs[addTotalsSummaryRecordKey])
+	assert.Equal(t, 2, recordIndexInFinal[addTotalsSummaryRecordKey])
+	assert.True(t, finalCols["Total"])
+}
+
+func Test_performAddTotalsRequest_OnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"customer"},
+		MeasureFunctions: []string{"cost", "tax"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"acme"}, MeasureVal: map[string]interface{}{"cost": 10.0, "tax": 2.0}},
+			{GroupByValues: []string{"globex"}, MeasureVal: map[string]interface{}{"cost": 5.0, "tax": 1.0}},
+		},
+		ColumnsOrder: map[string]int{"customer": 0, "cost": 1, "tax": 2},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		AddTotalsRequest: &structs.AddTotalsRequest{Row: true, Col: true, FieldName: "Total", LabelField: "customer", Label: "Total"},
+	}
+	err := performAddTotalsRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"cost", "tax", "Total"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, map[string]int{"customer": 0, "cost": 1, "tax": 2, "Total": 3}, nodeResult.ColumnsOrder)
+	assert.Equal(t, 3, len(nodeResult.MeasureResults))
+	assert.Equal(t, 3, nodeResult.BucketCount)
+	assert.Equal(t, 12.0, nodeResult.MeasureResults[0].MeasureVal["Total"])
+	assert.Equal(t, 6.0, nodeResult.MeasureResults[1].MeasureVal["Total"])
+	assert.Equal(t, []string{"Total"}, nodeResult.MeasureResults[2].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"cost": 15.0, "tax": 3.0, "Total": 18.0}, nodeResult.MeasureResults[2].MeasureVal)
+}
+
+func Test_performAddTotalsRequest_OnHistogram(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"test": {
+				Results: []*structs.BucketResult{
+					{
+						BucketKey:   "acme",
+						GroupByKeys: []string{"customer"},
+						StatRes: map[string]utils.CValueEnclosure{
+							"cost": {Dtype: utils.SS_DT_FLOAT, CVal: 10.0},
+							"tax":  {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(2)},
+						},
+					},
+					{
+						BucketKey:   "globex",
+						GroupByKeys: []string{"customer"},
+						StatRes: map[string]utils.CValueEnclosure{
+							"cost": {Dtype: utils.SS_DT_FLOAT, CVal: 5.0},
+						},
+					},
+				},
+			},
+		},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		AddTotalsRequest: &structs.AddTotalsRequest{Row: false, Col: true, LabelField: "label", Label: "Total"},
+	}
+	err := performAddTotalsRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+
+	results := nodeResult.Histogram["test"].Results
+	assert.Equal(t, 3, len(results))
+	assert.NotContains(t, results[0].StatRes, "Total")
+	assert.Equal(t, "", results[2].BucketKey)
+	assert.Equal(t, []string{"customer"}, results[2].GroupByKeys)
+	assert.Equal(t, map[string]utils.CValueEnclosure{
+		"cost":  {Dtype: utils.SS_DT_FLOAT, CVal: 15.0},
+		"tax":   {Dtype: utils.SS_DT_FLOAT, CVal: 2.0},
+		"label": {Dtype: utils.SS_DT_STRING, CVal: "Total"},
+	}, results[2].StatRes)
+}
+
+func Test_performSequentialRequest_DeltaAcrossSegments(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		SequentialRequest: &structs.SequentialRequest{CmdType: structs.SequentialDelta, Field: "bytes", NewField: "delta(bytes)", PStart: 1, PEnd: 1},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 1, "rec2": 0, "rec3": 2, "rec4": 0, "rec5": 1}
+	finalCols := map[string]bool{"bytes": true}
+
+	// The records are processed in the order of the results once the segment is finished.
+	recs := map[string]map[string]interface{}{
+		"rec1": {"bytes": 15},
+	}
+	err := performSequentialRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec2"] = map[string]interface{}{"bytes": 10}
+	recs["rec3"] = map[string]interface{}{"bytes": "abc"}
+	err = performSequentialRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(recs))
+	assert.NotContains(t, recs["rec2"], "delta(bytes)")
+	assert.Equal(t, 5.0, recs["rec1"]["delta(bytes)"])
+	assert.NotContains(t, recs["rec3"], "delta(bytes)")
+	assert.True(t, finalCols["delta(bytes)"])
+
+	// The values of the previous segment are carried over.
+	recs = map[string]map[string]interface{}{
+		"rec4": {"bytes": 20},
+		"rec5": {"bytes": 22.5},
+	}
+	err = performSequentialRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, true)
+	assert.Nil(t, err)
+	assert.NotContains(t, recs["rec4"], "delta(bytes)")
+	assert.Equal(t, 2.5, recs["rec5"]["delta(bytes)"])
+}
+
+func Test_performSequentialRequest_AccumAndAutoregress(t *testing.T) {
+	accumReq := &structs.LetColumnsRequest{
+		SequentialRequest: &structs.SequentialRequest{CmdType: structs.SequentialAccum, Field: "bytes", NewField: "bytes"},
+	}
+	autoregressReq := &structs.LetColumnsRequest{
+		SequentialRequest: &structs.SequentialRequest{CmdType: structs.SequentialAutoregress, Field: "bytes", PStart: 1, PEnd: 2},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 0, "rec2": 1, "rec3": 2, "rec4": 3}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"bytes": 1},
+		"rec2": {"bytes": "2"},
+		"rec3": {},
+		"rec4": {"bytes": 4},
+	}
+	finalCols := map[string]bool{"bytes": true}
+
+	err := performSequentialRequest(&structs.NodeResult{}, autoregressReq, recs, recordIndexInFinal, finalCols, true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"bytes": 1}, recs["rec1"])
+	assert.Equal(t, map[string]interface{}{"bytes": "2", "bytes_p1": 1}, recs["rec2"])
+	assert.Equal(t, map[string]interface{}{"bytes_p1": "2", "bytes_p2": 1}, recs["rec3"])
+	assert.Equal(t, map[string]interface{}{"bytes": 4, "bytes_p2": "2"}, recs["rec4"])
+	assert.True(t, finalCols["bytes_p1"])
+	assert.True(t, finalCols["bytes_p2"])
+
+	err = performSequentialRequest(&structs.NodeResult{}, accumReq, recs, recordIndexInFinal, finalCols, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, recs["rec1"]["bytes"])
+	assert.Equal(t, 3.0, recs["rec2"]["bytes"])
+	assert.NotContains(t, recs["rec3"], "bytes")
+	assert.Equal(t, 7.0, recs["rec4"]["bytes"])
+}
+
+func Test_performSequentialRequest_OnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"a"}, MeasureVal: map[string]interface{}{"count": uint64(3)}},
+			{GroupByValues: []string{"b"}, MeasureVal: map[string]interface{}{"count": uint64(5)}},
+			{GroupByValues: []string{"c"}, MeasureVal: map[string]interface{}{"count": uint64(4)}},
+		},
+		ColumnsOrder: map[string]int{"host": 0, "count": 1},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		SequentialRequest: &structs.SequentialRequest{CmdType: structs.SequentialDelta, Field: "count", NewField: "change", PStart: 1, PEnd: 1},
+	}
+
+	err := performSequentialRequest(nodeResult, letColReq, nil, nil, nil, true)
+	assert.Nil(t, err)
+	assert.NotContains(t, nodeResult.MeasureResults[0].MeasureVal, "change")
+	assert.Equal(t, 2.0, nodeResult.MeasureResults[1].MeasureVal["change"])
+	assert.Equal(t, -1.0, nodeResult.MeasureResults[2].MeasureVal["change"])
+	assert.Equal(t, []string{"count", "change"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 2, nodeResult.ColumnsOrder["change"])
+}
+
+func Test_performSortColRequestWithoutGroupby_Limit(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		SortColRequest: &structs.SortExpr{
+			SortEles:      []*structs.SortElement{{SortByAsc: true, Op: "num", Field: "bytes"}},
+			SortAscending: []int{1},
+			Limit:         3,
+		},
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"bytes": "abc"},
+		"rec2": {"bytes": 20},
+		"rec3": {"bytes": 3.5},
+		"rec4": {"bytes": "100"},
+		"rec5": {"bytes": 7},
+	}
+	recordIndexInFinal := map[string]int{"rec1": 0, "rec2": 1, "rec3": 2, "rec4": 3, "rec5": 4}
+
+	err := performSortColRequest(&structs.NodeResult{}, nil, letColReq, recs, recordIndexInFinal, map[string]bool{"bytes": true}, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, map[string]int{"rec3": 0, "rec5": 1, "rec2": 2}, recordIndexInFinal)
+}
+
+func Test_performSortColRequestWithoutGroupby_SpillToDisk(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+	oldMemoryTracker := memory.GlobalMemoryTracker
+	defer func() { memory.GlobalMemoryTracker = oldMemoryTracker }()
+
+	// The budget is too small for any record, so each segment is written to disk.
+	memory.GlobalMemoryTracker = &structs.MemoryTracker{SegSearchRequestedBytes: 100}
+
+	letColReq := &structs.LetColumnsRequest{
+		SortColRequest: &structs.SortExpr{
+			SortEles:      []*structs.SortElement{{SortByAsc: false, Op: "auto", Field: "bytes"}},
+			SortAscending: []int{-1},
+			Limit:         4,
+		},
+	}
+	segments := []map[string]map[string]interface{}{
+		{"rec1": {"bytes": int64(5), "host": "a"}, "rec2": {"bytes": "abc", "tags": []interface{}{"x", "y"}}},
+		{"rec3": {"bytes": 42.5}, "rec4": {"bytes": uint64(17)}, "rec5": {"bytes": nil}},
+		{"rec6": {"bytes": int64(30)}},
+	}
+
+	recs := map[string]map[string]interface{}{}
+	recordIndexInFinal := map[string]int{}
+	for i, segmentRecs := range segments {
+		for recordKey, record := range segmentRecs {
+			recs[recordKey] = record
+			recordIndexInFinal[recordKey] = len(recordIndexInFinal)
+		}
+
+		err := performSortColRequest(&structs.NodeResult{}, nil, letColReq, recs, recordIndexInFinal, map[string]bool{"bytes": true}, 3, true)
+		assert.Nil(t, err)
+		if i < len(segments)-1 {
+			assert.Equal(t, 0, len(recs))
+			assert.Equal(t, i+1, len(letColReq.SortColRequest.SpillFiles))
+		}
+	}
+
+	assert.Equal(t, 4, len(recs))
+	assert.Equal(t, map[string]int{"rec2": 0, "rec5": 1, "rec3": 2, "rec6": 3}, recordIndexInFinal)
+	assert.Equal(t, 42.5, recs["rec3"]["bytes"])
+	assert.Equal(t, int64(30), recs["rec6"]["bytes"])
+	assert.Equal(t, []interface{}{"x", "y"}, recs["rec2"]["tags"])
+
+	assert.Nil(t, letColReq.SortColRequest.SpillFiles)
+	spillFiles, err := os.ReadDir(getSortSpillDir())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(spillFiles))
+}
+
+func Test_spillSortRecords_ValueTypes(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+
+	sortExpr := &structs.SortExpr{
+		SortEles:      []*structs.SortElement{{SortByAsc: true, Op: "auto", Field: "bytes"}},
+		SortAscending: []int{1},
+		Limit:         10,
+	}
+	timestamp := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	sortExpr.SortRecords = map[string]map[string]interface{}{
+		"rec1": {
+			"bytes":  int64(1),
+			"object": map[string]interface{}{"name": "a", "tags": []interface{}{"x", int64(2)}, "empty": nil},
+			"array":  []map[string]interface{}{{"id": json.Number("7")}},
+		},
+		"rec2": {"bytes": int64(2), "time": timestamp, "number": json.Number("3.5"), "names": []string{"b", "c"}},
+	}
+	expectedRecords := map[string]map[string]interface{}{
+		"rec1": sortExpr.SortRecords["rec1"],
+		"rec2": sortExpr.SortRecords["rec2"],
+	}
+
+	err := spillSortRecords(sortExpr, map[string]int{"rec1": 0, "rec2": 1})
+	assert.Nil(t, err)
+	assert.Len(t, sortExpr.SpillFiles, 1)
+
+	mergedRecs, err := mergeSortRuns(sortExpr, nil)
+	assert.Nil(t, err)
+	assert.Len(t, mergedRecs, 2)
+	for _, sortedRec := range mergedRecs {
+		assert.Equal(t, expectedRecords[sortedRec.Key], sortedRec.Record)
+	}
+}
+
+func Test_RemoveSortSpillFilesInChain(t *testing.T) {
+	config.SetDataPath(t.TempDir() + "/")
+
+	sortExpr := &structs.SortExpr{
+		SortEles:      []*structs.SortElement{{SortByAsc: true, Op: "auto", Field: "bytes"}},
+		SortAscending: []int{1},
+		Limit:         10,
+		SortRecords:   map[string]map[string]interface{}{"rec1": {"bytes": int64(1)}},
+	}
+	aggs := &structs.QueryAggregators{
+		Next: &structs.QueryAggregators{
+			PipeCommandType: structs.OutputTransformType,
+			OutputTransforms: &structs.OutputTransforms{
+				LetColumns: &structs.LetColumnsRequest{SortColRequest: sortExpr},
+			},
+		},
+	}
+
+	// A query that stops before the runs are merged still removes its spill files.
+	err := spillSortRecords(sortExpr, map[string]int{"rec1": 0})
+	assert.Nil(t, err)
+	spillFiles, err := os.ReadDir(getSortSpillDir())
+	assert.Nil(t, err)
+	assert.Len(t, spillFiles, 1)
+
+	RemoveSortSpillFilesInChain(aggs)
+	assert.Nil(t, sortExpr.SpillFiles)
+	spillFiles, err = os.ReadDir(getSortSpillDir())
+	assert.Nil(t, err)
+	assert.Len(t, spillFiles, 0)
+}
+
+func Test_performReverseRequest(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{ReverseRequest: &structs.ReverseExpr{}}
+	recordIndexInFinal := map[string]int{"rec1": 0, "rec2": 1, "rec3": 2}
+
+	recs := map[string]map[string]interface{}{"rec1": {"a": 1}}
+	err := performReverseRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs["rec2"] = map[string]interface{}{"a": 2}
+	recs["rec3"] = map[string]interface{}{"a": 3}
+	err = performReverseRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, map[string]int{"rec1": 2, "rec2": 1, "rec3": 0}, recordIndexInFinal)
+
+	nodeResult := &structs.NodeResult{
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"a"}}, {GroupByValues: []string{"b"}}, {GroupByValues: []string{"c"}},
+		},
+	}
+	err = performReverseRequest(nodeResult, letColReq, nil, nil, 0, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, []string{"b"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, []string{"a"}, nodeResult.MeasureResults[2].GroupByValues)
+}
+
+func Test_performIPLocationRequestWithoutDatabase(t *testing.T) {
+	defer config.SetGeoIPDatabasePath(config.GetGeoIPDatabasePath())
+	config.SetGeoIPDatabasePath("")
+
+	letColReq := &structs.LetColumnsRequest{
+		IPLocationRequest: &structs.IPLocationRequest{Field: "clientip"},
+	}
+	recs := map[string]map[string]interface{}{"rec1": {"clientip": "81.2.69.160"}}
+
+	err := performIPLocationRequest(&structs.NodeResult{}, letColReq, recs, map[string]bool{})
+	assert.NotNil(t, err)
+}
+
+func Test_performIPLocationRequestOnHistogram(t *testing.T) {
+	defer config.SetGeoIPDatabasePath(config.GetGeoIPDatabasePath())
+	filePath := filepath.Join(t.TempDir(), "test.mmdb")
+	err := lookups.WriteMockMMDB(filePath, 4, 24, map[string]interface{}{
+		"81.2.69.0/24": map[string]interface{}{
+			"city":     map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+			"location": map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931},
+		},
+	})
+	assert.Nil(t, err)
+	config.SetGeoIPDatabasePath(filePath)
+
+	// The IP is a stats column, like in stats latest(clientip) as clientip by host.
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"host": {
+				Results: []*structs.BucketResult{
+					{
+						BucketKey:   "web",
+						GroupByKeys: []string{"host"},
+						StatRes: map[string]utils.CValueEnclosure{
+							"clientip": {Dtype: utils.SS_DT_STRING, CVal: "81.2.69.160"},
+						},
+					},
+				},
+			},
+		},
+	}
+	letColReq := &structs.LetColumnsRequest{
+		IPLocationRequest: &structs.IPLocationRequest{Field: "clientip"},
+	}
+
+	err = performIPLocationRequest(nodeResult, letColReq, nil, nil)
+	assert.Nil(t, err)
+	statRes := nodeResult.Histogram["host"].Results[0].StatRes
+	assert.Equal(t, "London", statRes["City"].CVal)
+	assert.Equal(t, 51.5142, statRes["lat"].CVal)
+}
+
+func Test_encodeGeohash(t *testing.T) {
+	assert.Equal(t, "u4pruydqqvj", encodeGeohash(57.64911, 10.40744, 11))
+	assert.Equal(t, "u4pru", encodeGeohash(57.64911, 10.40744, 5))
+	assert.Equal(t, "s", encodeGeohash(0, 0, 1))
+
+	lat, long, ok := decodeGeohashCenter("u4pruydqqvj")
+	assert.True(t, ok)
+	assert.InDelta(t, 57.64911, lat, 0.0001)
+	assert.InDelta(t, 10.40744, long, 0.0001)
+
+	lat, long, ok = decodeGeohashCenter("s")
+	assert.True(t, ok)
+	assert.Equal(t, 22.5, lat)
+	assert.Equal(t, 22.5, long)
+
+	_, _, ok = decodeGeohashCenter("u4a")
+	assert.False(t, ok)
+}
+
+func Test_performGeoStatsRequest(t *testing.T) {
+	binReq := &structs.GeoStatsRequest{
+		Step:            structs.GeoStatsBinPoints,
+		LatField:        "lat",
+		LongField:       "lon",
+		OutputLatField:  "latitude",
+		OutputLongField: "longitude",
+		Precision:       3,
+	}
+	recs := map[string]map[string]interface{}{
+		"rec1": {"lat": 57.64911, "lon": 10.40744},
+		"rec2": {"lat": "57.6", "lon": "10.4"},
+		"rec3": {"lat": 95.0, "lon": 10.0},
+		"rec4": {"lon": 10.0},
+		"rec5": {"lat": "unknown", "lon": 10.0},
+	}
+	finalCols := map[string]bool{"lat": true, "lon": true}
+
+	err := performGeoStatsRequest(&structs.NodeResult{}, &structs.LetColumnsRequest{GeoStatsRequest: binReq}, recs, finalCols)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 2)
+	assert.Equal(t, "u4p", recs["rec1"]["geobin"])
+	assert.Equal(t, "u4p", recs["rec2"]["geobin"])
+	assert.True(t, finalCols["geobin"])
+
+	centerReq := *binReq
+	centerReq.Step = structs.GeoStatsAddBinCenter
+	statsRecs := map[string]map[string]interface{}{
+		"rec1": {"geobin": "u4p", "count(*)": 2},
+	}
+	err = performGeoStatsRequest(&structs.NodeResult{}, &structs.LetColumnsRequest{GeoStatsRequest: &centerReq}, statsRecs, finalCols)
+	assert.Nil(t, err)
+	assert.InDelta(t, 56.953125, statsRecs["rec1"]["latitude"], 0.0000001)
+	assert.InDelta(t, 10.546875, statsRecs["rec1"]["longitude"], 0.0000001)
+	assert.True(t, finalCols["latitude"])
+	assert.True(t, finalCols["longitude"])
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"geobin"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"u4p"}, MeasureVal: map[string]interface{}{"count(*)": 2}},
+			{GroupByValues: []string{"s"}, MeasureVal: map[string]interface{}{"count(*)": 1}},
+		},
+	}
+	err = performGeoStatsRequest(nodeResult, &structs.LetColumnsRequest{GeoStatsRequest: &centerReq}, nil, finalCols)
+	assert.Nil(t, err)
+	assert.Equal(t, 22.5, nodeResult.MeasureResults[1].MeasureVal["latitude"])
+	assert.Equal(t, 22.5, nodeResult.MeasureResults[1].MeasureVal["longitude"])
+	assert.Equal(t, []string{"count(*)", "latitude", "longitude"}, nodeResult.MeasureFunctions)
+
+	binReq.Precision = 13
+	err = performGeoStatsRequest(&structs.NodeResult{}, &structs.LetColumnsRequest{GeoStatsRequest: binReq}, recs, finalCols)
+	assert.NotNil(t, err)
+}
+
+func Test_performGeoStatsRequestOnHistogram(t *testing.T) {
+	binReq := &structs.GeoStatsRequest{
+		Step:      structs.GeoStatsBinPoints,
+		LatField:  "lat",
+		LongField: "lon",
+		Precision: 3,
+	}
+
+	// The point is in stats columns, like in stats avg(lat) as lat, avg(lon) as lon by host.
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"host": {
+				Results: []*structs.BucketResult{
+					{
+						BucketKey:   "web",
+						GroupByKeys: []string{"host"},
+						StatRes: map[string]utils.CValueEnclosure{
+							"lat": {Dtype: utils.SS_DT_FLOAT, CVal: 57.64911},
+							"lon": {Dtype: utils.SS_DT_FLOAT, CVal: 10.40744},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := performGeoStatsRequest(nodeResult, &structs.LetColumnsRequest{GeoStatsRequest: binReq}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "u4p", nodeResult.Histogram["host"].Results[0].StatRes[GeoStatsBinField].CVal)
+}
+
+func Test_performSequentialRequest_Trendline(t *testing.T) {
+	smaReq := &structs.SequentialRequest{CmdType: structs.SequentialSMA, Field: "x", NewField: "sma3(x)", PEnd: 3}
+	emaReq := &structs.SequentialRequest{CmdType: structs.SequentialEMA, Field: "x", NewField: "ema3(x)", PEnd: 3}
+	wmaReq := &structs.SequentialRequest{CmdType: structs.SequentialWMA, Field: "x", NewField: "wma3(x)", PEnd: 3}
+
+	recs := map[string]map[string]interface{}{
+		"r1": {"x": 1},
+		"r2": {"x": "3"},
+		"r3": {"x": "n/a"},
+		"r4": {"x": 5.0},
+		"r5": {"x": 8},
+	}
+	recordIndexInFinal := map[string]int{"r1": 0, "r2": 1, "r3": 2, "r4": 3, "r5": 4}
+	finalCols := map[string]bool{"x": true}
+
+	for _, seqReq := range []*structs.SequentialRequest{smaReq, emaReq, wmaReq} {
+		letColReq := &structs.LetColumnsRequest{SequentialRequest: seqReq}
+		err := performSequentialRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, true)
+		assert.Nil(t, err)
+	}
+
+	assert.NotContains(t, recs["r2"], "sma3(x)")
+	assert.NotContains(t, recs["r3"], "sma3(x)")
+	assert.Equal(t, 3.0, recs["r4"]["sma3(x)"])
+	assert.InDelta(t, 16.0/3, recs["r5"]["sma3(x)"], 1e-9)
+
+	// alpha = 2 / (3 + 1)
+	assert.Equal(t, 1.0, recs["r1"]["ema3(x)"])
+	assert.Equal(t, 2.0, recs["r2"]["ema3(x)"])
+	assert.NotContains(t, recs["r3"], "ema3(x)")
+	assert.Equal(t, 3.5, recs["r4"]["ema3(x)"])
+	assert.Equal(t, 5.75, recs["r5"]["ema3(x)"])
+
+	assert.InDelta(t, (1.0+2*3+3*5)/6, recs["r4"]["wma3(x)"], 1e-9)
+	assert.InDelta(t, (3.0+2*5+3*8)/6, recs["r5"]["wma3(x)"], 1e-9)
+
+	assert.True(t, finalCols["sma3(x)"])
+	assert.True(t, finalCols["ema3(x)"])
+	assert.True(t, finalCols["wma3(x)"])
+}
+
+func getTestPredictRequest(algorithm structs.PredictAlgorithm) *structs.PredictRequest {
+	return &structs.PredictRequest{
+		Fields: []*structs.PredictField{
+			{Field: "count(*)", NewField: "prediction", UpperField: "upper95", LowerField: "lower95"},
+		},
+		Algorithm:      algorithm,
+		FutureTimespan: 3,
+		Confidence:     95,
+	}
+}
+
+func Test_performPredictRequestOnHistogram(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	results := make([]*structs.BucketResult, 0)
+	for i := 0; i < 20; i++ {
+		results = append(results, &structs.BucketResult{
+			BucketKey:   fmt.Sprintf("%v", 1000+i*60),
+			GroupByKeys: []string{"timestamp"},
+			StatRes: map[string]utils.CValueEnclosure{
+				"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(10 + 2*i)},
+			},
+		})
+	}
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{"timechart": {Results: results}},
+	}
+
+	letColReq := &structs.LetColumnsRequest{PredictRequest: getTestPredictRequest(structs.PredictLLT)}
+	err := performPredictRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+
+	results = nodeResult.Histogram["timechart"].Results
+	assert.Len(t, results, 23)
+	for i, bucketResult := range results[20:] {
+		assert.Equal(t, fmt.Sprintf("%v", 1000+(20+i)*60), bucketResult.BucketKey)
+		assert.NotContains(t, bucketResult.StatRes, "count(*)")
+
+		// The values go up by 2 in each bucket, so the trend is forecast exactly.
+		prediction := bucketResult.StatRes["prediction"].CVal.(float64)
+		assert.InDelta(t, float64(10+2*(20+i)), prediction, 0.5)
+		assert.GreaterOrEqual(t, bucketResult.StatRes["upper95"].CVal.(float64), prediction)
+		assert.LessOrEqual(t, bucketResult.StatRes["lower95"].CVal.(float64), prediction)
+	}
+	assert.InDelta(t, 48.0, results[19].StatRes["prediction"].CVal.(float64), 0.5)
+}
+
+func Test_performPredictRequestWithoutGroupby(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	// A season of 4 values, repeated.
+	season := []float64{10, 20, 30, 20}
+	recs := make(map[string]map[string]interface{})
+	recordIndexInFinal := make(map[string]int)
+	for i := 0; i < 16; i++ {
+		recordKey := fmt.Sprintf("rec%v", i)
+		recs[recordKey] = map[string]interface{}{"timestamp": uint64(i * 1000), "count(*)": season[i%4]}
+		recordIndexInFinal[recordKey] = i
+	}
+	finalCols := map[string]bool{"timestamp": true, "count(*)": true}
+
+	predictReq := getTestPredictRequest(structs.PredictLLP)
+	predictReq.Holdback = 4
+	letColReq := &structs.LetColumnsRequest{PredictRequest: predictReq}
+
+	// The records are held back until all the segments are processed.
+	err := performPredictRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 0)
+
+	err = performPredictRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 19)
+	assert.True(t, finalCols["prediction"])
+	assert.True(t, finalCols["upper95"])
+	assert.True(t, finalCols["lower95"])
+
+	// The last 4 records are held back, so they are forecast from the season before them.
+	for i := 12; i < 16; i++ {
+		assert.InDelta(t, season[i%4], recs[fmt.Sprintf("rec%v", i)]["prediction"], 1)
+	}
+	for i := 0; i < 3; i++ {
+		recordKey := fmt.Sprintf("predict_future_%v", i)
+		assert.Equal(t, 16+i, recordIndexInFinal[recordKey])
+		assert.Equal(t, uint64((16+i)*1000), recs[recordKey]["timestamp"])
+		assert.InDelta(t, season[i%4], recs[recordKey]["prediction"], 1)
+	}
+}
+
+func Test_performPredictRequestLimits(t *testing.T) {
+	getNodeResult := func() *structs.NodeResult {
+		return &structs.NodeResult{
+			GroupByCols:      []string{"host"},
+			MeasureFunctions: []string{"count(*)"},
+			MeasureResults: []*structs.BucketHolder{
+				{GroupByValues: []string{"a"}, MeasureVal: map[string]interface{}{"count(*)": 5}},
+				{GroupByValues: []string{"b"}, MeasureVal: map[string]interface{}{"count(*)": 6}},
+			},
+		}
+	}
+
+	// The model needs at least one row which is not held back.
+	predictReq := getTestPredictRequest(structs.PredictLL)
+	predictReq.Holdback = 2
+	nodeResult := getNodeResult()
+	err := performPredictRequest(nodeResult, &structs.LetColumnsRequest{PredictRequest: predictReq}, nil, nil, nil, 0, true)
+	assert.NotNil(t, err)
+	assert.Len(t, nodeResult.MeasureResults, 2)
+
+	predictReq.Holdback = 1
+	err = performPredictRequest(getNodeResult(), &structs.LetColumnsRequest{PredictRequest: predictReq}, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+
+	predictReq = getTestPredictRequest(structs.PredictLL)
+	predictReq.FutureTimespan = structs.MaxPredictOptionValue + 1
+	err = performPredictRequest(getNodeResult(), &structs.LetColumnsRequest{PredictRequest: predictReq}, nil, nil, nil, 0, true)
+	assert.NotNil(t, err)
+
+	predictReq = getTestPredictRequest(structs.PredictLLP)
+	predictReq.Period = structs.MaxPredictOptionValue + 1
+	err = performPredictRequest(getNodeResult(), &structs.LetColumnsRequest{PredictRequest: predictReq}, nil, nil, nil, 0, true)
+	assert.NotNil(t, err)
+}
+
+func Test_performPredictRequestOnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"a"}, MeasureVal: map[string]interface{}{"count(*)": 5}},
+			{GroupByValues: []string{"b"}, MeasureVal: map[string]interface{}{"count(*)": 5}},
+			{GroupByValues: []string{"c"}, MeasureVal: map[string]interface{}{"count(*)": 5}},
+		},
+	}
+
+	letColReq := &structs.LetColumnsRequest{PredictRequest: getTestPredictRequest(structs.PredictLL)}
+	err := performPredictRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+
+	assert.Len(t, nodeResult.MeasureResults, 6)
+	assert.Equal(t, 6, nodeResult.BucketCount)
+	assert.Equal(t, []string{"count(*)", "prediction", "upper95", "lower95"}, nodeResult.MeasureFunctions)
+	for _, bucketHolder := range nodeResult.MeasureResults {
+		assert.Equal(t, 5.0, bucketHolder.MeasureVal["prediction"])
+		assert.Equal(t, 5.0, bucketHolder.MeasureVal["upper95"])
+	}
+	assert.Equal(t, []string{""}, nodeResult.MeasureResults[3].GroupByValues)
+}
+
+func Test_performAnomalyRequestWithoutGroupby(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	recs := make(map[string]map[string]interface{})
+	for i := 0; i < 20; i++ {
+		recs[fmt.Sprintf("rec%02d", i)] = map[string]interface{}{"timestamp": uint64(i), "latency": 10 + i%3}
+	}
+	recs["rec10"]["latency"] = 1000
+	finalCols := map[string]bool{"timestamp": true, "latency": true}
+
+	anomalyReq := &structs.AnomalyRequest{
+		Method:   structs.AnomalyZScore,
+		Action:   structs.AnomalyAnnotate,
+		PThresh:  0.01,
+		UseLower: true,
+	}
+	letColReq := &structs.LetColumnsRequest{AnomalyRequest: anomalyReq}
+
+	// The records are held back until all the segments are processed.
+	err := performAnomalyRequest(&structs.NodeResult{}, letColReq, recs, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 0)
+
+	err = performAnomalyRequest(&structs.NodeResult{}, letColReq, recs, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 20)
+	assert.True(t, finalCols[AnomalyIsOutlierField])
+	assert.True(t, finalCols[AnomalyProbableCauseField])
+
+	for recordKey, record := range recs {
+		if recordKey == "rec10" {
+			assert.Equal(t, 1, record[AnomalyIsOutlierField])
+			assert.Equal(t, "latency", record[AnomalyProbableCauseField])
+		} else {
+			assert.Equal(t, 0, record[AnomalyIsOutlierField], recordKey)
+			assert.NotContains(t, record, AnomalyProbableCauseField)
+		}
+	}
+}
+
+func Test_performAnomalyRequestHistogramFilter(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	recs := make(map[string]map[string]interface{})
+	for i := 0; i < 200; i++ {
+		recs[fmt.Sprintf("rec%03d", i)] = map[string]interface{}{"status": "ok"}
+	}
+	recs["rec100"]["status"] = "failed"
+	finalCols := map[string]bool{"status": true}
+
+	anomalyReq := &structs.AnomalyRequest{
+		Method:  structs.AnomalyHistogram,
+		Action:  structs.AnomalyFilter,
+		PThresh: 0.01,
+	}
+	letColReq := &structs.LetColumnsRequest{AnomalyRequest: anomalyReq}
+
+	err := performAnomalyRequest(&structs.NodeResult{}, letColReq, recs, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 1)
+	assert.Equal(t, "failed", recs["rec100"]["status"])
+	assert.Equal(t, 1, recs["rec100"][AnomalyIsOutlierField])
+	assert.Equal(t, "status", recs["rec100"][AnomalyProbableCauseField])
+}
+
+func Test_performAnomalyRequestOutlierTransform(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	// Q1 is 1.5, Q3 is 4.5 and the IQR is 3, so the bounds are -6 and 12.
+	values := []interface{}{1, 2, 3, 4, 5, 100, -50, "n/a"}
+	recs := make(map[string]map[string]interface{})
+	for i, value := range values {
+		recs[fmt.Sprintf("rec%v", i)] = map[string]interface{}{"x": value}
+	}
+	finalCols := map[string]bool{"x": true}
+
+	anomalyReq := &structs.AnomalyRequest{
+		Method: structs.AnomalyIQR,
+		Action: structs.AnomalyTransform,
+		Fields: []string{"x"},
+		Param:  2.5,
+		Mark:   true,
+	}
+	letColReq := &structs.LetColumnsRequest{AnomalyRequest: anomalyReq}
+
+	err := performAnomalyRequest(&structs.NodeResult{}, letColReq, recs, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 8)
+	assert.Equal(t, "000 12", recs["rec5"]["x"])
+	assert.Equal(t, 1, recs["rec5"][AnomalyIsOutlierField])
+	// The lower bound is not checked unless uselower is set.
+	assert.Equal(t, -50, recs["rec6"]["x"])
+	assert.Equal(t, 0, recs["rec6"][AnomalyIsOutlierField])
+	assert.Equal(t, "n/a", recs["rec7"]["x"])
+}
+
+func Test_performAnomalyRequestOutlierRemoveOnMeasureResults(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir() + "/")
+
+	counts := []interface{}{10, 11, 12, 13, 500}
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults:   make([]*structs.BucketHolder, 0),
+	}
+	for i, count := range counts {
+		nodeResult.MeasureResults = append(nodeResult.MeasureResults, &structs.BucketHolder{
+			GroupByValues: []string{fmt.Sprintf("host%v", i)},
+			MeasureVal:    map[string]interface{}{"count(*)": count},
+		})
+	}
+
+	anomalyReq := &structs.AnomalyRequest{
+		Method: structs.AnomalyIQR,
+		Action: structs.AnomalyRemove,
+		Param:  2.5,
+	}
+	letColReq := &structs.LetColumnsRequest{AnomalyRequest: anomalyReq}
+
+	err := performAnomalyRequest(nodeResult, letColReq, nil, nil, 0, true)
+	assert.Nil(t, err)
+	assert.Len(t, nodeResult.MeasureResults, 4)
+	assert.Equal(t, 4, nodeResult.BucketCount)
+	assert.Equal(t, []string{"count(*)"}, nodeResult.MeasureFunctions)
+	for i, bucketHolder := range nodeResult.MeasureResults {
+		assert.Equal(t, []string{fmt.Sprintf("host%v", i)}, bucketHolder.GroupByValues)
+		assert.NotContains(t, bucketHolder.MeasureVal, AnomalyIsOutlierField)
+	}
+}
+
+func getTestClusterRequest(match string) *structs.ClusterRequest {
+	return &structs.ClusterRequest{
+		Cluster: &structs.Cluster{
+			Field:     "_raw",
+			Threshold: 0.8,
+			Match:     match,
+		},
+		ShowCount:  true,
+		LabelField: "cluster_label",
+		CountField: "cluster_count",
+	}
+}
+
+func Test_getClusterTerms(t *testing.T) {
+	cluster := &structs.Cluster{Match: "termlist"}
+	assert.Equal(t, []string{"user", "42", "logged", "in"}, getClusterTerms(cluster, "user=42 logged-in"))
+
+	cluster.Delims = " "
+	assert.Equal(t, []string{"user=42", "logged-in"}, getClusterTerms(cluster, "user=42 logged-in"))
+
+	cluster.Match = "ngramset"
+	assert.Equal(t, []string{"abc", "bcd"}, getClusterTerms(cluster, "abcd"))
+	assert.Equal(t, []string{"ab"}, getClusterTerms(cluster, "ab"))
+}
+
+func Test_performClusterRequestWithoutGroupby(t *testing.T) {
+	messages := []string{
+		"connection to db1 timed out after 30s",
+		"user alice logged in from web",
+		"connection to db2 timed out after 30s",
+		"user bob logged in from web",
+		"disk full on /var",
+		"connection to db1 timed out after 60s",
+	}
+	recs := make(map[string]map[string]interface{})
+	recordIndexInFinal := make(map[string]int)
+	for i, message := range messages {
+		recordKey := fmt.Sprintf("rec%v", i)
+		recs[recordKey] = map[string]interface{}{"_raw": message}
+		recordIndexInFinal[recordKey] = i
+	}
+	finalCols := map[string]bool{"_raw": true}
+
+	clusterReq := getTestClusterRequest("termlist")
+	clusterReq.LabelOnly = true
+	letColReq := &structs.LetColumnsRequest{ClusterRequest: clusterReq}
+
+	// The records are held back until all the segments are processed.
+	err := performClusterRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 0)
+
+	err = performClusterRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 6)
+	assert.True(t, finalCols["cluster_label"])
+	assert.True(t, finalCols["cluster_count"])
+
+	expectedLabels := []int{1, 2, 1, 2, 3, 1}
+	expectedCounts := []int{3, 2, 3, 2, 1, 3}
+	for i := range messages {
+		recordKey := fmt.Sprintf("rec%v", i)
+		assert.Equal(t, expectedLabels[i], recs[recordKey]["cluster_label"], recordKey)
+		assert.Equal(t, expectedCounts[i], recs[recordKey]["cluster_count"], recordKey)
+	}
+
+	// Without labelonly, only the first record of each cluster is kept.
+	for i, message := range messages {
+		recs[fmt.Sprintf("rec%v", i)] = map[string]interface{}{"_raw": message}
+	}
+	clusterReq = getTestClusterRequest("termlist")
+	letColReq = &structs.LetColumnsRequest{ClusterRequest: clusterReq}
+	err = performClusterRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 3)
+	assert.Contains(t, recs, "rec0")
+	assert.Contains(t, recs, "rec1")
+	assert.Contains(t, recs, "rec4")
+}
+
+func Test_performClusterRequestTermset(t *testing.T) {
+	// The same terms in a different order are only similar when the order does not matter.
+	rows := []map[string]interface{}{
+		{"_raw": "error reading config file"},
+		{"_raw": "config file error reading"},
+	}
+
+	keep := applyClusterRequest(getTestClusterRequest("termlist"), rows)
+	assert.Equal(t, []bool{true, true}, keep)
+	assert.Equal(t, 2, rows[1]["cluster_label"])
+
+	keep = applyClusterRequest(getTestClusterRequest("termset"), rows)
+	assert.Equal(t, []bool{true, false}, keep)
+	assert.Equal(t, 1, rows[1]["cluster_label"])
+	assert.Equal(t, 2, rows[0]["cluster_count"])
+}
+
+func Test_performClusterRequestOnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"_raw"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"GET /api/users 200"}, MeasureVal: map[string]interface{}{"count(*)": 5}},
+			{GroupByValues: []string{"GET /api/users 404"}, MeasureVal: map[string]interface{}{"count(*)": 2}},
+			{GroupByValues: []string{"worker restarted"}, MeasureVal: map[string]interface{}{"count(*)": 1}},
+		},
+	}
+
+	clusterReq := getTestClusterRequest("termlist")
+	clusterReq.Cluster.Threshold = 0.7
+	letColReq := &structs.LetColumnsRequest{ClusterRequest: clusterReq}
+	err := performClusterRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+
+	assert.Len(t, nodeResult.MeasureResults, 2)
+	assert.Equal(t, 2, nodeResult.BucketCount)
+	assert.Equal(t, []string{"count(*)", "cluster_label", "cluster_count"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, 1, nodeResult.MeasureResults[0].MeasureVal["cluster_label"])
+	assert.Equal(t, 2, nodeResult.MeasureResults[0].MeasureVal["cluster_count"])
+	assert.Equal(t, []string{"worker restarted"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, 2, nodeResult.MeasureResults[1].MeasureVal["cluster_label"])
+}
+
+func Test_performValueColRequestWithoutGroupBy_ToJsonAndSPath(t *testing.T) {
+	recs := map[string]map[string]interface{}{
+		"rec1": {"_raw": `{"user": {"name": "ann"}}`, "status": "ok"},
+		"rec2": {"_raw": `{"user": {"name": "bob"}}`, "bytes": uint64(10)},
+	}
+	finalCols := map[string]bool{"_raw": true, "status": true, "bytes": true}
+
+	letColReq := &structs.LetColumnsRequest{
+		NewColName: "json",
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr: &structs.StringExpr{
+				StringExprMode: structs.SEMTextExpr,
+				TextExpr: &structs.TextExpr{
+					Op:    "tojson",
+					Param: &structs.StringExpr{StringExprMode: structs.SEMRawString, RawString: "false"},
+				},
+			},
+		},
+	}
+
+	// Each record has its own fields in the JSON object.
+	err := performValueColRequestWithoutGroupBy(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"status":"ok"}`, recs["rec1"]["json"])
+	assert.Equal(t, `{"bytes":10}`, recs["rec2"]["json"])
+
+	letColReq = &structs.LetColumnsRequest{
+		NewColName: "user",
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr: &structs.StringExpr{
+				StringExprMode: structs.SEMTextExpr,
+				TextExpr: &structs.TextExpr{
+					IsTerminal: true,
+					Op:         "spath",
+					SPathExpr:  &structs.SPathExpr{InputColName: "_raw", Path: "user.name", OutputColName: "user"},
+				},
+			},
+		},
+	}
+	err = performValueColRequestWithoutGroupBy(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+	assert.Equal(t, "ann", recs["rec1"]["user"])
+	assert.Equal(t, "bob", recs["rec2"]["user"])
+	assert.True(t, finalCols["user"])
+}
+
+func getTestToJsonLetColReq() *structs.LetColumnsRequest {
+	return &structs.LetColumnsRequest{
+		NewColName: "json",
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr: &structs.StringExpr{
+				StringExprMode: structs.SEMTextExpr,
+				TextExpr:       &structs.TextExpr{Op: "tojson"},
+			},
+		},
+	}
+}
+
+func Test_performValueColRequestOnHistogram_ToJson(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		Histogram: map[string]*structs.AggregationResult{
+			"1": {
+				Results: []*structs.BucketResult{
+					{
+						BucketKey:   "web",
+						GroupByKeys: []string{"host"},
+						StatRes: map[string]utils.CValueEnclosure{
+							"count(*)": {Dtype: utils.SS_DT_UNSIGNED_NUM, CVal: uint64(3)},
+						},
+					},
+					{
+						BucketKey:   "db",
+						GroupByKeys: []string{"host"},
+						StatRes:     map[string]utils.CValueEnclosure{},
+					},
+				},
+			},
+		},
+	}
+
+	err := performValueColRequestOnHistogram(nodeResult, getTestToJsonLetColReq())
+	assert.Nil(t, err)
+	results := nodeResult.Histogram["1"].Results
+	assert.Equal(t, `{"count(*)":3,"host":"web"}`, results[0].StatRes["json"].CVal)
+	assert.Equal(t, `{"host":"db"}`, results[1].StatRes["json"].CVal)
+}
+
+func Test_performValueColRequestOnMeasureResults_ToJson(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count(*)"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web"}, MeasureVal: map[string]interface{}{"count(*)": uint64(3)}},
+			{GroupByValues: []string{"db"}, MeasureVal: map[string]interface{}{}},
+		},
+	}
+
+	err := performValueColRequestOnMeasureResults(nodeResult, getTestToJsonLetColReq())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"count(*)", "json"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, `{"count(*)":3,"host":"web"}`, nodeResult.MeasureResults[0].MeasureVal["json"])
+	assert.Equal(t, `{"host":"db"}`, nodeResult.MeasureResults[1].MeasureVal["json"])
+}
+
+func Test_performValueColRequestWithoutGroupBy_JsonExtract(t *testing.T) {
+	recs := map[string]map[string]interface{}{
+		"rec1": {"_raw": `{"user": {"tags": ["admin", "dev"]}}`},
+		"rec2": {"_raw": `{"user": {"tags": "ops"}}`},
+	}
+	finalCols := map[string]bool{"_raw": true}
+
+	letColReq := &structs.LetColumnsRequest{
+		NewColName: "tags",
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMMultiValueExpr,
+			MultiValueExpr: &structs.MultiValueExpr{
+				Op: "json_extract",
+				StringExprParams: []*structs.StringExpr{
+					{StringExprMode: structs.SEMField, FieldName: "_raw"},
+					{StringExprMode: structs.SEMRawString, RawString: "user.tags"},
+				},
+			},
+		},
+	}
+
+	err := performValueColRequestWithoutGroupBy(&structs.NodeResult{}, letColReq, recs, finalCols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"admin", "dev"}, recs["rec1"]["tags"])
+	assert.Equal(t, []string{"ops"}, recs["rec2"]["tags"])
+
+	// The extracted array is a multivalue, so mvexpand gives one value per element.
+	assert.Equal(t, []interface{}{"admin", "dev"}, performMVExpand(recs["rec1"]["tags"]))
+}
+
+// Parses subsearches like `eval new = field` or `eval new = "text"`.
+func getTestForeachRequest(fields []string, subsearch string, numParsed *int) *structs.ForeachRequest {
+	return &structs.ForeachRequest{
+		Fields:    fields,
+		FieldStr:  "<<FIELD>>",
+		MatchStr:  "<<MATCHSTR>>",
+		ItemStr:   "<<ITEM>>",
+		Subsearch: subsearch,
+		ParseSubsearch: func(subsearch string) (*structs.QueryAggregators, error) {
+			*numParsed++
+			parts := strings.SplitN(strings.TrimPrefix(subsearch, "eval "), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid subsearch: %v", subsearch)
+			}
+
+			valueExpr := &structs.ValueExpr{
+				ValueExprMode: structs.VEMNumericExpr,
+				NumericExpr: &structs.NumericExpr{
+					NumericExprMode: structs.NEMNumberField,
+					IsTerminal:      true,
+					ValueIsField:    true,
+					Value:           strings.TrimSpace(parts[1]),
+				},
+			}
+			if value := strings.TrimSpace(parts[1]); strings.HasPrefix(value, `"`) {
+				valueExpr = &structs.ValueExpr{
+					ValueExprMode: structs.VEMStringExpr,
+					StringExpr: &structs.StringExpr{
+						StringExprMode: structs.SEMRawString,
+						RawString:      strings.Trim(value, `"`),
+					},
+				}
+			}
+
+			return &structs.QueryAggregators{
+				PipeCommandType: structs.OutputTransformType,
+				OutputTransforms: &structs.OutputTransforms{
+					LetColumns: &structs.LetColumnsRequest{
+						NewColName:      strings.TrimSpace(parts[0]),
+						ValueColRequest: valueExpr,
+					},
+				},
+			}, nil
+		},
+	}
+}
+
+func Test_performForeachRequestWithoutGroupby(t *testing.T) {
+	numParsed := 0
+	foreachReq := getTestForeachRequest([]string{"bytes_*"}, "eval <<FIELD>>_copy = <<FIELD>>", &numParsed)
+	// The known fields were already expanded when the query was planned.
+	foreachReq.ExpandedFields = map[string]struct{}{"bytes_out": {}}
+	letColReq := &structs.LetColumnsRequest{ForeachRequest: foreachReq}
+
+	recs := map[string]map[string]interface{}{
+		"rec0": {"bytes_out": int64(20), "bytes_in": int64(10), "host": "a"},
+		"rec1": {"bytes_out": int64(40), "host": "b"},
+	}
+	finalCols := map[string]bool{"bytes_out": true, "bytes_in": true, "host": true}
+
+	err := performForeachRequest(&structs.NodeResult{}, letColReq, recs, nil, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), recs["rec0"]["bytes_in_copy"])
+	assert.NotContains(t, recs["rec0"], "bytes_out_copy")
+	assert.NotContains(t, recs["rec1"], "bytes_in_copy")
+	assert.True(t, finalCols["bytes_in_copy"])
+
+	// The columns added by the previous records do not match the fields again, and the
+	// parsed subsearch is reused.
+	recs = map[string]map[string]interface{}{
+		"rec2": {"bytes_in": int64(30)},
+	}
+	err = performForeachRequest(&structs.NodeResult{}, letColReq, recs, nil, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"bytes_in": int64(30), "bytes_in_copy": float64(30)}, recs["rec2"])
+	assert.Equal(t, 1, numParsed)
+}
+
+func Test_performForeachRequestMultiValue(t *testing.T) {
+	numParsed := 0
+	foreachReq := getTestForeachRequest([]string{"sizes"}, `eval seen_<<ITEM>> = "<<FIELD>>"`, &numParsed)
+	foreachReq.Mode = structs.ForeachMultiValue
+	letColReq := &structs.LetColumnsRequest{ForeachRequest: foreachReq}
+
+	recs := map[string]map[string]interface{}{
+		"rec0": {"sizes": []interface{}{"s", "m"}},
+		"rec1": {"sizes": "m"},
+		"rec2": {"other": "l"},
+	}
+	finalCols := map[string]bool{"sizes": true, "other": true}
+
+	err := performForeachRequest(&structs.NodeResult{}, letColReq, recs, nil, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "sizes", recs["rec0"]["seen_s"])
+	assert.Equal(t, "sizes", recs["rec0"]["seen_m"])
+	assert.Equal(t, map[string]interface{}{"sizes": "m", "seen_m": "sizes"}, recs["rec1"])
+	assert.Equal(t, map[string]interface{}{"other": "l"}, recs["rec2"])
+	assert.Equal(t, 2, numParsed)
+
+	err = performForeachRequest(&structs.NodeResult{}, letColReq, nil, nil, finalCols, 1, true)
+	assert.NotNil(t, err)
+}
+
+func Test_performForeachRequestMultiValue_Where(t *testing.T) {
+	getRawString := func(value string) *structs.ValueExpr {
+		return &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr:    &structs.StringExpr{StringExprMode: structs.SEMRawString, RawString: value},
+		}
+	}
+
+	// Parses subsearches like `where "<<ITEM>>" != "l"`, and `sort x` as a command that holds
+	// the records back.
+	foreachReq := getTestForeachRequest([]string{"sizes"}, `where "<<ITEM>>" != "l"`, new(int))
+	foreachReq.Mode = structs.ForeachMultiValue
+	foreachReq.ParseSubsearch = func(subsearch string) (*structs.QueryAggregators, error) {
+		if strings.HasPrefix(subsearch, "sort ") {
+			return &structs.QueryAggregators{
+				PipeCommandType: structs.OutputTransformType,
+				OutputTransforms: &structs.OutputTransforms{
+					LetColumns: &structs.LetColumnsRequest{SortColRequest: &structs.SortExpr{}},
+				},
+			}, nil
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(subsearch, "where "), "!=", 2)
+		return &structs.QueryAggregators{
+			PipeCommandType: structs.OutputTransformType,
+			OutputTransforms: &structs.OutputTransforms{
+				FilterRows: &structs.BoolExpr{
+					IsTerminal: true,
+					LeftValue:  getRawString(strings.Trim(strings.TrimSpace(parts[0]), `"`)),
+					RightValue: getRawString(strings.Trim(strings.TrimSpace(parts[1]), `"`)),
+					ValueOp:    "!=",
+				},
+			},
+		}, nil
+	}
+	letColReq := &structs.LetColumnsRequest{ForeachRequest: foreachReq}
+
+	recs := map[string]map[string]interface{}{
+		"rec0": {"sizes": []interface{}{"s", "l"}},
+		"rec1": {"sizes": "m"},
+		"rec2": {"sizes": "l"},
+	}
+	recordIndexInFinal := map[string]int{"rec0": 0, "rec1": 1, "rec2": 2}
+	finalCols := map[string]bool{"sizes": true}
+
+	err := performForeachRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]interface{}{"rec1": {"sizes": "m"}}, recs)
+	assert.Equal(t, map[string]int{"rec1": 1}, recordIndexInFinal)
+
+	// A command that holds the records back is rejected.
+	foreachReq.Subsearch = "sort <<ITEM>>"
+	foreachReq.Subsearches = nil
+	err = performForeachRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.NotNil(t, err)
+	assert.Len(t, recs, 1)
+}
+
+func Test_performForeachRequestOnMeasureResults(t *testing.T) {
+	numParsed := 0
+	letColReq := &structs.LetColumnsRequest{
+		ForeachRequest: getTestForeachRequest([]string{"sum_*"}, "eval total_<<MATCHSTR>> = <<FIELD>>", &numParsed),
+	}
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"sum_in", "sum_out"},
+		MeasureResults: []*structs.BucketHolder{
+			{
+				GroupByValues: []string{"a"},
+				MeasureVal:    map[string]interface{}{"sum_in": int64(10), "sum_out": int64(20)},
+			},
+		},
+	}
+
+	err := performForeachRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sum_in", "sum_out", "total_in", "total_out"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, "10", nodeResult.MeasureResults[0].MeasureVal["total_in"])
+	assert.Equal(t, "20", nodeResult.MeasureResults[0].MeasureVal["total_out"])
+}
+
+func Test_performAppendColsRequestWithoutGroupby(t *testing.T) {
+	recs := map[string]map[string]interface{}{
+		"rec0": {"host": "web1", "status": "200"},
+		"rec1": {"host": "web2", "status": "500"},
+	}
+	recordIndexInFinal := map[string]int{"rec0": 0, "rec1": 1}
+	finalCols := map[string]bool{"host": true, "status": true}
+
+	appendColsReq := &structs.AppendColsRequest{
+		SubsearchRecords: []map[string]interface{}{
+			{"status": "404", "latency": int64(10)},
+			{"latency": int64(20)},
+			{"latency": int64(30)},
+		},
+		SubsearchCols: []string{"status", "latency"},
+	}
+	letColReq := &structs.LetColumnsRequest{AppendColsRequest: appendColsReq}
+
+	// The records are held back until all the segments are processed.
+	err := performAppendColsRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 0)
+
+	err = performAppendColsRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 2, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 3)
+	assert.Equal(t, map[string]interface{}{"host": "web1", "status": "200", "latency": int64(10)}, recs["rec0"])
+	assert.Equal(t, map[string]interface{}{"host": "web2", "status": "500", "latency": int64(20)}, recs["rec1"])
+	assert.Equal(t, map[string]interface{}{"latency": int64(30)}, recs["appended_2"])
+	assert.Equal(t, 2, recordIndexInFinal["appended_2"])
+	assert.True(t, finalCols["latency"])
+
+	// With override, the values of the subsearch replace the values of the rows.
+	recs = map[string]map[string]interface{}{"rec0": {"host": "web1", "status": "200"}}
+	appendColsReq.Override = true
+	appendColsReq.NumProcessedSegments = 0
+	err = performAppendColsRequest(&structs.NodeResult{}, letColReq, recs, map[string]int{"rec0": 0}, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Len(t, recs, 3)
+	assert.Equal(t, "404", recs["rec0"]["status"])
+}
+
+func Test_performAppendPipeRequest(t *testing.T) {
+	// The pipeline is run by the record package, so the test sums the count column instead.
+	var pipelineRows []map[string]interface{}
+	runPipeline := func(pipeline *structs.QueryAggregators, records []map[string]interface{}) ([]map[string]interface{}, []string, error) {
+		pipelineRows = records
+		total := int64(0)
+		for _, record := range records {
+			total += record["count"].(int64)
+		}
+		return []map[string]interface{}{{"host": "total", "count": total}}, []string{"host", "count"}, nil
+	}
+
+	recs := map[string]map[string]interface{}{
+		"rec0": {"host": "web1", "count": int64(3)},
+		"rec1": {"host": "web2", "count": int64(4)},
+	}
+	recordIndexInFinal := map[string]int{"rec0": 0, "rec1": 1}
+	finalCols := map[string]bool{"host": true, "count": true}
+
+	appendPipeReq := &structs.AppendPipeRequest{
+		Pipeline:     `stats sum(count) as count | eval host="total"`,
+		PipelineAggs: &structs.QueryAggregators{},
+		RunPipeline:  runPipeline,
+	}
+	letColReq := &structs.LetColumnsRequest{AppendPipeRequest: appendPipeReq}
+	err := performAppendPipeRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"host": "web1", "count": int64(3)}, {"host": "web2", "count": int64(4)}}, pipelineRows)
+	assert.Len(t, recs, 3)
+	assert.Equal(t, map[string]interface{}{"host": "web1", "count": int64(3)}, recs["rec0"])
+	assert.Equal(t, map[string]interface{}{"host": "total", "count": int64(7)}, recs["appended_2"])
+
+	// The pipeline gets copies of the rows.
+	pipelineRows[0]["count"] = int64(100)
+	assert.Equal(t, int64(3), recs["rec0"]["count"])
+
+	// On aggregated results, the pipeline runs over the buckets and its records are added as new buckets.
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web1"}, MeasureVal: map[string]interface{}{"count": int64(5)}},
+			{GroupByValues: []string{"web2"}, MeasureVal: map[string]interface{}{"count": int64(4)}},
+		},
+		BucketCount: 2,
+	}
+	err = performAppendPipeRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+	assert.Len(t, pipelineRows, 2)
+	assert.Len(t, nodeResult.MeasureResults, 3)
+	assert.Equal(t, 3, nodeResult.BucketCount)
+	assert.Equal(t, []string{"total"}, nodeResult.MeasureResults[2].GroupByValues)
+	assert.Equal(t, map[string]interface{}{"count": int64(9)}, nodeResult.MeasureResults[2].MeasureVal)
+	assert.Equal(t, []string{"count"}, nodeResult.MeasureFunctions)
+
+	// The pipeline must be parsed before it can run.
+	appendPipeReq.PipelineAggs = nil
+	err = performAppendPipeRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.NotNil(t, err)
+
+	appendPipeReq.PipelineAggs = &structs.QueryAggregators{}
+	appendPipeReq.RunPipeline = nil
+	err = performAppendPipeRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.NotNil(t, err)
+}
+
+func Test_performAppendColsRequestOnMeasureResults(t *testing.T) {
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"web1"}, MeasureVal: map[string]interface{}{"count": int64(3)}},
+		},
+		BucketCount: 1,
+	}
+	letColReq := &structs.LetColumnsRequest{
+		AppendColsRequest: &structs.AppendColsRequest{
+			SubsearchRecords: []map[string]interface{}{
+				{"host": "db1", "latency": int64(10)},
+				{"host": "db2", "latency": int64(20)},
+			},
+			SubsearchCols: []string{"host", "latency"},
+		},
+	}
+	err := performAppendColsRequest(nodeResult, letColReq, nil, nil, nil, 0, true)
+	assert.Nil(t, err)
+
+	// The groupby values of the existing buckets are left unchanged.
+	assert.Len(t, nodeResult.MeasureResults, 2)
+	assert.Equal(t, []string{"web1"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, int64(10), nodeResult.MeasureResults[0].MeasureVal["latency"])
+	assert.Equal(t, []string{"db2"}, nodeResult.MeasureResults[1].GroupByValues)
+	assert.Equal(t, []string{"count", "latency"}, nodeResult.MeasureFunctions)
+}
+
+func Test_PerformMultisearch(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	aggs := &structs.QueryAggregators{
+		GenerateEvent: &structs.GenerateEvent{
+			Multisearch: &structs.Multisearch{
+				SubsearchRecords: [][]map[string]interface{}{
+					{{"timestamp": uint64(300), "src": "web"}, {"timestamp": uint64(100), "src": "web"}},
+					{{"src": "db"}, {"timestamp": uint64(200), "src": "db"}},
+				},
+				SubsearchCols: []string{"timestamp", "src"},
+			},
+		},
+	}
+
+	// The records of all the subsearches are ordered by time, with the records without a time last.
+	err := PerformMultisearch(aggs)
+	assert.Nil(t, err)
+	assert.Len(t, aggs.GenerateEvent.GeneratedRecords, 4)
+
+	expectedTimestamps := []interface{}{uint64(300), uint64(200), uint64(100), nil}
+	for recordKey, index := range aggs.GenerateEvent.GeneratedRecordsIndex {
+		assert.Equal(t, expectedTimestamps[index], aggs.GenerateEvent.GeneratedRecords[recordKey]["timestamp"], recordKey)
+	}
+	assert.Equal(t, "db", aggs.GenerateEvent.GeneratedRecords["0_3"]["src"])
+	assert.True(t, aggs.GenerateEvent.GeneratedCols["src"])
+}
+
+func Test_performConvertRequest(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	letColReq := &structs.LetColumnsRequest{
+		ConvertRequest: &structs.ConvertRequest{
+			TimeFormat: "%Y-%m-%d",
+			Conversions: []*structs.ConvertExpr{
+				{Func: "rmunit", Field: "size_*"},
+				{Func: "none", Field: "size_raw"},
+				{Func: "num", Field: "latency"},
+				{Func: "dur2sec", Field: "duration", NewField: "duration_sec"},
+				{Func: "memk", Field: "mem"},
+			},
+		},
+	}
+
+	recs := map[string]map[string]interface{}{
+		"1": {"timestamp": uint64(1000), "size_in": "10kb", "size_raw": "20kb", "latency": "1,200", "duration": "1+01:00:05", "mem": "2m"},
+		"2": {"timestamp": uint64(2000), "size_in": "abc", "size_raw": "5", "latency": "slow", "duration": "00:00:10", "mem": "512"},
+	}
+	finalCols := map[string]bool{"size_in": true, "size_raw": true, "latency": true, "duration": true, "mem": true}
+
+	performConvertRequest(nil, letColReq, recs, finalCols)
+	assert.True(t, finalCols["duration_sec"])
+
+	assert.Equal(t, int64(10), recs["1"]["size_in"])
+	assert.Equal(t, "20kb", recs["1"]["size_raw"])
+	assert.Equal(t, int64(1200), recs["1"]["latency"])
+	assert.Equal(t, int64(90005), recs["1"]["duration_sec"])
+	assert.Equal(t, "1+01:00:05", recs["1"]["duration"])
+	assert.Equal(t, float64(2048), recs["1"]["mem"])
+	assert.Equal(t, uint64(1000), recs["1"]["timestamp"])
+
+	assert.Equal(t, "abc", recs["2"]["size_in"])
+	_, exists := recs["2"]["latency"]
+	assert.False(t, exists)
+	assert.Equal(t, int64(10), recs["2"]["duration_sec"])
+	assert.Equal(t, float64(512), recs["2"]["mem"])
+}
+
+func Test_performConvertRequestOnMeasureResults(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		ConvertRequest: &structs.ConvertRequest{
+			Conversions: []*structs.ConvertExpr{
+				{Func: "rmcomma", Field: "total", NewField: "total_num"},
+			},
+		},
+	}
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"total"},
+		MeasureResults: []*structs.BucketHolder{
+			{
+				GroupByValues: []string{"a"},
+				MeasureVal:    map[string]interface{}{"total": "1,234.5"},
+			},
+		},
+	}
+
+	performConvertRequest(nodeResult, letColReq, nil, nil)
+	assert.Equal(t, []string{"total", "total_num"}, nodeResult.MeasureFunctions)
+	assert.Equal(t, float64(1234.5), nodeResult.MeasureResults[0].MeasureVal["total_num"])
+	assert.Equal(t, "1,234.5", nodeResult.MeasureResults[0].MeasureVal["total"])
+}
+
+func Test_performRangeMapRequest(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		RangeMapRequest: &structs.RangeMapRequest{
+			Field: "latency",
+			Ranges: []*structs.RangeMapRange{
+				{Name: "low", Start: 0, End: 100},
+				{Name: "elevated", Start: 100, End: 500},
+			},
+			Default: "None",
+		},
+	}
+
+	recs := map[string]map[string]interface{}{
+		"1": {"latency": int64(50)},
+		"2": {"latency": "100"},
+		"3": {"latency": float64(1000)},
+		"4": {"latency": "slow"},
+		"5": {"host": "a"},
+	}
+	finalCols := map[string]bool{"latency": true}
+
+	performRangeMapRequest(nil, letColReq, recs, finalCols)
+	assert.True(t, finalCols[RangeMapField])
+	assert.Equal(t, "low", recs["1"][RangeMapField])
+	assert.Equal(t, []string{"low", "elevated"}, recs["2"][RangeMapField])
+	assert.Equal(t, "None", recs["3"][RangeMapField])
+	assert.Equal(t, "None", recs["4"][RangeMapField])
+	assert.Equal(t, "None", recs["5"][RangeMapField])
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"latency"},
+		MeasureResults: []*structs.BucketHolder{
+			{
+				GroupByValues: []string{"a"},
+				MeasureVal:    map[string]interface{}{"latency": float64(250)},
+			},
+		},
+	}
+
+	performRangeMapRequest(nodeResult, letColReq, nil, nil)
+	assert.Equal(t, []string{"latency", RangeMapField}, nodeResult.MeasureFunctions)
+	assert.Equal(t, "elevated", nodeResult.MeasureResults[0].MeasureVal[RangeMapField])
+}
+
+func Test_performFieldSummaryRequest(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	letColReq := &structs.LetColumnsRequest{
+		FieldSummaryRequest: &structs.FieldSummaryRequest{MaxVals: 2},
+	}
+
+	recs := map[string]map[string]interface{}{
+		"1": {"timestamp": uint64(1000), "status": int64(200), "method": "GET", "_index": "web"},
+		"2": {"timestamp": uint64(2000), "status": int64(200), "method": "POST", "tags": []string{"a", "b"}},
+		"3": {"timestamp": uint64(3000), "status": "404", "method": "GET", "tags": []string{"a"}},
+		"4": {"timestamp": uint64(4000), "method": ""},
+	}
+	recordIndexInFinal := map[string]int{"1": 0, "2": 1, "3": 2, "4": 3}
+	finalCols := map[string]bool{"timestamp": true, "status": true, "method": true, "_index": true, "tags": true}
+
+	performFieldSummaryRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	assert.Len(t, recs, 3)
+	assert.Len(t, finalCols, len(fieldSummaryCols))
+
+	rows := make(map[string]map[string]interface{})
+	for recordKey, record := range recs {
+		rows[record["field"].(string)] = record
+		assert.Contains(t, recordIndexInFinal, recordKey)
+	}
+
+	method := rows["method"]
+	assert.Equal(t, uint64(3), method["count"])
+	assert.Equal(t, uint64(2), method["distinct_count"])
+	assert.Equal(t, int64(1), method["is_exact"])
+	assert.Equal(t, uint64(0), method["numeric_count"])
+	assert.Equal(t, `[{"value":"GET","count":2},{"value":"POST","count":1}]`, method["values"])
+	assert.NotContains(t, method, "mean")
+
+	status := rows["status"]
+	assert.Equal(t, uint64(3), status["count"])
+	assert.Equal(t, uint64(3), status["numeric_count"])
+	assert.Equal(t, float64(200), status["min"])
+	assert.Equal(t, float64(404), status["max"])
+	assert.InDelta(t, 268, status["mean"], 0.001)
+	assert.InDelta(t, 117.779, status["stdev"], 0.001)
+
+	tags := rows["tags"]
+	assert.Equal(t, uint64(2), tags["count"])
+	assert.Equal(t, `[{"value":"a","count":2},{"value":"b","count":1}]`, tags["values"])
+}
+
+func Test_performFieldSummaryRequestOnMeasureResults(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		FieldSummaryRequest: &structs.FieldSummaryRequest{Fields: []string{"c*"}},
+	}
+
+	nodeResult := &structs.NodeResult{
+		GroupByCols:      []string{"host"},
+		MeasureFunctions: []string{"count"},
+		MeasureResults: []*structs.BucketHolder{
+			{GroupByValues: []string{"a"}, MeasureVal: map[string]interface{}{"count": int64(10)}},
+			{GroupByValues: []string{"b"}, MeasureVal: map[string]interface{}{"count": int64(30)}},
+		},
+	}
+
+	performFieldSummaryRequest(nodeResult, letColReq, nil, nil, nil, 1, true)
+	assert.Equal(t, []string{"field"}, nodeResult.GroupByCols)
+	assert.Len(t, nodeResult.MeasureResults, 1)
+	assert.Equal(t, []string{"count"}, nodeResult.MeasureResults[0].GroupByValues)
+	assert.Equal(t, float64(20), nodeResult.MeasureResults[0].MeasureVal["mean"])
+	assert.Equal(t, uint64(2), nodeResult.MeasureResults[0].MeasureVal["numeric_count"])
+}
+
+func Test_GetFieldSummaryFromSegStats(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	numStats := &structs.SegStats{
+		IsNumeric: true,
+		Count:     4,
+		NumStats: &structs.NumericStats{
+			Min: utils.NumTypeEnclosure{Ntype: utils.SS_DT_SIGNED_NUM, IntgrVal: 1},
+			Max: utils.NumTypeEnclosure{Ntype: utils.SS_DT_FLOAT, FloatVal: 7.5},
+			Sum: utils.NumTypeEnclosure{Ntype: utils.SS_DT_FLOAT, FloatVal: 14},
+		},
+	}
+	numStats.CreateNewHll()
+	strStats := &structs.SegStats{Count: 3}
+	strStats.CreateNewHll()
+	strStats.InsertIntoHll([]byte("GET"))
+	strStats.InsertIntoHll([]byte("POST"))
+
+	sstMap := map[string]*structs.SegStats{"latency": numStats, "method": strStats, "_index": strStats}
+	rows, cols := GetFieldSummaryFromSegStats(&structs.FieldSummaryRequest{}, sstMap)
+	assert.Equal(t, fieldSummaryCols, cols)
+	assert.Len(t, rows, 2)
+
+	assert.Equal(t, "latency", rows[0]["field"])
+	assert.Equal(t, uint64(4), rows[0]["numeric_count"])
+	assert.Equal(t, float64(1), rows[0]["min"])
+	assert.Equal(t, float64(7.5), rows[0]["max"])
+	assert.Equal(t, float64(3.5), rows[0]["mean"])
+	assert.Equal(t, int64(0), rows[0]["is_exact"])
+
+	assert.Equal(t, "method", rows[1]["field"])
+	assert.Equal(t, uint64(3), rows[1]["count"])
+	assert.Equal(t, uint64(2), rows[1]["distinct_count"])
+	assert.Equal(t, uint64(0), rows[1]["numeric_count"])
+	assert.NotContains(t, rows[1], "min")
+}
+
+// The exact summary over the records and the approximate one over the segment stats of the same
+// records agree on the counts and the numeric stats, but only the exact one has the values and
+// the stdev.
+func Test_FieldSummaryExactAndApproximate(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	latencies := []string{"3", "10", "5", "10"}
+	methods := []string{"GET", "POST", "GET", "PUT"}
+
+	recs := make(map[string]map[string]interface{})
+	recordIndexInFinal := make(map[string]int)
+	sstMap := make(map[string]*structs.SegStats)
+	bb := bbp.Get()
+	defer bbp.Put(bb)
+	for i := range latencies {
+		latency, err := strconv.ParseInt(latencies[i], 10, 64)
+		assert.Nil(t, err)
+		recordKey := strconv.Itoa(i)
+		recs[recordKey] = map[string]interface{}{"latency": latency, "method": methods[i]}
+		recordIndexInFinal[recordKey] = i
+
+		numType, intVal, uintVal, fltVal := utils.GetNumberTypeAndVal(latencies[i])
+		stats.AddSegStatsNums(sstMap, "latency", numType, intVal, uintVal, fltVal, latencies[i], bb, nil, false, false)
+		stats.AddSegStatsStr(sstMap, "method", methods[i], bb, nil, false, false)
+	}
+
+	fieldSummaryReq := &structs.FieldSummaryRequest{MaxVals: 10}
+	letColReq := &structs.LetColumnsRequest{FieldSummaryRequest: fieldSummaryReq}
+	finalCols := map[string]bool{"latency": true, "method": true}
+	performFieldSummaryRequest(&structs.NodeResult{}, letColReq, recs, recordIndexInFinal, finalCols, 1, true)
+	exactRows := make(map[string]map[string]interface{})
+	for _, record := range recs {
+		exactRows[record["field"].(string)] = record
+	}
+
+	approximateRows, cols := GetFieldSummaryFromSegStats(fieldSummaryReq, sstMap)
+	assert.Equal(t, fieldSummaryCols, cols)
+	assert.Len(t, approximateRows, len(exactRows))
+
+	for _, approximateRow := range approximateRows {
+		exactRow := exactRows[approximateRow["field"].(string)]
+		for _, col := range []string{"count", "distinct_count", "numeric_count", "min", "max", "mean"} {
+			assert.Equal(t, exactRow[col], approximateRow[col], "field: %v, col: %v", approximateRow["field"], col)
+		}
+
+		assert.Equal(t, int64(1), exactRow["is_exact"])
+		assert.Equal(t, int64(0), approximateRow["is_exact"])
+		assert.Contains(t, exactRow, "values")
+		assert.NotContains(t, approximateRow, "values")
+		assert.NotContains(t, approximateRow, "stdev")
+	}
+	assert.InDelta(t, 3.559, exactRows["latency"]["stdev"], 0.001)
+	assert.NotContains(t, exactRows["method"], "stdev")
+}
+
+func Test_extractKeyValuePairs(t *testing.T) {
+	extractReq := &structs.ExtractRequest{Field: "_raw", PairDelims: " \t,;", KVDelims: "=", Limit: 50}
+
+	keys, values := extractKeyValuePairs(extractReq, `k1=v1 k2="v 2",k3='a \'b\'' junk user.name=bob k1=again empty= =novalue`)
+	assert.Equal(t, []string{"k1", "k2", "k3", "user_name", "empty"}, keys)
+	assert.Equal(t, []string{"v1", "v 2", "a 'b'", "bob", ""}, values)
+
+	extractReq.Limit = 2
+	keys, _ = extractKeyValuePairs(extractReq, `a=1 b=2 c=3`)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	extractReq = &structs.ExtractRequest{Field: "_raw", PairDelims: "|", KVDelims: ":"}
+	keys, values = extractKeyValuePairs(extractReq, `host:web 1|status:200|path:/a=b`)
+	assert.Equal(t, []string{"host", "status", "path"}, keys)
+	assert.Equal(t, []string{"web 1", "200", "/a=b"}, values)
+
+	keys, _ = extractKeyValuePairs(extractReq, `"unterminated`)
+	assert.Len(t, keys, 0)
+}
+
+func Test_performExtractRequest(t *testing.T) {
+	letColReq := &structs.LetColumnsRequest{
+		ExtractRequest: &structs.ExtractRequest{Field: "_raw", PairDelims: " ", KVDelims: "=", Limit: 50},
+	}
+
+	recs := map[string]map[string]interface{}{
+		"1": {"_raw": `user=alice status=500 host=ignored`, "host": "web1"},
+		"2": {"_raw": `user=bob msg="timed out"`},
+		"3": {"host": "web2"},
+	}
+	finalCols := map[string]bool{"_raw": true, "host": true}
+
+	performExtractRequest(nil, letColReq, recs, finalCols)
+	assert.Equal(t, map[string]bool{"_raw": true, "host": true, "user": true, "status": true, "msg": true}, finalCols)
+	assert.Equal(t, "alice", recs["1"]["user"])
+	assert.Equal(t, "500", recs["1"]["status"])
+	assert.Equal(t, "web1", recs["1"]["host"])
+	assert.Equal(t, "timed out", recs["2"]["msg"])
+	assert.Equal(t, map[string]interface{}{"host": "web2"}, recs["3"])
+}
+
+func Test_getNumericValue(t *testing.T) {
+	for _, value := range []interface{}{int64(3), uint64(3), 3.0, "3", json.Number("3")} {
+		number, ok := getNumericValue(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, 3.0, number, value)
+	}
+
+	for _, value := range []interface{}{nil, true, "", "abc", []string{"1"}, []interface{}{1}} {
+		_, ok := getNumericValue(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"fmt"
+
+	"github.com/siglens/siglens/pkg/segment/structs"
+	putils "github.com/siglens/siglens/pkg/utils"
+)
+
+func performSequentialRequest(nodeResult *structs.NodeResult, letColReq *structs.LetColumnsRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, finishesSegment bool) error {
+
+	seqReq := letColReq.SequentialRequest
+	if recs != nil {
+		return performSequentialRequestOnRawRecord(seqReq, recs, recordIndexInFinal, finalCols, finishesSegment)
+	}
+
+	// The histogram and the measure results hold the same rows, so each of them starts over.
+	if len(nodeResult.Histogram) > 0 {
+		resetSequentialState(seqReq)
+		performSequentialRequestOnHistogram(nodeResult, seqReq)
+	}
+
+	if len(nodeResult.MeasureResults) > 0 {
+		resetSequentialState(seqReq)
+		performSequentialRequestOnMeasureResults(nodeResult, seqReq)
+	}
+
+	return nil
+}
+
+func resetSequentialState(seqReq *structs.SequentialRequest) {
+	seqReq.PrevValues = nil
+	seqReq.RunningTotal = 0
+}
+
+// Returns the fields that are set on the records; autoregress without a new field name
+// sets one field per number of records to look back.
+func getSequentialOutputFields(seqReq *structs.SequentialRequest) []string {
+	if seqReq.CmdType != structs.SequentialAutoregress || seqReq.NewField != "" {
+		return []string{seqReq.NewField}
+	}
+
+	fields := make([]string, 0, seqReq.PEnd-seqReq.PStart+1)
+	for p := seqReq.PStart; p <= seqReq.PEnd; p++ {
+		fields = append(fields, fmt.Sprintf("%v_p%v", seqReq.Field, p))
+	}
+	return fields
+}
+
+// Sets the output fields of the record from the values of the previous records, then adds
+// the value of the record to the state for the records after it.
+func applySequentialRequest(seqReq *structs.SequentialRequest, record map[string]interface{}) {
+	value := record[seqReq.Field]
+
+	switch seqReq.CmdType {
+	case structs.SequentialDelta:
+		if seqReq.PEnd <= uint64(len(seqReq.PrevValues)) {
+			currNumber, currOk := getNumericValue(value)
+			prevNumber, prevOk := getNumericValue(seqReq.PrevValues[seqReq.PEnd-1])
+			if currOk && prevOk {
+				record[seqReq.NewField] = currNumber - prevNumber
+			}
+		}
+	case structs.SequentialAccum:
+		// Only the numeric values are added up; the other records are left unchanged.
+		if number, ok := getNumericValue(value); ok {
+			seqReq.RunningTotal += number
+			record[seqReq.NewField] = seqReq.RunningTotal
+		}
+		return
+	case structs.SequentialAutoregress:
+		for i, field := range getSequentialOutputFields(seqReq) {
+			p := seqReq.PStart + uint64(i)
+			if p > uint64(len(seqReq.PrevValues)) {
+				break
+			}
+			if prevValue := seqReq.PrevValues[p-1]; prevValue != nil {
+				record[field] = prevValue
+			}
+		}
+	case structs.SequentialSMA, structs.SequentialEMA, structs.SequentialWMA:
+		applyTrendline(seqReq, record, value)
+		return
+	}
+
+	seqReq.PrevValues = append([]interface{}{value}, seqReq.PrevValues...)
+	if uint64(len(seqReq.PrevValues)) > seqReq.PEnd {
+		seqReq.PrevValues = seqReq.PrevValues[:seqReq.PEnd]
+	}
+}
+
+// The moving averages only use the numeric values of the field; the sma and the wma are only
+// set once there are enough values for a whole period, while the ema starts from the first value.
+func applyTrendline(seqReq *structs.SequentialRequest, record map[string]interface{}, value interface{}) {
+	number, ok := getNumericValue(value)
+	if !ok {
+		return
+	}
+
+	if seqReq.CmdType == structs.SequentialEMA {
+		if len(seqReq.PrevValues) == 0 {
+			seqReq.RunningTotal = number
+			seqReq.PrevValues = []interface{}{number}
+		} else {
+			alpha := 2 / (float64(seqReq.PEnd) + 1)
+			seqReq.RunningTotal = alpha*number + (1-alpha)*seqReq.RunningTotal
+		}
+		record[seqReq.NewField] = seqReq.RunningTotal
+		return
+	}
+
+	seqReq.PrevValues = append([]interface{}{number}, seqReq.PrevValues...)
+	if uint64(len(seqReq.PrevValues)) > seqReq.PEnd {
+		seqReq.PrevValues = seqReq.PrevValues[:seqReq.PEnd]
+	}
+	if uint64(len(seqReq.PrevValues)) < seqReq.PEnd {
+		return
+	}
+
+	// The wma weighs the latest value by the period, down to 1 for the oldest value.
+	sum, totalWeight := 0.0, 0.0
+	for i, prevValue := range seqReq.PrevValues {
+		weight := 1.0
+		if seqReq.CmdType == structs.SequentialWMA {
+			weight = float64(seqReq.PEnd) - float64(i)
+		}
+		sum += weight * prevValue.(float64)
+		totalWeight += weight
+	}
+	record[seqReq.NewField] = sum / totalWeight
+}
+
+// Like streamstats, the records of each segment are held back until the segment is finished
+// so that they are processed in the order of the results.
+func performSequentialRequestOnRawRecord(seqReq *structs.SequentialRequest, recs map[string]map[string]interface{},
+	recordIndexInFinal map[string]int, finalCols map[string]bool, finishesSegment bool) error {
+
+	if seqReq.SegmentRecords == nil {
+		seqReq.SegmentRecords = make(map[string]map[string]interface{}, 0)
+	}
+
+	for recordKey, record := range recs {
+		seqReq.SegmentRecords[recordKey] = record
+		delete(recs, recordKey)
+	}
+
+	if !finishesSegment {
+		return nil
+	}
+
+	currentOrder, err := GetOrderedRecs(seqReq.SegmentRecords, recordIndexInFinal)
+	if err != nil {
+		return fmt.Errorf("performSequentialRequestOnRawRecord: Error while fetching the order of the records, err: %v", err)
+	}
+
+	for _, recordKey := range currentOrder {
+		record, exist := seqReq.SegmentRecords[recordKey]
+		if !exist {
+			return fmt.Errorf("performSequentialRequestOnRawRecord: Error: record not found")
+		}
+		applySequentialRequest(seqReq, record)
+	}
+
+	for _, field := range getSequentialOutputFields(seqReq) {
+		finalCols[field] = true
+	}
+
+	for recordKey, record := range seqReq.SegmentRecords {
+		recs[recordKey] = record
+		delete(seqReq.SegmentRecords, recordKey)
+	}
+
+	return nil
+}
+
+func performSequentialRequestOnHistogram(nodeResult *structs.NodeResult, seqReq *structs.SequentialRequest) {
+	outputFields := getSequentialOutputFields(seqReq)
+
+	for _, aggregationResult := range nodeResult.Histogram {
+		if len(aggregationResult.Results) == 0 {
+			continue
+		}
+
+		table := getHistogramResultTable(nodeResult, aggregationResult, nodeResult.ColumnsOrder)
+		for rowIndex, bucketResult := range aggregationResult.Results {
+			row := table.rows[rowIndex]
+			applySequentialRequest(seqReq, row)
+
+			// The groupby columns are the keys of the buckets, so they are left unchanged.
+			for _, field := range outputFields {
+				value, ok := row[field]
+				if !ok || putils.SliceContainsString(table.groupByCols, field) {
+					continue
+				}
+				bucketResult.StatRes[field] = CreateCValueFromColValue(value)
+			}
+		}
+	}
+}
+
+func performSequentialRequestOnMeasureResults(nodeResult *structs.NodeResult, seqReq *structs.SequentialRequest) {
+	outputFields := getSequentialOutputFields(seqReq)
+
+	table := getMeasureResultsTable(nodeResult, nodeResult.ColumnsOrder)
+	for rowIndex, bucketHolder := range nodeResult.MeasureResults {
+		row := table.rows[rowIndex]
+		applySequentialRequest(seqReq, row)
+
+		for _, field := range outputFields {
+			value, ok := row[field]
+			if !ok || putils.SliceContainsString(table.groupByCols, field) {
+				continue
+			}
+			bucketHolder.MeasureVal[field] = value
+		}
+	}
+
+	newCols := make([]string, 0, len(outputFields))
+	for _, field := range outputFields {
+		if !putils.SliceContainsString(table.groupByCols, field) {
+			newCols = append(newCols, field)
+		}
+	}
+	addMeasureResultsColumns(nodeResult, newCols)
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package aggregations
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	// The values of the records are held as interface{}, so gob has to know each type they
+	// can have besides its basic types: the multivalue fields, the objects and arrays of
+	// spath and the values decoded from JSON.
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(json.Number(""))
+	gob.Register(time.Time{})
+}
+
+type sortedRecord struct {
+	Key        string
+	SortValues []structs.SortValue
+	Record     map[string]interface{}
+}
+
+func getSortValues(sortExpr *structs.SortExpr, record map[string]interface{}) []structs.SortValue {
+	sortValues := make([]structs.SortValue, len(sortExpr.SortEles))
+	for i, sortEle := range sortExpr.SortEles {
+		val, exists := record[sortEle.Field]
+		if !exists {
+			val = nil
+		}
+
+		sortValues[i].Val = fmt.Sprintf("%v", val)
+		sortValues[i].InterpretAs = sortEle.Op
+	}
+
+	return sortValues
+}
+
+func compareSortedRecords(sortExpr *structs.SortExpr, rec1 *sortedRecord, rec2 *sortedRecord) int {
+	comparisonRes, err := structs.CompareSortValueSlices(rec1.SortValues, rec2.SortValues, sortExpr.SortAscending)
+	if err != nil {
+		log.Errorf("compareSortedRecords: failed to compare the sort values, err: %v", err)
+		return 0
+	}
+	return comparisonRes
+}
+
+func getSortedRecords(sortExpr *structs.SortExpr, recs map[string]map[string]interface{}) []*sortedRecord {
+	sortedRecs := make([]*sortedRecord, 0, len(recs))
+	for recordKey, record := range recs {
+		sortedRecs = append(sortedRecs, &sortedRecord{
+			Key:        recordKey,
+			SortValues: getSortValues(sortExpr, record),
+			Record:     record,
+		})
+	}
+
+	sort.SliceStable(sortedRecs, func(i, j int) bool {
+		return compareSortedRecords(sortExpr, sortedRecs[i], sortedRecs[j]) < 0
+	})
+
+	return sortedRecs
+}
+
+func getSortSpillDir() string {
+	return config.GetCurrentNodeQueryDir() + "sort/"
+}
+
+// Writes the buffered records of the sort to disk as a sorted run, so that the memory
+// used by a sort stays within its budget no matter how many records it has to order.
+func spillSortRecords(sortExpr *structs.SortExpr, recordIndexInFinal map[string]int) error {
+	sortedRecs := getSortedRecords(sortExpr, sortExpr.SortRecords)
+
+	// The records after the limit can never be part of the results.
+	if sortExpr.Limit < uint64(len(sortedRecs)) {
+		sortedRecs = sortedRecs[:sortExpr.Limit]
+	}
+
+	spillDir := getSortSpillDir()
+	err := os.MkdirAll(spillDir, 0764)
+	if err != nil {
+		return fmt.Errorf("spillSortRecords: failed to create directory %v, err: %v", spillDir, err)
+	}
+
+	file, err := os.CreateTemp(spillDir, "sortrun-*.gob")
+	if err != nil {
+		return fmt.Errorf("spillSortRecords: failed to create a file in %v, err: %v", spillDir, err)
+	}
+	sortExpr.SpillFiles = append(sortExpr.SpillFiles, file.Name())
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+	for _, sortedRec := range sortedRecs {
+		err = encoder.Encode(sortedRec)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("spillSortRecords: failed to write record %v to %v, err: %v", sortedRec.Key, file.Name(), err)
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("spillSortRecords: failed to flush %v, err: %v", file.Name(), err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("spillSortRecords: failed to close %v, err: %v", file.Name(), err)
+	}
+
+	// The records which are kept are indexed again once they are merged.
+	for recordKey := range sortExpr.SortRecords {
+		delete(recordIndexInFinal, recordKey)
+	}
+	sortExpr.SortRecords = make(map[string]map[string]interface{}, 0)
+	sortExpr.SortRecordsBytes = 0
+
+	return nil
+}
+
+// Removes the spill files that the sorts of the query still have. The files are normally
+// removed when the sorted runs are merged, but a query that is cancelled or fails stops
+// before that, so this is deferred for the whole query.
+func RemoveSortSpillFilesInChain(aggs *structs.QueryAggregators) {
+	for agg := aggs; agg != nil; agg = agg.Next {
+		if agg.HasSortBlock() {
+			removeSortSpillFiles(agg.OutputTransforms.LetColumns.SortColRequest)
+		}
+	}
+}
+
+func removeSortSpillFiles(sortExpr *structs.SortExpr) {
+	for _, fileName := range sortExpr.SpillFiles {
+		err := os.Remove(fileName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("removeSortSpillFiles: failed to remove %v, err: %v", fileName, err)
+		}
+	}
+	sortExpr.SpillFiles = nil
+}
+
+// A sorted run of records, either read back from a spill file or still in memory.
+type sortRun struct {
+	file    *os.File
+	decoder *gob.Decoder
+	records []*sortedRecord
+}
+
+func (run *sortRun) next() (*sortedRecord, error) {
+	if run.decoder == nil {
+		if len(run.records) == 0 {
+			return nil, nil
+		}
+		sortedRec := run.records[0]
+		run.records = run.records[1:]
+		return sortedRec, nil
+	}
+
+	sortedRec := &sortedRecord{}
+	err := run.decoder.Decode(sortedRec)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("sortRun.next: failed to read a record from %v, err: %v", run.file.Name(), err)
+	}
+	return sortedRec, nil
+}
+
+type sortRunHead struct {
+	record   *sortedRecord
+	runIndex int
+}
+
+// A heap with the next record of each run, like SortedResultRecords in blockresults/segheap.go.
+type sortRunHeap struct {
+	heads    []*sortRunHead
+	sortExpr *structs.SortExpr
+}
+
+func (h sortRunHeap) Len() int { return len(h.heads) }
+
+func (h sortRunHeap) Less(i, j int) bool {
+	comparisonRes := compareSortedRecords(h.sortExpr, h.heads[i].record, h.heads[j].record)
+	if comparisonRes != 0 {
+		return comparisonRes < 0
+	}
+	// Equal records keep the order of the runs.
+	return h.heads[i].runIndex < h.heads[j].runIndex
+}
+
+func (h sortRunHeap) Swap(i, j int) {
+	h.heads[i], h.heads[j] = h.heads[j], h.heads[i]
+}
+
+func (h *sortRunHeap) Push(x interface{}) {
+	h.heads = append(h.heads, x.(*sortRunHead))
+}
+
+func (h *sortRunHeap) Pop() interface{} {
+	old := h.heads
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil // avoid memory leak
+	h.heads = old[0 : n-1]
+	return item
+}
+
+// Merges the spilled runs with the records still in memory and returns the first Limit
+// records in order. The spill files are removed once they are merged.
+func mergeSortRuns(sortExpr *structs.SortExpr, inMemoryRecs []*sortedRecord) ([]*sortedRecord, error) {
+	defer removeSortSpillFiles(sortExpr)
+
+	runs := make([]*sortRun, 0, len(sortExpr.SpillFiles)+1)
+	defer func() {
+		for _, run := range runs {
+			if run.file != nil {
+				run.file.Close()
+			}
+		}
+	}()
+
+	for _, fileName := range sortExpr.SpillFiles {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("mergeSortRuns: failed to open %v, err: %v", fileName, err)
+		}
+		runs = append(runs, &sortRun{file: file, decoder: gob.NewDecoder(bufio.NewReader(file))})
+	}
+	runs = append(runs, &sortRun{records: inMemoryRecs})
+
+	runHeap := &sortRunHeap{sortExpr: sortExpr}
+	for runIndex, run := range runs {
+		sortedRec, err := run.next()
+		if err != nil {
+			return nil, fmt.Errorf("mergeSortRuns: %v", err)
+		}
+		if sortedRec != nil {
+			runHeap.heads = append(runHeap.heads, &sortRunHead{record: sortedRec, runIndex: runIndex})
+		}
+	}
+	heap.Init(runHeap)
+
+	mergedRecs := make([]*sortedRecord, 0)
+	for runHeap.Len() > 0 && uint64(len(mergedRecs)) < sortExpr.Limit {
+		head := heap.Pop(runHeap).(*sortRunHead)
+		mergedRecs = append(mergedRecs, head.record)
+
+		sortedRec, err := runs[head.runIndex].next()
+		if err != nil {
+			return nil, fmt.Errorf("mergeSortRuns: %v", err)
+		}
+		if sortedRec != nil {
+			heap.Push(runHeap, &sortRunHead{record: sortedRec, runIndex: head.runIndex})
+		}
+	}
+
+	return mergedRecs, nil
+}
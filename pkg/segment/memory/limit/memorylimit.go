@@ -19,6 +19,7 @@ package limit
 
 import (
 	"errors"
+	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -191,3 +192,35 @@ func RequestSearchMemory(sLimit uint64) error {
 	printMemoryManagerSummary()
 	return errors.New("failed to allocate resources for segment search")
 }
+
+// Checks if a join hash table of the given size can be held in memory.
+// The hash table is capped to a percentage of the memory allocated for segsearch.
+func RequestJoinMemory(hashTableBytes uint64) error {
+	if memory.GlobalMemoryTracker == nil {
+		return nil
+	}
+
+	maxJoinBytes := uint64(float64(atomic.LoadUint64(&memory.GlobalMemoryTracker.SegSearchRequestedBytes)) * utils.JOIN_MEM_PERCENT / 100)
+	if hashTableBytes <= maxJoinBytes {
+		return nil
+	}
+
+	return fmt.Errorf("RequestJoinMemory: join subsearch needs %v MB which exceeds the limit of %v MB",
+		utils.ConvertUintBytesToMB(hashTableBytes), utils.ConvertUintBytesToMB(maxJoinBytes))
+}
+
+// Checks if the records buffered for a sort can be held in memory.
+// The records are capped to a percentage of the memory allocated for segsearch.
+func RequestSortMemory(recordsBytes uint64) error {
+	if memory.GlobalMemoryTracker == nil {
+		return nil
+	}
+
+	maxSortBytes := uint64(float64(atomic.LoadUint64(&memory.GlobalMemoryTracker.SegSearchRequestedBytes)) * utils.SORT_MEM_PERCENT / 100)
+	if recordsBytes <= maxSortBytes {
+		return nil
+	}
+
+	return fmt.Errorf("RequestSortMemory: sort needs %v MB which exceeds the limit of %v MB",
+		utils.ConvertUintBytesToMB(recordsBytes), utils.ConvertUintBytesToMB(maxSortBytes))
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"fmt"
+	"sort"
+
+	dtu "github.com/siglens/siglens/pkg/common/dtypeutils"
+	"github.com/siglens/siglens/pkg/segment/aggregations"
+	"github.com/siglens/siglens/pkg/segment/query/metadata"
+	"github.com/siglens/siglens/pkg/segment/reader/segread"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/writer"
+	"github.com/siglens/siglens/pkg/segment/writer/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// When approximate=true, the search matches all the records and fieldsummary is the first
+// command, the summary only needs the segment stats of each column, so fieldsummary is turned into a generating
+// command and the records are never read. All the records must be in rotated segments that
+// are fully in the time range, since the stats of a segment cannot be split.
+func useFieldSummarySegStats(searchNode *structs.SearchNode, aggs *structs.QueryAggregators, timeRange *dtu.TimeRange,
+	indexNames []string, orgid uint64, qid uint64) bool {
+
+	if searchNode == nil || searchNode.NodeType != structs.MatchAllQuery || !aggs.HasFieldSummaryBlock() {
+		return false
+	}
+
+	// The segment stats give a different summary, so they are only used when it is asked for.
+	if !aggs.OutputTransforms.LetColumns.FieldSummaryRequest.Approximate {
+		return false
+	}
+
+	_, _, numUnrotated := writer.FilterUnrotatedSegmentsInQuery(timeRange, indexNames, orgid)
+	if numUnrotated > 0 {
+		log.Debugf("qid=%d, useFieldSummarySegStats: %v unrotated segments are in the time range", qid, numUnrotated)
+		return false
+	}
+
+	allSegKeys, _, _ := metadata.FilterSegmentsByTime(timeRange, indexNames, orgid)
+	for _, indexSegKeys := range allSegKeys {
+		for segKey, segInfo := range indexSegKeys {
+			if !timeRange.AreTimesFullyEnclosed(segInfo.TimeRange.StartEpochMs, segInfo.TimeRange.EndEpochMs) {
+				log.Debugf("qid=%d, useFieldSummarySegStats: segKey %v is only partly in the time range", qid, segKey)
+				return false
+			}
+		}
+	}
+
+	fieldSummaryReq := aggs.OutputTransforms.LetColumns.FieldSummaryRequest
+	aggs.PipeCommandType = structs.GenerateEventType
+	aggs.OutputTransforms = nil
+	aggs.GenerateEvent = &structs.GenerateEvent{FieldSummary: fieldSummaryReq}
+
+	return true
+}
+
+// Merges the segment stats of all the segments in the time range and summarizes their columns.
+func performFieldSummary(aggs *structs.QueryAggregators, timeRange *dtu.TimeRange, indexNames []string, orgid uint64, qid uint64) error {
+	allSegKeys, _, _ := metadata.FilterSegmentsByTime(timeRange, indexNames, orgid)
+	segKeys := make([]string, 0)
+	for _, indexSegKeys := range allSegKeys {
+		for segKey := range indexSegKeys {
+			segKeys = append(segKeys, segKey)
+		}
+	}
+	sort.Strings(segKeys)
+
+	sstMap := make(map[string]*structs.SegStats)
+	for _, segKey := range segKeys {
+		segSstMap, err := segread.ReadSegStats(segKey, qid)
+		if err != nil {
+			return fmt.Errorf("performFieldSummary: failed to read the segment stats of segKey %v, err: %v", segKey, err)
+		}
+		sstMap = stats.MergeSegStats(sstMap, segSstMap)
+	}
+
+	records, cols := aggregations.GetFieldSummaryFromSegStats(aggs.GenerateEvent.FieldSummary, sstMap)
+
+	aggregations.InitGenEvent(aggs)
+	aggregations.SetGeneratedCols(aggs.GenerateEvent, cols)
+	for i, record := range records {
+		recordKey := fmt.Sprintf("%v_%v", aggs.GenerateEvent.EventPosition, i)
+		aggs.GenerateEvent.GeneratedRecords[recordKey] = record
+		aggs.GenerateEvent.GeneratedRecordsIndex[recordKey] = i
+	}
+
+	return nil
+}
@@ -110,6 +110,7 @@ type RunningQueryState struct {
 	nodeResult               *structs.NodeResult
 	totalRecsToBeSearched    uint64
 	AllColsInAggs            map[string]struct{}
+	warnings                 []string
 }
 
 var allRunningQueries = map[uint64]*RunningQueryState{}
@@ -415,6 +416,34 @@ func GetOrCreateQuerySearchNodeResult(qid uint64) (*structs.NodeResult, error) {
 	return rQuery.nodeResult, nil
 }
 
+// Adds warnings which are sent along with the results of the query.
+func AddQueryWarnings(qid uint64, warnings []string) {
+	arqMapLock.RLock()
+	rQuery, ok := allRunningQueries[qid]
+	arqMapLock.RUnlock()
+	if !ok {
+		log.Errorf("AddQueryWarnings: qid %+v does not exist!", qid)
+		return
+	}
+
+	rQuery.rqsLock.Lock()
+	rQuery.warnings = append(rQuery.warnings, warnings...)
+	rQuery.rqsLock.Unlock()
+}
+
+func GetQueryWarnings(qid uint64) ([]string, error) {
+	arqMapLock.RLock()
+	rQuery, ok := allRunningQueries[qid]
+	arqMapLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("GetQueryWarnings: qid %+v does not exist", qid)
+	}
+
+	rQuery.rqsLock.Lock()
+	defer rQuery.rqsLock.Unlock()
+	return rQuery.warnings, nil
+}
+
 func CancelQuery(qid uint64) {
 	LogGlobalSearchErrors(qid)
 	arqMapLock.RLock()
@@ -100,7 +100,7 @@ func queryMetricsLooper() {
 }
 
 func GetNodeAndQueryTypes(sNode *structs.SearchNode, aggs *structs.QueryAggregators) (structs.SearchNodeType, structs.QueryType) {
-	if aggs != nil && aggs.GroupByRequest != nil && aggs.StreamStatsOptions == nil {
+	if aggs != nil && aggs.GroupByRequest != nil && aggs.StreamStatsOptions == nil && aggs.EventStatsOptions == nil {
 		if aggs.GroupByRequest.MeasureOperations != nil && aggs.GroupByRequest.GroupByColumns == nil {
 			return sNode.NodeType, structs.SegmentStatsCmd
 		}
@@ -108,7 +108,7 @@ func GetNodeAndQueryTypes(sNode *structs.SearchNode, aggs *structs.QueryAggregat
 			return sNode.NodeType, structs.GroupByCmd
 		}
 	}
-	if aggs != nil && aggs.MeasureOperations != nil && aggs.GroupByRequest == nil && aggs.StreamStatsOptions == nil {
+	if aggs != nil && aggs.MeasureOperations != nil && aggs.GroupByRequest == nil && aggs.StreamStatsOptions == nil && aggs.EventStatsOptions == nil {
 		return sNode.NodeType, structs.SegmentStatsCmd
 	}
 	return sNode.NodeType, structs.RRCCmd
@@ -140,7 +140,7 @@ func ApplyVectorArithmetic(aggs *structs.QueryAggregators, qid uint64) *structs.
 	return nodeRes
 }
 
-func GenerateEvents(aggs *structs.QueryAggregators, qid uint64) *structs.NodeResult {
+func GenerateEvents(aggs *structs.QueryAggregators, timeRange *dtu.TimeRange, qc *structs.QueryContext, qid uint64) *structs.NodeResult {
 
 	if aggs.GenerateEvent == nil {
 		return nil
@@ -163,6 +163,32 @@ func GenerateEvents(aggs *structs.QueryAggregators, qid uint64) *structs.NodeRes
 		if err != nil {
 			log.Errorf("qid=%d, Failed to perform input lookup! Error: %v", qid, err)
 		}
+	} else if aggs.GenerateEvent.MakeResults != nil {
+		err := aggregations.PerformMakeResults(aggs)
+		if err != nil {
+			log.Errorf("qid=%d, Failed to make results! Error: %v", qid, err)
+		}
+	} else if aggs.GenerateEvent.Multisearch != nil {
+		err := aggregations.PerformMultisearch(aggs)
+		if err != nil {
+			log.Errorf("qid=%d, Failed to merge the multisearch results! Error: %v", qid, err)
+		}
+	} else if aggs.GenerateEvent.TStats != nil {
+		warnings, err := performTStats(aggs, timeRange, qc.TableInfo.GetQueryTables(), qc.Orgid, qid)
+		if err != nil {
+			log.Errorf("qid=%d, Failed to compute tstats! Error: %v", qid, err)
+			nodeRes.ErrList = append(nodeRes.ErrList, err)
+		}
+		if len(warnings) > 0 {
+			nodeRes.Warnings = append(nodeRes.Warnings, warnings...)
+			AddQueryWarnings(qid, warnings)
+		}
+	} else if aggs.GenerateEvent.FieldSummary != nil {
+		err := performFieldSummary(aggs, timeRange, qc.TableInfo.GetQueryTables(), qc.Orgid, qid)
+		if err != nil {
+			log.Errorf("qid=%d, Failed to compute fieldsummary! Error: %v", qid, err)
+			nodeRes.ErrList = append(nodeRes.ErrList, err)
+		}
 	}
 
 	if aggs.HasGeneratedEventsWithoutSearch() {
@@ -249,8 +275,11 @@ func ApplyFilterOperator(node *structs.ASTNode, timeRange *dtu.TimeRange, aggs *
 				bucketLimit = aggs.BucketLimit
 			}
 			aggs.BucketLimit = bucketLimit
+			if useFieldSummarySegStats(searchNode, aggs, timeRange, nonKibanaIndices, qc.Orgid, qid) {
+				log.Infof("qid=%d, ApplyFilterOperator: computing fieldsummary from the segment stats", qid)
+			}
 			if aggs.HasGenerateEvent() {
-				nodeRes := GenerateEvents(aggs, qid)
+				nodeRes := GenerateEvents(aggs, timeRange, qc, qid)
 				if aggs.HasGeneratedEventsWithoutSearch() {
 					return nodeRes
 				}
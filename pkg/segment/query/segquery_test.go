@@ -18,7 +18,9 @@
 package query
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +31,7 @@ import (
 	"github.com/siglens/siglens/pkg/segment/memory/limit"
 	"github.com/siglens/siglens/pkg/segment/query/metadata"
 	"github.com/siglens/siglens/pkg/segment/query/summary"
+	"github.com/siglens/siglens/pkg/segment/results/segresults"
 	. "github.com/siglens/siglens/pkg/segment/structs"
 	. "github.com/siglens/siglens/pkg/segment/utils"
 	serverutils "github.com/siglens/siglens/pkg/server/utils"
@@ -426,3 +429,157 @@ func Test_segQueryFilter(t *testing.T) {
 	err = os.RemoveAll("data/")
 	assert.Nil(t, err)
 }
+
+func Test_getTStatsSpanStart(t *testing.T) {
+	hourMillis := uint64(3600_000)
+
+	spanStart, crossesSpans := getTStatsSpanStart(&dtu.TimeRange{StartEpochMs: 2*hourMillis + 10, EndEpochMs: 3*hourMillis - 1}, hourMillis)
+	assert.Equal(t, 2*hourMillis, spanStart)
+	assert.False(t, crossesSpans)
+
+	// A segment that crosses a span boundary is in the span where it starts.
+	spanStart, crossesSpans = getTStatsSpanStart(&dtu.TimeRange{StartEpochMs: 2*hourMillis + 10, EndEpochMs: 3*hourMillis + 20}, hourMillis)
+	assert.Equal(t, 2*hourMillis, spanStart)
+	assert.True(t, crossesSpans)
+
+	spanStart, crossesSpans = getTStatsSpanStart(&dtu.TimeRange{StartEpochMs: 10, EndEpochMs: 5 * hourMillis}, 0)
+	assert.Equal(t, uint64(0), spanStart)
+	assert.False(t, crossesSpans)
+}
+
+func Test_getTStatsWarnings(t *testing.T) {
+	assert.Nil(t, getTStatsWarnings(nil, nil))
+
+	hourMillis := uint64(3600_000)
+	skippedSegments := []string{
+		getTStatsSkippedSegment("seg2", &dtu.TimeRange{StartEpochMs: hourMillis, EndEpochMs: 2 * hourMillis}, "still being written"),
+		getTStatsSkippedSegment("seg1", &dtu.TimeRange{StartEpochMs: 0, EndEpochMs: hourMillis}, "only partly in the time range"),
+	}
+	warnings := getTStatsWarnings(skippedSegments, nil)
+	assert.Equal(t, []string{"tstats left out the events of 2 segments, since their pre-aggregated data cannot be split by time: " +
+		"seg1 (1970-01-01T00:00:00Z to 1970-01-01T01:00:00Z, only partly in the time range), " +
+		"seg2 (1970-01-01T01:00:00Z to 1970-01-01T02:00:00Z, still being written)"}, warnings)
+
+	skippedSegments = make([]string, 0)
+	for i := 0; i < tstatsMaxWarnedSegments+3; i++ {
+		skippedSegments = append(skippedSegments, getTStatsSkippedSegment(fmt.Sprintf("seg%02d", i), &dtu.TimeRange{}, "still being written"))
+	}
+	warnings = getTStatsWarnings(skippedSegments, nil)
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "tstats left out the events of 13 segments")
+	assert.Contains(t, warnings[0], "seg09")
+	assert.NotContains(t, warnings[0], "seg10")
+	assert.True(t, strings.HasSuffix(warnings[0], " and 3 more"))
+
+	spreadSegments := []string{
+		getTStatsSkippedSegment("seg3", &dtu.TimeRange{StartEpochMs: hourMillis + 10, EndEpochMs: 2*hourMillis + 10}, "counted from 1970-01-01T01:00:00Z"),
+	}
+	warnings = getTStatsWarnings(nil, spreadSegments)
+	assert.Equal(t, []string{"tstats counted all the events of 1 segments that cross a span boundary in the span where the segment starts," +
+		" since their pre-aggregated data cannot be split by time: seg3 (1970-01-01T01:00:00Z to 1970-01-01T02:00:00Z, counted from 1970-01-01T01:00:00Z)"}, warnings)
+}
+
+func getTestTStatsSegStats(count uint64, sum int64) map[string]*SegStats {
+	return map[string]*SegStats{
+		"latency": {
+			IsNumeric: true,
+			Count:     count,
+			NumStats: &NumericStats{
+				Min:   NumTypeEnclosure{Ntype: SS_DT_SIGNED_NUM, IntgrVal: 1},
+				Max:   NumTypeEnclosure{Ntype: SS_DT_SIGNED_NUM, IntgrVal: sum},
+				Sum:   NumTypeEnclosure{Ntype: SS_DT_SIGNED_NUM, IntgrVal: sum},
+				Dtype: SS_DT_SIGNED_NUM,
+			},
+		},
+		"host": {Count: count},
+	}
+}
+
+func Test_checkTStatsSegStats(t *testing.T) {
+	tstats := &TStats{
+		MeasureOperations: []*MeasureAggregator{
+			{MeasureCol: "*", MeasureFunc: Count},
+			{MeasureCol: "latency", MeasureFunc: Sum},
+		},
+	}
+	segCols := map[string]uint32{"latency": 8, "host": 0}
+	assert.Nil(t, checkTStatsSegStats(tstats, "seg1", segCols, getTestTStatsSegStats(3, 6)))
+
+	// A field that is in the segment must have segment stats.
+	assert.NotNil(t, checkTStatsSegStats(tstats, "seg1", segCols, map[string]*SegStats{"host": {Count: 3}}))
+
+	// A field that is not in the segment is skipped.
+	assert.Nil(t, checkTStatsSegStats(tstats, "seg1", map[string]uint32{"host": 0}, map[string]*SegStats{"host": {Count: 3}}))
+
+	// Only numeric fields have a sum.
+	tstats.MeasureOperations[1].MeasureCol = "host"
+	assert.NotNil(t, checkTStatsSegStats(tstats, "seg1", segCols, getTestTStatsSegStats(3, 6)))
+}
+
+func Test_getTStatsRecords(t *testing.T) {
+	config.SetTimeStampKey("timestamp")
+	tstats := &TStats{
+		MeasureOperations: []*MeasureAggregator{
+			{MeasureCol: "latency", MeasureFunc: Sum},
+			{MeasureCol: "latency", MeasureFunc: Max},
+		},
+		Span: &SpanLength{Num: 1, TimeScalr: TMHour},
+	}
+
+	buckets := make(map[uint64]*segresults.SearchResults)
+	for spanStart, sum := range map[uint64]int64{7200_000: 20, 3600_000: 6} {
+		bucket, err := initTStatsBucket(tstats, 0)
+		assert.Nil(t, err)
+		assert.Nil(t, bucket.UpdateSegmentStats(getTestTStatsSegStats(3, sum), tstats.MeasureOperations))
+		buckets[spanStart] = bucket
+	}
+
+	// The second segment of a span is merged into its stats.
+	assert.Nil(t, buckets[3600_000].UpdateSegmentStats(getTestTStatsSegStats(2, 4), tstats.MeasureOperations))
+
+	records, cols, err := getTStatsRecords(tstats, buckets)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"timestamp", "sum(latency)", "max(latency)"}, cols)
+	assert.Equal(t, []map[string]interface{}{
+		{"timestamp": uint64(3600_000), "sum(latency)": int64(10), "max(latency)": int64(6)},
+		{"timestamp": uint64(7200_000), "sum(latency)": int64(20), "max(latency)": int64(20)},
+	}, records)
+}
+
+func Test_useFieldSummarySegStats(t *testing.T) {
+	getAggs := func() *QueryAggregators {
+		return &QueryAggregators{
+			PipeCommandType: OutputTransformType,
+			OutputTransforms: &OutputTransforms{
+				LetColumns: &LetColumnsRequest{
+					FieldSummaryRequest: &FieldSummaryRequest{MaxVals: 10, Approximate: true},
+				},
+			},
+		}
+	}
+	timeRange := &dtu.TimeRange{StartEpochMs: 0, EndEpochMs: uint64(time.Now().UnixMilli())}
+	indexNames := []string{"test-fieldsummary-index"}
+
+	// A search that filters the records needs the records themselves.
+	aggs := getAggs()
+	assert.False(t, useFieldSummarySegStats(&SearchNode{NodeType: ColumnValueQuery}, aggs, timeRange, indexNames, 0, 1))
+	assert.True(t, aggs.HasFieldSummaryBlock())
+	assert.Nil(t, aggs.GenerateEvent)
+
+	// So does a fieldsummary after another command.
+	aggs = &QueryAggregators{PipeCommandType: OutputTransformType, OutputTransforms: &OutputTransforms{}, Next: getAggs()}
+	assert.False(t, useFieldSummarySegStats(&SearchNode{NodeType: MatchAllQuery}, aggs, timeRange, indexNames, 0, 1))
+
+	// The exact summary needs the records.
+	aggs = getAggs()
+	aggs.OutputTransforms.LetColumns.FieldSummaryRequest.Approximate = false
+	assert.False(t, useFieldSummarySegStats(&SearchNode{NodeType: MatchAllQuery}, aggs, timeRange, indexNames, 0, 1))
+	assert.Nil(t, aggs.GenerateEvent)
+
+	aggs = getAggs()
+	assert.True(t, useFieldSummarySegStats(&SearchNode{NodeType: MatchAllQuery}, aggs, timeRange, indexNames, 0, 1))
+	assert.Equal(t, GenerateEventType, aggs.PipeCommandType)
+	assert.Nil(t, aggs.OutputTransforms)
+	assert.Equal(t, uint64(10), aggs.GenerateEvent.FieldSummary.MaxVals)
+	assert.True(t, aggs.HasGeneratedEventsWithoutSearch())
+}
@@ -0,0 +1,407 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dtu "github.com/siglens/siglens/pkg/common/dtypeutils"
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/aggregations"
+	"github.com/siglens/siglens/pkg/segment/query/metadata"
+	"github.com/siglens/siglens/pkg/segment/reader/segread"
+	"github.com/siglens/siglens/pkg/segment/results/segresults"
+	"github.com/siglens/siglens/pkg/segment/search"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	segutils "github.com/siglens/siglens/pkg/segment/utils"
+	"github.com/siglens/siglens/pkg/segment/writer"
+	log "github.com/sirupsen/logrus"
+)
+
+// The most segments that the warning about the left out segments names.
+const tstatsMaxWarnedSegments = 10
+
+// The functions that the agile trees can compute. The trees only store the min, max, sum
+// and count of each measure column.
+var tstatsAgileTreeFuncs = map[segutils.AggregateFunctions]struct{}{
+	segutils.Count: {},
+	segutils.Sum:   {},
+	segutils.Avg:   {},
+	segutils.Min:   {},
+	segutils.Max:   {},
+	segutils.Range: {},
+}
+
+// Computes tstats from the agile trees and the segment stats of the rotated segments, and
+// never reads the raw columns. The unrotated segments and the segments that are only partly
+// in the time range are left out, since their pre-aggregated data cannot be split by time.
+// For the same reason, all the events of a segment that crosses a span boundary are counted
+// in the span where the segment starts, so the spans are approximate. The returned warnings
+// name these segments along with the time they cover.
+func performTStats(aggs *structs.QueryAggregators, timeRange *dtu.TimeRange, indexNames []string, orgid uint64, qid uint64) ([]string, error) {
+	tstats := aggs.GenerateEvent.TStats
+	if len(tstats.IndexNames) > 0 {
+		indexNames = structs.InitTableInfo(strings.Join(tstats.IndexNames, ","), orgid, false).GetQueryTables()
+	}
+
+	if len(tstats.GroupByColumns) > 0 {
+		for _, measureAgg := range tstats.MeasureOperations {
+			if _, ok := tstatsAgileTreeFuncs[measureAgg.MeasureFunc]; !ok {
+				return nil, fmt.Errorf("performTStats: %v is not pre-aggregated in the agile trees", measureAgg.String())
+			}
+		}
+	}
+
+	spanMillis := uint64(0)
+	if tstats.Span != nil {
+		spanMillis = aggregations.GetIntervalInMillis(tstats.Span.Num, tstats.Span.TimeScalr)
+	}
+
+	allSegKeys, _, _ := metadata.FilterSegmentsByTime(timeRange, indexNames, orgid)
+	segKeys := make([]string, 0)
+	segInfos := make(map[string]*structs.SegmentByTimeAndColSizes)
+	for _, indexSegKeys := range allSegKeys {
+		for segKey, segInfo := range indexSegKeys {
+			segKeys = append(segKeys, segKey)
+			segInfos[segKey] = segInfo
+		}
+	}
+	sort.Strings(segKeys)
+
+	skippedSegments := make([]string, 0)
+	unrotatedSegKeys, _, _ := writer.FilterUnrotatedSegmentsInQuery(timeRange, indexNames, orgid)
+	for _, indexSegKeys := range unrotatedSegKeys {
+		for segKey, segInfo := range indexSegKeys {
+			skippedSegments = append(skippedSegments, getTStatsSkippedSegment(segKey, segInfo.TimeRange, "still being written"))
+		}
+	}
+
+	// The stats of each span, or of the whole time range when there is no span.
+	buckets := make(map[uint64]*segresults.SearchResults)
+	spreadSegments := make([]string, 0)
+	for _, segKey := range segKeys {
+		segTimeRange := segInfos[segKey].TimeRange
+		if !timeRange.AreTimesFullyEnclosed(segTimeRange.StartEpochMs, segTimeRange.EndEpochMs) {
+			log.Debugf("qid=%d, performTStats: skipping segKey %v since it is only partly in the time range", qid, segKey)
+			skippedSegments = append(skippedSegments, getTStatsSkippedSegment(segKey, segTimeRange, "only partly in the time range"))
+			continue
+		}
+
+		spanStart, crossesSpans := getTStatsSpanStart(segTimeRange, spanMillis)
+		if crossesSpans {
+			spreadSegments = append(spreadSegments, getTStatsSkippedSegment(segKey, segTimeRange,
+				"counted from "+formatTStatsTime(spanStart)))
+		}
+
+		var err error
+		bucket, ok := buckets[spanStart]
+		if !ok {
+			bucket, err = initTStatsBucket(tstats, qid)
+			if err != nil {
+				return nil, fmt.Errorf("performTStats: %v", err)
+			}
+			buckets[spanStart] = bucket
+		}
+
+		if len(tstats.GroupByColumns) > 0 {
+			err = applyTStatsAgileTree(tstats, segKey, bucket, qid)
+		} else {
+			err = applyTStatsSegStats(tstats, segKey, segInfos[segKey].ConsistentCValLenMap, bucket, qid)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("performTStats: %v", err)
+		}
+	}
+
+	records, cols, err := getTStatsRecords(tstats, buckets)
+	if err != nil {
+		return nil, fmt.Errorf("performTStats: %v", err)
+	}
+
+	aggregations.InitGenEvent(aggs)
+	aggregations.SetGeneratedCols(aggs.GenerateEvent, cols)
+	for i, record := range records {
+		recordKey := fmt.Sprintf("%v_%v", aggs.GenerateEvent.EventPosition, i)
+		aggs.GenerateEvent.GeneratedRecords[recordKey] = record
+		aggs.GenerateEvent.GeneratedRecordsIndex[recordKey] = i
+	}
+
+	return getTStatsWarnings(skippedSegments, spreadSegments), nil
+}
+
+func getTStatsSkippedSegment(segKey string, segTimeRange *dtu.TimeRange, reason string) string {
+	return fmt.Sprintf("%v (%v to %v, %v)", segKey, formatTStatsTime(segTimeRange.StartEpochMs),
+		formatTStatsTime(segTimeRange.EndEpochMs), reason)
+}
+
+func formatTStatsTime(epochMs uint64) string {
+	return time.UnixMilli(int64(epochMs)).UTC().Format(time.RFC3339)
+}
+
+func getTStatsWarnings(skippedSegments []string, spreadSegments []string) []string {
+	var warnings []string
+	if len(skippedSegments) > 0 {
+		warnings = append(warnings, fmt.Sprintf("tstats left out the events of %v segments, since their pre-aggregated data cannot be split by time: %v",
+			len(skippedSegments), listTStatsSegments(skippedSegments)))
+	}
+	if len(spreadSegments) > 0 {
+		warnings = append(warnings, fmt.Sprintf("tstats counted all the events of %v segments that cross a span boundary in the span where the segment starts,"+
+			" since their pre-aggregated data cannot be split by time: %v", len(spreadSegments), listTStatsSegments(spreadSegments)))
+	}
+
+	return warnings
+}
+
+func listTStatsSegments(segments []string) string {
+	sort.Strings(segments)
+	listed := segments
+	if len(listed) > tstatsMaxWarnedSegments {
+		listed = listed[:tstatsMaxWarnedSegments]
+	}
+
+	list := strings.Join(listed, ", ")
+	if len(segments) > len(listed) {
+		list += fmt.Sprintf(" and %v more", len(segments)-len(listed))
+	}
+	return list
+}
+
+// Returns the start of the span where the segment starts, and whether the segment ends in a
+// later span. The pre-aggregated data of a segment cannot be split, and a segment is usually
+// rotated every hour or so without being aligned to the hour, so even with span=1h most
+// segments cross a span boundary.
+func getTStatsSpanStart(segTimeRange *dtu.TimeRange, spanMillis uint64) (uint64, bool) {
+	if spanMillis == 0 {
+		return 0, false
+	}
+
+	spanStart := segTimeRange.StartEpochMs - segTimeRange.StartEpochMs%spanMillis
+	return spanStart, segTimeRange.EndEpochMs >= spanStart+spanMillis
+}
+
+func initTStatsBucket(tstats *structs.TStats, qid uint64) (*segresults.SearchResults, error) {
+	aggs := &structs.QueryAggregators{}
+	var qType structs.QueryType = structs.SegmentStatsCmd
+	if len(tstats.GroupByColumns) > 0 {
+		aggs.GroupByRequest = &structs.GroupByRequest{
+			GroupByColumns:    tstats.GroupByColumns,
+			MeasureOperations: tstats.MeasureOperations,
+			BucketCount:       MAX_GRP_BUCKS,
+		}
+		qType = structs.GroupByCmd
+	} else {
+		aggs.MeasureOperations = tstats.MeasureOperations
+	}
+
+	segResults, err := segresults.InitSearchResults(0, aggs, qType, qid)
+	if err != nil {
+		return nil, fmt.Errorf("initTStatsBucket: failed to init the search results, err: %v", err)
+	}
+	if qType == structs.SegmentStatsCmd {
+		segResults.InitSegmentStatsResults(tstats.MeasureOperations)
+	}
+
+	return segResults, nil
+}
+
+func applyTStatsAgileTree(tstats *structs.TStats, segKey string, bucket *segresults.SearchResults, qid uint64) error {
+	agileTree, err := segread.InitNewAgileTreeReader(segKey, qid)
+	if err != nil {
+		return fmt.Errorf("applyTStatsAgileTree: segKey %v has no agile tree, err: %v", segKey, err)
+	}
+	defer agileTree.Close()
+
+	grpReq := bucket.GetAggs().GroupByRequest
+	ok, err := agileTree.CanUseAgileTree(grpReq)
+	if err != nil {
+		return fmt.Errorf("applyTStatsAgileTree: failed to read the agile tree of segKey %v, err: %v", segKey, err)
+	}
+	if !ok {
+		return fmt.Errorf("applyTStatsAgileTree: the agile tree of segKey %v does not pre-aggregate the groupby fields %v and the measure fields %v",
+			segKey, tstats.GroupByColumns, getTStatsMeasureCols(tstats))
+	}
+
+	search.ApplyAgileTree(agileTree, bucket.GetAggs(), bucket, 0, qid, nil)
+	if errList := bucket.GetAllErrors(); len(errList) > 0 {
+		return fmt.Errorf("applyTStatsAgileTree: failed to apply the agile tree of segKey %v, err: %v", segKey, errList[0])
+	}
+
+	return nil
+}
+
+// A measure field that is not in the segment is skipped, but one that is in the segment
+// without segment stats cannot be computed.
+func applyTStatsSegStats(tstats *structs.TStats, segKey string, segCols map[string]uint32, bucket *segresults.SearchResults, qid uint64) error {
+	sstMap, err := segread.ReadSegStats(segKey, qid)
+	if err != nil {
+		return fmt.Errorf("applyTStatsSegStats: failed to read the segment stats of segKey %v, err: %v", segKey, err)
+	}
+
+	err = checkTStatsSegStats(tstats, segKey, segCols, sstMap)
+	if err != nil {
+		return fmt.Errorf("applyTStatsSegStats: %v", err)
+	}
+
+	err = bucket.UpdateSegmentStats(sstMap, tstats.MeasureOperations)
+	if err != nil {
+		return fmt.Errorf("applyTStatsSegStats: failed to update the segment stats of segKey %v, err: %v", segKey, err)
+	}
+
+	return nil
+}
+
+func checkTStatsSegStats(tstats *structs.TStats, segKey string, segCols map[string]uint32, sstMap map[string]*structs.SegStats) error {
+	for _, measureAgg := range tstats.MeasureOperations {
+		if measureAgg.MeasureCol == "*" {
+			continue
+		}
+		if _, inSegment := segCols[measureAgg.MeasureCol]; !inSegment {
+			continue
+		}
+
+		segStats, ok := sstMap[measureAgg.MeasureCol]
+		if !ok {
+			return fmt.Errorf("checkTStatsSegStats: field %v is not pre-aggregated in segKey %v", measureAgg.MeasureCol, segKey)
+		}
+
+		switch measureAgg.MeasureFunc {
+		case segutils.Sum, segutils.Avg, segutils.Min, segutils.Max, segutils.Range:
+			if !segStats.IsNumeric || segStats.NumStats == nil {
+				return fmt.Errorf("checkTStatsSegStats: field %v has no numeric stats in segKey %v for %v",
+					measureAgg.MeasureCol, segKey, measureAgg.String())
+			}
+		case segutils.Cardinality:
+			if segStats.Hll == nil {
+				return fmt.Errorf("checkTStatsSegStats: field %v has no cardinality stats in segKey %v", measureAgg.MeasureCol, segKey)
+			}
+		}
+	}
+
+	return nil
+}
+
+func getTStatsMeasureCols(tstats *structs.TStats) []string {
+	cols := make([]string, 0, len(tstats.MeasureOperations))
+	for _, measureAgg := range tstats.MeasureOperations {
+		if measureAgg.MeasureCol != "*" {
+			cols = append(cols, measureAgg.MeasureCol)
+		}
+	}
+	return cols
+}
+
+// Returns the rows ordered by span, then by the groupby values, along with their columns.
+func getTStatsRecords(tstats *structs.TStats, buckets map[uint64]*segresults.SearchResults) ([]map[string]interface{}, []string, error) {
+	cols := make([]string, 0)
+	timestampKey := config.GetTimeStampKey()
+	if tstats.Span != nil {
+		cols = append(cols, timestampKey)
+	}
+	cols = append(cols, tstats.GroupByColumns...)
+	cols = append(cols, structs.GetMeasureAggregatorStrEncColumns(tstats.MeasureOperations)...)
+
+	spanStarts := make([]uint64, 0, len(buckets))
+	for spanStart := range buckets {
+		spanStarts = append(spanStarts, spanStart)
+	}
+	sort.Slice(spanStarts, func(i, j int) bool { return spanStarts[i] < spanStarts[j] })
+
+	records := make([]map[string]interface{}, 0)
+	for _, spanStart := range spanStarts {
+		bucketRecords, err := getTStatsBucketRecords(tstats, buckets[spanStart])
+		if err != nil {
+			return nil, nil, fmt.Errorf("getTStatsRecords: %v", err)
+		}
+
+		for _, record := range bucketRecords {
+			if tstats.Span != nil {
+				record[timestampKey] = spanStart
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records, cols, nil
+}
+
+func getTStatsBucketRecords(tstats *structs.TStats, bucket *segresults.SearchResults) ([]map[string]interface{}, error) {
+	if len(tstats.GroupByColumns) == 0 {
+		record := make(map[string]interface{})
+		for measureName, measureVal := range bucket.GetSegmentStatsMeasureResults() {
+			value, err := measureVal.GetValue()
+			if err != nil {
+				return nil, fmt.Errorf("getTStatsBucketRecords: failed to get the value of %v, err: %v", measureName, err)
+			}
+			record[measureName] = value
+		}
+		return []map[string]interface{}{record}, nil
+	}
+
+	aggResult := bucket.BlockResults.GetGroupByBuckets()
+	if aggResult == nil {
+		return nil, nil
+	}
+
+	records := make([]map[string]interface{}, 0, len(aggResult.Results))
+	groupByValues := make([][]string, 0, len(aggResult.Results))
+	for _, bucketResult := range aggResult.Results {
+		var values []string
+		switch bucketKey := bucketResult.BucketKey.(type) {
+		case []string:
+			values = bucketKey
+		case string:
+			values = []string{bucketKey}
+		default:
+			return nil, fmt.Errorf("getTStatsBucketRecords: unexpected bucket key type %T", bucketResult.BucketKey)
+		}
+
+		record := make(map[string]interface{}, len(values)+len(bucketResult.StatRes))
+		for i, col := range tstats.GroupByColumns {
+			if i < len(values) {
+				record[col] = values[i]
+			}
+		}
+		for measureName, measureVal := range bucketResult.StatRes {
+			value, err := measureVal.GetValue()
+			if err != nil {
+				return nil, fmt.Errorf("getTStatsBucketRecords: failed to get the value of %v, err: %v", measureName, err)
+			}
+			record[measureName] = value
+		}
+
+		records = append(records, record)
+		groupByValues = append(groupByValues, values)
+	}
+
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return strings.Join(groupByValues[order[i]], "\x00") < strings.Join(groupByValues[order[j]], "\x00")
+	})
+
+	sortedRecords := make([]map[string]interface{}, len(records))
+	for i, recordIndex := range order {
+		sortedRecords[i] = records[recordIndex]
+	}
+	return sortedRecords, nil
+}
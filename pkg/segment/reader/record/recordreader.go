@@ -207,6 +207,28 @@ func getMathOpsColMap(MathOps []*structs.MathEvaluator) map[string]int {
 	return colMap
 }
 
+// Replaces the value by the result of the math or text operation on it. When the operation
+// fails, the value is kept as it is.
+func applyMathOperation(mathOp *structs.MathEvaluator, cValEnc *utils.CValueEnclosure, qid uint64) {
+	fieldToValue := make(map[string]utils.CValueEnclosure)
+	fieldToValue[mathOp.MathCol] = *cValEnc
+	if mathOp.ValueColRequest.ValueExprMode == structs.VEMStringExpr {
+		valueStr, err := mathOp.ValueColRequest.EvaluateToString(fieldToValue)
+		if err != nil {
+			log.Errorf("qid=%d, failed to evaluate text operation for col %s, err=%v", qid, mathOp.MathCol, err)
+		} else {
+			cValEnc.CVal = valueStr
+		}
+	} else {
+		valueFloat, err := mathOp.ValueColRequest.EvaluateToFloat(fieldToValue)
+		if err != nil {
+			log.Errorf("qid=%d, failed to evaluate math operation for col %s, err=%v", qid, mathOp.MathCol, err)
+		} else {
+			cValEnc.CVal = valueFloat
+		}
+	}
+}
+
 func readAllRawRecords(orderedRecNums []uint16, blockIdx uint16, segReader *segread.MultiColSegmentReader,
 	allMatchedColumns map[string]bool, esQuery bool, qid uint64, aggs *structs.QueryAggregators,
 	nodeRes *structs.NodeResult) map[uint16]map[string]interface{} {
@@ -247,6 +269,35 @@ func readAllRawRecords(orderedRecNums []uint16, blockIdx uint16, segReader *segr
 		mathColMap = make(map[string]int)
 	}
 
+	// The dictionary encoded columns are already read, so their operations are applied here.
+	if mathColOpsPresent {
+		for cname := range dictEncCols {
+			colIndex, exists := mathColMap[cname]
+			if !exists {
+				continue
+			}
+			for _, recNum := range orderedRecNums {
+				record, ok := results[recNum]
+				if !ok {
+					continue
+				}
+				value, ok := record[cname]
+				if !ok {
+					continue
+				}
+
+				var cValEnc utils.CValueEnclosure
+				err := cValEnc.ConvertValue(value)
+				if err != nil {
+					log.Errorf("qid=%d, readAllRawRecords: failed to convert value of col %s, err=%v", qid, cname, err)
+					continue
+				}
+				applyMathOperation(aggs.MathOperations[colIndex], &cValEnc, qid)
+				record[cname] = cValEnc.CVal
+			}
+		}
+	}
+
 	colsToReadIndices := make(map[int]struct{})
 	for colKeyIdx, cname := range allColKeyIndices {
 		_, exists := dictEncCols[cname]
@@ -291,15 +342,7 @@ func readAllRawRecords(orderedRecNums []uint16, blockIdx uint16, segReader *segr
 				if mathColOpsPresent {
 					colIndex, exists := mathColMap[cname]
 					if exists {
-						mathOp := aggs.MathOperations[colIndex]
-						fieldToValue := make(map[string]utils.CValueEnclosure)
-						fieldToValue[mathOp.MathCol] = cValEnc
-						valueFloat, err := mathOp.ValueColRequest.EvaluateToFloat(fieldToValue)
-						if err != nil {
-							log.Errorf("qid=%d, failed to evaluate math operation for col %s, err=%v", qid, cname, err)
-						} else {
-							cValEnc.CVal = valueFloat
-						}
+						applyMathOperation(aggs.MathOperations[colIndex], &cValEnc, qid)
 					}
 				}
 
@@ -0,0 +1,89 @@
+// Copyright (c) 2021-2024 SigScalr, Inc.
+//
+// This file is part of SigLens Observability Solution
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"testing"
+
+	"github.com/siglens/siglens/pkg/config"
+	"github.com/siglens/siglens/pkg/segment/reader/segread"
+	"github.com/siglens/siglens/pkg/segment/structs"
+	"github.com/siglens/siglens/pkg/segment/writer"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestTextOperation(op string, col string) *structs.MathEvaluator {
+	return &structs.MathEvaluator{
+		MathCol: col,
+		ValueColRequest: &structs.ValueExpr{
+			ValueExprMode: structs.VEMStringExpr,
+			StringExpr: &structs.StringExpr{
+				StringExprMode: structs.SEMTextExpr,
+				TextExpr: &structs.TextExpr{
+					Op:    op,
+					Param: &structs.StringExpr{StringExprMode: structs.SEMField, FieldName: col},
+				},
+			},
+		},
+	}
+}
+
+func Test_readAllRawRecords_TextOperations(t *testing.T) {
+	config.InitializeTestingConfig(t.TempDir())
+	writer.SetCardinalityLimit(1001)
+	segKey := t.TempDir() + "/test"
+	numRecords := 4
+	_, bSum, _, cols, blockmeta, _ := writer.WriteMockDictEncColSegFile(segKey, 1, numRecords)
+
+	sharedReader, err := segread.InitSharedMultiColumnReaders(segKey, cols, blockmeta, bSum, 1, nil, 0)
+	assert.Nil(t, err)
+	defer sharedReader.Close()
+	segReader := sharedReader.MultiColReaders[0]
+
+	orderedRecNums := []uint16{0, 1, 2, 3}
+
+	// key1 and key7 hold a single value in the block, so they are dictionary encoded.
+	dictEncVals := make(map[uint16]map[string]interface{})
+	assert.True(t, segReader.GetDictEncCvalsFromColFile(dictEncVals, "key1", 0, orderedRecNums, 0))
+	assert.True(t, segReader.GetDictEncCvalsFromColFile(dictEncVals, "key7", 0, orderedRecNums, 0))
+
+	aggs := &structs.QueryAggregators{
+		MathOperations: []*structs.MathEvaluator{
+			getTestTextOperation("base64encode", "key1"),
+			getTestTextOperation("base64encode", "key7"),
+			getTestTextOperation("base64decode", "key0"),
+			getTestTextOperation("base64decode", "key5"),
+		},
+	}
+	allMatchedColumns := make(map[string]bool)
+	nodeRes := &structs.NodeResult{}
+	rawResults := readAllRawRecords(orderedRecNums, 0, segReader, allMatchedColumns, false, 0, nil, nodeRes)
+	results := readAllRawRecords(orderedRecNums, 0, segReader, allMatchedColumns, false, 0, aggs, nodeRes)
+	assert.Len(t, results, numRecords)
+
+	for _, recNum := range orderedRecNums {
+		assert.Equal(t, "dmFsdWUx", results[recNum]["key1"])
+		assert.Equal(t, "YmF0Y2gtMA==", results[recNum]["key7"])
+
+		// The values that are not base64 are kept as they are.
+		assert.Equal(t, rawResults[recNum]["key0"], results[recNum]["key0"])
+		assert.Equal(t, rawResults[recNum]["key5"], results[recNum]["key5"])
+
+		assert.Equal(t, "value1", dictEncVals[recNum]["key1"])
+	}
+}
@@ -466,3 +466,69 @@ func extractKeyValuePairsFromString(str string) (map[string]interface{}, error)
 
 	return keyValuePairs, nil
 }
+
+// Runs the pipe commands over the records, like GetJsonFromAllRrc does for the records of a
+// search that has a single segment, and returns the resulting records in order.
+func RunPipelineOnRecords(pipeline *structs.QueryAggregators, records []map[string]interface{}, qid uint64) ([]map[string]interface{}, []string, error) {
+	recs := make(map[string]map[string]interface{}, len(records))
+	recordIndexInFinal := make(map[string]int, len(records))
+	finalCols := make(map[string]bool)
+	for i, record := range records {
+		recordKey := fmt.Sprintf("pipeline_%v", i)
+		recs[recordKey] = record
+		recordIndexInFinal[recordKey] = i
+		for col := range record {
+			finalCols[col] = true
+		}
+	}
+
+	// The first aggregator is skipped by PostQueryBucketCleaning when it is not a pipe command,
+	// so an empty one is added before the pipeline.
+	aggs := &structs.QueryAggregators{Next: pipeline}
+	nodeRes := &structs.NodeResult{}
+	for {
+		agg.PostQueryBucketCleaning(nodeRes, aggs, recs, recordIndexInFinal, finalCols, 1, true)
+		if !nodeRes.PerformAggsOnRecs {
+			break
+		}
+
+		resultRecMap := search.PerformAggsOnRecs(nodeRes, aggs, recs, finalCols, 1, true, qid)
+		nodeRes.PerformAggsOnRecs = false
+		if !resultRecMap["CHECK_NEXT_AGG"] {
+			break
+		}
+		aggs = nodeRes.NextQueryAgg
+	}
+	if len(nodeRes.ErrList) > 0 {
+		return nil, nil, fmt.Errorf("RunPipelineOnRecords: failed to run the pipeline, err: %v", nodeRes.ErrList[0])
+	}
+
+	// The commands like stats keep only some of the record keys, so the indexes may have gaps.
+	recordKeys := make([]string, 0, len(recs))
+	for recordKey := range recs {
+		recordKeys = append(recordKeys, recordKey)
+	}
+	sort.Slice(recordKeys, func(i, j int) bool {
+		return recordIndexInFinal[recordKeys[i]] < recordIndexInFinal[recordKeys[j]]
+	})
+
+	// Commands like stats leave the columns they drop in the records, so only the final
+	// columns are kept.
+	resultRecords := make([]map[string]interface{}, len(recordKeys))
+	for i, recordKey := range recordKeys {
+		resultRecords[i] = make(map[string]interface{}, len(finalCols))
+		for col, value := range recs[recordKey] {
+			if finalCols[col] {
+				resultRecords[i][col] = value
+			}
+		}
+	}
+
+	cols := make([]string, 0, len(finalCols))
+	for col := range finalCols {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	return resultRecords, cols, nil
+}
@@ -246,3 +246,56 @@ func Test_GetJsonFromAllRrc_withAggs_ExcludeCols(t *testing.T) {
 
 	os.RemoveAll(dir)
 }
+
+func Test_RunPipelineOnRecords(t *testing.T) {
+	records := []map[string]interface{}{
+		{"host": "web1", "count": int64(3)},
+		{"host": "web2", "count": int64(4)},
+		{"host": "web1", "count": int64(5)},
+	}
+
+	// stats sum(count) | eval host="total"
+	pipeline := &structs.QueryAggregators{
+		PipeCommandType: structs.MeasureAggsType,
+		MeasureOperations: []*structs.MeasureAggregator{
+			{MeasureCol: "count", MeasureFunc: utils.Sum},
+		},
+		Next: &structs.QueryAggregators{
+			PipeCommandType: structs.OutputTransformType,
+			OutputTransforms: &structs.OutputTransforms{
+				LetColumns: &structs.LetColumnsRequest{
+					NewColName: "host",
+					ValueColRequest: &structs.ValueExpr{
+						ValueExprMode: structs.VEMStringExpr,
+						StringExpr:    &structs.StringExpr{StringExprMode: structs.SEMRawString, RawString: "total"},
+					},
+				},
+			},
+		},
+	}
+	resultRecords, cols, err := RunPipelineOnRecords(pipeline, records, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"sum(count)": "12", "host": "total"}}, resultRecords)
+	assert.Equal(t, []string{"host", "sum(count)"}, cols)
+
+	// stats count by host
+	pipeline = &structs.QueryAggregators{
+		PipeCommandType: structs.GroupByType,
+		GroupByRequest: &structs.GroupByRequest{
+			GroupByColumns:    []string{"host"},
+			MeasureOperations: []*structs.MeasureAggregator{{MeasureCol: "*", MeasureFunc: utils.Count}},
+		},
+	}
+	records = []map[string]interface{}{
+		{"host": "web1", "count": int64(3)},
+		{"host": "web2", "count": int64(4)},
+		{"host": "web1", "count": int64(5)},
+	}
+	resultRecords, cols, err = RunPipelineOnRecords(pipeline, records, 0)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"host": "web1", "count(*)": uint64(2)},
+		{"host": "web2", "count(*)": uint64(1)},
+	}, resultRecords)
+	assert.Equal(t, []string{"count(*)", "host"}, cols)
+}
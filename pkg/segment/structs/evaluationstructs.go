@@ -19,6 +19,13 @@ package structs
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/rand"
@@ -121,10 +128,18 @@ type SortExpr struct {
 
 	SortAscending         []int
 	SortRecords           map[string]map[string]interface{}
+	SortRecordsBytes      uint64   // approximate size of SortRecords
+	SpillFiles            []string // sorted runs of the records written to disk when SortRecords exceeds its memory budget
 	NumProcessedSegments  uint64
 	processedSegmentsLock sync.Mutex
 }
 
+// Reverses the order of the results.
+type ReverseExpr struct {
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
 type SortElement struct {
 	SortByAsc bool
 	Op        string
@@ -205,6 +220,7 @@ type TextExpr struct {
 	Delimiter      *StringExpr
 	MultiValueExpr *MultiValueExpr
 	ValueList      []*StringExpr
+	ValueExprList  []*ValueExpr // the keys and values of json_object, or the paths and values of json_set
 	StartIndex     *NumericExpr
 	LengthExpr     *NumericExpr
 	Val            *ValueExpr
@@ -579,6 +595,12 @@ func (self *BoolExpr) Evaluate(fieldToValue map[string]utils.CValueEnclosure) (b
 
 			_, parseErr := strconv.ParseFloat(val, 64)
 			return parseErr == nil, nil
+		case "json_valid":
+			val, err := self.LeftValue.EvaluateToString(fieldToValue)
+			if err != nil {
+				return false, fmt.Errorf("BoolExpr.Evaluate: 'json_valid' can not evaluate to String: %v", err)
+			}
+			return json.Valid([]byte(val)), nil
 		case "isstr":
 			_, floatErr := self.LeftValue.EvaluateToFloat(fieldToValue)
 
@@ -966,6 +988,245 @@ func handleMVIndex(self *MultiValueExpr, fieldToValue map[string]utils.CValueEnc
 	return mvSlice[startIndex : endIndex+1], nil
 }
 
+// Returns the hash of the value as a lowercase hexadecimal string.
+func getHashDigest(op string, value string) string {
+	var digest []byte
+	switch op {
+	case "md5":
+		sum := md5.Sum([]byte(value))
+		digest = sum[:]
+	case "sha1":
+		sum := sha1.Sum([]byte(value))
+		digest = sum[:]
+	case "sha256":
+		sum := sha256.Sum256([]byte(value))
+		digest = sum[:]
+	case "sha512":
+		sum := sha512.Sum512([]byte(value))
+		digest = sum[:]
+	}
+	return hex.EncodeToString(digest)
+}
+
+// Returns the elements of the JSON array, each as a JSON value in a string.
+func handleJSONArray(self *MultiValueExpr, fieldToValue map[string]utils.CValueEnclosure) ([]string, error) {
+	elements := make([]string, len(self.ValueExprParams))
+	for i, valueExpr := range self.ValueExprParams {
+		value, err := getJSONFunctionArgument(valueExpr, fieldToValue)
+		if err != nil {
+			return []string{}, fmt.Errorf("handleJSONArray: cannot evaluate element %v, err: %v", i+1, err)
+		}
+		elements[i], err = toputils.FormatJSONValue(value)
+		if err != nil {
+			return []string{}, fmt.Errorf("handleJSONArray: %v", err)
+		}
+	}
+
+	return elements, nil
+}
+
+// Returns the values at the paths of the JSON input, or the whole input when there are no
+// paths. A single array is returned as its elements, so that it can be used as a multivalue.
+// The input that is not JSON has no values.
+func handleJSONExtract(self *MultiValueExpr, fieldToValue map[string]utils.CValueEnclosure) ([]string, error) {
+	if len(self.StringExprParams) == 0 {
+		return []string{}, fmt.Errorf("handleJSONExtract: json_extract requires a JSON value")
+	}
+	input, err := self.StringExprParams[0].Evaluate(fieldToValue)
+	if err != nil {
+		return []string{}, fmt.Errorf("handleJSONExtract: cannot evaluate the JSON value, err: %v", err)
+	}
+	data, err := toputils.DecodeJSONValue(input)
+	if err != nil {
+		return []string{}, nil
+	}
+
+	values := []interface{}{data}
+	if len(self.StringExprParams) > 1 {
+		values = make([]interface{}, 0)
+		for _, pathExpr := range self.StringExprParams[1:] {
+			path, err := pathExpr.Evaluate(fieldToValue)
+			if err != nil {
+				return []string{}, fmt.Errorf("handleJSONExtract: cannot evaluate the path, err: %v", err)
+			}
+			matches, err := toputils.GetJSONPathValues(data, path, 0)
+			if err != nil {
+				return []string{}, fmt.Errorf("handleJSONExtract: %v", err)
+			}
+			values = append(values, matches...)
+		}
+	}
+	if len(values) == 1 {
+		if arr, ok := values[0].([]interface{}); ok {
+			values = arr
+		}
+	}
+
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i], err = toputils.FormatJSONValue(value)
+		if err != nil {
+			return []string{}, fmt.Errorf("handleJSONExtract: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the keys of the JSON object in their order; the input that is not a JSON object
+// has no keys.
+func handleJSONKeys(self *MultiValueExpr, fieldToValue map[string]utils.CValueEnclosure) ([]string, error) {
+	if len(self.StringExprParams) != 1 {
+		return []string{}, fmt.Errorf("handleJSONKeys: json_keys requires one JSON object")
+	}
+	input, err := self.StringExprParams[0].Evaluate(fieldToValue)
+	if err != nil {
+		return []string{}, fmt.Errorf("handleJSONKeys: cannot evaluate the JSON object, err: %v", err)
+	}
+	if _, err := toputils.DecodeJSONValue(input); err != nil {
+		return []string{}, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(input))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return []string{}, nil
+	}
+
+	keys := make([]string, 0)
+	for decoder.More() {
+		keyToken, err := decoder.Token()
+		if err != nil {
+			return []string{}, fmt.Errorf("handleJSONKeys: invalid JSON object %v, err: %v", input, err)
+		}
+		var rawValue json.RawMessage
+		if err := decoder.Decode(&rawValue); err != nil {
+			return []string{}, fmt.Errorf("handleJSONKeys: invalid JSON object %v, err: %v", input, err)
+		}
+		keys = append(keys, fmt.Sprintf("%v", keyToken))
+	}
+
+	return keys, nil
+}
+
+// Returns the argument of a json_* function as a decoded JSON value. The numbers and booleans
+// keep their types, the multivalues are arrays, and the strings holding a JSON object or array
+// are nested instead of quoted.
+func getJSONFunctionArgument(valueExpr *ValueExpr, fieldToValue map[string]utils.CValueEnclosure) (interface{}, error) {
+	switch valueExpr.ValueExprMode {
+	case VEMMultiValueExpr:
+		mvSlice, err := valueExpr.MultiValueExpr.Evaluate(fieldToValue)
+		if err != nil {
+			return nil, fmt.Errorf("getJSONFunctionArgument: %v", err)
+		}
+		arr := make([]interface{}, len(mvSlice))
+		for i, element := range mvSlice {
+			arr[i] = element
+			if value, err := toputils.DecodeJSONValue(element); err == nil {
+				arr[i] = value
+			}
+		}
+		return arr, nil
+	case VEMBooleanExpr:
+		boolResult, err := valueExpr.BooleanExpr.Evaluate(fieldToValue)
+		if err != nil {
+			return nil, fmt.Errorf("getJSONFunctionArgument: %v", err)
+		}
+		return boolResult, nil
+	case VEMNumericExpr:
+		if floatValue, err := valueExpr.NumericExpr.Evaluate(fieldToValue); err == nil {
+			return json.Number(strconv.FormatFloat(floatValue, 'f', -1, 64)), nil
+		}
+	}
+
+	str, err := valueExpr.EvaluateToString(fieldToValue)
+	if err != nil {
+		return nil, fmt.Errorf("getJSONFunctionArgument: %v", err)
+	}
+	if value, err := toputils.DecodeJSONValue(str); err == nil {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return value, nil
+		}
+	}
+	return str, nil
+}
+
+// Returns the JSON object of the keys and values, which alternate in the list. The keys keep
+// their order, and a repeated key takes the last of its values.
+func handleJSONObject(keysAndValues []*ValueExpr, fieldToValue map[string]utils.CValueEnclosure) (string, error) {
+	if len(keysAndValues)%2 != 0 {
+		return "", fmt.Errorf("handleJSONObject: every key requires a value")
+	}
+
+	keys := make([]string, 0, len(keysAndValues)/2)
+	values := make(map[string]string, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, err := keysAndValues[i].EvaluateToString(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONObject: cannot evaluate key %v, err: %v", i/2+1, err)
+		}
+		value, err := getJSONFunctionArgument(keysAndValues[i+1], fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONObject: cannot evaluate the value of the key %v, err: %v", key, err)
+		}
+		valueJson, err := toputils.EncodeJSONValue(value)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONObject: %v", err)
+		}
+
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = valueJson
+	}
+
+	// The object is written by hand because json.Marshal would sort the keys.
+	members := make([]string, len(keys))
+	for i, key := range keys {
+		keyJson, err := toputils.EncodeJSONValue(key)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONObject: %v", err)
+		}
+		members[i] = keyJson + ":" + values[key]
+	}
+
+	return "{" + strings.Join(members, ",") + "}", nil
+}
+
+// Returns the JSON input with the values set at the paths, which alternate in the list. The
+// keys of the objects in the result are sorted.
+func handleJSONSet(input string, pathsAndValues []*ValueExpr, fieldToValue map[string]utils.CValueEnclosure) (string, error) {
+	if len(pathsAndValues) == 0 || len(pathsAndValues)%2 != 0 {
+		return "", fmt.Errorf("handleJSONSet: every path requires a value")
+	}
+
+	data, err := toputils.DecodeJSONValue(input)
+	if err != nil {
+		return "", fmt.Errorf("handleJSONSet: the value is not JSON: %v", input)
+	}
+
+	for i := 0; i < len(pathsAndValues); i += 2 {
+		path, err := pathsAndValues[i].EvaluateToString(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONSet: cannot evaluate path %v, err: %v", i/2+1, err)
+		}
+		value, err := getJSONFunctionArgument(pathsAndValues[i+1], fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONSet: cannot evaluate the value of the path %v, err: %v", path, err)
+		}
+		data, err = toputils.SetJSONPathValue(data, path, value, 0)
+		if err != nil {
+			return "", fmt.Errorf("handleJSONSet: %v", err)
+		}
+	}
+
+	jsonStr, err := toputils.EncodeJSONValue(data)
+	if err != nil {
+		return "", fmt.Errorf("handleJSONSet: %v", err)
+	}
+	return jsonStr, nil
+}
+
 func (self *MultiValueExpr) Evaluate(fieldToValue map[string]utils.CValueEnclosure) ([]string, error) {
 	if self == nil {
 		return []string{}, fmt.Errorf("MultiValueExpr.Evaluate: self is nil")
@@ -991,6 +1252,12 @@ func (self *MultiValueExpr) Evaluate(fieldToValue map[string]utils.CValueEnclosu
 		return handleSplit(self, fieldToValue)
 	case "mvindex":
 		return handleMVIndex(self, fieldToValue)
+	case "json_array":
+		return handleJSONArray(self, fieldToValue)
+	case "json_extract":
+		return handleJSONExtract(self, fieldToValue)
+	case "json_keys":
+		return handleJSONKeys(self, fieldToValue)
 	default:
 		return []string{}, fmt.Errorf("MultiValueExpr.Evaluate: invalid Op %v", self.Op)
 	}
@@ -1604,6 +1871,8 @@ func (self *RenameExpr) RemoveBucketHolderGroupByColumnsByIndex(bucketHolder *Bu
 // For Rename or top/rare block, we may need to delete some groupby columns while processing them
 func RemoveUnusedGroupByCols(aggs *QueryAggregators, aggGroupByCols []string) []string {
 	for agg := aggs; agg != nil; agg = agg.Next {
+		// Chart, xyseries, untable and transpose blocks
+		aggGroupByCols = GetReshapeGroupByCols(aggGroupByCols, agg)
 		// Rename block
 		aggGroupByCols = GetRenameGroupByCols(aggGroupByCols, agg)
 		// Statistic block: to be finished
@@ -1611,6 +1880,36 @@ func RemoveUnusedGroupByCols(aggs *QueryAggregators, aggGroupByCols []string) []
 	return aggGroupByCols
 }
 
+// Chart, xyseries, untable and transpose turn the values of some fields into columns, so the results have new groupby columns
+func GetReshapeGroupByCols(aggGroupByCols []string, agg *QueryAggregators) []string {
+	if agg.OutputTransforms == nil || agg.OutputTransforms.LetColumns == nil {
+		return aggGroupByCols
+	}
+
+	if agg.OutputTransforms.LetColumns.ChartRequest != nil {
+		return []string{agg.OutputTransforms.LetColumns.ChartRequest.OverField}
+	}
+
+	if reshapeReq := agg.OutputTransforms.LetColumns.ReshapeRequest; reshapeReq != nil {
+		return reshapeReq.GetGroupByCols()
+	}
+
+	return aggGroupByCols
+}
+
+func (rr *ReshapeRequest) GetGroupByCols() []string {
+	switch rr.ReshapeType {
+	case ReshapeXYSeries:
+		return []string{rr.XField}
+	case ReshapeUntable:
+		return []string{rr.XField, rr.YNameField}
+	case ReshapeTranspose:
+		return []string{rr.ColumnName}
+	default:
+		return []string{}
+	}
+}
+
 // Rename field A to field B. If A and B are groupby columns, field B should be removed from groupby columns, and rename A to B
 func GetRenameGroupByCols(aggGroupByCols []string, agg *QueryAggregators) []string {
 	if agg.OutputTransforms != nil && agg.OutputTransforms.LetColumns != nil && agg.OutputTransforms.LetColumns.RenameColRequest != nil {
@@ -1933,6 +2232,225 @@ func handleTrimFunctions(op string, value string, trim_chars string) string {
 	}
 }
 
+// Returns the IP address with the bits outside of the IPv4 subnet mask cleared.
+func handleIPMask(maskStr string, ipStr string) (string, error) {
+	mask := net.ParseIP(strings.TrimSpace(maskStr)).To4()
+	if mask == nil {
+		return "", fmt.Errorf("handleIPMask: invalid mask %v; it must be an IPv4 address", maskStr)
+	}
+	if _, bits := net.IPMask(mask).Size(); bits == 0 {
+		return "", fmt.Errorf("handleIPMask: invalid mask %v; its set bits must come before its unset bits", maskStr)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(ipStr)).To4()
+	if ip == nil {
+		return "", fmt.Errorf("handleIPMask: invalid IPv4 address %v", ipStr)
+	}
+
+	return ip.Mask(net.IPMask(mask)).String(), nil
+}
+
+// The replacement of replace() refers to the groups of the regex as \1 to \9, while Go uses
+// ${1}, so the backreferences are converted and any other $ is escaped.
+func getRegexReplacementTemplate(replacement string) string {
+	var sb strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		char := replacement[i]
+		switch {
+		case char == '$':
+			sb.WriteString("$$")
+		case char == '\\' && i+1 < len(replacement) && replacement[i+1] >= '0' && replacement[i+1] <= '9':
+			sb.WriteString("${")
+			sb.WriteByte(replacement[i+1])
+			sb.WriteString("}")
+			i++
+		case char == '\\' && i+1 < len(replacement) && replacement[i+1] == '\\':
+			sb.WriteByte('\\')
+			i++
+		default:
+			sb.WriteByte(char)
+		}
+	}
+	return sb.String()
+}
+
+// Formats the values like the C printf: each conversion is % followed by optional flags
+// (-, +, space, # and 0), a width and a precision, then one of d, i, u, o, x, X, f, F, e,
+// E, g, G, c or s. The conversions must match the values one to one.
+func handlePrintf(format string, values []string) (string, error) {
+	var sb strings.Builder
+	valueIndex := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			sb.WriteByte('%')
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			i++
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				i++
+			}
+		}
+		spec := format[start:i]
+		// The length modifiers of C make no difference here.
+		for i < len(format) && strings.IndexByte("hlLqjzt", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) {
+			return "", fmt.Errorf("handlePrintf: incomplete conversion %v at the end of the format", format[start:])
+		}
+
+		if valueIndex >= len(values) {
+			return "", fmt.Errorf("handlePrintf: the format %v has more conversions than the %v values", format, len(values))
+		}
+		value := values[valueIndex]
+		valueIndex++
+
+		verb := format[i]
+		switch verb {
+		case 'd', 'i', 'u', 'o', 'x', 'X':
+			number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return "", fmt.Errorf("handlePrintf: %%%c requires a number, got %v", verb, value)
+			}
+			goVerb := string(verb)
+			if verb == 'i' || verb == 'u' {
+				goVerb = "d"
+			}
+			sb.WriteString(fmt.Sprintf(spec+goVerb, int64(number)))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return "", fmt.Errorf("handlePrintf: %%%c requires a number, got %v", verb, value)
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(verb), number))
+		case 'c':
+			// The first character of the value, like printf("%c", "abc") in Splunk.
+			if value == "" {
+				sb.WriteString(fmt.Sprintf(spec+"s", ""))
+			} else {
+				sb.WriteString(fmt.Sprintf(spec+"c", []rune(value)[0]))
+			}
+		case 's':
+			sb.WriteString(fmt.Sprintf(spec+"s", value))
+		default:
+			return "", fmt.Errorf("handlePrintf: unsupported conversion %%%c in the format %v", verb, format)
+		}
+	}
+
+	if valueIndex < len(values) {
+		return "", fmt.Errorf("handlePrintf: the format %v has %v conversions but got %v values", format, valueIndex, len(values))
+	}
+
+	return sb.String(), nil
+}
+
+// Returns the fields as a JSON object; the internal fields are the ones starting with _.
+func handleToJson(fieldToValue map[string]utils.CValueEnclosure, includeInternalFields bool) (string, error) {
+	obj := make(map[string]interface{}, len(fieldToValue))
+	for field, value := range fieldToValue {
+		if !includeInternalFields && strings.HasPrefix(field, "_") {
+			continue
+		}
+		if value.Dtype == utils.SS_DT_BACKFILL || value.CVal == nil {
+			continue
+		}
+		obj[field] = value.CVal
+	}
+
+	jsonStr, err := toputils.FormatJSONValue(obj)
+	if err != nil {
+		return "", fmt.Errorf("handleToJson: %v", err)
+	}
+	return jsonStr, nil
+}
+
+// Converts the JSON object into a JSON array with one object per key of the object, which
+// has the key under keyLabel and the value under valueLabel. The keys keep their order.
+func handleObjectToArray(objStr string, keyLabel string, valueLabel string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(objStr))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return "", fmt.Errorf("handleObjectToArray: the value is not a JSON object: %v", objStr)
+	}
+
+	elements := make([]string, 0)
+	for decoder.More() {
+		keyToken, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("handleObjectToArray: invalid JSON object %v, err: %v", objStr, err)
+		}
+		var rawValue json.RawMessage
+		if err := decoder.Decode(&rawValue); err != nil {
+			return "", fmt.Errorf("handleObjectToArray: invalid JSON object %v, err: %v", objStr, err)
+		}
+
+		// The objects are written by hand because json.Marshal would sort the labels.
+		keyLabelJson, _ := json.Marshal(keyLabel)
+		keyJson, _ := json.Marshal(keyToken)
+		valueLabelJson, _ := json.Marshal(valueLabel)
+		var compactValue bytes.Buffer
+		if err := json.Compact(&compactValue, rawValue); err != nil {
+			return "", fmt.Errorf("handleObjectToArray: invalid value of the key %v, err: %v", keyToken, err)
+		}
+		elements = append(elements, fmt.Sprintf("{%s:%s,%s:%s}", keyLabelJson, keyJson, valueLabelJson, compactValue.String()))
+	}
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('}') || decoder.More() {
+		return "", fmt.Errorf("handleObjectToArray: invalid JSON object %v", objStr)
+	}
+
+	return "[" + strings.Join(elements, ",") + "]", nil
+}
+
+// Returns the value at the path of the JSON input. When the path matches several values,
+// like the elements of an array, they are returned as a multivalue. The input that is not
+// JSON and the paths that do not match have no value.
+func handleSPath(input string, path string) (string, error) {
+	data, err := toputils.DecodeJSONValue(input)
+	if err != nil {
+		return "", nil
+	}
+
+	matches, err := toputils.GetJSONPathValues(data, path, 1)
+	if err != nil {
+		return "", fmt.Errorf("handleSPath: %v", err)
+	}
+
+	values := make([]string, 0, len(matches))
+	for _, match := range matches {
+		value, err := toputils.FormatJSONValue(match)
+		if err != nil {
+			return "", fmt.Errorf("handleSPath: %v", err)
+		}
+		values = append(values, value)
+	}
+
+	switch len(values) {
+	case 0:
+		return "", nil
+	case 1:
+		return values[0], nil
+	default:
+		mvValue := utils.CValueEnclosure{Dtype: utils.SS_DT_STRING_SLICE, CVal: values}
+		return mvValue.GetString()
+	}
+}
+
 // formatTime formats a time.Time object into a string based on the provided format string, using mappings from Go's time package and strftime.net.
 func formatTime(t time.Time, format string) string {
 	preReplacements := map[string]string{
@@ -2034,6 +2552,138 @@ func parseTime(dateStr, format string) (time.Time, error) {
 	return time.Parse(format, dateStr)
 }
 
+var convertUnitRegex = regexp.MustCompile(`^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?`)
+var convertMemkRegex = regexp.MustCompile(`^([-+]?([0-9]+\.?[0-9]*|\.[0-9]+))([kKmMgG]?)$`)
+
+// Returns the converted value, or false if the value can't be converted and should be left
+// unchanged. A nil value means that the field should be removed.
+func (self *ConvertExpr) Convert(value interface{}, timeFormat string) (interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+	str := strings.TrimSpace(fmt.Sprintf("%v", value))
+
+	switch self.Func {
+	case "auto":
+		if number, ok := parseConvertNumber(str); ok {
+			return number, true
+		}
+		return parseConvertNumber(strings.ReplaceAll(str, ",", ""))
+	case "num":
+		if number, ok := parseConvertNumber(str); ok {
+			return number, true
+		}
+		if number, ok := parseConvertNumber(strings.ReplaceAll(str, ",", "")); ok {
+			return number, true
+		}
+		return nil, true
+	case "rmcomma":
+		return parseConvertNumber(strings.ReplaceAll(str, ",", ""))
+	case "rmunit":
+		return parseConvertNumber(convertUnitRegex.FindString(str))
+	case "memk":
+		return convertMemk(str)
+	case "dur2sec":
+		return convertDurationToSeconds(str)
+	case "mstime":
+		return convertMinutesAndSeconds(str)
+	case "ctime":
+		// The timestamps are in milliseconds, like for strftime.
+		timestamp, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, false
+		}
+		return formatTime(time.UnixMilli(int64(timestamp)), timeFormat), true
+	case "mktime":
+		t, err := parseTime(str, timeFormat)
+		if err != nil {
+			return nil, false
+		}
+		return t.UnixMilli(), true
+	default:
+		return nil, false
+	}
+}
+
+// Integers are returned as int64 and the other numbers as float64.
+func parseConvertNumber(str string) (interface{}, bool) {
+	if intValue, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return intValue, true
+	}
+
+	floatValue, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+		return nil, false
+	}
+	return floatValue, true
+}
+
+// Converts a size like 10, 10k, 10m or 10g to kilobytes.
+func convertMemk(str string) (interface{}, bool) {
+	match := convertMemkRegex.FindStringSubmatch(str)
+	if match == nil {
+		return nil, false
+	}
+
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, false
+	}
+	switch strings.ToLower(match[3]) {
+	case "m":
+		number *= 1024
+	case "g":
+		number *= 1024 * 1024
+	}
+	return number, true
+}
+
+// Converts a duration like [D+]HH:MM:SS to seconds.
+func convertDurationToSeconds(str string) (interface{}, bool) {
+	days := int64(0)
+	if daysStr, rest, found := strings.Cut(str, "+"); found {
+		var err error
+		days, err = strconv.ParseInt(daysStr, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		str = rest
+	}
+
+	parts := strings.Split(str, ":")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	seconds := int64(0)
+	for _, part := range parts {
+		number, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || number < 0 {
+			return nil, false
+		}
+		seconds = seconds*60 + number
+	}
+	return days*24*3600 + seconds, true
+}
+
+// Converts a time like [MM:]SS.SSS to seconds.
+func convertMinutesAndSeconds(str string) (interface{}, bool) {
+	minutes := float64(0)
+	if minutesStr, rest, found := strings.Cut(str, ":"); found {
+		var err error
+		minutes, err = strconv.ParseFloat(minutesStr, 64)
+		if err != nil {
+			return nil, false
+		}
+		str = rest
+	}
+
+	seconds, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, false
+	}
+	return minutes*60 + seconds, true
+}
+
 func (self *TextExpr) EvaluateText(fieldToValue map[string]utils.CValueEnclosure) (string, error) {
 	// Todo: implement the processing logic for these functions:
 	switch self.Op {
@@ -2058,18 +2708,15 @@ func (self *TextExpr) EvaluateText(fieldToValue map[string]utils.CValueEnclosure
 		}
 		return strconv.FormatInt(t.Unix(), 10), nil
 	case "ipmask":
-		mask := net.ParseIP(self.Param.RawString).To4()
-		ip := net.ParseIP(self.Val.StringExpr.RawString).To4()
-		if mask == nil || ip == nil {
-			return "", fmt.Errorf("TextExpr.EvaluateText: invalid mask or IP address for 'ipmask' operation")
-		}
-		if len(ip) != len(mask) {
-			return "", fmt.Errorf("TextExpr.EvaluateText: IP address and mask are of different lengths")
+		maskStr, err := self.Param.Evaluate(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the mask for 'ipmask', err: %v", err)
 		}
-		for i := range ip {
-			ip[i] &= mask[i]
+		ipStr, err := self.Val.EvaluateToString(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the IP address for 'ipmask', err: %v", err)
 		}
-		return ip.String(), nil
+		return handleIPMask(maskStr, ipStr)
 	case "replace":
 		if len(self.ValueList) < 2 {
 			return "", fmt.Errorf("TextExpr.EvaluateText: 'replace' operation requires a regex and a replacement")
@@ -2091,7 +2738,63 @@ func (self *TextExpr) EvaluateText(fieldToValue map[string]utils.CValueEnclosure
 		if err != nil {
 			return "", fmt.Errorf("TextExpr.EvaluateText: failed to compile regex '%s': %v", regexStr, err)
 		}
-		return regex.ReplaceAllString(baseStr, replacementStr), nil
+		return regex.ReplaceAllString(baseStr, getRegexReplacementTemplate(replacementStr)), nil
+	case "printf":
+		formatStr, err := self.Param.Evaluate(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the format for 'printf', err: %v", err)
+		}
+		values := make([]string, len(self.ValueList))
+		for i, valueExpr := range self.ValueList {
+			values[i], err = valueExpr.Evaluate(fieldToValue)
+			if err != nil {
+				return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate argument %v of 'printf', err: %v", i+1, err)
+			}
+		}
+		return handlePrintf(formatStr, values)
+	case "tojson":
+		includeInternalFields := true
+		if self.Param != nil {
+			includeStr, err := self.Param.Evaluate(fieldToValue)
+			if err != nil {
+				return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the argument of 'tojson', err: %v", err)
+			}
+			includeInternalFields, err = strconv.ParseBool(includeStr)
+			if err != nil {
+				return "", fmt.Errorf("TextExpr.EvaluateText: the argument of 'tojson' must be true or false, got %v", includeStr)
+			}
+		}
+		return handleToJson(fieldToValue, includeInternalFields)
+	case "object_to_array":
+		if self.Param == nil || len(self.Param.StringList) != 2 {
+			return "", fmt.Errorf("TextExpr.EvaluateText: 'object_to_array' requires an object, a key label and a value label")
+		}
+		objStr, err := self.Val.EvaluateToString(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the object for 'object_to_array', err: %v", err)
+		}
+		keyLabel := strings.Trim(self.Param.StringList[0], `"`)
+		valueLabel := strings.Trim(self.Param.StringList[1], `"`)
+		return handleObjectToArray(objStr, keyLabel, valueLabel)
+	case "spath":
+		if self.SPathExpr == nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: 'spath' requires an input field and a path")
+		}
+		inputStr, err := getValueAsString(fieldToValue, self.SPathExpr.InputColName)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the input of 'spath', err: %v", err)
+		}
+		return handleSPath(inputStr, self.SPathExpr.Path)
+	case "json_object":
+		return handleJSONObject(self.ValueExprList, fieldToValue)
+	case "json_set":
+		input, err := self.Val.EvaluateToString(fieldToValue)
+		if err != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: cannot evaluate the JSON value for 'json_set', err: %v", err)
+		}
+		return handleJSONSet(input, self.ValueExprList, fieldToValue)
+	case "cluster":
+		return "", fmt.Errorf("TextExpr.EvaluateText: 'cluster' compares events with each other, so it cannot be used in eval; use the cluster command instead")
 	case "mvjoin":
 		mvSlice, err := self.MultiValueExpr.Evaluate(fieldToValue)
 		if err != nil {
@@ -2147,8 +2850,6 @@ func (self *TextExpr) EvaluateText(fieldToValue map[string]utils.CValueEnclosure
 		fallthrough
 	case "mv_to_json_array":
 		fallthrough
-	case "cluster":
-		fallthrough
 	case "getfields":
 		fallthrough
 	case "typeof":
@@ -2277,6 +2978,18 @@ func (self *TextExpr) EvaluateText(fieldToValue map[string]utils.CValueEnclosure
 			return "", fmt.Errorf("TextExpr.EvaluateText: failed to decode URL: %v", decodeErr)
 		}
 		return decodedStr, nil
+	case "urlencode":
+		return url.QueryEscape(cellValueStr), nil
+	case "md5", "sha1", "sha256", "sha512":
+		return getHashDigest(self.Op, cellValueStr), nil
+	case "base64encode":
+		return base64.StdEncoding.EncodeToString([]byte(cellValueStr)), nil
+	case "base64decode":
+		decodedBytes, decodeErr := base64.StdEncoding.DecodeString(cellValueStr)
+		if decodeErr != nil {
+			return "", fmt.Errorf("TextExpr.EvaluateText: failed to decode base64: %v", decodeErr)
+		}
+		return string(decodedBytes), nil
 	case "substr":
 		baseString, err := self.Param.Evaluate(fieldToValue)
 		if err != nil {
@@ -2463,6 +3176,19 @@ func (self *TextExpr) GetFields() []string {
 		if self.MultiValueExpr != nil {
 			fields = append(fields, self.MultiValueExpr.GetFields()...)
 		}
+		for _, expr := range self.ValueList {
+			fields = append(fields, expr.GetFields()...)
+		}
+		for _, expr := range self.ValueExprList {
+			fields = append(fields, expr.GetFields()...)
+		}
+		if self.SPathExpr != nil {
+			fields = append(fields, self.SPathExpr.InputColName)
+		}
+		// The JSON object has all the fields.
+		if self.Op == "tojson" {
+			fields = []string{"*"}
+		}
 
 		return fields
 	}
@@ -2550,48 +3276,56 @@ func getValueAsFloat(fieldToValue map[string]utils.CValueEnclosure, field string
 	return 0, fmt.Errorf("getValueAsFloat: Cannot convert CValueEnclosure %v to float", enclosure)
 }
 
+// Values which cannot be interpreted as the requested type are sorted after the
+// ones which can, so that records with mixed types still have a consistent order.
 func (self *SortValue) Compare(other *SortValue) (int, error) {
 	switch self.InterpretAs {
 	case "ip":
 		selfIP := net.ParseIP(self.Val)
 		otherIP := net.ParseIP(other.Val)
-		if selfIP == nil || otherIP == nil {
-			return 0, fmt.Errorf("SortValue.Compare: cannot parse IP address selfIp: %v, otherIp: %v", self.Val, other.Val)
+		if selfIP != nil && otherIP != nil {
+			return bytes.Compare(selfIP, otherIP), nil
+		} else if selfIP != nil {
+			return -1, nil
+		} else if otherIP != nil {
+			return 1, nil
 		}
-		return bytes.Compare(selfIP, otherIP), nil
+
+		return strings.Compare(self.Val, other.Val), nil
 	case "num":
 		selfFloat, selfErr := strconv.ParseFloat(self.Val, 64)
 		otherFloat, otherErr := strconv.ParseFloat(other.Val, 64)
-		if selfErr != nil || otherErr != nil {
-			return 0, fmt.Errorf("SortValue.Compare: cannot parse %v and %v as float", self.Val, other.Val)
-		}
-
-		if selfFloat == otherFloat {
-			return 0, nil
-		} else if selfFloat < otherFloat {
+		if selfErr == nil && otherErr == nil {
+			return compareFloats(selfFloat, otherFloat), nil
+		} else if selfErr == nil {
 			return -1, nil
-		} else {
+		} else if otherErr == nil {
 			return 1, nil
 		}
+
+		return strings.Compare(self.Val, other.Val), nil
 	case "str":
 		return strings.Compare(self.Val, other.Val), nil
 	case "auto", "":
+		// Numbers come first, then IP addresses, then the other strings.
 		selfFloat, selfErr := strconv.ParseFloat(self.Val, 64)
 		otherFloat, otherErr := strconv.ParseFloat(other.Val, 64)
 		if selfErr == nil && otherErr == nil {
-			if selfFloat == otherFloat {
-				return 0, nil
-			} else if selfFloat < otherFloat {
-				return -1, nil
-			} else {
-				return 1, nil
-			}
+			return compareFloats(selfFloat, otherFloat), nil
+		} else if selfErr == nil {
+			return -1, nil
+		} else if otherErr == nil {
+			return 1, nil
 		}
 
 		selfIp := net.ParseIP(self.Val)
 		otherIp := net.ParseIP(other.Val)
 		if selfIp != nil && otherIp != nil {
 			return bytes.Compare(selfIp, otherIp), nil
+		} else if selfIp != nil {
+			return -1, nil
+		} else if otherIp != nil {
+			return 1, nil
 		}
 
 		return strings.Compare(self.Val, other.Val), nil
@@ -2600,6 +3334,16 @@ func (self *SortValue) Compare(other *SortValue) (int, error) {
 	}
 }
 
+func compareFloats(a float64, b float64) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	} else {
+		return 1
+	}
+}
+
 // The `ascending` slice should have the same length as `a` and `b`. Moreover,
 // each element of `ascending` should be either +1 or -1; +1 means higher
 // values get sorted higher, and -1 means lower values get sorted higher.
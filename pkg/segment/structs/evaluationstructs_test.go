@@ -2183,3 +2183,389 @@ func Test_MultiValueExpr(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, utils.CompareStringSlices(value, []string{"dc", "b2c"}))
 }
+
+func Test_SortValueCompareMixedTypes(t *testing.T) {
+	compare := func(interpretAs string, a string, b string) int {
+		val1 := SortValue{Val: a, InterpretAs: interpretAs}
+		val2 := SortValue{Val: b, InterpretAs: interpretAs}
+		res, err := val1.Compare(&val2)
+		assert.Nil(t, err)
+		return res
+	}
+
+	assert.Equal(t, -1, compare("num", "9", "10"))
+	assert.Equal(t, -1, compare("num", "10", "abc"))
+	assert.Equal(t, 1, compare("num", "abc", "10"))
+	assert.Equal(t, -1, compare("num", "abc", "def"))
+
+	assert.Equal(t, -1, compare("ip", "10.0.0.2", "10.0.0.10"))
+	assert.Equal(t, -1, compare("ip", "10.0.0.2", "host"))
+	assert.Equal(t, 1, compare("ip", "host", "10.0.0.2"))
+
+	assert.Equal(t, 1, compare("str", "9", "10"))
+
+	// Numbers come before IP addresses, which come before the other strings.
+	assert.Equal(t, -1, compare("auto", "9", "10"))
+	assert.Equal(t, -1, compare("auto", "100", "10.0.0.1"))
+	assert.Equal(t, -1, compare("auto", "10.0.0.1", "abc"))
+	assert.Equal(t, 1, compare("auto", "abc", "5"))
+	assert.Equal(t, 0, compare("", "abc", "abc"))
+
+	val := SortValue{Val: "1", InterpretAs: "bad"}
+	_, err := val.Compare(&val)
+	assert.NotNil(t, err)
+}
+
+func Test_handlePrintf(t *testing.T) {
+	value, err := handlePrintf("%c,%c", []string{"abc", "Foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a,F", value)
+
+	value, err = handlePrintf("%05.1f%% of %-4s|%+d %x %o %e", []string{"12.345", "ab", "7", "255", "8", "1500"})
+	assert.Nil(t, err)
+	assert.Equal(t, "012.3% of ab  |+7 ff 10 1.500000e+03", value)
+
+	value, err = handlePrintf("%i items, %ld bytes", []string{"3.9", "1024"})
+	assert.Nil(t, err)
+	assert.Equal(t, "3 items, 1024 bytes", value)
+
+	for _, testCase := range []struct {
+		format string
+		values []string
+	}{
+		{"%d and %d", []string{"1"}},
+		{"%d", []string{"1", "2"}},
+		{"%d", []string{"abc"}},
+		{"%y", []string{"1"}},
+		{"value %", []string{}},
+	} {
+		_, err = handlePrintf(testCase.format, testCase.values)
+		assert.NotNil(t, err, testCase.format)
+	}
+}
+
+func Test_EvaluateText_IPMaskAndReplace(t *testing.T) {
+	fieldToValue := map[string]segutils.CValueEnclosure{
+		"clientip": {Dtype: segutils.SS_DT_STRING, CVal: "192.168.37.205"},
+		"host":     {Dtype: segutils.SS_DT_STRING, CVal: "web-12.prod"},
+	}
+	fieldExpr := func(field string) *ValueExpr {
+		return &ValueExpr{
+			ValueExprMode: VEMNumericExpr,
+			NumericExpr:   &NumericExpr{IsTerminal: true, ValueIsField: true, Value: field},
+		}
+	}
+
+	ipmaskExpr := &TextExpr{
+		Op:    "ipmask",
+		Param: &StringExpr{StringExprMode: SEMRawString, RawString: "255.255.240.0"},
+		Val:   fieldExpr("clientip"),
+	}
+	assert.Equal(t, []string{"clientip"}, ipmaskExpr.GetFields())
+	value, err := ipmaskExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.32.0", value)
+
+	ipmaskExpr.Param.RawString = "255.0.255.0"
+	_, err = ipmaskExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+
+	ipmaskExpr.Param.RawString = "255.255.255.0"
+	ipmaskExpr.Val = fieldExpr("host")
+	_, err = ipmaskExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+
+	// The groups are referenced as \1 and the $ is a literal.
+	replaceExpr := &TextExpr{
+		Op:  "replace",
+		Val: fieldExpr("host"),
+		ValueList: []*StringExpr{
+			{StringExprMode: SEMRawString, RawString: `^(\w+)-(\d+)`},
+			{StringExprMode: SEMRawString, RawString: `\2$\1`},
+		},
+	}
+	value, err = replaceExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "12$web.prod", value)
+
+	replaceExpr.ValueList[1] = &StringExpr{StringExprMode: SEMField, FieldName: "clientip"}
+	assert.Equal(t, []string{"host", "clientip"}, replaceExpr.GetFields())
+}
+
+func Test_EvaluateText_JsonFunctions(t *testing.T) {
+	fieldToValue := map[string]segutils.CValueEnclosure{
+		"_raw":     {Dtype: segutils.SS_DT_STRING, CVal: `{"user": {"name": "ann", "roles": ["admin", "dev"]}, "count": 3}`},
+		"statePop": {Dtype: segutils.SS_DT_STRING, CVal: `{"CA": 39.5, "TX": {"pop": 29}, "AK": null}`},
+		"bytes":    {Dtype: segutils.SS_DT_UNSIGNED_NUM, CVal: uint64(512)},
+	}
+
+	spathExpr := &TextExpr{
+		Op:        "spath",
+		SPathExpr: &SPathExpr{InputColName: "_raw", Path: "user.name"},
+	}
+	assert.Equal(t, []string{"_raw"}, spathExpr.GetFields())
+	value, err := spathExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "ann", value)
+
+	for path, expected := range map[string]string{
+		"user.roles{2}": "dev",
+		"user.roles{}":  "[admin dev]",
+		"user.roles":    `["admin","dev"]`,
+		"count":         "3",
+		"user.missing":  "",
+	} {
+		spathExpr.SPathExpr.Path = path
+		value, err = spathExpr.EvaluateText(fieldToValue)
+		assert.Nil(t, err, path)
+		assert.Equal(t, expected, value, path)
+	}
+	spathExpr.SPathExpr.Path = "user.roles{x}"
+	_, err = spathExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+
+	objectToArrayExpr := &TextExpr{
+		Op: "object_to_array",
+		Val: &ValueExpr{
+			ValueExprMode: VEMNumericExpr,
+			NumericExpr:   &NumericExpr{IsTerminal: true, ValueIsField: true, Value: "statePop"},
+		},
+		Param: &StringExpr{StringExprMode: SEMRawStringList, StringList: []string{`"state"`, `"population"`}},
+	}
+	value, err = objectToArrayExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"state":"CA","population":39.5},{"state":"TX","population":{"pop":29}},{"state":"AK","population":null}]`, value)
+
+	objectToArrayExpr.Val.NumericExpr.Value = "bytes"
+	_, err = objectToArrayExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+
+	toJsonExpr := &TextExpr{
+		Op:    "tojson",
+		Param: &StringExpr{StringExprMode: SEMRawString, RawString: "false"},
+	}
+	assert.Equal(t, []string{"*"}, toJsonExpr.GetFields())
+	value, err = toJsonExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"bytes":512,"statePop":"{\"CA\": 39.5, \"TX\": {\"pop\": 29}, \"AK\": null}"}`, value)
+
+	clusterExpr := &TextExpr{Op: "cluster", Cluster: &Cluster{Field: "_raw"}}
+	_, err = clusterExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+}
+
+func Test_EvaluateText_UpperAndTrim(t *testing.T) {
+	fieldToValue := map[string]segutils.CValueEnclosure{
+		"host": {Dtype: segutils.SS_DT_STRING, CVal: "xxWeb01x"},
+	}
+
+	trimExpr := &TextExpr{
+		Op:          "trim",
+		Param:       &StringExpr{StringExprMode: SEMField, FieldName: "host"},
+		StrToRemove: "x",
+	}
+	upperExpr := &TextExpr{
+		Op:    "upper",
+		Param: &StringExpr{StringExprMode: SEMTextExpr, TextExpr: trimExpr},
+	}
+	assert.Equal(t, []string{"host"}, upperExpr.GetFields())
+
+	value, err := upperExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "WEB01", value)
+}
+
+func Test_JsonEvalFunctions(t *testing.T) {
+	fieldToValue := map[string]segutils.CValueEnclosure{
+		"_raw":  {Dtype: segutils.SS_DT_STRING, CVal: `{"user": {"name": "ann", "tags": ["a", "b", {"c": 1}]}, "z": 1, "y": [2, 3]}`},
+		"count": {Dtype: segutils.SS_DT_UNSIGNED_NUM, CVal: uint64(3)},
+		"plain": {Dtype: segutils.SS_DT_STRING, CVal: "not json"},
+	}
+	rawExpr := &StringExpr{StringExprMode: SEMField, FieldName: "_raw"}
+	rawStr := func(str string) *StringExpr {
+		return &StringExpr{StringExprMode: SEMRawString, RawString: str}
+	}
+	rawValue := func(str string) *ValueExpr {
+		return &ValueExpr{ValueExprMode: VEMStringExpr, StringExpr: rawStr(str)}
+	}
+	fieldValue := func(field string) *ValueExpr {
+		return &ValueExpr{
+			ValueExprMode: VEMNumericExpr,
+			NumericExpr:   &NumericExpr{IsTerminal: true, ValueIsField: true, NumericExprMode: NEMNumberField, Value: field},
+		}
+	}
+
+	extractExpr := &MultiValueExpr{Op: "json_extract", StringExprParams: []*StringExpr{rawExpr, rawStr("user.tags")}}
+	values, err := extractExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", `{"c":1}`}, values)
+
+	extractExpr.StringExprParams = []*StringExpr{rawExpr, rawStr("user.tags{2}.c"), rawStr("y"), rawStr("missing")}
+	values, err = extractExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "[2,3]"}, values)
+
+	extractExpr.StringExprParams = []*StringExpr{{StringExprMode: SEMField, FieldName: "plain"}, rawStr("a")}
+	values, err = extractExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, values)
+
+	extractExpr.StringExprParams = []*StringExpr{rawExpr, rawStr("y{x}")}
+	_, err = extractExpr.Evaluate(fieldToValue)
+	assert.NotNil(t, err)
+
+	keysExpr := &MultiValueExpr{Op: "json_keys", StringExprParams: []*StringExpr{rawExpr}}
+	values, err = keysExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user", "z", "y"}, values)
+
+	// The keys can be indexed like any other multivalue.
+	mvIndexExpr := &MultiValueExpr{
+		Op:                   "mvindex",
+		MultiValueExprParams: []*MultiValueExpr{keysExpr},
+		NumericExprParams:    []*NumericExpr{{IsTerminal: true, Value: "-1"}},
+	}
+	values, err = mvIndexExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"y"}, values)
+
+	arrayExpr := &MultiValueExpr{
+		Op:              "json_array",
+		ValueExprParams: []*ValueExpr{fieldValue("count"), rawValue("x"), rawValue(`{"k": true}`)},
+	}
+	values, err = arrayExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"3", "x", `{"k":true}`}, values)
+
+	objectExpr := &TextExpr{
+		Op: "json_object",
+		ValueExprList: []*ValueExpr{
+			rawValue("name"), rawValue("ann"),
+			rawValue("count"), fieldValue("count"),
+			rawValue("list"), {ValueExprMode: VEMMultiValueExpr, MultiValueExpr: arrayExpr},
+			rawValue("name"), rawValue("bob"),
+		},
+	}
+	assert.Equal(t, []string{"count", "count"}, objectExpr.GetFields())
+	value, err := objectExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"bob","count":3,"list":[3,"x",{"k":true}]}`, value)
+
+	setExpr := &TextExpr{
+		Op:  "json_set",
+		Val: fieldValue("_raw"),
+		ValueExprList: []*ValueExpr{
+			rawValue("user.name"), rawValue("bob"),
+			rawValue("y{2}"), fieldValue("count"),
+			rawValue("new.key"), rawValue("[1]"),
+		},
+	}
+	value, err = setExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"new":{"key":[1]},"user":{"name":"bob","tags":["a","b",{"c":1}]},"y":[2,3,3],"z":1}`, value)
+
+	setExpr.Val = fieldValue("plain")
+	_, err = setExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+
+	validExpr := &BoolExpr{IsTerminal: true, ValueOp: "json_valid", LeftValue: fieldValue("_raw")}
+	valid, err := validExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	validExpr.LeftValue = fieldValue("plain")
+	valid, err = validExpr.Evaluate(fieldToValue)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+}
+
+func Test_EvaluateText_HashAndEncoding(t *testing.T) {
+	fieldToValue := map[string]segutils.CValueEnclosure{
+		"user": {Dtype: segutils.SS_DT_STRING, CVal: "abc"},
+		"path": {Dtype: segutils.SS_DT_STRING, CVal: "a b&c=d/é"},
+	}
+
+	for op, expected := range map[string]string{
+		"md5":          "900150983cd24fb0d6963f7d28e17f72",
+		"sha1":         "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"sha256":       "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":       "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"base64encode": "YWJj",
+	} {
+		textExpr := &TextExpr{
+			Op:    op,
+			Param: &StringExpr{StringExprMode: SEMField, FieldName: "user"},
+		}
+		value, err := textExpr.EvaluateText(fieldToValue)
+		assert.Nil(t, err, op)
+		assert.Equal(t, expected, value, op)
+	}
+
+	urlEncodeExpr := &TextExpr{
+		Op:    "urlencode",
+		Param: &StringExpr{StringExprMode: SEMField, FieldName: "path"},
+	}
+	value, err := urlEncodeExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "a+b%26c%3Dd%2F%C3%A9", value)
+
+	// Decoding gives back the encoded value.
+	urlDecodeExpr := &TextExpr{
+		Op:    "urldecode",
+		Param: &StringExpr{StringExprMode: SEMRawString, RawString: value},
+	}
+	value, err = urlDecodeExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "a b&c=d/é", value)
+
+	base64DecodeExpr := &TextExpr{
+		Op:    "base64decode",
+		Param: &StringExpr{StringExprMode: SEMRawString, RawString: "YWJj"},
+	}
+	value, err = base64DecodeExpr.EvaluateText(fieldToValue)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", value)
+
+	base64DecodeExpr.Param.RawString = "not base64!"
+	_, err = base64DecodeExpr.EvaluateText(fieldToValue)
+	assert.NotNil(t, err)
+}
+
+func Test_ConvertExpr(t *testing.T) {
+	timeFormat := "%Y-%m-%d %H:%M:%S"
+
+	mktimeExpr := &ConvertExpr{Func: "mktime", Field: "date"}
+	value, ok := mktimeExpr.Convert("2024-03-05 10:20:30", timeFormat)
+	assert.True(t, ok)
+
+	ctimeExpr := &ConvertExpr{Func: "ctime", Field: "date"}
+	value, ok = ctimeExpr.Convert(value, timeFormat)
+	assert.True(t, ok)
+	assert.Equal(t, "2024-03-05 10:20:30", value)
+
+	_, ok = mktimeExpr.Convert("not a date", timeFormat)
+	assert.False(t, ok)
+
+	value, ok = (&ConvertExpr{Func: "mstime"}).Convert("02:15.250", timeFormat)
+	assert.True(t, ok)
+	assert.Equal(t, float64(135.25), value)
+
+	value, ok = (&ConvertExpr{Func: "auto"}).Convert("1,024", timeFormat)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1024), value)
+
+	_, ok = (&ConvertExpr{Func: "auto"}).Convert("abc", timeFormat)
+	assert.False(t, ok)
+
+	value, ok = (&ConvertExpr{Func: "num"}).Convert("abc", timeFormat)
+	assert.True(t, ok)
+	assert.Nil(t, value)
+
+	value, ok = (&ConvertExpr{Func: "rmunit"}).Convert("-3.5e2 ms", timeFormat)
+	assert.True(t, ok)
+	assert.Equal(t, float64(-350), value)
+
+	value, ok = (&ConvertExpr{Func: "memk"}).Convert("1.5g", timeFormat)
+	assert.True(t, ok)
+	assert.Equal(t, float64(1.5*1024*1024), value)
+}
@@ -113,6 +113,16 @@ type ASTNode struct {
 	TimeRange                *dtu.TimeRange // Time range for node micro index / raw search
 	ActiveFileSearch         bool           // Lookup unrotated segfiles
 	BucketLimit              int
+	Subsearch                *SearchSubsearch // Replaced by a filter on the rows of the subsearch before the query runs
+}
+
+// A subsearch in the search filter, like [search index=threats | fields ip]. It is executed
+// before the main search and the node matches the lines that match any of its rows.
+type SearchSubsearch struct {
+	Query   string // query of the subsearch, without the brackets
+	MaxOut  uint64 // max number of rows of the subsearch used in the filter
+	MaxTime int    // max number of seconds the subsearch can run
+	Negated bool   // the node matches the lines that match none of the rows
 }
 
 // Helper struct to keep track of raw and expanded tables
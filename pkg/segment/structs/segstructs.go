@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"regexp"
+	"strings"
 	"sync/atomic"
 
 	"github.com/cespare/xxhash"
@@ -166,6 +168,7 @@ type QueryAggregators struct {
 	TransactionArguments *TransactionArguments
 	StatsOptions         *StatsOptions
 	StreamStatsOptions   *StreamStatsOptions
+	EventStatsOptions    *EventStatsOptions
 	GenerateEvent        *GenerateEvent
 	Next                 *QueryAggregators
 	Limit                int
@@ -174,6 +177,10 @@ type QueryAggregators struct {
 type GenerateEvent struct {
 	GenTimes              *GenTimes
 	InputLookup           *InputLookup
+	MakeResults           *MakeResults
+	Multisearch           *Multisearch
+	TStats                *TStats
+	FieldSummary          *FieldSummaryRequest
 	GeneratedRecords      map[string]map[string]interface{}
 	GeneratedRecordsIndex map[string]int
 	GeneratedCols         map[string]bool
@@ -187,6 +194,11 @@ type GenTimes struct {
 	Interval  *SpanLength
 }
 
+type MakeResults struct {
+	Count    uint64
+	Annotate bool
+}
+
 type InputLookup struct {
 	Filename             string
 	Append               bool
@@ -216,6 +228,12 @@ type StreamStatsOptions struct {
 	NumProcessedRecords uint64
 }
 
+type EventStatsOptions struct {
+	// contains the records of all the segments; recordKey -> record
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
 type RunningStreamStatsResults struct {
 	Window              *sutils.GobbableList
 	CurrResult          utils.CValueEnclosure
@@ -317,6 +335,52 @@ type AppendRequest struct {
 	Subsearch       interface{}
 }
 
+// Adds the fields of each row of the subsearch to the row of the results at the same
+// position; the extra rows of the subsearch are added after the results.
+type AppendColsRequest struct {
+	Override  bool   // whether the subsearch values replace the values the results already have
+	Subsearch string // query of the subsearch; it is executed before the main search
+	MaxOut    uint64 // max number of rows returned by the subsearch
+
+	SubsearchRecords     []map[string]interface{}
+	SubsearchCols        []string
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Adds the results of a pipeline over the current results after them, like a summary row.
+// The pipeline runs over the rows that reach appendpipe, so the search is not run again.
+type AppendPipeRequest struct {
+	Pipeline     string            // the commands in the brackets, without the leading pipe
+	PipelineAggs *QueryAggregators // the parsed pipeline
+
+	// Runs the parsed pipeline over the records for the query, and returns the resulting
+	// records and their columns. It is set along with PipelineAggs, since the commands like
+	// stats are run by packages that import this one.
+	RunPipeline func(pipeline *QueryAggregators, records []map[string]interface{}) ([]map[string]interface{}, []string, error)
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Runs the searches concurrently and merges their results in time order, newest first.
+type Multisearch struct {
+	Subsearches []string
+	MaxOut      uint64 // max number of rows returned by each subsearch
+
+	SubsearchRecords [][]map[string]interface{} // the records of each subsearch
+	SubsearchCols    []string
+}
+
+// Computes the stats only from the pre-aggregated data of the rotated segments: the agile
+// trees when there are groupby columns, and the segment stats otherwise.
+type TStats struct {
+	IndexNames        []string // when empty, the indexes of the query are used
+	MeasureOperations []*MeasureAggregator
+	GroupByColumns    []string
+	Span              *SpanLength // nil when the stats are not split by time
+}
+
 type AppendCmdOptions struct {
 	ExtendTimeRange bool
 	MaxTime         int
@@ -328,6 +392,303 @@ type AppendCmdOption struct {
 	Value      interface{}
 }
 
+type JoinType uint8
+
+const (
+	JoinInner JoinType = iota
+	JoinLeft           // outer is an alias for left
+)
+
+type JoinRequest struct {
+	JoinType  JoinType
+	Max       uint64   // max number of subsearch rows to join with each main result row. 0 means no limit
+	FieldList []string // fields to join on; they must exist in both the main search and the subsearch
+	Subsearch string   // query of the subsearch; it is executed before the main search
+	MaxOut    uint64   // max number of rows returned by the subsearch
+
+	SubsearchRecords     []map[string]interface{}
+	SubsearchCols        []string
+	HashTable            map[string][]map[string]interface{}
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+type LookupFieldMapping struct {
+	LookupField string // field in the lookup file
+	EventField  string // field in the events; it is the same as LookupField unless renamed with AS
+}
+
+type LookupRequest struct {
+	Filename     string
+	MatchFields  []*LookupFieldMapping
+	OutputFields []*LookupFieldMapping // if empty, all the lookup fields other than the match fields are output
+	OutputNew    bool                  // OUTPUTNEW only sets the output fields which do not exist in the event yet
+
+	Table      map[string]map[string]interface{} // output values keyed by the match field values; loaded once per query
+	OutputCols []string
+}
+
+// Pivots the results of a stats by the over field and the split field into one row
+// per value of the over field, with a column per aggregation and split field value.
+type ChartRequest struct {
+	OverField string
+	Timechart *TimechartExpr // split field, aggregations, limit and tc options; the same as for a timechart
+}
+
+type ReshapeType uint8
+
+const (
+	ReshapeXYSeries ReshapeType = iota
+	ReshapeUntable
+	ReshapeTranspose
+)
+
+// Reshapes the rows and columns of the results for xyseries, untable and transpose.
+type ReshapeRequest struct {
+	ReshapeType  ReshapeType
+	XField       string   // xyseries and untable
+	YNameField   string   // xyseries and untable
+	YDataFields  []string // xyseries; untable has exactly one
+	Sep          string   // xyseries: separates the data field and the y-name value when there are multiple data fields
+	NumRows      uint64   // transpose: number of rows to turn into columns; 0 means all the rows
+	ColumnName   string   // transpose: name of the column holding the original column names
+	HeaderField  string   // transpose: field whose values are used as the names of the new columns
+	IncludeEmpty bool     // transpose: whether to keep the columns which are empty in all the rows
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Adds the total of the numeric fields of each row and/or a summary row with the total of each column.
+type AddTotalsRequest struct {
+	Row        bool     // whether to add the total of each row in FieldName
+	Col        bool     // whether to add a summary row with the column totals
+	FieldName  string   // field holding the total of each row
+	LabelField string   // field of the summary row which is set to Label
+	Label      string   // label of the summary row
+	FieldList  []string // fields to total, which may have wildcards; if empty, all the numeric fields are totaled
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Adds the location of the IP address in Field from the GeoIP database.
+type IPLocationRequest struct {
+	Field     string
+	Prefix    string // prepended to the names of the location fields
+	AllFields bool   // whether to also add Continent, MetroCode and Timezone
+}
+
+type GeoStatsStep uint8
+
+const (
+	GeoStatsBinPoints    GeoStatsStep = iota // before the stats: sets the geohash tile of each point
+	GeoStatsAddBinCenter                     // after the stats: sets the center of each tile
+)
+
+// Bins the points of geostats into geohash tiles, so that the stats are grouped by tile.
+type GeoStatsRequest struct {
+	Step            GeoStatsStep
+	LatField        string
+	LongField       string
+	OutputLatField  string
+	OutputLongField string
+	Precision       uint64 // number of characters of the geohash; the higher it is, the smaller the tiles
+}
+
+type SequentialCmdType uint8
+
+const (
+	SequentialDelta SequentialCmdType = iota
+	SequentialAccum
+	SequentialAutoregress
+	SequentialSMA // trendline simple moving average
+	SequentialEMA // trendline exponential moving average
+	SequentialWMA // trendline weighted moving average
+)
+
+// Sets a value on each record from the values of Field in the records before it, in the
+// order of the results, for delta, accum, autoregress and the moving averages of trendline.
+type SequentialRequest struct {
+	CmdType  SequentialCmdType
+	Field    string
+	NewField string // delta, accum, trendline and autoregress with a single p; otherwise autoregress uses <field>_p<n>
+	PStart   uint64 // delta and autoregress: the first number of records to look back
+	PEnd     uint64 // delta and autoregress: the last number of records to look back; trendline: the period
+
+	// The state is carried over from one segment to the next.
+	PrevValues     []interface{} // values of Field in the last PEnd records, the latest first; only the numeric ones for trendline
+	RunningTotal   float64       // accum; the last average of an ema
+	SegmentRecords map[string]map[string]interface{}
+}
+
+type PredictAlgorithm uint8
+
+const (
+	PredictLL  PredictAlgorithm = iota // local level
+	PredictLLT                         // local level with a trend
+	PredictLLP                         // local level with a seasonal period
+)
+
+type PredictField struct {
+	Field      string
+	NewField   string // defaults to prediction(<field>)
+	UpperField string // defaults to upper<confidence>(<new field>)
+	LowerField string // defaults to lower<confidence>(<new field>)
+}
+
+// The largest future_timespan, holdback and period of predict, since the future rows and the
+// seasons of the model are allocated up front.
+const MaxPredictOptionValue = 10_000
+
+// Forecasts the fields from the rows before, which are usually the time buckets of a timechart,
+// and adds FutureTimespan rows after the last one.
+type PredictRequest struct {
+	Fields         []*PredictField
+	Algorithm      PredictAlgorithm
+	FutureTimespan uint64
+	Holdback       uint64 // number of the last rows which are forecast instead of being used by the model
+	Period         uint64 // LLP: number of rows in a season; 0 means it is found from the values
+	Confidence     uint64 // percent of the confidence interval between the lower and upper fields
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+type AnomalyMethod uint8
+
+const (
+	AnomalyHistogram AnomalyMethod = iota // the rows with the least frequent values
+	AnomalyZScore                         // the rows with values too many standard deviations from the mean
+	AnomalyIQR                            // the rows with values too far outside of the interquartile range
+)
+
+type AnomalyAction uint8
+
+const (
+	AnomalyFilter    AnomalyAction = iota // keeps only the anomalous rows
+	AnomalyAnnotate                       // keeps all the rows
+	AnomalyRemove                         // keeps only the rows which are not anomalous
+	AnomalyTransform                      // clamps the outlying values to the bounds of the IQR method
+)
+
+// Finds the anomalous rows for anomalydetection and outlier, using statistics of the fields
+// over all the rows, and flags them in the isOutlier and probable_cause fields.
+type AnomalyRequest struct {
+	Method   AnomalyMethod
+	Action   AnomalyAction
+	Fields   []string // if empty, all the fields other than the internal ones
+	PThresh  float64  // histogram and zscore: the probability below which a value is anomalous
+	Param    float64  // iqr: the multiple of the interquartile range beyond which a value is anomalous
+	UseLower bool     // iqr: whether the values below the range are also anomalous
+	Mark     bool     // transform: whether the clamped values are prefixed with "000 "
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Groups the rows whose values of the field are similar, like the messages written by the same
+// log statement, and labels each row with the number of its cluster.
+type ClusterRequest struct {
+	Cluster    *Cluster
+	ShowCount  bool   // whether the number of rows in the cluster is added to each row
+	LabelOnly  bool   // whether all the rows are kept instead of only the first row of each cluster
+	LabelField string // defaults to cluster_label
+	CountField string // defaults to cluster_count
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+type ForeachMode uint8
+
+const (
+	ForeachMultiField ForeachMode = iota // runs the subsearch once for each field matching the wildcard fields
+	ForeachMultiValue                    // runs the subsearch once for each value of a multivalue field
+)
+
+// Runs a subsearch for each field, or for each value of a field, after replacing the
+// placeholders in it, like in foreach bytes_* [eval <<FIELD>>_kb = <<FIELD>>/1024]
+type ForeachRequest struct {
+	Fields    []string // multifield: the wildcard fields; multivalue: the multivalue field
+	Mode      ForeachMode
+	FieldStr  string // replaced by the name of the field, defaults to <<FIELD>>
+	MatchStr  string // replaced by the part of the name matched by the wildcards, defaults to <<MATCHSTR>>
+	ItemStr   string // replaced by the value of the multivalue field, defaults to <<ITEM>>
+	Subsearch string
+
+	// The fields known when the query is planned are expanded into the commands after this
+	// one, so this request only runs the subsearch for the fields that appear later.
+	ExpandedFields map[string]struct{}
+	ParseSubsearch func(subsearch string) (*QueryAggregators, error)
+	Subsearches    map[string]*QueryAggregators // the parsed subsearches, by their text
+}
+
+// Converts the values of fields in bulk, like in convert ctime(timestamp) rmunit(size) auto(*)
+type ConvertRequest struct {
+	TimeFormat  string // strftime format used by ctime and mktime
+	Conversions []*ConvertExpr
+}
+
+type ConvertExpr struct {
+	Func     string // auto, ctime, dur2sec, memk, mktime, mstime, none, num, rmcomma or rmunit
+	Field    string // may contain wildcards, unless there is a NewField
+	NewField string // if empty, the converted value replaces the value of the field
+}
+
+// Changes how the values of a field are displayed. The expression is evaluated like in eval,
+// but after all the other commands, so they still use the values before the formatting.
+type FieldFormatRequest struct {
+	ValueExpr *ValueExpr
+}
+
+// Sets the range field to the names of the numeric ranges that contain the value of the field,
+// or to the default name when no range contains it.
+type RangeMapRequest struct {
+	Field   string
+	Ranges  []*RangeMapRange
+	Default string // defaults to None
+}
+
+type RangeMapRange struct {
+	Name  string
+	Start float64 // inclusive
+	End   float64 // inclusive
+}
+
+// Summarizes each field of the results in a row. With approximate=true, when the search matches
+// all the records and fieldsummary is the first command, the summary is computed from the
+// segment stats instead.
+type FieldSummaryRequest struct {
+	MaxVals uint64   // number of the most common values listed for each field; 0 means all the values
+	Fields  []string // fields to summarize, which may have wildcards; if empty, all the fields are summarized
+
+	// When set, the summary may be computed from the segment stats, which is faster but
+	// estimates the distinct counts and lists neither the values nor the stdev.
+	Approximate bool
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
+// Extracts the key/value pairs in the text of a field, like k1=v1 k2="v 2", as new fields.
+type ExtractRequest struct {
+	Field      string // field holding the text
+	PairDelims string // characters that separate the pairs
+	KVDelims   string // characters that separate the key and the value of a pair
+	Limit      uint64 // maximum number of pairs extracted from each record
+}
+
+// Writes the final results to a lookup file; the results themselves are passed on unchanged.
+type OutputLookupRequest struct {
+	Filename    string
+	Append      bool
+	CreateEmpty bool // whether to write a file without rows when there are no results
+
+	Records              map[string]map[string]interface{}
+	NumProcessedSegments uint64
+}
+
 // Only NewColName and one of the other fields should have a value
 type LetColumnsRequest struct {
 	MultiColsRequest     *MultiColLetRequest
@@ -345,6 +706,27 @@ type LetColumnsRequest struct {
 	BinRequest           *BinCmdOptions
 	FillNullRequest      *FillNullExpr
 	AppendRequest        *AppendRequest
+	JoinRequest          *JoinRequest
+	LookupRequest        *LookupRequest
+	ChartRequest         *ChartRequest
+	ReshapeRequest       *ReshapeRequest
+	OutputLookupRequest  *OutputLookupRequest
+	AddTotalsRequest     *AddTotalsRequest
+	SequentialRequest    *SequentialRequest
+	ReverseRequest       *ReverseExpr
+	IPLocationRequest    *IPLocationRequest
+	GeoStatsRequest      *GeoStatsRequest
+	PredictRequest       *PredictRequest
+	AnomalyRequest       *AnomalyRequest
+	ClusterRequest       *ClusterRequest
+	ForeachRequest       *ForeachRequest
+	AppendColsRequest    *AppendColsRequest
+	AppendPipeRequest    *AppendPipeRequest
+	ConvertRequest       *ConvertRequest
+	FieldFormatRequest   *FieldFormatRequest
+	RangeMapRequest      *RangeMapRequest
+	FieldSummaryRequest  *FieldSummaryRequest
+	ExtractRequest       *ExtractRequest
 }
 
 type FillNullExpr struct {
@@ -465,6 +847,7 @@ type NodeResult struct {
 	AllSearchColumnsByTimeRange map[string]bool
 	FinalColumns                map[string]bool
 	AllColumnsInAggs            map[string]struct{}
+	Warnings                    []string // shown along with the results, which may be incomplete
 }
 
 type SegStats struct {
@@ -801,7 +1184,18 @@ func (qa *QueryAggregators) hasLetColumnsRequest() bool {
 		(qa.OutputTransforms.LetColumns.RexColRequest != nil || qa.OutputTransforms.LetColumns.RenameColRequest != nil || qa.OutputTransforms.LetColumns.DedupColRequest != nil ||
 			qa.OutputTransforms.LetColumns.ValueColRequest != nil || qa.OutputTransforms.LetColumns.SortColRequest != nil || qa.OutputTransforms.LetColumns.MultiValueColRequest != nil ||
 			qa.OutputTransforms.LetColumns.FormatResults != nil || qa.OutputTransforms.LetColumns.EventCountRequest != nil || qa.OutputTransforms.LetColumns.BinRequest != nil ||
-			qa.OutputTransforms.LetColumns.FillNullRequest != nil || qa.OutputTransforms.LetColumns.AppendRequest != nil)
+			qa.OutputTransforms.LetColumns.FillNullRequest != nil || qa.OutputTransforms.LetColumns.AppendRequest != nil ||
+			qa.OutputTransforms.LetColumns.JoinRequest != nil || qa.OutputTransforms.LetColumns.LookupRequest != nil ||
+			qa.OutputTransforms.LetColumns.ChartRequest != nil || qa.OutputTransforms.LetColumns.ReshapeRequest != nil ||
+			qa.OutputTransforms.LetColumns.OutputLookupRequest != nil || qa.OutputTransforms.LetColumns.AddTotalsRequest != nil ||
+			qa.OutputTransforms.LetColumns.SequentialRequest != nil || qa.OutputTransforms.LetColumns.ReverseRequest != nil ||
+			qa.OutputTransforms.LetColumns.IPLocationRequest != nil || qa.OutputTransforms.LetColumns.GeoStatsRequest != nil ||
+			qa.OutputTransforms.LetColumns.PredictRequest != nil || qa.OutputTransforms.LetColumns.AnomalyRequest != nil ||
+			qa.OutputTransforms.LetColumns.ClusterRequest != nil || qa.OutputTransforms.LetColumns.ForeachRequest != nil ||
+			qa.OutputTransforms.LetColumns.AppendColsRequest != nil || qa.OutputTransforms.LetColumns.AppendPipeRequest != nil ||
+			qa.OutputTransforms.LetColumns.ConvertRequest != nil || qa.OutputTransforms.LetColumns.FieldFormatRequest != nil ||
+			qa.OutputTransforms.LetColumns.RangeMapRequest != nil || qa.OutputTransforms.LetColumns.FieldSummaryRequest != nil ||
+			qa.OutputTransforms.LetColumns.ExtractRequest != nil)
 }
 
 func (qa *QueryAggregators) hasAppendRequest() bool {
@@ -813,6 +1207,129 @@ func (qa *QueryAggregators) HasAppendInChain() bool {
 	return qa.HasInChain((*QueryAggregators).hasAppendRequest)
 }
 
+func (qa *QueryAggregators) HasJoinBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.JoinRequest != nil
+}
+
+func (qa *QueryAggregators) HasJoinInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasJoinBlock)
+}
+
+func (qa *QueryAggregators) HasReshapeBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.ReshapeRequest != nil
+}
+
+func (qa *QueryAggregators) HasReshapeInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasReshapeBlock)
+}
+
+// Only the column totals need all the records.
+func (qa *QueryAggregators) HasAddColTotalsBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.AddTotalsRequest != nil && qa.OutputTransforms.LetColumns.AddTotalsRequest.Col
+}
+
+func (qa *QueryAggregators) HasAddColTotalsInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasAddColTotalsBlock)
+}
+
+func (qa *QueryAggregators) HasReverseBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.ReverseRequest != nil
+}
+
+func (qa *QueryAggregators) HasReverseInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasReverseBlock)
+}
+
+func (qa *QueryAggregators) HasPredictBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.PredictRequest != nil
+}
+
+func (qa *QueryAggregators) HasPredictInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasPredictBlock)
+}
+
+func (qa *QueryAggregators) HasAnomalyBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.AnomalyRequest != nil
+}
+
+func (qa *QueryAggregators) HasAnomalyInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasAnomalyBlock)
+}
+
+func (qa *QueryAggregators) HasClusterBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.ClusterRequest != nil
+}
+
+func (qa *QueryAggregators) HasClusterInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasClusterBlock)
+}
+
+func (qa *QueryAggregators) HasFieldFormatBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.FieldFormatRequest != nil
+}
+
+func (qa *QueryAggregators) HasForeachBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.ForeachRequest != nil
+}
+
+func (qa *QueryAggregators) HasForeachInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasForeachBlock)
+}
+
+func (qa *QueryAggregators) HasAppendColsBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.AppendColsRequest != nil
+}
+
+func (qa *QueryAggregators) HasAppendColsInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasAppendColsBlock)
+}
+
+func (qa *QueryAggregators) HasAppendPipeBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.AppendPipeRequest != nil
+}
+
+func (qa *QueryAggregators) HasAppendPipeInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasAppendPipeBlock)
+}
+
+func (qa *QueryAggregators) HasFieldSummaryBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.FieldSummaryRequest != nil
+}
+
+func (qa *QueryAggregators) HasFieldSummaryInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasFieldSummaryBlock)
+}
+
+func (qa *QueryAggregators) HasSequentialBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.SequentialRequest != nil
+}
+
+func (qa *QueryAggregators) HasSequentialInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasSequentialBlock)
+}
+
+func (qa *QueryAggregators) HasOutputLookupBlock() bool {
+	return qa != nil && qa.OutputTransforms != nil && qa.OutputTransforms.LetColumns != nil &&
+		qa.OutputTransforms.LetColumns.OutputLookupRequest != nil
+}
+
+func (qa *QueryAggregators) HasOutputLookupInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasOutputLookupBlock)
+}
+
 func (qa *QueryAggregators) hasHeadBlock() bool {
 	if qa == nil {
 		return false
@@ -843,7 +1360,7 @@ func (qa *QueryAggregators) HasInChain(hasInCur queryAggregatorsBoolFunc) bool {
 
 // To determine whether it contains certain specific AggregatorBlocks, such as: Rename Block, Rex Block, FilterRows, MaxRows...
 func (qa *QueryAggregators) HasQueryAggergatorBlock() bool {
-	if qa.HasStreamStatsInChain() || qa.HasGenerateEvent() {
+	if qa.HasStreamStatsInChain() || qa.HasEventStatsInChain() || qa.HasGenerateEvent() {
 		return true
 	}
 	return qa != nil && qa.OutputTransforms != nil && (qa.hasLetColumnsRequest() || qa.OutputTransforms.TailRequest != nil || qa.OutputTransforms.FilterRows != nil || qa.hasHeadBlock())
@@ -949,6 +1466,14 @@ func (qa *QueryAggregators) HasStreamStatsInChain() bool {
 	return qa.HasInChain((*QueryAggregators).HasStreamStats)
 }
 
+func (qa *QueryAggregators) HasEventStats() bool {
+	return qa != nil && qa.EventStatsOptions != nil
+}
+
+func (qa *QueryAggregators) HasEventStatsInChain() bool {
+	return qa.HasInChain((*QueryAggregators).HasEventStats)
+}
+
 func (qa *QueryAggregators) HasTransactionArguments() bool {
 	return qa != nil && qa.TransactionArguments != nil
 }
@@ -1098,6 +1623,56 @@ func (fillNullExpr *FillNullExpr) GetFields() []string {
 	return fillNullExpr.FieldList
 }
 
+// Each wildcard in the field captures the part of the name it matches.
+func getForeachFieldRegex(field string) *regexp.Regexp {
+	literals := strings.Split(field, "*")
+	for i, literal := range literals {
+		literals[i] = regexp.QuoteMeta(literal)
+	}
+	return regexp.MustCompile("^" + strings.Join(literals, "(.*)") + "$")
+}
+
+// Returns the columns that match any of the wildcard fields, in the order of the columns.
+func (req *ForeachRequest) GetMatchingFields(cols []string) []string {
+	regexes := make([]*regexp.Regexp, len(req.Fields))
+	for i, field := range req.Fields {
+		regexes[i] = getForeachFieldRegex(field)
+	}
+
+	matchingFields := make([]string, 0)
+	for _, col := range cols {
+		for _, regex := range regexes {
+			if regex.MatchString(col) {
+				matchingFields = append(matchingFields, col)
+				break
+			}
+		}
+	}
+	return matchingFields
+}
+
+// Returns the subsearch for the field, with the placeholders replaced. The match string is
+// what the first matching wildcard field captured.
+func (req *ForeachRequest) GetFieldSubsearch(field string) string {
+	matchStr := ""
+	for _, wildcardField := range req.Fields {
+		submatches := getForeachFieldRegex(wildcardField).FindStringSubmatch(field)
+		if submatches != nil {
+			matchStr = strings.Join(submatches[1:], "")
+			break
+		}
+	}
+
+	subsearch := strings.ReplaceAll(req.Subsearch, req.FieldStr, field)
+	return strings.ReplaceAll(subsearch, req.MatchStr, matchStr)
+}
+
+// Returns the subsearch for a value of the multivalue field, with the placeholders replaced.
+func (req *ForeachRequest) GetItemSubsearch(item string) string {
+	subsearch := strings.ReplaceAll(req.Subsearch, req.FieldStr, req.Fields[0])
+	return strings.ReplaceAll(subsearch, req.ItemStr, item)
+}
+
 // Init default query aggregators.
 // By default, a descending sort is added
 func InitDefaultQueryAggregations() *QueryAggregators {
@@ -1126,7 +1701,7 @@ func (qtype QueryType) String() string {
 }
 
 func (qa *QueryAggregators) HasStatsBlock() bool {
-	return qa != nil && !qa.HasStreamStats() && qa.HasGroupByOrMeasureAggsInBlock()
+	return qa != nil && !qa.HasStreamStats() && !qa.HasEventStats() && qa.HasGroupByOrMeasureAggsInBlock()
 }
 
 func (qa *QueryAggregators) HasStatsBlockInChain() bool {
@@ -1252,6 +1827,29 @@ func AddAllColumnsInLetColumnsRequest(cols map[string]struct{}, letColumnsReques
 	}
 
 	AddAllColumnsInExpr(cols, letColumnsRequest.FillNullRequest)
+
+	if letColumnsRequest.JoinRequest != nil {
+		sutils.AddSliceToSet(cols, letColumnsRequest.JoinRequest.FieldList)
+	}
+
+	if letColumnsRequest.LookupRequest != nil {
+		for _, matchField := range letColumnsRequest.LookupRequest.MatchFields {
+			cols[matchField.EventField] = struct{}{}
+		}
+	}
+
+	if letColumnsRequest.ExtractRequest != nil {
+		cols[letColumnsRequest.ExtractRequest.Field] = struct{}{}
+	}
+
+	if letColumnsRequest.ReshapeRequest != nil {
+		for _, field := range append([]string{letColumnsRequest.ReshapeRequest.XField, letColumnsRequest.ReshapeRequest.YNameField,
+			letColumnsRequest.ReshapeRequest.HeaderField}, letColumnsRequest.ReshapeRequest.YDataFields...) {
+			if field != "" {
+				cols[field] = struct{}{}
+			}
+		}
+	}
 }
 
 func AddAllColumnsInExpr(cols map[string]struct{}, expr FieldGetter) {
@@ -1340,14 +1938,10 @@ var unsupportedEvalFuncs = map[string]struct{}{
 	"mvzip":            {},
 	"mv_to_json_array": {},
 	"sigfig":           {},
-	"object_to_array":  {},
-	"printf":           {},
-	"tojson":           {},
 	"cluster":          {},
 	"getfields":        {},
 	"isnum":            {},
 	"isnotnull":        {},
-	"spath":            {},
 	"eventcount":       {},
 }
 
@@ -1404,6 +1998,10 @@ func CheckUnsupportedFunctions(post *QueryAggregators) error {
 				if evalChecker.IsUnsupported(valueCol.StringExpr.TextExpr.Op) {
 					return fmt.Errorf("checkUnsupportedFunctions: using %v in eval cmd is not yet supported", valueCol.StringExpr.TextExpr.Op)
 				}
+				spathExpr := valueCol.StringExpr.TextExpr.SPathExpr
+				if spathExpr != nil && spathExpr.Path == "" {
+					return fmt.Errorf("checkUnsupportedFunctions: using spath without a path is not yet supported")
+				}
 			}
 			if valueCol.NumericExpr != nil {
 				if evalChecker.IsUnsupported(valueCol.NumericExpr.Op) {
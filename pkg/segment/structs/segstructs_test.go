@@ -870,3 +870,28 @@ func Test_EqualsIsDeepEquals(t *testing.T) {
 
 	assert.NotEqual(t, segStat1, segStat2)
 }
+
+func Test_ForeachRequestSubsearches(t *testing.T) {
+	foreachReq := &ForeachRequest{
+		Fields:    []string{"bytes_*", "*_ms_*", "status"},
+		FieldStr:  "<<FIELD>>",
+		MatchStr:  "<<MATCHSTR>>",
+		ItemStr:   "<<ITEM>>",
+		Subsearch: "eval <<FIELD>>_kb = '<<FIELD>>' / 1024, part = \"<<MATCHSTR>>\"",
+	}
+
+	cols := []string{"bytes", "bytes_in", "bytes_out", "latency_ms_p99", "status", "status_code", "a.b"}
+	assert.Equal(t, []string{"bytes_in", "bytes_out", "latency_ms_p99", "status"}, foreachReq.GetMatchingFields(cols))
+
+	assert.Equal(t, "eval bytes_in_kb = 'bytes_in' / 1024, part = \"in\"", foreachReq.GetFieldSubsearch("bytes_in"))
+	assert.Equal(t, "eval latency_ms_p99_kb = 'latency_ms_p99' / 1024, part = \"latencyp99\"", foreachReq.GetFieldSubsearch("latency_ms_p99"))
+	assert.Equal(t, "eval status_kb = 'status' / 1024, part = \"\"", foreachReq.GetFieldSubsearch("status"))
+
+	// The dot is matched literally.
+	foreachReq.Fields = []string{"a.*"}
+	assert.Equal(t, []string{"a.b"}, foreachReq.GetMatchingFields([]string{"abc", "a.b"}))
+
+	foreachReq.Fields = []string{"sizes"}
+	foreachReq.Subsearch = "eval total = total + <<ITEM>>, last = \"<<FIELD>>\""
+	assert.Equal(t, "eval total = total + 42, last = \"sizes\"", foreachReq.GetItemSubsearch("42"))
+}
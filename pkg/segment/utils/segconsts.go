@@ -52,6 +52,8 @@ const SSM_MEM_PERCENT = 20
 const MICRO_IDX_CHECK_MEM_PERCENT = 5 // percent allocated for runtime checking & loading of cmis
 const RAW_SEARCH_MEM_PERCENT = 38     // minimum percent allocated for segsearch
 const METRICS_MEMORY_MEM_PERCENT = 2
+const JOIN_MEM_PERCENT = 10 // max percent of the segsearch allocation a single join hash table can use
+const SORT_MEM_PERCENT = 10 // max percent of the segsearch allocation the records buffered by a single sort can use
 
 // percent allocated for segmentsearchmeta (blocksummaries, blocklen/off)
 
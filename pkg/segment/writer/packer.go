@@ -930,6 +930,22 @@ func WriteMockColSegFile(segkey string, numBlocks int, entryCount int) ([]map[st
 	[]*BlockSummary, []map[string]*RangeIndex, map[string]bool, map[uint16]*BlockMetadataHolder,
 	map[string]*ColSizeInfo) {
 
+	return writeMockColSegFile(segkey, numBlocks, entryCount, false)
+}
+
+// Like WriteMockColSegFile, but the columns with few distinct values in a block are
+// dictionary encoded, like when the block is flushed during ingestion.
+func WriteMockDictEncColSegFile(segkey string, numBlocks int, entryCount int) ([]map[string]*BloomIndex,
+	[]*BlockSummary, []map[string]*RangeIndex, map[string]bool, map[uint16]*BlockMetadataHolder,
+	map[string]*ColSizeInfo) {
+
+	return writeMockColSegFile(segkey, numBlocks, entryCount, true)
+}
+
+func writeMockColSegFile(segkey string, numBlocks int, entryCount int, dictEncode bool) ([]map[string]*BloomIndex,
+	[]*BlockSummary, []map[string]*RangeIndex, map[string]bool, map[uint16]*BlockMetadataHolder,
+	map[string]*ColSizeInfo) {
+
 	allBlockBlooms := make([]map[string]*BloomIndex, numBlocks)
 	allBlockRangeIdx := make([]map[string]*RangeIndex, numBlocks)
 	allBlockSummaries := make([]*BlockSummary, numBlocks)
@@ -1012,6 +1028,8 @@ func WriteMockColSegFile(segkey string, numBlocks int, entryCount int) ([]map[st
 			var encType []byte
 			if cname == config.GetTimeStampKey() {
 				encType, _ = segStore.wipBlock.encodeTimestamps()
+			} else if dictEncode && colWip.deData.deCount > 0 && colWip.deData.deCount < wipCardLimit {
+				encType = ZSTD_DICTIONARY_BLOCK
 			} else {
 				encType = ZSTD_COMLUNAR_BLOCK
 			}
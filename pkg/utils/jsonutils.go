@@ -18,9 +18,17 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+var jsonPathStepRegex = regexp.MustCompile(`^([^{}]*)((?:\{[^{}]*\})*)$`)
+var jsonPathIndexRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
 // Flatten takes a map and returns a new one where nested maps are replaced
 // by dot-delimited keys.
 func Flatten(m map[string]interface{}) map[string]interface{} {
@@ -58,3 +66,178 @@ func FlattenSingleValue(key string, m map[string]interface{}, child interface{})
 		m[key] = child
 	}
 }
+
+// Decodes the JSON text, keeping the numbers as json.Number so that they are written back
+// the way they were.
+func DecodeJSONValue(jsonStr string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("DecodeJSONValue: invalid JSON, err: %v", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("DecodeJSONValue: invalid JSON, unexpected data after the value")
+	}
+	return value, nil
+}
+
+// Returns the strings as they are and the other values as compact JSON.
+func FormatJSONValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	}
+
+	jsonStr, err := EncodeJSONValue(value)
+	if err != nil {
+		return "", fmt.Errorf("FormatJSONValue: %v", err)
+	}
+	return jsonStr, nil
+}
+
+// Returns the value as compact JSON, without escaping the HTML characters.
+func EncodeJSONValue(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", fmt.Errorf("EncodeJSONValue: cannot encode %v, err: %v", value, err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Returns the values at the path in the decoded JSON value. The path uses the spath syntax:
+// keys separated by dots, where a key may be followed by {} for all the elements of an array
+// or {N} for an element, counting from firstIndex. spath counts from 1 and the json_* eval
+// functions count from 0.
+func GetJSONPathValues(value interface{}, path string, firstIndex int) ([]interface{}, error) {
+	values := []interface{}{value}
+	if path == "" {
+		return values, nil
+	}
+
+	for _, step := range strings.Split(path, ".") {
+		match := jsonPathStepRegex.FindStringSubmatch(step)
+		if match == nil {
+			return nil, fmt.Errorf("GetJSONPathValues: invalid step %v in path %v", step, path)
+		}
+
+		key := match[1]
+		if key != "" {
+			next := make([]interface{}, 0, len(values))
+			for _, v := range values {
+				if obj, ok := v.(map[string]interface{}); ok {
+					if child, exists := obj[key]; exists {
+						next = append(next, child)
+					}
+				}
+			}
+			values = next
+		}
+
+		for _, indexMatch := range jsonPathIndexRegex.FindAllStringSubmatch(match[2], -1) {
+			next := make([]interface{}, 0, len(values))
+			for _, v := range values {
+				arr, ok := v.([]interface{})
+				if !ok {
+					continue
+				}
+
+				if indexMatch[1] == "" {
+					next = append(next, arr...)
+					continue
+				}
+
+				// The attributes of XML elements, like {@year}, never match in JSON.
+				if strings.HasPrefix(indexMatch[1], "@") {
+					continue
+				}
+				index, err := strconv.Atoi(indexMatch[1])
+				if err != nil || index < firstIndex {
+					return nil, fmt.Errorf("GetJSONPathValues: invalid array index %v in path %v", indexMatch[1], path)
+				}
+				if index-firstIndex < len(arr) {
+					next = append(next, arr[index-firstIndex])
+				}
+			}
+			values = next
+		}
+	}
+
+	return values, nil
+}
+
+// Returns the decoded JSON value with newValue at the path, which uses the syntax of
+// GetJSONPathValues with one element per step. The missing keys are added, and an index
+// one past the end of an array appends to it.
+func SetJSONPathValue(value interface{}, path string, newValue interface{}, firstIndex int) (interface{}, error) {
+	if path == "" {
+		return newValue, nil
+	}
+
+	steps := make([]interface{}, 0)
+	for _, step := range strings.Split(path, ".") {
+		match := jsonPathStepRegex.FindStringSubmatch(step)
+		if match == nil {
+			return nil, fmt.Errorf("SetJSONPathValue: invalid step %v in path %v", step, path)
+		}
+		if match[1] != "" {
+			steps = append(steps, match[1])
+		}
+		for _, indexMatch := range jsonPathIndexRegex.FindAllStringSubmatch(match[2], -1) {
+			index, err := strconv.Atoi(indexMatch[1])
+			if err != nil || index < firstIndex {
+				return nil, fmt.Errorf("SetJSONPathValue: invalid array index {%v} in path %v", indexMatch[1], path)
+			}
+			steps = append(steps, index-firstIndex)
+		}
+	}
+
+	return setJSONPathSteps(value, steps, newValue)
+}
+
+func setJSONPathSteps(value interface{}, steps []interface{}, newValue interface{}) (interface{}, error) {
+	if len(steps) == 0 {
+		return newValue, nil
+	}
+
+	switch step := steps[0].(type) {
+	case string:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			if value != nil {
+				return nil, fmt.Errorf("setJSONPathSteps: cannot set the key %v of a value that is not an object", step)
+			}
+			obj = make(map[string]interface{})
+		}
+		child, err := setJSONPathSteps(obj[step], steps[1:], newValue)
+		if err != nil {
+			return nil, err
+		}
+		obj[step] = child
+		return obj, nil
+	case int:
+		arr, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("setJSONPathSteps: cannot set the element %v of a value that is not an array", step)
+		}
+		if step > len(arr) {
+			return nil, fmt.Errorf("setJSONPathSteps: the index %v is past the end of the array of length %v", step, len(arr))
+		}
+		if step == len(arr) {
+			arr = append(arr, nil)
+		}
+		child, err := setJSONPathSteps(arr[step], steps[1:], newValue)
+		if err != nil {
+			return nil, err
+		}
+		arr[step] = child
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("setJSONPathSteps: invalid step %v", step)
+	}
+}
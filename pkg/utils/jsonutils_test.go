@@ -279,3 +279,102 @@ func TestFlatten(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetJSONPathValues(t *testing.T) {
+	data, err := DecodeJSONValue(`{"a": {"b": [{"c": 1}, {"c": 2.50}, {"d": 3}]}, "e": "x"}`)
+	if err != nil {
+		t.Fatalf("Test_GetJSONPathValues: unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{"e", []string{"x"}},
+		{"a.b{}.c", []string{"1", "2.50"}},
+		{"a.b{3}", []string{`{"d":3}`}},
+		{"a.b{4}", []string{}},
+		{"a.b{@year}", []string{}},
+		{"a.x.c", []string{}},
+		{"", []string{`{"a":{"b":[{"c":1},{"c":2.50},{"d":3}]},"e":"x"}`}},
+	}
+
+	for _, c := range cases {
+		values, err := GetJSONPathValues(data, c.path, 1)
+		if err != nil {
+			t.Errorf("Test_GetJSONPathValues: path %v: unexpected error: %v", c.path, err)
+			continue
+		}
+
+		got := make([]string, 0, len(values))
+		for _, value := range values {
+			str, err := FormatJSONValue(value)
+			if err != nil {
+				t.Errorf("Test_GetJSONPathValues: path %v: unexpected error: %v", c.path, err)
+			}
+			got = append(got, str)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Test_GetJSONPathValues: path %v: got %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	values, err := GetJSONPathValues(data, "a.b{0}.c", 0)
+	if err != nil || !reflect.DeepEqual(values, []interface{}{json.Number("1")}) {
+		t.Errorf("Test_GetJSONPathValues: path a.b{0}.c from 0: got %v, err: %v", values, err)
+	}
+
+	for _, path := range []string{"a.b{0}", "a.b{x}", "a{b"} {
+		if _, err := GetJSONPathValues(data, path, 1); err == nil {
+			t.Errorf("Test_GetJSONPathValues: path %v: expected an error", path)
+		}
+	}
+
+	if _, err := DecodeJSONValue(`{"a": 1} {"b": 2}`); err == nil {
+		t.Errorf("Test_GetJSONPathValues: expected an error for two JSON values")
+	}
+}
+
+func Test_SetJSONPathValue(t *testing.T) {
+	cases := []struct {
+		json string
+		path string
+		want string
+	}{
+		{`{"a": 1}`, "a", `{"a":"new"}`},
+		{`{"a": 1}`, "b.c", `{"a":1,"b":{"c":"new"}}`},
+		{`{"a": [1, 2]}`, "a{1}", `{"a":[1,"new"]}`},
+		{`{"a": [1, 2]}`, "a{2}", `{"a":[1,2,"new"]}`},
+		{`{"a": [{"b": 1}]}`, "a{0}.b", `{"a":[{"b":"new"}]}`},
+		{`{"a": 1}`, "", `"new"`},
+	}
+
+	for _, c := range cases {
+		data, err := DecodeJSONValue(c.json)
+		if err != nil {
+			t.Fatalf("Test_SetJSONPathValue: unexpected error: %v", err)
+		}
+		value, err := SetJSONPathValue(data, c.path, "new", 0)
+		if err != nil {
+			t.Errorf("Test_SetJSONPathValue: path %v: unexpected error: %v", c.path, err)
+			continue
+		}
+		got, err := EncodeJSONValue(value)
+		if err != nil {
+			t.Errorf("Test_SetJSONPathValue: path %v: unexpected error: %v", c.path, err)
+		}
+		if got != c.want {
+			t.Errorf("Test_SetJSONPathValue: path %v: got %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	for _, path := range []string{"a.b", "a{3}", "a{x}", "a{}"} {
+		data, err := DecodeJSONValue(`{"a": [1, 2]}`)
+		if err != nil {
+			t.Fatalf("Test_SetJSONPathValue: unexpected error: %v", err)
+		}
+		if _, err := SetJSONPathValue(data, path, "new", 0); err == nil {
+			t.Errorf("Test_SetJSONPathValue: path %v: expected an error", path)
+		}
+	}
+}